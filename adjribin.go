@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// neighborLister lists the neighbors of gobgpd, along with the numbers of
+// the prefixes received from each
+type neighborLister interface {
+	Neighbors(ctx context.Context) ([]gobgp.Neighbor, error)
+}
+
+// receivedLister lists the prefixes which the built-in speaker has received
+// from the neighbor of each address, if it retains them
+type receivedLister interface {
+	Received(address string) ([]string, bool)
+}
+
+// publishReceivedRoutes records, for each of the given Routers, the number
+// of the prefixes of each address family received from it, and of those
+// accepted, in the status Report and the metrics.  gobgpd retains the
+// routes received from every Router; the built-in speaker retains only the
+// prefixes of those with softReconfigurationInbound, and accepts none.
+// Either speaker may be nil, if it is not in use.
+func publishReceivedRoutes(ctx context.Context, gobgpd neighborLister, builtin receivedLister, routers []Router) error {
+	var list []status.ReceivedRoutes
+
+	if gobgpd != nil {
+		neighbors, err := gobgpd.Neighbors(ctx)
+		if err != nil {
+			return err
+		}
+
+		families := make(map[string][]gobgp.FamilyCount, len(neighbors))
+		for _, n := range neighbors {
+			families[n.Address] = n.Families
+		}
+
+		for _, r := range routers {
+			for _, f := range families[r.Address] {
+				list = append(list, status.ReceivedRoutes{
+					Session:  status.Session{Address: r.Address, Name: r.Name},
+					Speaker:  speakerGoBGPD,
+					Family:   f.Family,
+					Received: int(f.Received),
+					Accepted: int(f.Accepted),
+				})
+			}
+		}
+	}
+
+	if builtin != nil {
+		for _, r := range routers {
+			prefixes, ok := builtin.Received(r.Address)
+			if !ok {
+				continue
+			}
+
+			received := make(map[string]int, len(gobgpdFamilies))
+			for _, p := range prefixes {
+				if ip, _, err := net.ParseCIDR(p); err == nil && ip.To4() == nil {
+					received["ipv6-unicast"]++
+				} else {
+					received["ipv4-unicast"]++
+				}
+			}
+
+			for _, family := range gobgpdFamilies {
+				list = append(list, status.ReceivedRoutes{
+					Session:  status.Session{Address: r.Address, Name: r.Name},
+					Speaker:  speakerBuiltin,
+					Family:   family,
+					Received: received[family],
+				})
+			}
+		}
+	}
+
+	metrics.ReceivedPrefixes.Reset()
+	metrics.AcceptedPrefixes.Reset()
+
+	for _, rr := range list {
+		metrics.ReceivedPrefixes.WithLabelValues(rr.Address, rr.Family, rr.Speaker).Set(float64(rr.Received))
+		metrics.AcceptedPrefixes.WithLabelValues(rr.Address, rr.Family, rr.Speaker).Set(float64(rr.Accepted))
+	}
+
+	status.SetReceivedRoutes(list)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+type fakeNeighbors []gobgp.Neighbor
+
+func (f fakeNeighbors) Neighbors(ctx context.Context) ([]gobgp.Neighbor, error) {
+	return f, nil
+}
+
+type fakeReceived map[string][]string
+
+func (f fakeReceived) Received(address string) ([]string, bool) {
+	prefixes, ok := f[address]
+	return prefixes, ok
+}
+
+func TestPublishReceivedRoutes(t *testing.T) {
+	routers := []Router{
+		{Name: "edge-a", Address: "10.0.0.1"},
+		{Name: "edge-b", Address: "10.0.0.2"},
+	}
+
+	edgeA := status.Session{Address: "10.0.0.1", Name: "edge-a"}
+	edgeB := status.Session{Address: "10.0.0.2", Name: "edge-b"}
+
+	for _, tt := range []struct {
+		name    string
+		gobgpd  neighborLister
+		builtin receivedLister
+		want    []status.ReceivedRoutes
+	}{
+		{
+			name: "gobgpd received and accepted",
+			gobgpd: fakeNeighbors{
+				{Address: "10.0.0.1", Families: []gobgp.FamilyCount{
+					{Family: "ipv4-unicast", Received: 12, Accepted: 9},
+					{Family: "ipv6-unicast", Received: 3, Accepted: 0},
+				}},
+				{Address: "10.0.0.9", Families: []gobgp.FamilyCount{
+					{Family: "ipv4-unicast", Received: 5, Accepted: 5},
+				}},
+			},
+			want: []status.ReceivedRoutes{
+				{Session: edgeA, Speaker: speakerGoBGPD, Family: "ipv4-unicast", Received: 12, Accepted: 9},
+				{Session: edgeA, Speaker: speakerGoBGPD, Family: "ipv6-unicast", Received: 3, Accepted: 0},
+			},
+		},
+		{
+			name: "builtin accepts none",
+			builtin: fakeReceived{
+				"10.0.0.2": {"192.0.2.0/24", "198.51.100.0/24", "2001:db8::/32"},
+			},
+			want: []status.ReceivedRoutes{
+				{Session: edgeB, Speaker: speakerBuiltin, Family: "ipv4-unicast", Received: 2},
+				{Session: edgeB, Speaker: speakerBuiltin, Family: "ipv6-unicast", Received: 1},
+			},
+		},
+		{
+			name: "no speakers",
+		},
+	} {
+		if err := publishReceivedRoutes(context.Background(), tt.gobgpd, tt.builtin, routers); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if got := status.Current().Received; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/rotisserie/eris"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultAdminSocket is the default path of the unix socket of the admin API
+const defaultAdminSocket = "/var/run/kube-bgp/admin.sock"
+
+// serveUnix serves the given handler on a unix socket at the given path,
+// with the given file permissions, until the context is cancelled
+func serveUnix(ctx context.Context, socket string, mode os.FileMode, handler http.Handler) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return eris.Wrapf(err, "failed to create directory of socket %s", socket)
+	}
+
+	// A socket left behind by a previous run would prevent the listen.
+	os.Remove(socket) // nolint: errcheck
+
+	// The socket is created accessible only to this user, so that it is
+	// never more accessible than the given permissions, even until they are
+	// set.
+	var l net.Listener
+	var err error
+	withUmask(0077, func() {
+		l, err = net.Listen("unix", socket)
+	})
+	if err != nil {
+		return eris.Wrapf(err, "failed to listen on socket %s", socket)
+	}
+
+	if err := os.Chmod(socket, mode); err != nil {
+		l.Close() // nolint: errcheck
+		return eris.Wrapf(err, "failed to set permissions of socket %s", socket)
+	}
+
+	srv := &http.Server{Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close() // nolint: errcheck
+	}()
+
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return eris.Wrapf(err, "failed to serve on socket %s", socket)
+	}
+
+	return nil
+}
+
+// unixClient returns an http.Client which connects to the unix socket at the given path
+func unixClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// readAdminToken reads the admin token from the given file, or returns the
+// empty string if no file is given
+func readAdminToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", errcode.Wrapf(err, errcode.ConfigInvalid, "failed to read admin token file %s", tokenFile)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errcode.New(errcode.ConfigInvalid, "admin token file "+tokenFile+" is empty")
+	}
+
+	return token, nil
+}
+
+// requireToken returns an http.Handler which passes only the requests
+// bearing the given token, by the Bearer scheme, to the given handler.  If
+// the token is empty, the socket permissions are the only protection, and
+// every request is passed.
+func requireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			given, ok := bearerToken(r)
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "a valid admin token is required", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of the Authorization header of the given
+// request, and whether the header uses the Bearer scheme at all
+func bearerToken(r *http.Request) (string, bool) {
+	const scheme = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) {
+		return "", false
+	}
+
+	return auth[len(scheme):], true
+}
+
+// serveAdmin serves the admin API, by which the CLI subcommands act on the
+// running kube-bgp, on the admin socket.  Since it performs actions and
+// exposes the whole configuration, it is never served over TCP.
+func serveAdmin(ctx context.Context, cfg *KubeBGPConfig, refreshRequests chan<- *refreshRequest, dynamicClient dynamic.Interface) {
+	token, err := readAdminToken(cfg.AdminTokenFile)
+	if err != nil {
+		log.Fatalln("failed to load admin token:", err)
+	}
+	if token == "" {
+		log.Println("warning: the admin API requires no token, since adminTokenFile is not set; only the permissions of " + cfg.AdminSocket + " protect it")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/refresh", refreshHandler(refreshRequests))
+	mux.Handle("/debug/state", stateDumpHandler())
+
+	if cfg.RouterMaintenance {
+		mux.Handle("/routers/", routerMaintenanceHandler(cfg, dynamicClient))
+	}
+
+	if err := serveUnix(ctx, cfg.AdminSocket, 0600, requireToken(token, mux)); err != nil {
+		log.Println("failed to serve admin API:", err)
+	}
+}
+
+// adminRequest performs a request of the admin API of the running kube-bgp,
+// returning the body of the response
+func adminRequest(cfg *KubeBGPConfig, method, path string, query url.Values) ([]byte, error) {
+	token, err := readAdminToken(cfg.AdminTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	u := url.URL{
+		Scheme:   "http",
+		Host:     "kube-bgp",
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(nil))
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to create request")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := unixClient(cfg.AdminSocket)
+	client.Timeout = refreshTimeout
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to contact kube-bgp")
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to read response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, eris.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
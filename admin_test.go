@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeUnixMode(t *testing.T) {
+	for _, mode := range []os.FileMode{0600, 0666} {
+		socket := filepath.Join(t.TempDir(), "test.sock")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- serveUnix(ctx, socket, mode, http.NotFoundHandler())
+		}()
+
+		// The socket must never be more accessible than the given mode.
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			info, err := os.Stat(socket)
+			if err == nil {
+				if perm := info.Mode().Perm(); perm&^mode != 0 {
+					t.Errorf("%o: socket created with mode %o", mode, perm)
+					break
+				} else if perm == mode {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%o: socket not created with its mode", mode)
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("%o: unexpected error: %v", mode, err)
+		}
+	}
+}
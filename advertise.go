@@ -0,0 +1,486 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// routersAnnotation is the annotation which references a comma-separated
+// list of Routers, by name or address.  On a Service, it restricts the
+// Routers to which the Service's VIPs are announced.  On a Node, it adds
+// Routers with which the Node should peer.
+const routersAnnotation = "kube-bgp.cycoresystems.com/routers"
+
+// advertiseIPsAnnotation is the Pod annotation which lists (comma-separated)
+// the IPs or CIDRs which the Pod's Node should announce while the Pod is
+// Running and Ready
+const advertiseIPsAnnotation = "kube-bgp.cycoresystems.com/advertise-ips"
+
+// RouteAttributes are the BGP path attributes applied to a class of advertised routes
+type RouteAttributes struct {
+	// Origin is the ORIGIN attribute of the routes: one of `igp`, `egp`, or `incomplete`.
+	// This is optional, and defaults to `igp`.
+	Origin routes.Origin `yaml:"origin"`
+
+	// AIGP is the Accumulated IGP Metric attribute of the routes.
+	// This is optional, and if not supplied, no AIGP attribute is sent.
+	AIGP *uint32 `yaml:"aigp"`
+}
+
+func (a *RouteAttributes) validate() error {
+	if !a.Origin.Valid() {
+		return eris.Errorf("invalid origin %q", a.Origin)
+	}
+
+	return nil
+}
+
+// Advertisement describes a class of routes which are announced by every Node
+type Advertisement struct {
+	// Name is the name of this class of advertisement
+	Name string `yaml:"name"`
+
+	// Prefixes is the list of CIDRs to be announced
+	Prefixes []string `yaml:"prefixes"`
+
+	RouteAttributes `yaml:",inline"`
+
+	// Routers is the list of Routers (by name or address) to which these
+	// routes should be announced.  This is optional, and if not supplied, the routes
+	// are announced to all Routers.
+	Routers []string `yaml:"routers"`
+
+	// NodeGroups is the list of BGPNodeGroups (by name) whose Nodes announce
+	// these routes.  This is optional, and if not supplied, the routes are
+	// announced by every Node.
+	NodeGroups []string `yaml:"nodeGroups"`
+
+	// NAT64 translates the prefixes between IPv4 and their NAT64 IPv6
+	// equivalents.  This is optional, and if not supplied, the prefixes are
+	// advertised as they are.
+	NAT64 *NAT64 `yaml:"nat64"`
+
+	// Hostname is the DNS name under which the host routes of this
+	// Advertisement are published, if DNS export is enabled.  This is
+	// optional.
+	Hostname string `yaml:"hostname"`
+
+	// PodSelector is the label selector of the Pods (such as the current
+	// primary of a database, `app=postgres,role=primary`) whose Nodes
+	// announce these routes: only a Node with a Running and Ready Pod which
+	// matches it does.  This is optional, and if not supplied, the routes
+	// are announced regardless of any Pod.
+	PodSelector string `yaml:"podSelector"`
+
+	// PodNamespace restricts the PodSelector to the Pods of the given
+	// namespace.  This is optional.
+	PodNamespace string `yaml:"podNamespace"`
+
+	// Standby designates primary and backup Nodes for these routes, where
+	// the backups announce them less preferably rather than not at all.
+	// This is optional, and if not supplied, every Node announces them
+	// alike.
+	Standby *Standby `yaml:"standby"`
+
+	// HoldDownSeconds is the time for which the routes must be desired
+	// continuously, once withdrawn, before they are advertised again.  This
+	// is optional, and if not supplied, they are advertised again at once.
+	HoldDownSeconds int `yaml:"holdDownSeconds"`
+
+	// Schedule restricts the announcement of the routes to its time
+	// windows.  This is optional, and if not supplied, they are announced
+	// at all times.
+	Schedule *Schedule `yaml:"schedule"`
+
+	// DryRun computes the routes of the advertisement and reports them in
+	// the status report, without advertising them, for the staged rollout
+	// of a new advertisement
+	DryRun bool `yaml:"dryRun"`
+}
+
+func (a *Advertisement) validate() error {
+	for _, p := range a.Prefixes {
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			return eris.Wrapf(err, "invalid prefix %q", p)
+		}
+	}
+
+	if a.NAT64 != nil {
+		if err := a.NAT64.validate(); err != nil {
+			return eris.Wrap(err, "invalid nat64")
+		}
+	}
+
+	if _, err := labels.Parse(a.PodSelector); err != nil {
+		return eris.Wrapf(err, "invalid podSelector %q", a.PodSelector)
+	}
+
+	if a.PodNamespace != "" && a.PodSelector == "" {
+		return eris.New("podNamespace requires a podSelector")
+	}
+
+	if a.HoldDownSeconds < 0 {
+		return eris.Errorf("invalid holdDownSeconds %d", a.HoldDownSeconds)
+	}
+
+	if a.Standby != nil {
+		if err := a.Standby.validate(); err != nil {
+			return eris.Wrap(err, "invalid standby")
+		}
+	}
+
+	if a.Schedule != nil {
+		if err := a.Schedule.validate(); err != nil {
+			return eris.Wrap(err, "invalid schedule")
+		}
+	}
+
+	return a.RouteAttributes.validate()
+}
+
+// selectsPod indicates whether any of the given Pods is a Running and Ready
+// Pod which matches the PodSelector of the Advertisement
+func (a *Advertisement) selectsPod(podList []v1.Pod) bool {
+	selector, err := labels.Parse(a.PodSelector)
+	if err != nil {
+		return false // validated at load
+	}
+
+	for i := range podList {
+		pod := &podList[i]
+
+		if a.PodNamespace != "" && pod.Namespace != a.PodNamespace {
+			continue
+		}
+
+		if podReady(pod) && selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchesPods indicates whether the Pods of the Node are watched, for the
+// Pod advertisement or for any Advertisement gated on a Pod
+func (c *KubeBGPConfig) watchesPods() bool {
+	if c.Pods != nil {
+		return true
+	}
+
+	for _, a := range c.Advertisements {
+		if a.PodSelector != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routes returns the routes described by the Advertisement
+func (a *Advertisement) routes() (list []routes.Route) {
+	for _, p := range a.Prefixes {
+		if a.NAT64 == nil {
+			list = append(list, a.RouteAttributes.route(p, "advertisement/"+a.Name))
+			continue
+		}
+
+		for _, t := range a.NAT64.translate(p) {
+			list = append(list, a.RouteAttributes.route(t, "advertisement/"+a.Name))
+		}
+	}
+
+	return list
+}
+
+// route returns a route for the given prefix with these attributes, originated on behalf of the given source
+func (a *RouteAttributes) route(prefix, source string) routes.Route {
+	return routes.Route{
+		Prefix: prefix,
+		Origin: a.Origin,
+		AIGP:   a.AIGP,
+		Source: source,
+	}
+}
+
+// PodAdvertisement configures the announcement of the IPs listed in the
+// advertise-ips annotation of the Pods on each Node
+type PodAdvertisement struct {
+	RouteAttributes `yaml:",inline"`
+
+	// HostRoutes configures the announcement of host routes for further
+	// classes of Pod IPs.  This is optional, and if not supplied, only the
+	// advertise-ips annotation is honoured.
+	HostRoutes *HostRoutes `yaml:"hostRoutes"`
+}
+
+// HostRoutes configures the announcement of host routes for the IPs of the
+// Pods on each Node, beyond those listed in the advertise-ips annotation
+type HostRoutes struct {
+	// HostNetwork enables host routes for the IPs of hostNetwork Pods which
+	// are not among the addresses of the Node itself
+	HostNetwork bool `yaml:"hostNetwork"`
+
+	// Annotations is a list of further Pod annotations, such as those of
+	// static IP CNI plugins, which list IPs for which host routes should be
+	// announced.  Values may be comma-separated or a JSON array.
+	Annotations []string `yaml:"annotations"`
+}
+
+func (a *PodAdvertisement) validate() error {
+	if a.HostRoutes != nil {
+		for _, k := range a.HostRoutes.Annotations {
+			if strings.TrimSpace(k) == "" {
+				return eris.New("empty hostRoutes annotation")
+			}
+		}
+	}
+
+	return a.RouteAttributes.validate()
+}
+
+// routes returns the routes for the IPs of the given Pods on the given Node
+func (a *PodAdvertisement) routes(thisNode *v1.Node, podList []v1.Pod) (list []routes.Route) {
+	annotations := []string{advertiseIPsAnnotation}
+	if a.HostRoutes != nil {
+		annotations = append(annotations, a.HostRoutes.Annotations...)
+	}
+
+	// The host routes of Pod IPs are exclusive to this Node, whereas the
+	// advertise-ips annotation may list the same (anycast) IPs on many Pods.
+	seen := make(map[string]bool)
+	add := func(prefix string, exclusive bool, pod *v1.Pod) {
+		if !seen[prefix] {
+			seen[prefix] = true
+			r := a.RouteAttributes.route(prefix, "pod/"+pod.Namespace+"/"+pod.Name)
+			r.Exclusive = exclusive
+			list = append(list, r)
+		}
+	}
+
+	for i := range podList {
+		pod := &podList[i]
+
+		if !podReady(pod) {
+			continue
+		}
+
+		for _, k := range annotations {
+			v, ok := pod.Annotations[k]
+			if !ok {
+				continue
+			}
+
+			for _, s := range splitIPList(v) {
+				prefix, err := parsePrefix(s)
+				if err != nil {
+					status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "invalid %s annotation on pod %s/%s", k, pod.Namespace, pod.Name))
+					continue
+				}
+
+				add(prefix, k != advertiseIPsAnnotation, pod)
+			}
+		}
+
+		if a.HostRoutes != nil && a.HostRoutes.HostNetwork && pod.Spec.HostNetwork {
+			if ip := net.ParseIP(pod.Status.PodIP); ip != nil && !nodeHasAddress(thisNode, ip) {
+				add(hostPrefix(ip), true, pod)
+			}
+		}
+	}
+
+	return list
+}
+
+// splitIPList splits an annotation value listing IPs, either comma-separated
+// or as a JSON array of strings
+func splitIPList(v string) (list []string) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.Trim(strings.TrimSpace(s), `"`); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// nodeHasAddress indicates whether the given IP is among the addresses of the given Node
+func nodeHasAddress(n *v1.Node, ip net.IP) bool {
+	if n == nil {
+		return false
+	}
+
+	for _, addr := range n.Status.Addresses {
+		if ip.Equal(net.ParseIP(addr.Address)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podReady indicates whether the given Pod is Running and Ready
+func podReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// parsePrefix parses a CIDR or a single IP, which is treated as a host prefix
+func parsePrefix(s string) (string, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network.String(), nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", eris.Errorf("invalid IP or CIDR %q", s)
+	}
+
+	return hostPrefix(ip), nil
+}
+
+// hostPrefix returns the single-address prefix of the given IP
+func hostPrefix(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+
+	return ip.String() + "/128"
+}
+
+// desiredRoutes returns the list of routes which the named Node should
+// originate.  Until the cluster has bootstrapped, the cluster-wide
+// advertisements are held back; only the routes of the local Pods, pod
+// CIDRs, and EgressIPs are made.  The Services which cannot be announced are
+// returned as errors, so that a reconcile reports each of them once.
+func desiredRoutes(cfg *KubeBGPConfig, thisNode string, state *clusterState, bootstrapped bool) (list []routes.Route, errs []error) {
+	node := findNode(thisNode, state.Nodes)
+
+	for _, a := range cfg.Advertisements {
+		if !bootstrapped || (len(a.NodeGroups) > 0 && !inNodeGroups(node, state.NodeGroups, a.NodeGroups)) {
+			continue
+		}
+
+		// A Pod-gated advertisement is withdrawn once the Pods are stale,
+		// since its Pod may have moved to another Node meanwhile.
+		if a.PodSelector != "" && (cfg.expired("pod", state.PodsListed) || !a.selectsPod(state.Pods)) {
+			continue
+		}
+
+		if a.Schedule != nil {
+			if open, _ := a.Schedule.active(time.Now()); !open {
+				continue
+			}
+		}
+
+		if a.Standby == nil {
+			list = append(list, a.routes()...)
+			continue
+		}
+
+		switch a.Standby.role(node) {
+		case standbyPrimary:
+			list = append(list, a.routes()...)
+		case standbyBackup:
+			list = append(list, a.Standby.backupRoutes(a.routes(), cfg.ASN)...)
+		}
+	}
+
+	if bootstrapped {
+		for i := range cfg.ZoneSummaries {
+			list = append(list, cfg.ZoneSummaries[i].routes(node, state)...)
+		}
+
+		if cfg.Services != nil {
+			serviceRoutes, serviceErrs := cfg.Services.routes(cfg, node, state)
+			list = append(list, serviceRoutes...)
+			errs = serviceErrs
+		}
+	}
+
+	if cfg.Pods != nil && !cfg.expired("pod", state.PodsListed) {
+		list = append(list, cfg.Pods.routes(node, state.Pods)...)
+	}
+
+	if cfg.PodCIDRs != nil {
+		list = append(list, cfg.PodCIDRs.routes(node)...)
+	}
+
+	if cfg.EgressIPs != nil && !cfg.expired("egress IP", state.EgressIPsListed) {
+		list = append(list, cfg.EgressIPs.routes(thisNode, state)...)
+	}
+
+	if cfg.AnnounceAPI != nil {
+		list = append(list, cfg.AnnounceAPI.routes(state.Announced)...)
+	}
+
+	if cfg.IPAM != nil {
+		list = permittedRoutes(list, state.Pools)
+	}
+
+	if cfg.Tenants {
+		list = tenantRoutes(list, state.Tenants)
+	}
+
+	if cfg.NodeConditions != nil {
+		list = cfg.NodeConditions.routes(node, list)
+	}
+
+	if nextHop := localSourceAddress(node, cfg, state.NodeGroups); nextHop != nil {
+		for i := range list {
+			list[i].NextHop = nextHop.String()
+		}
+	}
+
+	if cfg.ClusterCommunity != "" {
+		for i := range list {
+			list[i].Communities = append(list[i].Communities, cfg.ClusterCommunity)
+		}
+	}
+
+	if g := nodeGroup(node, state.NodeGroups); g != nil {
+		for i := range list {
+			list[i].Communities = append(list[i].Communities, g.Spec.Communities...)
+		}
+	}
+
+	if communities := cfg.labelCommunities(node); len(communities) > 0 {
+		for i := range list {
+			list[i].Communities = append(list[i].Communities, communities...)
+		}
+	}
+
+	return list, errs
+}
+
+// routerRefs returns the list of Router references in the routers annotation
+// of the given set of annotations
+func routerRefs(annotations map[string]string) (list []string) {
+	for _, r := range strings.Split(annotations[routersAnnotation], ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			list = append(list, r)
+		}
+	}
+
+	return list
+}
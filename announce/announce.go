@@ -0,0 +1,103 @@
+// Package announce is the client library of the kube-bgp announcement API,
+// by which applications running on a Node ask the kube-bgp of that Node to
+// announce (and withdraw) the IPs they own, such as a SIP proxy announcing
+// its media IP while it is healthy.  The API is served on a unix socket,
+// and callers identify themselves by their ServiceAccount token.
+package announce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rotisserie/eris"
+)
+
+// DefaultSocket is the default path of the unix socket of the announcement API
+const DefaultSocket = "/var/run/kube-bgp/announce.sock"
+
+// DefaultTokenFile is the default file from which the ServiceAccount token of the caller is read
+const DefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Request is a request to announce or withdraw an IP
+type Request struct {
+	// IP is the address to be announced or withdrawn
+	IP string `json:"ip"`
+
+	// TTLSeconds is the time for which the announcement remains in effect
+	// unless it is renewed by another Announce.  If zero, the default of the
+	// agent is used.  It is ignored by Withdraw.
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+}
+
+// Client is a client of the announcement API
+type Client struct {
+	// TokenFile is the file from which the ServiceAccount token of the caller is read
+	TokenFile string
+
+	http *http.Client
+}
+
+// New returns a Client of the announcement API served on the given unix socket
+func New(socket string) *Client {
+	return &Client{
+		TokenFile: DefaultTokenFile,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Announce asks for the given IP to be announced for the given number of
+// seconds (or, if zero, the default of the agent).  The announcement must be
+// renewed by calling Announce again before it expires, so that it is
+// withdrawn should the caller fail.
+func (c *Client) Announce(ctx context.Context, ip string, ttlSeconds int) error {
+	return c.post(ctx, "/announce", &Request{IP: ip, TTLSeconds: ttlSeconds})
+}
+
+// Withdraw asks for the given IP to be withdrawn at once
+func (c *Client) Withdraw(ctx context.Context, ip string) error {
+	return c.post(ctx, "/withdraw", &Request{IP: ip})
+}
+
+func (c *Client) post(ctx context.Context, path string, req *Request) error {
+	token, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return eris.Wrap(err, "failed to read service account token")
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return eris.Wrap(err, "failed to encode request")
+	}
+
+	r, err := http.NewRequest(http.MethodPost, "http://kube-bgp"+path, bytes.NewReader(body))
+	if err != nil {
+		return eris.Wrap(err, "failed to create request")
+	}
+	r.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(r.WithContext(ctx))
+	if err != nil {
+		return eris.Wrap(err, "failed to reach kube-bgp")
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body) // nolint: errcheck
+		return eris.Errorf("kube-bgp refused the request: %s", strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
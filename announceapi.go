@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/announce"
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultAnnounceTTLSeconds is the default time for which an announcement made through the announcement API remains in effect
+const defaultAnnounceTTLSeconds = 30
+
+// maxAnnounceTTLSeconds is the maximum time for which an announcement made through the announcement API remains in effect
+const maxAnnounceTTLSeconds = 300
+
+// announceAuthCacheTime is the time for which the identity of a token is remembered
+const announceAuthCacheTime = time.Minute
+
+// serviceAccountPrefix is the prefix of the usernames of ServiceAccounts
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// AnnounceAPI configures the announcement API, by which applications on this
+// Node request the announcement of the IPs they own
+type AnnounceAPI struct {
+	// Socket is the path of the unix socket on which the API is served.
+	// This is optional, and defaults to "/var/run/kube-bgp/announce.sock".
+	Socket string `yaml:"socket"`
+
+	// DefaultTTLSeconds is the time for which an announcement remains in
+	// effect without being renewed, if the caller does not say.
+	// This is optional, and defaults to 30.
+	DefaultTTLSeconds int `yaml:"defaultTTLSeconds"`
+
+	RouteAttributes `yaml:",inline"`
+
+	// Allow is the list of rules stating which ServiceAccounts may announce
+	// which IPs.  Requests not allowed by any rule are refused.
+	Allow []AnnounceRule `yaml:"allow"`
+}
+
+// AnnounceRule allows a set of ServiceAccounts to announce IPs within a set of CIDRs
+type AnnounceRule struct {
+	// ServiceAccounts is the list of ServiceAccounts, each in the form
+	// `<namespace>/<name>`, where the name may be `*` to allow every
+	// ServiceAccount of the namespace
+	ServiceAccounts []string `yaml:"serviceAccounts"`
+
+	// CIDRs is the list of networks within which the IPs must fall
+	CIDRs []string `yaml:"cidrs"`
+}
+
+func (a *AnnounceAPI) validate() error {
+	if a.DefaultTTLSeconds < 0 || a.DefaultTTLSeconds > maxAnnounceTTLSeconds {
+		return eris.Errorf("invalid defaultTTLSeconds %d", a.DefaultTTLSeconds)
+	}
+
+	for _, rule := range a.Allow {
+		for _, sa := range rule.ServiceAccounts {
+			if parts := strings.Split(sa, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return eris.Errorf("invalid service account %q; must be <namespace>/<name>", sa)
+			}
+		}
+
+		for _, c := range rule.CIDRs {
+			if _, _, err := net.ParseCIDR(c); err != nil {
+				return eris.Wrapf(err, "invalid CIDR %q", c)
+			}
+		}
+	}
+
+	return a.RouteAttributes.validate()
+}
+
+// socket returns the path of the unix socket on which the API is served
+func (a *AnnounceAPI) socket() string {
+	if a.Socket == "" {
+		return announce.DefaultSocket
+	}
+
+	return a.Socket
+}
+
+// ttl returns the given TTL of an announcement, or the default TTL if it is zero
+func (a *AnnounceAPI) ttl(seconds int) time.Duration {
+	if seconds == 0 {
+		seconds = a.DefaultTTLSeconds
+	}
+	if seconds == 0 {
+		seconds = defaultAnnounceTTLSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// allowed indicates whether the given ServiceAccount (as `<namespace>/<name>`) may announce the given IP
+func (a *AnnounceAPI) allowed(account string, ip net.IP) bool {
+	namespace := strings.SplitN(account, "/", 2)[0]
+
+	for _, rule := range a.Allow {
+		var matched bool
+		for _, sa := range rule.ServiceAccounts {
+			if sa == account || sa == namespace+"/*" {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		for _, c := range rule.CIDRs {
+			if _, network, err := net.ParseCIDR(c); err == nil && network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// routes returns the routes for the given announced IPs, each given with the ServiceAccount which announced it
+func (a *AnnounceAPI) routes(announced map[string]string) (list []routes.Route) {
+	ips := make([]string, 0, len(announced))
+	for s := range announced {
+		ips = append(ips, s)
+	}
+	sort.Strings(ips)
+
+	for _, s := range ips {
+		r := a.RouteAttributes.route(hostPrefix(net.ParseIP(s)), "announce/"+announced[s])
+		r.Exclusive = true
+		list = append(list, r)
+	}
+
+	return list
+}
+
+// announcement is an IP announced through the announcement API
+type announcement struct {
+	// owner is the ServiceAccount which announced the IP
+	owner string
+
+	// expires is the time at which the announcement lapses unless renewed
+	expires time.Time
+}
+
+// authenticated is a remembered identity of a token
+type authenticated struct {
+	account string
+	expires time.Time
+}
+
+// announceServer serves the announcement API and holds the IPs announced through it
+type announceServer struct {
+	cfg       *AnnounceAPI
+	clientset kubernetes.Interface
+	changes   *dirty.Flag
+
+	mu            sync.Mutex
+	announcements map[string]announcement
+	tokens        map[string]authenticated
+}
+
+func newAnnounceServer(cfg *AnnounceAPI, clientset kubernetes.Interface) *announceServer {
+	return &announceServer{
+		cfg:           cfg,
+		clientset:     clientset,
+		changes:       dirty.New(),
+		announcements: make(map[string]announcement),
+		tokens:        make(map[string]authenticated),
+	}
+}
+
+// Changes returns a channel which signals whenever the set of announced IPs changes
+func (s *announceServer) Changes() <-chan struct{} {
+	return s.changes.C()
+}
+
+// Announced returns the currently-announced IPs, with the ServiceAccount which announced each
+func (s *announceServer) Announced() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	announced := make(map[string]string, len(s.announcements))
+	for ip, a := range s.announcements {
+		announced[ip] = a.owner
+	}
+
+	return announced
+}
+
+func (s *announceServer) notify() {
+	s.changes.Set()
+}
+
+// Serve serves the announcement API until the context is cancelled
+func (s *announceServer) Serve(ctx context.Context) error {
+	go s.expire(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/announce", s.handle(s.announce))
+	mux.HandleFunc("/withdraw", s.handle(s.withdraw))
+
+	// Callers are authenticated by their tokens, so any local user may connect.
+	return serveUnix(ctx, s.cfg.socket(), 0666, mux)
+}
+
+// expire withdraws each announcement which has not been renewed in time
+func (s *announceServer) expire(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var changed bool
+
+			s.mu.Lock()
+			for ip, a := range s.announcements {
+				if now.After(a.expires) {
+					log.Printf("announcement of %s by %s expired", ip, a.owner)
+					delete(s.announcements, ip)
+					changed = true
+				}
+			}
+			s.mu.Unlock()
+
+			if changed {
+				s.notify()
+			}
+		}
+	}
+}
+
+// handle returns an http.HandlerFunc which authenticates and decodes a
+// request and passes it to the given action, which returns the HTTP status
+// and an error message
+func (s *announceServer) handle(action func(account string, ip net.IP, req *announce.Request) (int, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			http.Error(w, "a service account token is required", http.StatusUnauthorized)
+			return
+		}
+
+		account, err := s.authenticate(r.Context(), token)
+		if err != nil {
+			if errcode.Of(err) == errcode.APIServerUnreachable {
+				status.Error(err)
+				http.Error(w, "failed to authenticate", http.StatusServiceUnavailable)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		req := new(announce.Request)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ip := net.ParseIP(req.IP)
+		if ip == nil {
+			http.Error(w, "invalid IP "+req.IP, http.StatusBadRequest)
+			return
+		}
+
+		if !s.cfg.allowed(account, ip) {
+			http.Error(w, account+" may not announce "+ip.String(), http.StatusForbidden)
+			return
+		}
+
+		if code, msg := action(account, ip, req); code != http.StatusOK {
+			http.Error(w, msg, code)
+		}
+	}
+}
+
+func (s *announceServer) announce(account string, ip net.IP, req *announce.Request) (int, string) {
+	if req.TTLSeconds < 0 || req.TTLSeconds > maxAnnounceTTLSeconds {
+		return http.StatusBadRequest, "invalid ttlSeconds"
+	}
+
+	key := ip.String()
+
+	s.mu.Lock()
+	existing, ok := s.announcements[key]
+	if ok && existing.owner != account {
+		s.mu.Unlock()
+		return http.StatusConflict, key + " is announced by " + existing.owner
+	}
+	s.announcements[key] = announcement{
+		owner:   account,
+		expires: time.Now().Add(s.cfg.ttl(req.TTLSeconds)),
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		log.Printf("%s announced %s", account, key)
+		s.notify()
+	}
+
+	return http.StatusOK, ""
+}
+
+func (s *announceServer) withdraw(account string, ip net.IP, req *announce.Request) (int, string) {
+	key := ip.String()
+
+	s.mu.Lock()
+	existing, ok := s.announcements[key]
+	if ok && existing.owner != account {
+		s.mu.Unlock()
+		return http.StatusConflict, key + " is announced by " + existing.owner
+	}
+	delete(s.announcements, key)
+	s.mu.Unlock()
+
+	if ok {
+		log.Printf("%s withdrew %s", account, key)
+		s.notify()
+	}
+
+	return http.StatusOK, ""
+}
+
+// authenticate returns the ServiceAccount (as `<namespace>/<name>`) of the
+// given token, by a TokenReview
+func (s *announceServer) authenticate(ctx context.Context, token string) (string, error) {
+	s.mu.Lock()
+	if a, ok := s.tokens[token]; ok && time.Now().Before(a.expires) {
+		s.mu.Unlock()
+		return a.account, nil
+	}
+	s.mu.Unlock()
+
+	review, err := s.clientset.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", errcode.Wrap(err, errcode.APIServerUnreachable, "failed to review announcement API token")
+	}
+
+	if !review.Status.Authenticated {
+		return "", eris.Errorf("announcement API token rejected: %s", review.Status.Error)
+	}
+
+	user := review.Status.User.Username
+	if !strings.HasPrefix(user, serviceAccountPrefix) {
+		return "", eris.Errorf("announcement API caller %s is not a service account", user)
+	}
+	account := strings.Replace(strings.TrimPrefix(user, serviceAccountPrefix), ":", "/", 1)
+
+	s.mu.Lock()
+	now := time.Now()
+	for t, a := range s.tokens {
+		if now.After(a.expires) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = authenticated{account: account, expires: now.Add(announceAuthCacheTime)}
+	s.mu.Unlock()
+
+	return account, nil
+}
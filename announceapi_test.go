@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAnnounceAPIAllowed(t *testing.T) {
+	a := &AnnounceAPI{
+		Allow: []AnnounceRule{
+			{ServiceAccounts: []string{"lb/speaker"}, CIDRs: []string{"10.10.0.0/24", "2001:db8:10::/64"}},
+			{ServiceAccounts: []string{"edge/*"}, CIDRs: []string{"10.20.0.0/24"}},
+			{ServiceAccounts: []string{"broken/sa"}, CIDRs: []string{"not-a-cidr"}},
+		},
+	}
+
+	for _, tt := range []struct {
+		account string
+		ip      string
+		want    bool
+	}{
+		{"lb/speaker", "10.10.0.5", true},
+		{"lb/speaker", "2001:db8:10::5", true},
+		{"lb/speaker", "10.20.0.5", false},
+		{"lb/other", "10.10.0.5", false},
+		{"edge/any", "10.20.0.5", true},
+		{"edge/any", "10.10.0.5", false},
+		{"edgy/any", "10.20.0.5", false},
+		{"broken/sa", "10.10.0.5", false},
+		{"lb", "10.10.0.5", false},
+	} {
+		if got := a.allowed(tt.account, net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s announcing %s: expected %v, got %v", tt.account, tt.ip, tt.want, got)
+		}
+	}
+
+	if (&AnnounceAPI{}).allowed("lb/speaker", net.ParseIP("10.10.0.5")) {
+		t.Error("expected nothing to be allowed without rules")
+	}
+}
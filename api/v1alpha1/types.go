@@ -0,0 +1,219 @@
+// Package v1alpha1 defines the v1alpha1 kube-bgp custom resources
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Group is the API group of the kube-bgp custom resources
+const Group = "kube-bgp.cycoresystems.com"
+
+// Version is the API version of the custom resources in this package
+const Version = "v1alpha1"
+
+// Standard condition types of the kube-bgp custom resources, which GitOps
+// tools such as Argo CD and Flux may use as health checks
+const (
+	// ConditionReady indicates that the resource is fully in effect
+	ConditionReady = "Ready"
+
+	// ConditionApplied indicates that the resource has been applied to the BGP state
+	ConditionApplied = "Applied"
+
+	// ConditionDegraded indicates that the resource is at most partially in effect
+	ConditionDegraded = "Degraded"
+)
+
+// EgressIPResource is the resource of EgressIPs
+var EgressIPResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "egressips"}
+
+// EgressIP assigns a set of egress source IPs to the selected namespaces and
+// Pods.  The IPs are hosted by, and announced from, a single Node at a time
+// (recorded in the status), which fails over to another eligible Node when it
+// is no longer Ready.
+type EgressIP struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressIPSpec   `json:"spec"`
+	Status EgressIPStatus `json:"status,omitempty"`
+}
+
+// EgressIPSpec describes the desired egress IPs
+type EgressIPSpec struct {
+	// IPs is the list of egress source IPs
+	IPs []string `json:"ips"`
+
+	// NamespaceSelector selects the namespaces whose egress traffic should use these IPs
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PodSelector selects the Pods (within the selected namespaces) whose egress traffic should use these IPs
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// NodeSelector selects the Nodes which are eligible to host these IPs.
+	// If not supplied, all Nodes are eligible.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+// EgressIPStatus describes the current hosting of the egress IPs
+type EgressIPStatus struct {
+	// Node is the name of the Node which currently hosts and announces the egress IPs
+	Node string `json:"node,omitempty"`
+
+	// Conditions describes the state of the EgressIP: Ready, Applied, and Degraded
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// BGPNodeGroupResource is the resource of BGPNodeGroups
+var BGPNodeGroupResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "bgpnodegroups"}
+
+// BGPNodeGroup applies a common set of BGP settings to the Nodes it selects,
+// so that they need not be repeated in the annotations of each Node.  The
+// group may also be referenced by Routers and advertisements in place of
+// listing its Nodes.  A Node belongs to at most one group: the first, by
+// name, which selects it.
+type BGPNodeGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPNodeGroupSpec `json:"spec"`
+}
+
+// BGPNodeGroupSpec describes the Nodes of a group and their settings
+type BGPNodeGroupSpec struct {
+	// NodeSelector selects the Nodes of the group.
+	// If not supplied, the group selects no Nodes.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// ASN is the Autonomous System Number of the Nodes of the group.
+	// If not supplied, the system ASN is used.
+	ASN string `json:"asn,omitempty"`
+
+	// Communities is the list of standard communities (`<asn>:<value>`)
+	// attached to every route originated by the Nodes of the group
+	Communities []string `json:"communities,omitempty"`
+
+	// RouteReflector makes the Nodes of the group route reflectors.  When any
+	// group is a reflector group, the other Nodes peer only with the
+	// reflectors, rather than with every other Node.
+	RouteReflector bool `json:"routeReflector,omitempty"`
+
+	// Reflectors is the number of Nodes of the group to elect as its route
+	// reflectors, which the rest of the group peers with in place of every
+	// reflector.  Reflectors which disappear are replaced by re-election.
+	// This may not be combined with RouteReflector.
+	Reflectors int `json:"reflectors,omitempty"`
+
+	// SourceCIDR is the network in which the Nodes of the group establish
+	// their BGP sessions, overriding the global peerSourceCIDR
+	SourceCIDR string `json:"sourceCIDR,omitempty"`
+}
+
+// BGPTenantResource is the resource of BGPTenants
+var BGPTenantResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "bgptenants"}
+
+// BGPTenant maps the VIP pools of a tenant to its MPLS VPN, so that the
+// routes within them are exported in VPNv4/VPNv6 (RFC 4364) with the route
+// distinguisher and route targets of the tenant, directly into the
+// customer VPN, rather than in the global table
+type BGPTenant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPTenantSpec `json:"spec"`
+}
+
+// BGPTenantSpec describes the VPN of a tenant
+type BGPTenantSpec struct {
+	// Prefixes is the list of CIDRs of the VIP pools of the tenant.  Every
+	// route within one of them is exported in the VPN of the tenant.
+	Prefixes []string `json:"prefixes"`
+
+	// RouteDistinguisher is the route distinguisher of the routes of the
+	// tenant, either `<asn>:<value>` or `<ipv4>:<value>`
+	RouteDistinguisher string `json:"routeDistinguisher"`
+
+	// RouteTargets is the list of route target extended communities (each
+	// `<asn>:<value>` or `<ipv4>:<value>`) with which the routes of the
+	// tenant are exported
+	RouteTargets []string `json:"routeTargets"`
+}
+
+// BGPRouterMaintenanceResource is the resource of BGPRouterMaintenances
+var BGPRouterMaintenanceResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "bgproutermaintenances"}
+
+// BGPRouterMaintenance places a Router under maintenance, so that every Node
+// drains its sessions with it, until the BGPRouterMaintenance is deleted
+type BGPRouterMaintenance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPRouterMaintenanceSpec `json:"spec"`
+}
+
+// BGPRouterMaintenanceSpec describes the maintenance of a Router
+type BGPRouterMaintenanceSpec struct {
+	// Router is the address or name of the Router, as configured
+	Router string `json:"router"`
+
+	// Reason is the reason for the maintenance, for the operators
+	Reason string `json:"reason,omitempty"`
+}
+
+// BGPNodeStatusResource is the resource of BGPNodeStatuses
+var BGPNodeStatusResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "bgpnodestatuses"}
+
+// BGPNodeStatus is the status of the kube-bgp of a single Node, of the same
+// name, as last applied
+type BGPNodeStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status BGPNodeStatusStatus `json:"status"`
+}
+
+// BGPNodeStatusStatus describes the prefixes exported by a Node
+type BGPNodeStatusStatus struct {
+	// Updated is the time at which the status was last updated
+	Updated metav1.Time `json:"updated"`
+
+	// Originated is the number of prefixes originated by the Node, by
+	// address family and class
+	Originated []PrefixCount `json:"originated,omitempty"`
+
+	// Neighbors is the number of prefixes exported to each neighbor of the
+	// Node, by address family and class
+	Neighbors []NeighborExports `json:"neighbors,omitempty"`
+}
+
+// NeighborExports is the number of prefixes exported to a single neighbor
+type NeighborExports struct {
+	// Address is the address of the neighbor
+	Address string `json:"address"`
+
+	// Name is the name of the neighbor (the Node of an iBGP peer, or the
+	// name of a Router), if it has one
+	Name string `json:"name,omitempty"`
+
+	// Kind is the kind of the neighbor, either `peer` or `router`
+	Kind string `json:"kind"`
+
+	// Exported is the number of prefixes exported to the neighbor, by
+	// address family and class
+	Exported []PrefixCount `json:"exported,omitempty"`
+}
+
+// PrefixCount is the number of prefixes of a single address family and class
+type PrefixCount struct {
+	// Family is the address family of the prefixes: `ipv4`, `ipv6`,
+	// `vpnv4`, or `vpnv6`
+	Family string `json:"family"`
+
+	// Class is the kind of source of the prefixes, such as `advertisement`,
+	// `pod`, `egressip`, or `announce`
+	Class string `json:"class"`
+
+	// Prefixes is the number of prefixes
+	Prefixes int `json:"prefixes"`
+}
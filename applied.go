@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// stateHashLen is the number of hex digits of the short hashes of the applied state
+const stateHashLen = 12
+
+// shortHash returns a short hash of the JSON encoding of the given value
+func shortHash(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:stateHashLen]
+}
+
+// appliedState is the state whose hash is published once it has been applied
+type appliedState struct {
+	Peers   []Peer
+	Routers []Router
+	Routes  []routes.Route
+}
+
+// publishAppliedHashes publishes the short hashes of the configuration and
+// of the desired state, once the desired state has been applied, so that the
+// Nodes which have diverged from the rest of the cluster (such as after a
+// configuration rollout) can be told apart
+func publishAppliedHashes(cfg *KubeBGPConfig, peers []Peer, routers []Router, desired []routes.Route) {
+	configHash := shortHash(cfg)
+	stateHash := appliedStateHash(peers, routers, desired)
+
+	metrics.AppliedState.Reset()
+	metrics.AppliedState.WithLabelValues(configHash, stateHash).Set(1)
+
+	status.SetAppliedHashes(configHash, stateHash)
+}
+
+// appliedStateHash returns the short hash of the given desired state
+func appliedStateHash(peers []Peer, routers []Router, desired []routes.Route) string {
+	sorted := append([]routes.Route(nil), desired...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Prefix < sorted[j].Prefix })
+
+	return shortHash(appliedState{Peers: peers, Routers: routers, Routes: sorted})
+}
+
+// applyTracker tracks the age of the last successful apply of the desired
+// state, and that of the oldest change not yet applied, whether for want of
+// a successful apply or because it is held back (such as by a hold-down or
+// a freeze)
+type applyTracker struct {
+	// appliedHash is the hash of the state last applied
+	appliedHash string
+
+	// lastApplied is the time at which the state was last applied, if it has been
+	lastApplied time.Time
+
+	// unappliedSince is the time since which the intended state has
+	// differed from that applied, or the zero time if it has not
+	unappliedSince time.Time
+}
+
+// Observe records the hash of the state which is intended to be applied,
+// before any change is held back
+func (t *applyTracker) Observe(intended string, now time.Time) {
+	switch {
+	case intended == t.appliedHash:
+		t.unappliedSince = time.Time{}
+	case t.unappliedSince.IsZero():
+		t.unappliedSince = now
+	}
+
+	t.publish()
+}
+
+// Applied records the successful apply of the state of the given hash, while
+// the state of the other given hash was intended
+func (t *applyTracker) Applied(applied, intended string, now time.Time) {
+	t.appliedHash = applied
+	t.lastApplied = now
+
+	t.Observe(intended, now)
+}
+
+func (t *applyTracker) publish() {
+	status.SetApplyTimes(t.lastApplied, t.unappliedSince)
+}
+
+// divergedNodes returns the Nodes whose configuration hash differs from
+// that of the most Nodes, given the configuration hash of each Node
+func divergedNodes(hashes map[string]string) (list []string) {
+	counts := make(map[string]int)
+	for _, h := range hashes {
+		if h != "" {
+			counts[h]++
+		}
+	}
+
+	var common string
+	for h, n := range counts {
+		if n > counts[common] || (n == counts[common] && h < common) {
+			common = h
+		}
+	}
+
+	for node, h := range hashes {
+		if h != "" && h != common {
+			list = append(list, node)
+		}
+	}
+	sort.Strings(list)
+
+	return list
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/rotisserie/eris"
+)
+
+// tcpMD5MaxPasswordLength is the longest TCP-MD5 password which Linux accepts
+const tcpMD5MaxPasswordLength = 80
+
+// validateAuth checks that, with RequireAuth, every session which may be
+// generated has a password
+func (c *KubeBGPConfig) validateAuth() error {
+	if !c.RequireAuth {
+		return nil
+	}
+
+	if c.MeshPasswordFile == "" && (c.Mesh != meshDisabled || len(c.Topologies) > 0) {
+		return eris.New("meshPasswordFile is required by requireAuth")
+	}
+
+	for _, r := range c.Routers {
+		if r.PasswordFile == "" {
+			return eris.Errorf("passwordFile is required by requireAuth for router %s", r.Ref())
+		}
+	}
+
+	return nil
+}
+
+// readPassword reads the TCP-MD5 password held by the given file
+func readPassword(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errcode.Wrapf(err, errcode.ConfigInvalid, "failed to read password file %s", filename)
+	}
+
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return "", errcode.New(errcode.ConfigInvalid, "password file "+filename+" is empty")
+	}
+
+	if len(password) > tcpMD5MaxPasswordLength {
+		return "", errcode.New(errcode.ConfigInvalid, "password in "+filename+" is longer than "+strconv.Itoa(tcpMD5MaxPasswordLength)+" characters")
+	}
+
+	return password, nil
+}
+
+// checkAuth verifies that every configured password file holds a usable password
+func checkAuth(cfg *KubeBGPConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	files := []string{cfg.MeshPasswordFile}
+	for _, r := range cfg.Routers {
+		files = append(files, r.PasswordFile)
+	}
+
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+
+		if _, err := readPassword(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/rotisserie/eris"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// bootstrapCheckInterval is the interval at which the bootstrap conditions are re-checked until they are met
+var bootstrapCheckInterval = 10 * time.Second
+
+// Bootstrap describes the minimum health of the cluster which must be reached
+// after startup before the cluster-wide advertisements are made, so that
+// traffic does not arrive at a half-bootstrapped cluster.  Once the
+// conditions have been met, they are not checked again.
+type Bootstrap struct {
+	// MinReadyNodes is the minimum number of Ready Nodes
+	MinReadyNodes int `yaml:"minReadyNodes"`
+
+	// Deployments is the list of Deployments, each as `<namespace>/<name>`
+	// (such as `kube-system/coredns`), which must be Available
+	Deployments []string `yaml:"deployments"`
+}
+
+func (b *Bootstrap) validate() error {
+	if b.MinReadyNodes < 0 {
+		return eris.Errorf("invalid minReadyNodes %d", b.MinReadyNodes)
+	}
+
+	for _, d := range b.Deployments {
+		if _, _, err := splitDeployment(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitDeployment splits a `<namespace>/<name>` Deployment reference
+func splitDeployment(ref string) (namespace, name string, err error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", eris.Errorf("invalid deployment %q (should be <namespace>/<name>)", ref)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// check returns an error describing the first unmet bootstrap condition, or nil if all are met
+func (b *Bootstrap) check(ctx context.Context, client kubernetes.Interface, nodeList []v1.Node) error {
+	var ready int
+	for i := range nodeList {
+		if nodeReady(&nodeList[i]) {
+			ready++
+		}
+	}
+	if ready < b.MinReadyNodes {
+		return eris.New("only " + strconv.Itoa(ready) + " of the required " + strconv.Itoa(b.MinReadyNodes) + " Nodes are Ready")
+	}
+
+	for _, ref := range b.Deployments {
+		namespace, name, _ := splitDeployment(ref) // validated at load
+
+		d, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get deployment %s", ref)
+		}
+
+		if !deploymentAvailable(d) {
+			return eris.Errorf("deployment %s is not Available", ref)
+		}
+	}
+
+	return nil
+}
+
+// deploymentAvailable indicates whether the given Deployment has the Available condition
+func deploymentAvailable(d *appsv1.Deployment) bool {
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentAvailable {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// bootstrapGate holds back the cluster-wide advertisements until the
+// Bootstrap conditions have been met, after which it stays open
+type bootstrapGate struct {
+	cfg      *Bootstrap
+	client   kubernetes.Interface
+	recorder events.Recorder
+
+	open bool
+
+	// reason is the description of the unmet condition last logged
+	reason string
+}
+
+func newBootstrapGate(cfg *Bootstrap, client kubernetes.Interface, recorder events.Recorder) *bootstrapGate {
+	return &bootstrapGate{
+		cfg:      cfg,
+		client:   client,
+		recorder: recorder,
+		open:     cfg == nil,
+	}
+}
+
+// Open indicates whether the cluster-wide advertisements may be made, checking the Bootstrap conditions if they have not yet been met
+func (g *bootstrapGate) Open(ctx context.Context, nodeList []v1.Node) bool {
+	if g.open {
+		return true
+	}
+
+	if err := g.cfg.check(ctx, g.client, nodeList); err != nil {
+		if err.Error() != g.reason {
+			log.Println("holding back advertisements until the cluster has bootstrapped:", err)
+			g.reason = err.Error()
+		}
+
+		return false
+	}
+
+	log.Println("cluster bootstrap conditions met")
+	g.recorder.Normal("Bootstrapped", "cluster bootstrap conditions met; advertising")
+	g.open = true
+
+	return true
+}
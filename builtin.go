@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/history"
+	"github.com/CyCoreSystems/kube-bgp/speaker"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// speakerGoBGPD is the Speaker mode in which routes are originated by an external gobgpd
+	speakerGoBGPD = "gobgpd"
+
+	// speakerBuiltin is the Speaker mode in which routes are announced by the built-in, announce-only BGP speaker
+	speakerBuiltin = "builtin"
+)
+
+// routerIDAnnotation is the Node annotation which explicitly sets the BGP router-id of the Node
+const routerIDAnnotation = "kube-bgp.cycoresystems.com/router-id"
+
+// parseASN parses an Autonomous System Number
+func parseASN(s string) (uint32, error) {
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, eris.Wrapf(err, "invalid ASN %q", s)
+	}
+
+	return uint32(asn), nil
+}
+
+// routerID returns the BGP router-id of the given Node: its router-id
+// annotation, if present, or else its router-id allocated from the
+// configured RouterIDPool, or else the configured RouterID, or else its
+// first IPv4 InternalIP.
+func routerID(n *v1.Node, cfg *KubeBGPConfig) (net.IP, error) {
+	if s, ok := n.Annotations[routerIDAnnotation]; ok {
+		if ip := net.ParseIP(s).To4(); ip != nil {
+			return ip, nil
+		}
+
+		return nil, eris.Errorf("invalid %s annotation %q on node %s", routerIDAnnotation, s, n.Name)
+	}
+
+	if cfg.RouterIDPool != nil {
+		id, err := poolRouterID(n, cfg.RouterIDPool)
+		if err != nil || id != nil {
+			return id, err
+		}
+
+		return nil, eris.Errorf("node %s has not been allocated a router-id from the pool", n.Name)
+	}
+
+	if cfg.RouterID != "" {
+		if ip := net.ParseIP(cfg.RouterID).To4(); ip != nil {
+			return ip, nil
+		}
+
+		return nil, eris.Errorf("invalid routerID %q", cfg.RouterID)
+	}
+
+	for _, ip := range internalIPs(n) {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, eris.Errorf("node %s has no IPv4 InternalIP to use as its router-id; it must be supplied with the %s annotation", n.Name, routerIDAnnotation)
+}
+
+// newBuiltinSpeaker creates the built-in BGP speaker for the named Node.  If
+// restarting, it takes over the sessions of the previous speaker of the Node.
+func newBuiltinSpeaker(ctx context.Context, clientset kubernetes.Interface, nodeName string, cfg *KubeBGPConfig, restarting bool) (*speaker.Speaker, error) {
+	n, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get node %s", nodeName)
+	}
+
+	id, err := routerID(n, cfg)
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.ConfigInvalid, "failed to determine router-id")
+	}
+
+	asn, err := parseASN(cfg.ASN)
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.ConfigInvalid, "invalid system ASN")
+	}
+
+	return speaker.New(ctx, speaker.Config{
+		ASN:         asn,
+		RouterID:    id,
+		Hostname:    n.Name,
+		DomainName:  cfg.ClusterName,
+		RestartTime: cfg.restartTime(),
+		Restarting:  restarting,
+		StateChange: func(address string, established bool, reason error) {
+			if established {
+				history.Record(address, string(gobgp.SessionEstablished), "")
+				return
+			}
+
+			// Repeated failures to connect are not transitions
+			if history.Last(address) != "idle" {
+				history.Record(address, "idle", reason.Error())
+			}
+		},
+	})
+}
+
+// builtinNeighbors returns the neighbors of the built-in speaker for the given Routers
+func builtinNeighbors(cfg *KubeBGPConfig, routers []Router) (list []speaker.Neighbor) {
+	for _, r := range routers {
+		asn := r.ASN
+		if asn == "" {
+			asn = cfg.ASN
+		}
+
+		n, err := parseASN(asn)
+		if err != nil {
+			continue // validated at load
+		}
+
+		nb := speaker.Neighbor{
+			Address:                  r.Address,
+			ASN:                      n,
+			Port:                     r.RemotePort(),
+			MinAdvertisementInterval: time.Duration(r.AdvertisementIntervalSeconds) * time.Second,
+
+			SoftReconfigurationInbound: r.SoftReconfigurationInbound,
+		}
+		if r.Link != nil {
+			nb.Interface = r.Link.Interface
+			if ip := r.Link.localAddress(r.Address); ip != nil {
+				nb.LocalAddress = ip.String()
+			}
+		}
+
+		list = append(list, nb)
+	}
+
+	return list
+}
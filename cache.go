@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StateCache configures a local cache of the last known-good cluster state,
+// which is applied at startup until the state has been obtained from the
+// apiserver, so that a rebooted Node need not run with an empty neighbor set
+// in the meantime (nor indefinitely, during an apiserver outage)
+type StateCache struct {
+	// Path is the file in which the state is cached.  It should be on
+	// persistent storage of the Node, such as a hostPath volume.
+	Path string `yaml:"path"`
+
+	// ThresholdSeconds was the time for which the apiserver had to be
+	// unreachable before the cached state was applied.  The cached state is
+	// now applied at once, so it is ignored, and accepted only so that the
+	// configurations which set it remain valid.
+	ThresholdSeconds int `yaml:"thresholdSeconds"`
+}
+
+func (c *StateCache) validate() error {
+	if c.Path == "" {
+		return eris.New("a path is required")
+	}
+
+	return nil
+}
+
+// cachedState is the content of the state cache file
+type cachedState struct {
+	// Time is the time at which the state was observed
+	Time time.Time `json:"time"`
+
+	// Nodes are only the parts of the Nodes which kube-bgp reads, since the
+	// rest (such as their images and heartbeats) is large and changes often.
+	Nodes      []v1.Node               `json:"nodes"`
+	Pods       []v1.Pod                `json:"pods,omitempty"`
+	Services   []v1.Service            `json:"services,omitempty"`
+	Endpoints  []v1.Endpoints          `json:"endpoints,omitempty"`
+	EgressIPs  []v1alpha1.EgressIP     `json:"egressIPs,omitempty"`
+	NodeGroups []v1alpha1.BGPNodeGroup `json:"nodeGroups,omitempty"`
+}
+
+// save writes the given state to the cache file, replacing it atomically
+func (c *StateCache) save(state *clusterState) error {
+	data, err := json.Marshal(&cachedState{
+		Time:       time.Now(),
+		Nodes:      cachedNodes(state.Nodes),
+		Pods:       state.Pods,
+		Services:   state.Services,
+		Endpoints:  state.Endpoints,
+		EgressIPs:  state.EgressIPs,
+		NodeGroups: state.NodeGroups,
+	})
+	if err != nil {
+		return eris.Wrap(err, "failed to encode state cache")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(c.Path), ".kube-bgp-state")
+	if err != nil {
+		return errcode.Wrapf(err, errcode.RenderFailed, "failed to create state cache in %s", filepath.Dir(c.Path))
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+
+	if _, err := f.Write(data); err != nil {
+		f.Close() // nolint: errcheck
+		return errcode.Wrap(err, errcode.RenderFailed, "failed to write state cache")
+	}
+
+	if err := f.Close(); err != nil {
+		return errcode.Wrap(err, errcode.RenderFailed, "failed to write state cache")
+	}
+
+	if err := os.Rename(f.Name(), c.Path); err != nil {
+		return errcode.Wrapf(err, errcode.RenderFailed, "failed to replace state cache %s", c.Path)
+	}
+
+	return nil
+}
+
+// cachedNodes returns the parts of the given Nodes which kube-bgp reads:
+// their names, labels, and annotations, their pod CIDRs and provider IDs,
+// and their addresses and the status of their conditions
+func cachedNodes(nodeList []v1.Node) []v1.Node {
+	list := make([]v1.Node, 0, len(nodeList))
+
+	for i := range nodeList {
+		n := &nodeList[i]
+
+		c := v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        n.Name,
+				Labels:      n.Labels,
+				Annotations: n.Annotations,
+			},
+			Spec: v1.NodeSpec{
+				PodCIDR:    n.Spec.PodCIDR,
+				PodCIDRs:   n.Spec.PodCIDRs,
+				ProviderID: n.Spec.ProviderID,
+			},
+			Status: v1.NodeStatus{
+				Addresses: n.Status.Addresses,
+			},
+		}
+
+		for _, cond := range n.Status.Conditions {
+			c.Status.Conditions = append(c.Status.Conditions, v1.NodeCondition{Type: cond.Type, Status: cond.Status})
+		}
+
+		list = append(list, c)
+	}
+
+	return list
+}
+
+// load reads the cached state, returning it along with the time at which it
+// was observed.  If there is no cache yet, the returned state is nil.
+func (c *StateCache) load() (*clusterState, time.Time, error) {
+	data, err := ioutil.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, eris.Wrapf(err, "failed to read state cache %s", c.Path)
+	}
+
+	cached := new(cachedState)
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, time.Time{}, eris.Wrapf(err, "failed to decode state cache %s", c.Path)
+	}
+
+	return &clusterState{
+		Nodes:           cached.Nodes,
+		Pods:            cached.Pods,
+		Services:        cached.Services,
+		Endpoints:       cached.Endpoints,
+		EgressIPs:       cached.EgressIPs,
+		NodeGroups:      cached.NodeGroups,
+		PodsListed:      cached.Time,
+		EgressIPsListed: cached.Time,
+	}, cached.Time, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStateCache(t *testing.T) {
+	c := &StateCache{Path: filepath.Join(t.TempDir(), "state.json")}
+
+	if state, _, err := c.load(); err != nil || state != nil {
+		t.Fatalf("expected no state before the cache is written, got %+v, %v", state, err)
+	}
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "node-a",
+			Labels:          map[string]string{"rr": "true"},
+			Annotations:     map[string]string{routersAnnotation: "edge-a"},
+			ResourceVersion: "42",
+		},
+		Spec: v1.NodeSpec{
+			PodCIDR:    "10.244.1.0/24",
+			PodCIDRs:   []string{"10.244.1.0/24"},
+			ProviderID: "aws:///us-east-1a/i-0123",
+			Taints:     []v1.Taint{{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+			Conditions: []v1.NodeCondition{{
+				Type:              v1.NodeReady,
+				Status:            v1.ConditionTrue,
+				LastHeartbeatTime: metav1.Now(),
+				Message:           "kubelet is posting ready status",
+			}},
+			Images: []v1.ContainerImage{{Names: []string{"k8s.gcr.io/pause:3.2"}, SizeBytes: 683317}},
+		},
+	}
+
+	if err := c.save(&clusterState{Nodes: []v1.Node{node}}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, _, err := c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: node.Name, Labels: node.Labels, Annotations: node.Annotations},
+		Spec:       v1.NodeSpec{PodCIDR: node.Spec.PodCIDR, PodCIDRs: node.Spec.PodCIDRs, ProviderID: node.Spec.ProviderID},
+		Status: v1.NodeStatus{
+			Addresses:  node.Status.Addresses,
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+
+	if len(state.Nodes) != 1 || !reflect.DeepEqual(state.Nodes[0], want) {
+		t.Errorf("expected cached node %+v, got %+v", want, state.Nodes)
+	}
+}
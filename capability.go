@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rotisserie/eris"
+)
+
+// capability is a feature of the desired BGP state which not every backend can express
+type capability string
+
+// Capabilities
+const (
+	// capIBGPMesh is the establishment of sessions with other Nodes
+	capIBGPMesh capability = "ibgp-mesh"
+
+	// capLinkBandwidth is the attachment of the link-bandwidth extended community to routes
+	capLinkBandwidth capability = "link-bandwidth"
+
+	// capGracefulRestart is the advertisement of the graceful restart
+	// capability with the restart time configured in kube-bgp
+	capGracefulRestart capability = "graceful-restart"
+
+	// capRIB is the inspection of the routes received from other speakers
+	capRIB capability = "rib"
+
+	// capVPN is the export of routes in VPNv4/VPNv6 (RFC 4364)
+	capVPN capability = "vpn"
+
+	// capTCPMD5 is the authentication of sessions with TCP-MD5 (RFC 2385)
+	capTCPMD5 capability = "tcp-md5"
+
+	// capHandover is the handover of a running speaker from one kube-bgp
+	// instance to another, leaving its sessions and routes in place
+	capHandover capability = "handover"
+)
+
+// backendCapabilities are the capabilities of each backend
+var backendCapabilities = map[string][]capability{
+	speakerGoBGPD:  {capIBGPMesh, capRIB, capVPN, capTCPMD5, capHandover},
+	speakerBuiltin: {capLinkBandwidth, capGracefulRestart},
+}
+
+// supports indicates whether the named backend has the given capability
+func supports(backend string, c capability) bool {
+	for _, have := range backendCapabilities[backend] {
+		if have == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requirement is the need of a configured setting for a capability
+type requirement struct {
+	// setting is the setting which needs the capability
+	setting string
+
+	capability capability
+
+	// fatal indicates that the configuration is invalid if a backend lacks
+	// the capability, because it would apply a materially different state
+	// (such as unweighted routes alongside weighted ones)
+	fatal bool
+}
+
+// requirements returns the capabilities needed by the configured settings
+func (c *KubeBGPConfig) requirements() (list []requirement) {
+	if c.LinkBandwidth != nil {
+		list = append(list, requirement{"linkBandwidth", capLinkBandwidth, true})
+	}
+
+	if c.GracefulRestartSeconds > 0 {
+		list = append(list, requirement{"gracefulRestartSeconds", capGracefulRestart, false})
+	}
+
+	if c.DeferTakeoverSeconds > 0 {
+		list = append(list, requirement{"deferTakeoverSeconds", capRIB, false})
+	}
+
+	// The routes of a tenant must never leak into the global table
+	if c.Tenants {
+		list = append(list, requirement{"tenants", capVPN, true})
+	}
+
+	// The built-in speaker runs within the instance, so two instances would contend for the BGP port
+	if c.Handover != nil && c.Handover.Enabled {
+		list = append(list, requirement{"handover", capHandover, true})
+	}
+
+	if len(c.Topologies) > 0 {
+		list = append(list, requirement{"topologies", capIBGPMesh, false})
+	}
+
+	if c.NodeGroups {
+		list = append(list, requirement{"nodeGroups", capIBGPMesh, false})
+	}
+
+	// A session configured with a password must never be established without it
+	if c.MeshPasswordFile != "" {
+		list = append(list, requirement{"meshPasswordFile", capTCPMD5, true})
+	}
+
+	for _, r := range c.Routers {
+		if r.PasswordFile != "" {
+			list = append(list, requirement{"passwordFile of router " + r.Ref(), capTCPMD5, true})
+		}
+	}
+
+	return list
+}
+
+// unsupportedFeatures returns a description of each configured setting
+// which a backend in use cannot express, and so ignores.  If any such
+// setting is fatal to the configuration (or every one is, with
+// strictCapabilities), an error describing it is returned instead.
+func (c *KubeBGPConfig) unsupportedFeatures() (warnings []string, err error) {
+	backends := append([]string{c.Speaker}, c.Outputs...)
+
+	for _, req := range c.requirements() {
+		for _, b := range backends {
+			if _, speaker := backendCapabilities[b]; !speaker || supports(b, req.capability) {
+				continue
+			}
+
+			msg := req.setting + " is not supported by " + b + " (" + string(req.capability) + ")"
+
+			if req.fatal || c.StrictCapabilities {
+				return nil, eris.New(msg)
+			}
+
+			warnings = append(warnings, msg+"; it is ignored")
+		}
+	}
+
+	return warnings, nil
+}
+
+// logUnsupportedFeatures logs the configured settings which a backend in use ignores
+func (c *KubeBGPConfig) logUnsupportedFeatures() {
+	warnings, _ := c.unsupportedFeatures() // nolint: errcheck
+
+	for _, w := range warnings {
+		log.Println("warning:", w)
+	}
+}
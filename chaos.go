@@ -0,0 +1,176 @@
+//go:build chaos
+// +build chaos
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+// chaosEnv is the environment variable which configures the faults injected
+// by chaos builds, as a comma-separated list of `fault=value` pairs:
+//
+//	notify-drop=0.2      drop 20% of the notifications of gobgpd
+//	api-delay=3s         delay each apiserver request by up to 3s
+//	render-corrupt=0.1   corrupt 10% of the rendered gobgpd configurations
+const chaosEnv = "KUBE_BGP_CHAOS"
+
+// chaosFaults describes the faults injected by a chaos build
+type chaosFaults struct {
+	// notifyDrop is the probability with which a notification is dropped
+	notifyDrop float64
+
+	// apiDelay is the maximum delay of an apiserver request
+	apiDelay time.Duration
+
+	// renderCorrupt is the probability with which a rendered configuration is corrupted
+	renderCorrupt float64
+}
+
+var faults = mustParseChaos(os.Getenv(chaosEnv))
+
+func mustParseChaos(spec string) *chaosFaults {
+	f, err := parseChaos(spec)
+	if err != nil {
+		log.Fatalln("invalid "+chaosEnv+":", err)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	log.Printf("chaos build: dropping %.0f%% of notifications, delaying apiserver requests by up to %s, corrupting %.0f%% of renders", f.notifyDrop*100, f.apiDelay, f.renderCorrupt*100)
+
+	return f
+}
+
+func parseChaos(spec string) (*chaosFaults, error) {
+	f := new(chaosFaults)
+
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, eris.Errorf("invalid fault %q", pair)
+		}
+
+		var err error
+
+		switch kv[0] {
+		case "notify-drop":
+			f.notifyDrop, err = parseProbability(kv[1])
+		case "api-delay":
+			f.apiDelay, err = time.ParseDuration(kv[1])
+		case "render-corrupt":
+			f.renderCorrupt, err = parseProbability(kv[1])
+		default:
+			return nil, eris.Errorf("unknown fault %q", kv[0])
+		}
+
+		if err != nil {
+			return nil, eris.Wrapf(err, "invalid %s", kv[0])
+		}
+	}
+
+	return f, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if p < 0 || p > 1 {
+		return 0, eris.Errorf("probability %s must be between 0 and 1", s)
+	}
+
+	return p, nil
+}
+
+// chaosNotifier wraps the given Notifier so that it drops notifications
+func chaosNotifier(n Notifier) Notifier {
+	if faults.notifyDrop == 0 {
+		return n
+	}
+
+	return &droppingNotifier{Notifier: n}
+}
+
+type droppingNotifier struct {
+	Notifier
+}
+
+func (n *droppingNotifier) Notify(filename string) error {
+	if rand.Float64() < faults.notifyDrop { // nolint: gosec
+		log.Printf("chaos: dropped notification of %s", filename)
+		return nil
+	}
+
+	return n.Notifier.Notify(filename)
+}
+
+// chaosTransport wraps the given apiserver transport so that it delays requests
+func chaosTransport(rt http.RoundTripper) http.RoundTripper {
+	if faults.apiDelay == 0 {
+		return rt
+	}
+
+	return delayingTransport{rt}
+}
+
+type delayingTransport struct {
+	http.RoundTripper
+}
+
+func (t delayingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := time.Duration(rand.Int63n(int64(faults.apiDelay))) // nolint: gosec
+
+	select {
+	case <-time.After(delay):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// chaosCorruptRender corrupts the rendered configuration in the given file,
+// by truncating it before one of its tables (or at its end) and appending a
+// line which is not TOML at all, so that it never parses
+func chaosCorruptRender(filename string) {
+	if faults.renderCorrupt == 0 || rand.Float64() >= faults.renderCorrupt { // nolint: gosec
+		return
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	cuts := []int{len(data)}
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] == '\n' && data[i+1] == '[' {
+			cuts = append(cuts, i+1)
+		}
+	}
+
+	data = append(data[:cuts[rand.Intn(len(cuts))]], "\n\x00corrupted by chaos\n"...) // nolint: gosec
+
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil { // nolint: gosec
+		log.Printf("chaos: failed to corrupt %s: %v", filename, err)
+		return
+	}
+
+	log.Printf("chaos: corrupted %s", filename)
+}
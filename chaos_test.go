@@ -0,0 +1,188 @@
+//go:build chaos
+// +build chaos
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withFaults injects the given faults for the duration of the test
+func withFaults(t *testing.T, f *chaosFaults) {
+	t.Helper()
+
+	saved := faults
+	faults = f
+	t.Cleanup(func() { faults = saved })
+}
+
+func TestParseChaos(t *testing.T) {
+	f, err := parseChaos("notify-drop=0.2, api-delay=3s,render-corrupt=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.notifyDrop != 0.2 || f.apiDelay != 3*time.Second || f.renderCorrupt != 1 {
+		t.Errorf("unexpected faults %+v", f)
+	}
+
+	if f, err := parseChaos(""); err != nil || *f != (chaosFaults{}) {
+		t.Errorf("expected no faults from an empty spec, got %+v, %v", f, err)
+	}
+
+	for _, spec := range []string{"notify-drop", "notify-drop=1.5", "notify-drop=-1", "api-delay=soon", "disk-full=1"} {
+		if _, err := parseChaos(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+// countingNotifier is a fake Notifier which counts its notifications
+type countingNotifier struct {
+	notified int
+}
+
+func (n *countingNotifier) Notify(filename string) error {
+	n.notified++
+	return nil
+}
+
+func (n *countingNotifier) Check() error {
+	return nil
+}
+
+func TestChaosNotifier(t *testing.T) {
+	fake := new(countingNotifier)
+
+	withFaults(t, &chaosFaults{})
+	if chaosNotifier(fake) != Notifier(fake) {
+		t.Error("notifier was wrapped without any notification fault")
+	}
+
+	withFaults(t, &chaosFaults{notifyDrop: 1})
+	n := chaosNotifier(fake)
+	for i := 0; i < 10; i++ {
+		if err := n.Notify("gobgpd.conf"); err != nil {
+			t.Fatalf("a dropped notification failed: %v", err)
+		}
+	}
+	if fake.notified != 0 {
+		t.Errorf("%d notifications were not dropped", fake.notified)
+	}
+
+	withFaults(t, &chaosFaults{notifyDrop: 0.5})
+	n = chaosNotifier(fake)
+	for i := 0; i < 1000; i++ {
+		n.Notify("gobgpd.conf") // nolint: errcheck
+	}
+	if fake.notified == 0 || fake.notified == 1000 {
+		t.Errorf("%d of 1000 notifications were delivered; expected about half", fake.notified)
+	}
+}
+
+// roundTripFunc is a fake apiserver transport
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestChaosTransport(t *testing.T) {
+	var requests int
+	fake := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	withFaults(t, &chaosFaults{apiDelay: 50 * time.Millisecond})
+	rt := chaosTransport(fake)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://apiserver/api/v1/nodes", nil)
+	if _, err := rt.RoundTrip(req); err != nil || requests != 1 {
+		t.Fatalf("delayed request failed: %v", err)
+	}
+
+	// A request cancelled while delayed fails without reaching the apiserver.
+	withFaults(t, &chaosFaults{apiDelay: time.Hour})
+	rt = chaosTransport(fake)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := rt.RoundTrip(req.WithContext(ctx)); err == nil {
+		t.Error("cancelled request succeeded")
+	}
+	if requests != 1 {
+		t.Error("cancelled request reached the apiserver")
+	}
+}
+
+// TestCorruptRenderRepaired verifies that a corrupted gobgpd configuration
+// never parses, and does not persist: the next render replaces it with the
+// desired one.  The corruption is random, so it is repeated.
+func TestCorruptRenderRepaired(t *testing.T) {
+	const fixture = "fixtures/topologies/single-router"
+
+	cfg, err := loadConfig(filepath.Join(fixture, "kube-bgp.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _, err := (&StateCache{Path: filepath.Join(fixture, "state.json")}).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "kube-bgp-chaos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	filename := filepath.Join(dir, "gobgpd.conf")
+	node := state.Nodes[0].Name
+
+	render := func() []byte {
+		t.Helper()
+
+		if err := export(filename, node, cfg, state, peers(node, cfg, state), localRouters(node, cfg, state)); err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	desired := render()
+
+	withFaults(t, &chaosFaults{renderCorrupt: 1})
+
+	for i := 0; i < 100; i++ {
+		chaosCorruptRender(filename)
+
+		corrupted, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(corrupted) == string(desired) {
+			t.Fatal("configuration was not corrupted")
+		}
+		if _, err := parseTOML(corrupted); err == nil {
+			t.Fatalf("corrupted configuration still parses:\n%s", corrupted)
+		}
+
+		if string(render()) != string(desired) {
+			t.Fatal("next render did not restore the desired configuration")
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/rotisserie/eris"
+	authv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// bgpPort is the well-known BGP port
+const bgpPort = 179
+
+// checkTimeout is the amount of time to wait for any single network check
+var checkTimeout = 5 * time.Second
+
+// requiredAccess is the list of kubernetes API permissions which kube-bgp requires
+var requiredAccess = []authv1.ResourceAttributes{
+	{Verb: "list", Resource: "nodes"},
+	{Verb: "watch", Resource: "nodes"},
+	{Verb: "list", Resource: "services"},
+	{Verb: "watch", Resource: "services"},
+	{Verb: "create", Resource: "events"},
+}
+
+// podAccess is the list of additional kubernetes API permissions which kube-bgp requires when Pods are watched
+var podAccess = []authv1.ResourceAttributes{
+	{Verb: "list", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+}
+
+// egressAccess is the list of additional kubernetes API permissions which kube-bgp requires when EgressIPs are watched
+var egressAccess = []authv1.ResourceAttributes{
+	{Verb: "list", Group: v1alpha1.Group, Resource: "egressips"},
+	{Verb: "watch", Group: v1alpha1.Group, Resource: "egressips"},
+	{Verb: "update", Group: v1alpha1.Group, Resource: "egressips", Subresource: "status"},
+}
+
+// nodeGroupAccess is the list of additional kubernetes API permissions which kube-bgp requires when BGPNodeGroups are watched
+var nodeGroupAccess = []authv1.ResourceAttributes{
+	{Verb: "list", Group: v1alpha1.Group, Resource: "bgpnodegroups"},
+	{Verb: "watch", Group: v1alpha1.Group, Resource: "bgpnodegroups"},
+}
+
+// tenantAccess is the list of additional kubernetes API permissions which kube-bgp requires when BGPTenants are watched
+var tenantAccess = []authv1.ResourceAttributes{
+	{Verb: "list", Group: v1alpha1.Group, Resource: "bgptenants"},
+	{Verb: "watch", Group: v1alpha1.Group, Resource: "bgptenants"},
+}
+
+// maintenanceAccess is the list of additional kubernetes API permissions
+// which kube-bgp requires when BGPRouterMaintenances are watched, including
+// those by which the admin API disables and enables Routers
+var maintenanceAccess = []authv1.ResourceAttributes{
+	{Verb: "list", Group: v1alpha1.Group, Resource: "bgproutermaintenances"},
+	{Verb: "watch", Group: v1alpha1.Group, Resource: "bgproutermaintenances"},
+	{Verb: "create", Group: v1alpha1.Group, Resource: "bgproutermaintenances"},
+	{Verb: "delete", Group: v1alpha1.Group, Resource: "bgproutermaintenances"},
+}
+
+// nodeStatusAccess is the list of additional kubernetes API permissions which kube-bgp requires to write BGPNodeStatuses
+var nodeStatusAccess = []authv1.ResourceAttributes{
+	{Verb: "get", Group: v1alpha1.Group, Resource: "bgpnodestatuses"},
+	{Verb: "create", Group: v1alpha1.Group, Resource: "bgpnodestatuses"},
+	{Verb: "update", Group: v1alpha1.Group, Resource: "bgpnodestatuses"},
+}
+
+// announceAccess is the list of additional kubernetes API permissions which kube-bgp requires to serve the announcement API
+var announceAccess = []authv1.ResourceAttributes{
+	{Verb: "create", Group: "authentication.k8s.io", Resource: "tokenreviews"},
+}
+
+// freezeAccess returns the list of additional kubernetes API permissions
+// which kube-bgp requires to check the freeze annotation of the given Namespace
+func freezeAccess(namespace string) []authv1.ResourceAttributes {
+	return []authv1.ResourceAttributes{{Verb: "get", Resource: "namespaces", Name: namespace}}
+}
+
+// leaseAccess returns the list of additional kubernetes API permissions which
+// kube-bgp requires to elect route reflectors by Leases in the given namespace
+func leaseAccess(namespace string) []authv1.ResourceAttributes {
+	var list []authv1.ResourceAttributes
+	for _, verb := range []string{"list", "create", "update", "delete"} {
+		list = append(list, authv1.ResourceAttributes{Namespace: namespace, Verb: verb, Group: "coordination.k8s.io", Resource: "leases"})
+	}
+
+	return list
+}
+
+// routerIDAccess returns the list of additional kubernetes API permissions
+// which kube-bgp requires to allocate router-ids by Leases in the given namespace
+func routerIDAccess(namespace string) []authv1.ResourceAttributes {
+	list := []authv1.ResourceAttributes{
+		{Verb: "get", Resource: "nodes"},
+		{Verb: "update", Resource: "nodes"},
+	}
+	for _, verb := range []string{"get", "list", "create"} {
+		list = append(list, authv1.ResourceAttributes{Namespace: namespace, Verb: verb, Group: "coordination.k8s.io", Resource: "leases"})
+	}
+
+	return list
+}
+
+// dnsAccess returns the list of additional kubernetes API permissions which
+// kube-bgp requires to publish DNSEndpoints in the given namespace
+func dnsAccess(namespace string) []authv1.ResourceAttributes {
+	var list []authv1.ResourceAttributes
+	for _, verb := range []string{"get", "create", "update"} {
+		list = append(list, authv1.ResourceAttributes{Namespace: namespace, Verb: verb, Group: dnsEndpointResource.Group, Resource: dnsEndpointResource.Resource})
+	}
+
+	return list
+}
+
+// bootstrapAccess returns the list of additional kubernetes API permissions
+// which kube-bgp requires to check the given bootstrap conditions
+func bootstrapAccess(b *Bootstrap) (list []authv1.ResourceAttributes) {
+	for _, ref := range b.Deployments {
+		namespace, name, _ := splitDeployment(ref)
+		list = append(list, authv1.ResourceAttributes{Namespace: namespace, Verb: "get", Group: "apps", Resource: "deployments", Name: name})
+	}
+
+	return list
+}
+
+// precondition is an external requirement verified by the `check` command
+type precondition struct {
+	name string
+	run  func() error
+}
+
+// runCheck implements the `check` command, which verifies all external
+// preconditions of kube-bgp and prints a pass/fail report.  It returns the
+// process exit code, which is non-zero if any check failed.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	skip := fs.String("skip", "", "comma-separated list of checks to skip (e.g. `gobgpd,port` in an init container)")
+	fs.Parse(args) // nolint: errcheck
+
+	ctx := context.Background()
+
+	skipped := make(map[string]bool)
+	for _, name := range strings.Split(*skip, ",") {
+		skipped[strings.TrimSpace(name)] = true
+	}
+
+	nodeName := os.Getenv("NODE_NAME")
+
+	cfg, cfgErr := loadConfig(configFile)
+	var clientset kubernetes.Interface
+	kubeconfig, clientErr := kubeConfig()
+	if clientErr == nil {
+		clientset, clientErr = newClientset(kubeconfig)
+	}
+
+	checks := []precondition{
+		{"config", func() error { return cfgErr }},
+		{"node", func() error { return checkNode(ctx, clientset, clientErr, nodeName) }},
+		{"rbac", func() error { return checkRBAC(ctx, clientset, clientErr, cfg) }},
+		{"output", func() error { return checkOutput(cfg, outputFile) }},
+		{"gobgpd", func() error { return checkGoBGPD(cfg) }},
+		{"notify", func() error { return checkNotify(cfg) }},
+		{"ipam", func() error { return checkIPAM(cfg) }},
+		{"auth", func() error { return checkAuth(cfg) }},
+		{"port", checkPort},
+	}
+
+	var failed bool
+
+	for _, c := range checks {
+		if skipped[c.name] {
+			fmt.Printf("SKIP  %s\n", c.name)
+			continue
+		}
+
+		if err := c.run(); err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", c.name, err)
+			continue
+		}
+
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+func checkNode(ctx context.Context, clientset kubernetes.Interface, clientErr error, nodeName string) error {
+	if nodeName == "" {
+		return eris.New("NODE_NAME must be set")
+	}
+
+	if clientErr != nil {
+		return clientErr
+	}
+
+	return verifyLocalNode(ctx, clientset, nodeName)
+}
+
+func checkRBAC(ctx context.Context, clientset kubernetes.Interface, clientErr error, cfg *KubeBGPConfig) error {
+	if clientErr != nil {
+		return clientErr
+	}
+
+	access := requiredAccess
+	if cfg != nil && cfg.watchesPods() {
+		access = append(access, podAccess...)
+	}
+	if cfg != nil && cfg.EgressIPs != nil {
+		access = append(access, egressAccess...)
+	}
+	if cfg != nil && cfg.Bootstrap != nil {
+		access = append(access, bootstrapAccess(cfg.Bootstrap)...)
+	}
+	if cfg != nil && cfg.AnnounceAPI != nil {
+		access = append(access, announceAccess...)
+	}
+	if cfg != nil && cfg.FreezeNamespace != "" {
+		access = append(access, freezeAccess(cfg.FreezeNamespace)...)
+	}
+	if cfg != nil && cfg.DNS != nil {
+		access = append(access, dnsAccess(cfg.DNS.Namespace)...)
+	}
+	if cfg != nil && cfg.RouterIDPool != nil {
+		access = append(access, routerIDAccess(cfg.LeaseNamespace)...)
+	}
+	if cfg != nil && cfg.NodeGroups {
+		access = append(access, nodeGroupAccess...)
+		access = append(access, leaseAccess(cfg.LeaseNamespace)...)
+	}
+	if cfg != nil && cfg.Tenants {
+		access = append(access, tenantAccess...)
+	}
+	if cfg != nil && cfg.RouterMaintenance {
+		access = append(access, maintenanceAccess...)
+	}
+	if cfg != nil && cfg.NodeStatus {
+		access = append(access, nodeStatusAccess...)
+	}
+
+	var denied []string
+
+	for _, a := range access {
+		resource := a.Resource
+		if a.Subresource != "" {
+			resource += "/" + a.Subresource
+		}
+
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authv1.SelfSubjectAccessReview{
+			Spec: authv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &a,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return eris.Wrapf(err, "failed to review access to %s %s", a.Verb, resource)
+		}
+
+		if !review.Status.Allowed {
+			denied = append(denied, a.Verb+" "+resource)
+		}
+	}
+
+	if len(denied) > 0 {
+		return eris.Errorf("permission denied: %s", strings.Join(denied, ", "))
+	}
+
+	return nil
+}
+
+// checkNotify verifies that gobgpd can be notified of configuration changes
+func checkNotify(cfg *KubeBGPConfig) error {
+	if cfg == nil || !cfg.notifiesGoBGPD() {
+		return nil
+	}
+
+	return newNotifier(cfg).Check()
+}
+
+func checkOutput(cfg *KubeBGPConfig, filename string) error {
+	// No configuration file is written when gobgpd is configured through its API.
+	if cfg != nil && cfg.GoBGPDConfig == gobgpdConfigAPI {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".kube-bgp-check")
+	if err != nil {
+		return eris.Wrapf(err, "cannot write to %s", filepath.Dir(filename))
+	}
+
+	f.Close()           // nolint: errcheck
+	os.Remove(f.Name()) // nolint: errcheck
+
+	return nil
+}
+
+func checkGoBGPD(cfg *KubeBGPConfig) error {
+	// An embedded gobgp is only started by kube-bgp itself.
+	if cfg != nil && cfg.EmbeddedBGP {
+		return nil
+	}
+
+	addr := defaultGoBGPAPIAddress
+	if cfg != nil {
+		addr = cfg.GoBGPAPIAddress
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, checkTimeout)
+	if err != nil {
+		return eris.Wrapf(err, "gobgpd API unreachable at %s", addr)
+	}
+
+	return conn.Close()
+}
+
+// checkIPAM verifies that the permitted pools can be obtained from the IPAM, if one is configured
+func checkIPAM(cfg *KubeBGPConfig) error {
+	if cfg == nil || cfg.IPAM == nil {
+		return nil
+	}
+
+	source, err := cfg.IPAM.source()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	pools, err := source.Pools(ctx)
+	if err != nil {
+		return eris.Wrap(err, "IPAM unreachable")
+	}
+
+	if len(pools) == 0 {
+		return eris.New("IPAM permits no pools")
+	}
+
+	return nil
+}
+
+func checkPort() error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", bgpPort))
+	if err != nil {
+		return eris.Wrapf(err, "port %d is not available", bgpPort)
+	}
+
+	return l.Close()
+}
@@ -0,0 +1,241 @@
+// Command kubectl-bgp is a kubectl plugin which aggregates the status of
+// the kube-bgp instances of a cluster into cluster-wide overviews of their
+// sessions and advertisements.  Installed on the PATH, it is run as
+// `kubectl bgp`.  It reaches each kube-bgp Pod by way of the apiserver Pod
+// proxy, using the kubectl of the current context.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/history"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// instance is the status of a single kube-bgp Pod
+type instance struct {
+	Pod    string
+	Report status.Report
+	Peers  map[string][]history.Transition
+}
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	namespace := fs.String("n", "kube-system", "namespace of the kube-bgp Pods")
+	selector := fs.String("l", "app=kube-bgp", "label selector of the kube-bgp Pods")
+	port := fs.Int("port", 8080, "port of the kube-bgp status endpoint")
+	service := fs.String("service", "", "show only the routes of the given `[namespace/]name` Service")
+	fs.Parse(os.Args[2:]) // nolint: errcheck
+
+	switch os.Args[1] {
+	case "peers", "routes":
+	default:
+		usage()
+	}
+
+	instances, err := collect(*namespace, *selector, *port)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush() // nolint: errcheck
+
+	if os.Args[1] == "peers" {
+		printPeers(w, instances)
+		return
+	}
+
+	var ips []net.IP
+	if *service != "" {
+		if ips, err = serviceIPs(*service); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	printRoutes(w, instances, ips)
+}
+
+func usage() {
+	log.Fatalln("usage: kubectl bgp peers|routes [-n namespace] [-l selector] [-port port] [-service [namespace/]name]")
+}
+
+// kubectl runs kubectl with the given arguments, returning its output
+func kubectl(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, eris.Wrapf(err, "kubectl %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// getJSON decodes the output of kubectl with the given arguments
+func getJSON(v interface{}, args ...string) error {
+	out, err := kubectl(args...)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(out, v)
+}
+
+// collect obtains the status of each running kube-bgp Pod.  Pods whose
+// status cannot be obtained are reported and skipped.
+func collect(namespace, selector string, port int) (list []instance, err error) {
+	pods := new(v1.PodList)
+	if err := getJSON(pods, "get", "pods", "-n", namespace, "-l", selector, "-o", "json"); err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+
+		base := "/api/v1/namespaces/" + namespace + "/pods/" + pod.Name + ":" + strconv.Itoa(port) + "/proxy"
+
+		i := instance{Pod: pod.Name}
+		if err := getJSON(&i.Report, "get", "--raw", base+"/status"); err != nil {
+			log.Printf("skipping %s: %v", pod.Name, err)
+			continue
+		}
+		if err := getJSON(&i.Peers, "get", "--raw", base+"/status/peers"); err != nil {
+			log.Printf("skipping %s: %v", pod.Name, err)
+			continue
+		}
+		if i.Report.Node == "" {
+			i.Report.Node = pod.Spec.NodeName
+		}
+
+		list = append(list, i)
+	}
+
+	sort.Slice(list, func(a, b int) bool { return list[a].Report.Node < list[b].Report.Node })
+
+	return list, nil
+}
+
+// printPeers prints the sessions of every Node, with the most recent state of each
+func printPeers(w *tabwriter.Writer, instances []instance) {
+	fmt.Fprintln(w, "NODE\tPEER\tNAME\tSTATE\tSINCE\tREASON")
+
+	for _, i := range instances {
+		names := make(map[string]string)
+		if i.Report.Desired != nil {
+			for _, s := range append(i.Report.Desired.Peers, i.Report.Desired.Routers...) {
+				names[s.Address] = s.Name
+			}
+		}
+
+		addrs := make([]string, 0, len(i.Peers))
+		for addr := range i.Peers {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+
+		for _, addr := range addrs {
+			transitions := i.Peers[addr]
+			if len(transitions) == 0 {
+				continue
+			}
+			last := transitions[len(transitions)-1]
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", i.Report.Node, addr, names[addr], last.State, last.Time.Format(time.RFC3339), last.Reason)
+		}
+	}
+}
+
+// printRoutes prints the routes originated by every Node, or only those
+// covering the given IPs, if any are given
+func printRoutes(w *tabwriter.Writer, instances []instance, ips []net.IP) {
+	fmt.Fprintln(w, "NODE\tPREFIX\tNEXTHOP\tCOMMUNITIES\tSOURCE")
+
+	for _, i := range instances {
+		if i.Report.Desired == nil {
+			log.Printf("%s does not publish its routes; add `status` to its outputs", i.Pod)
+			continue
+		}
+
+		for _, r := range i.Report.Desired.Routes {
+			if len(ips) > 0 && !covers(r.Prefix, ips) {
+				continue
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", i.Report.Node, r.Prefix, r.NextHop, strings.Join(r.Communities, ","), r.Source)
+		}
+	}
+}
+
+// covers indicates whether the given prefix contains any of the given IPs
+func covers(prefix string, ips []net.IP) bool {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return false
+	}
+
+	for _, ip := range ips {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceIPs returns the addresses of the given `[namespace/]name` Service
+// which may be advertised: its cluster, external, and load balancer IPs
+func serviceIPs(ref string) ([]net.IP, error) {
+	namespace, name := "default", ref
+	if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+
+	svc := new(v1.Service)
+	if err := getJSON(svc, "get", "service", "-n", namespace, name, "-o", "json"); err != nil {
+		return nil, err
+	}
+
+	addrs := append([]string{svc.Spec.ClusterIP}, svc.Spec.ExternalIPs...)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		addrs = append(addrs, ingress.IP)
+	}
+
+	var ips []net.IP
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, eris.Errorf("service %s/%s has no addresses", namespace, name)
+	}
+
+	return ips, nil
+}
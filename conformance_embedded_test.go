@@ -0,0 +1,55 @@
+//go:build embedded
+// +build embedded
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+)
+
+// TestConformanceGoBGPD runs the conformance suite against the embedded
+// gobgp server, which the gobgpd backend starts on the BGP port
+func TestConformanceGoBGPD(t *testing.T) {
+	if l, err := net.Listen("tcp", net.JoinHostPort("", "179")); err != nil {
+		t.Skip("the BGP port is not available:", err)
+	} else {
+		l.Close() // nolint: errcheck
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close() // nolint: errcheck
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if err := gobgp.StartEmbedded(ctx, address); err != nil {
+		t.Fatal(err)
+	}
+
+	c := gobgp.New(address)
+	t.Cleanup(func() { c.Close() }) // nolint: errcheck
+
+	// The API is served asynchronously, so it is waited for.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, err = c.Version(ctx)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("failed to reach the embedded server:", err)
+	}
+
+	testConformance(t, newGoBGPDBackend(c))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/model"
+	"github.com/CyCoreSystems/kube-bgp/model/conformance"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/speaker"
+)
+
+// builtinBackend applies the desired-state model to the built-in speaker
+type builtinBackend struct {
+	speaker    *speaker.Speaker
+	advertiser *routes.Advertiser
+}
+
+func newBuiltinBackend(s *speaker.Speaker) *builtinBackend {
+	return &builtinBackend{
+		speaker:    s,
+		advertiser: routes.NewAdvertiser(s),
+	}
+}
+
+// Name implements model.Backend
+func (b *builtinBackend) Name() string {
+	return speakerBuiltin
+}
+
+// Features implements model.Backend.  The built-in speaker announces every
+// route to every neighbor, so it has no export policy.
+func (b *builtinBackend) Features() []model.Feature {
+	return []model.Feature{model.FeatureNeighbors, model.FeatureAttributes, model.FeatureCommunities, model.FeatureLinkBandwidth, model.FeatureIPv6}
+}
+
+// Apply implements model.Backend
+func (b *builtinBackend) Apply(ctx context.Context, d *model.Desired) error {
+	var neighbors []speaker.Neighbor
+	for _, n := range d.Neighbors {
+		neighbors = append(neighbors, speaker.Neighbor{Address: n.Address, ASN: n.ASN, Port: n.Port})
+	}
+	b.speaker.SetNeighbors(neighbors)
+
+	return b.advertiser.Apply(ctx, d.Routes)
+}
+
+// Observe implements model.Backend
+func (b *builtinBackend) Observe(ctx context.Context) (*model.Desired, error) {
+	d := &model.Desired{Routes: b.speaker.Routes()}
+
+	for _, n := range b.speaker.Neighbors() {
+		d.Neighbors = append(d.Neighbors, model.Neighbor{Address: n.Address, ASN: n.ASN, Port: n.Port})
+	}
+
+	return d, nil
+}
+
+// gobgpdBackend applies the desired-state model to gobgpd through its API,
+// by the same gobgpdSessionApplier by which kube-bgp applies its neighbors
+type gobgpdBackend struct {
+	client     *gobgp.Client
+	applier    *gobgpdSessionApplier
+	advertiser *routes.Advertiser
+}
+
+func newGoBGPDBackend(client *gobgp.Client) *gobgpdBackend {
+	return &gobgpdBackend{
+		client:     client,
+		applier:    newGoBGPDSessionApplier(client),
+		advertiser: routes.NewAdvertiser(client),
+	}
+}
+
+// Name implements model.Backend
+func (b *gobgpdBackend) Name() string {
+	return speakerGoBGPD
+}
+
+// Features implements model.Backend.  gobgpd cannot be given the
+// link-bandwidth community.  The export policies which kube-bgp gives it are
+// those of its Topologies and Routers, rather than a list of prefixes per
+// neighbor, so the export policy of the model is not applied.
+func (b *gobgpdBackend) Features() []model.Feature {
+	return []model.Feature{model.FeatureNeighbors, model.FeatureAttributes, model.FeatureCommunities, model.FeatureIPv6}
+}
+
+// Apply implements model.Backend
+func (b *gobgpdBackend) Apply(ctx context.Context, d *model.Desired) error {
+	c := &gobgpdConfig{ASN: d.ASN, RouterID: d.RouterID}
+	for _, n := range d.Neighbors {
+		c.Neighbors = append(c.Neighbors, gobgpdNeighbor{Address: n.Address, ASN: n.ASN, LocalASN: n.LocalASN, Port: n.Port, Description: n.Name})
+	}
+
+	if err := b.applier.Apply(ctx, c); err != nil {
+		return err
+	}
+
+	return b.advertiser.Apply(ctx, d.Routes)
+}
+
+// Observe implements model.Backend
+func (b *gobgpdBackend) Observe(ctx context.Context) (*model.Desired, error) {
+	d := new(model.Desired)
+
+	neighbors, err := b.client.Neighbors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range neighbors {
+		d.Neighbors = append(d.Neighbors, model.Neighbor{Address: n.Address, ASN: n.ASN})
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		list, err := b.client.Originated(ctx, family)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Routes = append(d.Routes, list...)
+	}
+
+	return d, nil
+}
+
+// ensure the backends implement model.Backend
+var (
+	_ model.Backend = (*builtinBackend)(nil)
+	_ model.Backend = (*gobgpdBackend)(nil)
+)
+
+// testConformance runs every case of the conformance suite against the
+// given Backend, as a subtest each, skipping those which need a feature
+// which it does not support
+func testConformance(t *testing.T, b model.Backend) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	for _, c := range conformance.Cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			res := conformance.RunCase(ctx, b, c)
+
+			switch {
+			case len(res.Unsupported) > 0:
+				t.Skipf("%s does not support %v", b.Name(), res.Unsupported)
+			case res.Err != nil:
+				t.Error(res.Err)
+			}
+		})
+	}
+
+	if err := b.Apply(ctx, &model.Desired{ASN: conformance.ASN, RouterID: conformance.RouterID}); err != nil {
+		t.Error("failed to clean up:", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testConformance(t, new(model.Memory))
+	})
+
+	t.Run(speakerBuiltin, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		s, err := speaker.New(ctx, speaker.Config{ASN: conformance.ASN, RouterID: net.ParseIP(conformance.RouterID)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testConformance(t, newBuiltinBackend(s))
+	})
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/history"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Dashboard is the consolidated BGP state of the cluster, as gathered from
+// the status endpoints of every kube-bgp instance
+type Dashboard struct {
+	// Time is the time at which the state was gathered
+	Time time.Time `json:"time"`
+
+	// Nodes describes the instance on each Node, by Node name
+	Nodes map[string]*DashboardNode `json:"nodes"`
+
+	// Mesh describes the health of the iBGP sessions between the Nodes
+	Mesh MeshHealth `json:"mesh"`
+
+	// Routers is the state of the session of each Node to each Router: by Router address, then by Node name
+	Routers map[string]map[string]string `json:"routers"`
+
+	// Prefixes is the list of Nodes which originate each prefix
+	Prefixes map[string][]string `json:"prefixes"`
+
+	// Conflicts is the list of Nodes which each claim exclusive origination
+	// of the same prefix, by prefix
+	Conflicts map[string][]string `json:"conflicts,omitempty"`
+
+	// Diverged is the list of Nodes which last applied a configuration other
+	// than that of the most Nodes
+	Diverged []string `json:"diverged,omitempty"`
+}
+
+// DashboardNode describes the kube-bgp instance on a single Node
+type DashboardNode struct {
+	// Pod is the name of the kube-bgp Pod
+	Pod string `json:"pod"`
+
+	// Error is the reason, if any, that the status of the instance could not be obtained
+	Error string `json:"error,omitempty"`
+
+	// Errors is the number of errors reported by the instance, by category
+	Errors map[errcode.Code]uint64 `json:"errors,omitempty"`
+
+	// Warnings is the list of hazards reported by the instance
+	Warnings []string `json:"warnings,omitempty"`
+
+	// ConfigHash is the short hash of the configuration last applied by the instance
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// AppliedHash is the short hash of the desired state last applied by the instance
+	AppliedHash string `json:"appliedHash,omitempty"`
+}
+
+// MeshHealth describes the health of the iBGP sessions between the Nodes
+type MeshHealth struct {
+	// Expected is the number of desired iBGP sessions
+	Expected int `json:"expected"`
+
+	// Established is the number of desired iBGP sessions which are established
+	Established int `json:"established"`
+
+	// Down is the list of desired iBGP sessions which are not established, each as `<node> -> <peer>`
+	Down []string `json:"down,omitempty"`
+}
+
+// dashboardTemplate renders a Dashboard as HTML
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html><head><title>kube-bgp</title>
+<style>body{font-family:sans-serif} table{border-collapse:collapse;margin-bottom:2em} td,th{border:1px solid #ccc;padding:2px 8px}</style>
+</head><body>
+<h1>kube-bgp</h1>
+<p>Gathered {{ .Time.Format "2006-01-02 15:04:05 MST" }}</p>
+<h2>Mesh</h2>
+<p>{{ .Mesh.Established }} of {{ .Mesh.Expected }} iBGP sessions established</p>
+{{ if .Mesh.Down }}<ul>{{ range .Mesh.Down }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}
+<h2>Nodes</h2>
+{{ if .Diverged }}<p>Diverged from the configuration of the most nodes: {{ range .Diverged }}{{ . }} {{ end }}</p>{{ end }}
+<table><tr><th>Node</th><th>Pod</th><th>Config</th><th>Errors</th><th>Warnings</th></tr>
+{{ range $name, $n := .Nodes }}<tr><td>{{ $name }}</td><td>{{ $n.Pod }}</td><td>{{ $n.ConfigHash }}</td><td>{{ if $n.Error }}{{ $n.Error }}{{ else }}{{ range $code, $count := $n.Errors }}{{ $code }}: {{ $count }} {{ end }}{{ end }}</td><td>{{ range $n.Warnings }}{{ . }}<br>{{ end }}</td></tr>
+{{ end }}</table>
+<h2>Routers</h2>
+<table><tr><th>Router</th><th>Sessions</th></tr>
+{{ range $router, $sessions := .Routers }}<tr><td>{{ $router }}</td><td>{{ range $node, $state := $sessions }}{{ $node }}: {{ $state }}<br>{{ end }}</td></tr>
+{{ end }}</table>
+{{ if .Conflicts }}<h2>Conflicts</h2>
+<table><tr><th>Prefix</th><th>Claimed exclusively by</th></tr>
+{{ range $prefix, $nodes := .Conflicts }}<tr><td>{{ $prefix }}</td><td>{{ range $nodes }}{{ . }} {{ end }}</td></tr>
+{{ end }}</table>{{ end }}
+<h2>Prefixes</h2>
+<table><tr><th>Prefix</th><th>Originated by</th></tr>
+{{ range $prefix, $nodes := .Prefixes }}<tr><td>{{ $prefix }}</td><td>{{ range $nodes }}{{ . }} {{ end }}</td></tr>
+{{ end }}</table>
+</body></html>
+`))
+
+// runDashboard implements the `dashboard` command, an optional aggregator
+// which periodically gathers the status of every kube-bgp Pod and serves the
+// consolidated Dashboard as JSON (`/dashboard.json`) and HTML (`/`)
+func runDashboard(args []string) int {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	listen := fs.String("listen", ":8090", "address on which to serve the dashboard")
+	namespace := fs.String("n", "kube-system", "namespace of the kube-bgp Pods")
+	selector := fs.String("l", "app=kube-bgp", "label selector of the kube-bgp Pods")
+	port := fs.Int("port", 8080, "port of the kube-bgp status endpoint")
+	interval := fs.Duration("interval", 30*time.Second, "interval at which the status is gathered")
+	fs.Parse(args) // nolint: errcheck
+
+	kubeconfig, err := kubeConfig()
+	if err != nil {
+		log.Println("failed to create kubernetes client:", err)
+		return 1
+	}
+
+	clientset, err := newClientset(kubeconfig)
+	if err != nil {
+		log.Println("failed to create kubernetes client:", err)
+		return 1
+	}
+
+	var mu sync.Mutex
+	current := &Dashboard{Time: time.Now()}
+
+	conflicts := newConflictReporter(clientset)
+
+	ctx := context.Background()
+
+	go func() {
+		for {
+			d, err := gatherDashboard(ctx, clientset, *namespace, *selector, *port)
+			if err != nil {
+				log.Println("failed to gather status:", err)
+			} else {
+				conflicts.Report(d.Conflicts)
+
+				mu.Lock()
+				current = d
+				mu.Unlock()
+			}
+
+			time.Sleep(*interval)
+		}
+	}()
+
+	get := func() *Dashboard {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return current
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/dashboard.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(get()); err != nil {
+			log.Println("failed to write dashboard:", err)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := dashboardTemplate.Execute(w, get()); err != nil {
+			log.Println("failed to write dashboard:", err)
+		}
+	})
+
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		log.Println("failed to serve dashboard:", err)
+	}
+
+	return 1
+}
+
+// proxyGet gets the given path of the status endpoint of the given Pod, by way of the apiserver Pod proxy
+func proxyGet(ctx context.Context, clientset kubernetes.Interface, namespace, pod string, port int, path string, v interface{}) error {
+	data, err := clientset.CoreV1().RESTClient().Get().
+		Namespace(namespace).
+		Resource("pods").
+		Name(pod + ":" + strconv.Itoa(port)).
+		SubResource("proxy").
+		Suffix(path).
+		DoRaw(ctx)
+	if err != nil {
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get %s of pod %s", path, pod)
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// gatherDashboard gathers the status of every running kube-bgp Pod into a Dashboard
+func gatherDashboard(ctx context.Context, clientset kubernetes.Interface, namespace, selector string, port int) (*Dashboard, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to list kube-bgp pods")
+	}
+
+	d := &Dashboard{
+		Time:      time.Now(),
+		Nodes:     make(map[string]*DashboardNode),
+		Routers:   make(map[string]map[string]string),
+		Prefixes:  make(map[string][]string),
+		Conflicts: make(map[string][]string),
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+
+		n := &DashboardNode{Pod: pod.Name}
+		d.Nodes[pod.Spec.NodeName] = n
+
+		var report status.Report
+		var peers map[string][]history.Transition
+
+		if err := proxyGet(ctx, clientset, namespace, pod.Name, port, "status", &report); err != nil {
+			n.Error = err.Error()
+			continue
+		}
+		if err := proxyGet(ctx, clientset, namespace, pod.Name, port, "status/peers", &peers); err != nil {
+			n.Error = err.Error()
+			continue
+		}
+
+		d.addInstance(pod.Spec.NodeName, n, &report, peers)
+	}
+
+	sort.Strings(d.Mesh.Down)
+	for _, nodes := range d.Prefixes {
+		sort.Strings(nodes)
+	}
+	for prefix, nodes := range d.Conflicts {
+		if len(nodes) < 2 {
+			delete(d.Conflicts, prefix)
+			continue
+		}
+		sort.Strings(nodes)
+	}
+
+	hashes := make(map[string]string, len(d.Nodes))
+	for name, n := range d.Nodes {
+		hashes[name] = n.ConfigHash
+	}
+	d.Diverged = divergedNodes(hashes)
+
+	return d, nil
+}
+
+// addInstance adds the status of the kube-bgp instance of the named Node to the Dashboard
+func (d *Dashboard) addInstance(node string, n *DashboardNode, report *status.Report, peers map[string][]history.Transition) {
+	n.Warnings = report.Warnings
+	n.ConfigHash = report.ConfigHash
+	n.AppliedHash = report.AppliedHash
+	n.Errors = make(map[errcode.Code]uint64, len(report.Errors))
+	for code, e := range report.Errors {
+		n.Errors[code] = e.Count
+	}
+
+	state := func(addr string) string {
+		if list := peers[addr]; len(list) > 0 {
+			return list[len(list)-1].State
+		}
+
+		return "unknown"
+	}
+
+	if report.Desired == nil {
+		// Without the status output, only the observed sessions are known
+		for addr := range peers {
+			if d.Routers[addr] == nil {
+				d.Routers[addr] = make(map[string]string)
+			}
+			d.Routers[addr][node] = state(addr)
+		}
+
+		return
+	}
+
+	for _, p := range report.Desired.Peers {
+		d.Mesh.Expected++
+
+		if state(p.Address) == string(gobgp.SessionEstablished) {
+			d.Mesh.Established++
+		} else {
+			d.Mesh.Down = append(d.Mesh.Down, node+" -> "+p.Name)
+		}
+	}
+
+	for _, r := range report.Desired.Routers {
+		if d.Routers[r.Address] == nil {
+			d.Routers[r.Address] = make(map[string]string)
+		}
+		d.Routers[r.Address][node] = state(r.Address)
+	}
+
+	for _, r := range report.Desired.Routes {
+		d.Prefixes[r.Prefix] = append(d.Prefixes[r.Prefix], node)
+
+		if r.Exclusive {
+			d.Conflicts[r.Prefix] = append(d.Conflicts[r.Prefix], node)
+		}
+	}
+}
+
+// conflictReporter raises an Event on each claimant Node of each newly-found
+// route ownership conflict, and maintains the route conflict metric
+type conflictReporter struct {
+	clientset kubernetes.Interface
+
+	// recorders is the Event recorder of each Node, by Node name
+	recorders map[string]events.Recorder
+
+	// reported is the set of conflicts which have already been recorded as Events
+	reported map[string]bool
+}
+
+func newConflictReporter(clientset kubernetes.Interface) *conflictReporter {
+	return &conflictReporter{
+		clientset: clientset,
+		recorders: make(map[string]events.Recorder),
+		reported:  make(map[string]bool),
+	}
+}
+
+// Report reports the given conflicts: the claimant Nodes, by prefix
+func (c *conflictReporter) Report(conflicts map[string][]string) {
+	metrics.RouteConflict.Reset()
+
+	current := make(map[string]bool, len(conflicts))
+	for prefix, nodes := range conflicts {
+		key := prefix + " " + strings.Join(nodes, ",")
+		current[key] = true
+
+		for _, node := range nodes {
+			metrics.RouteConflict.WithLabelValues(prefix, node).Set(1)
+
+			if c.reported[key] {
+				continue
+			}
+
+			if c.recorders[node] == nil {
+				c.recorders[node] = events.NewRecorder(c.clientset, node)
+			}
+			c.recorders[node].Warning("RouteConflict", "%s is claimed exclusively by several nodes: %s", prefix, strings.Join(nodes, ", "))
+		}
+
+		if !c.reported[key] {
+			log.Printf("route conflict: %s is claimed exclusively by %s", prefix, strings.Join(nodes, ", "))
+		}
+	}
+
+	c.reported = current
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/CyCoreSystems/kube-bgp/model"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	v1 "k8s.io/api/core/v1"
+)
+
+// desiredModel returns the desired BGP state of the given Node in the
+// backend-neutral model: its iBGP peers and Routers, each with the routes
+// exported to it as its export policy, and the desired routes
+func desiredModel(cfg *KubeBGPConfig, n *v1.Node, peers []Peer, routers []Router, desired []routes.Route) *model.Desired {
+	d := &model.Desired{Routes: desired}
+
+	d.ASN, _ = parseASN(cfg.ASN) // validated at load
+
+	if n != nil {
+		if id, err := routerID(n, cfg); err == nil {
+			d.RouterID = id.String()
+		}
+	}
+
+	exports := exportedRoutes(cfg, peers, routers, desired)
+
+	for i, p := range peers {
+		nb := model.Neighbor{
+			Address: p.Address,
+			Name:    p.Name,
+			ASN:     d.ASN,
+			Export:  exportPolicy(exports[i].Routes, desired),
+		}
+		if p.ASN != "" {
+			nb.ASN, _ = parseASN(p.ASN)
+		}
+		if p.LocalASN != "" {
+			nb.LocalASN, _ = parseASN(p.LocalASN)
+		}
+
+		d.Neighbors = append(d.Neighbors, nb)
+	}
+
+	for i := range routers {
+		r := &routers[i]
+
+		nb := model.Neighbor{
+			Address: r.Address,
+			Name:    r.Name,
+			ASN:     d.ASN,
+			Port:    r.RemotePort(),
+			Export:  exportPolicy(exports[len(peers)+i].Routes, desired),
+		}
+		if r.ASN != "" {
+			nb.ASN, _ = parseASN(r.ASN)
+		}
+		if cfg.Tenants {
+			nb.Families = []model.Family{model.IPv4Unicast, model.IPv6Unicast, model.VPNv4Unicast, model.VPNv6Unicast}
+		}
+
+		d.Neighbors = append(d.Neighbors, nb)
+	}
+
+	return d
+}
+
+// exportPolicy returns the export policy by which only the given routes of
+// those desired are exported, or nil if every one is
+func exportPolicy(exported, desired []routes.Route) *model.Policy {
+	if len(exported) == len(desired) {
+		return nil
+	}
+
+	p := &model.Policy{Prefixes: []string{}}
+	for i := range exported {
+		p.Prefixes = append(p.Prefixes, exported[i].Prefix)
+	}
+
+	return p
+}
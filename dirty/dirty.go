@@ -0,0 +1,40 @@
+// Package dirty provides the change notifications by which the watchers
+// tell the reconcile loop that their state has changed
+package dirty
+
+// Flag is a level-triggered, coalescing change notification, which Set sets
+// and receiving from C clears: any number of changes between two receives
+// are delivered as one, and Set never blocks, however slow the consumer.  No
+// change is lost, provided that the consumer reads the state only after
+// receiving.
+type Flag struct {
+	// set holds a token while the Flag is set
+	set chan struct{}
+}
+
+// New returns a Flag which is not set
+func New() *Flag {
+	return &Flag{
+		set: make(chan struct{}, 1),
+	}
+}
+
+// Set sets the Flag.  If it is already set, the pending notification covers
+// this change too.
+func (f *Flag) Set() {
+	select {
+	case f.set <- struct{}{}:
+	default:
+	}
+}
+
+// C returns the channel from which a value may be received while the Flag
+// is set, which clears it
+func (f *Flag) C() <-chan struct{} {
+	return f.set
+}
+
+// Pending indicates whether the Flag is set, with a change awaiting the consumer
+func (f *Flag) Pending() bool {
+	return len(f.set) > 0
+}
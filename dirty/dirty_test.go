@@ -0,0 +1,132 @@
+package dirty
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewIsNotSet(t *testing.T) {
+	f := New()
+
+	if f.Pending() {
+		t.Fatal("new flag is pending")
+	}
+
+	select {
+	case <-f.C():
+		t.Fatal("received from a flag which was never set")
+	default:
+	}
+}
+
+func TestSetCoalesces(t *testing.T) {
+	f := New()
+
+	for i := 0; i < 100; i++ {
+		f.Set()
+	}
+
+	if !f.Pending() {
+		t.Fatal("flag is not pending after Set")
+	}
+
+	select {
+	case <-f.C():
+	default:
+		t.Fatal("failed to receive from a set flag")
+	}
+
+	if f.Pending() {
+		t.Fatal("flag is still pending after receiving")
+	}
+
+	select {
+	case <-f.C():
+		t.Fatal("received a second notification for coalesced changes")
+	default:
+	}
+}
+
+func TestSetAfterReceive(t *testing.T) {
+	f := New()
+
+	f.Set()
+	<-f.C()
+
+	f.Set()
+
+	select {
+	case <-f.C():
+	default:
+		t.Fatal("change after receiving was lost")
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	f := New()
+
+	var mu sync.Mutex
+	var state int
+
+	// The producer must never block, however far behind the consumer falls.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 1; i <= 10000; i++ {
+			mu.Lock()
+			state = i
+			mu.Unlock()
+
+			f.Set()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set blocked on a consumer which is not receiving")
+	}
+
+	// The consumer, reading the state only after receiving, sees the last change.
+	select {
+	case <-f.C():
+	case <-time.After(time.Second):
+		t.Fatal("no notification pending for the changes")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if state != 10000 {
+		t.Fatalf("consumer observed state %d; expected 10000", state)
+	}
+}
+
+func TestConcurrentSetters(t *testing.T) {
+	f := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				f.Set()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var received int
+	for f.Pending() {
+		<-f.C()
+		received++
+	}
+
+	if received != 1 {
+		t.Fatalf("received %d notifications; expected 1", received)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// dnsHostnameAnnotation is the external-dns annotation of an EgressIP which
+// lists (comma-separated) the hostnames under which its IPs are published
+const dnsHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+
+// dnsEndpointResource is the resource of the DNSEndpoints of external-dns
+var dnsEndpointResource = schema.GroupVersionResource{Group: "externaldns.k8s.io", Version: "v1alpha1", Resource: "dnsendpoints"}
+
+// DNSExport configures the publication of the advertised host routes as
+// DNSEndpoints, from which external-dns (with its `crd` source) publishes
+// DNS records as soon as the addresses are advertised
+type DNSExport struct {
+	// Namespace is the namespace in which the DNSEndpoint of each Node is kept
+	Namespace string `yaml:"namespace"`
+
+	// TTLSeconds is the TTL of the records.  This is optional, and if not
+	// supplied, the default of external-dns applies.
+	TTLSeconds int64 `yaml:"ttlSeconds"`
+}
+
+func (d *DNSExport) validate() error {
+	if d.Namespace == "" {
+		return eris.New("namespace is required")
+	}
+
+	if d.TTLSeconds < 0 {
+		return eris.Errorf("invalid ttlSeconds %d", d.TTLSeconds)
+	}
+
+	return nil
+}
+
+// dnsRecords returns the addresses to be published under each hostname, of
+// the host routes among those given: those of the Advertisements with a
+// hostname, and those of the EgressIPs with the external-dns hostname
+// annotation
+func dnsRecords(cfg *KubeBGPConfig, desired []routes.Route, state *clusterState) map[string][]string {
+	advertised := make(map[string]bool, len(desired))
+	for _, r := range desired {
+		advertised[normalizePrefix(r.Prefix)] = true
+	}
+
+	records := make(map[string][]string)
+
+	add := func(hostname string, prefix string) {
+		ip, network, err := net.ParseCIDR(prefix)
+		if err != nil || !advertised[network.String()] {
+			return
+		}
+
+		if ones, bits := network.Mask.Size(); ones != bits {
+			return // only host routes have a single address to publish
+		}
+
+		records[hostname] = append(records[hostname], ip.String())
+	}
+
+	for _, a := range cfg.Advertisements {
+		if a.Hostname == "" {
+			continue
+		}
+
+		for _, r := range a.routes() {
+			add(a.Hostname, r.Prefix)
+		}
+	}
+
+	for i := range state.EgressIPs {
+		e := &state.EgressIPs[i]
+
+		for _, hostname := range strings.Split(e.Annotations[dnsHostnameAnnotation], ",") {
+			if hostname = strings.TrimSpace(hostname); hostname == "" {
+				continue
+			}
+
+			for _, s := range e.Spec.IPs {
+				if ip := net.ParseIP(s); ip != nil {
+					add(hostname, hostPrefix(ip))
+				}
+			}
+		}
+	}
+
+	for hostname := range records {
+		sort.Strings(records[hostname])
+	}
+
+	return records
+}
+
+// normalizePrefix returns the canonical form of the given CIDR, or the CIDR
+// itself if it cannot be parsed
+func normalizePrefix(prefix string) string {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return prefix
+	}
+
+	return network.String()
+}
+
+// dnsEndpoints returns the endpoints of a DNSEndpoint for the given records
+func dnsEndpoints(records map[string][]string, ttl int64) []interface{} {
+	hostnames := make([]string, 0, len(records))
+	for hostname := range records {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+
+	var endpoints []interface{}
+
+	for _, hostname := range hostnames {
+		byType := map[string][]interface{}{}
+		for _, ip := range records[hostname] {
+			typ := "AAAA"
+			if net.ParseIP(ip).To4() != nil {
+				typ = "A"
+			}
+			byType[typ] = append(byType[typ], ip)
+		}
+
+		for _, typ := range []string{"A", "AAAA"} {
+			if len(byType[typ]) == 0 {
+				continue
+			}
+
+			ep := map[string]interface{}{
+				"dnsName":    hostname,
+				"recordType": typ,
+				"targets":    byType[typ],
+			}
+			if ttl > 0 {
+				ep["recordTTL"] = ttl
+			}
+
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	return endpoints
+}
+
+// dnsPublisher keeps the DNSEndpoint of this Node up to date with the
+// records of its advertised host routes.  Since each Node publishes only the
+// routes it originates, the records of anycast addresses are published by
+// every Node which advertises them.
+type dnsPublisher struct {
+	cfg    *DNSExport
+	client dynamic.Interface
+	node   string
+
+	// last is the set of records last published
+	last map[string][]string
+}
+
+func newDNSPublisher(cfg *DNSExport, client dynamic.Interface, node string) *dnsPublisher {
+	return &dnsPublisher{
+		cfg:    cfg,
+		client: client,
+		node:   node,
+	}
+}
+
+// Publish updates the DNSEndpoint of this Node with the given records, if they have changed
+func (p *dnsPublisher) Publish(ctx context.Context, records map[string][]string, state *clusterState) error {
+	if p.last != nil && reflect.DeepEqual(records, p.last) {
+		return nil
+	}
+
+	name := "kube-bgp-" + p.node
+	resource := p.client.Resource(dnsEndpointResource).Namespace(p.cfg.Namespace)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"endpoints": dnsEndpoints(records, p.cfg.TTLSeconds),
+		},
+	}}
+	obj.SetAPIVersion(dnsEndpointResource.Group + "/" + dnsEndpointResource.Version)
+	obj.SetKind("DNSEndpoint")
+	obj.SetName(name)
+	obj.SetNamespace(p.cfg.Namespace)
+	obj.SetLabels(map[string]string{"app.kubernetes.io/managed-by": "kube-bgp"})
+
+	// The DNSEndpoint is garbage-collected along with its Node.
+	if n := findNode(p.node, state.Nodes); n != nil {
+		obj.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       n.Name,
+			UID:        n.UID,
+		}})
+	}
+
+	existing, err := resource.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		if _, err := resource.Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to create DNSEndpoint %s", name)
+		}
+	case err != nil:
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get DNSEndpoint %s", name)
+	default:
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		if _, err := resource.Update(ctx, obj, metav1.UpdateOptions{}); err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to update DNSEndpoint %s", name)
+		}
+	}
+
+	p.last = records
+
+	return nil
+}
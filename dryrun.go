@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// inDryRun indicates whether the given route is of an advertisement in dry run
+func (c *KubeBGPConfig) inDryRun(r *routes.Route) bool {
+	for i := range c.Advertisements {
+		if c.Advertisements[i].DryRun && r.Source == "advertisement/"+c.Advertisements[i].Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitDryRun splits the given routes into those which are to be applied
+// and those of the advertisements in dry run, which are only reported
+func splitDryRun(cfg *KubeBGPConfig, list []routes.Route) (applied, dryRun []routes.Route) {
+	for _, r := range list {
+		if cfg.inDryRun(&r) {
+			dryRun = append(dryRun, r)
+			continue
+		}
+
+		applied = append(applied, r)
+	}
+
+	return applied, dryRun
+}
+
+// dryRunRouters returns the Routers in dry run with which the named Node would peer
+func dryRunRouters(thisNode string, cfg *KubeBGPConfig, state *clusterState) (routers []Router) {
+	n := findNode(thisNode, state.Nodes)
+	if n == nil {
+		return nil
+	}
+
+	for _, r := range cfg.Routers {
+		if r.DryRun && drainReason(&r, state) == "" && (r.PeersWith(n) || inNodeGroups(n, state.NodeGroups, r.PeerNodeGroups)) {
+			routers = append(routers, r)
+		}
+	}
+
+	return routers
+}
+
+// reportDryRun records in the status report what would be applied, but for
+// the advertisements and Routers in dry run: the routes of the
+// advertisements, and the session with each Router along with the prefixes
+// which would be exported to it
+func reportDryRun(cfg *KubeBGPConfig, routers []Router, applied, dryRun []routes.Route) {
+	if len(dryRun) == 0 && len(routers) == 0 {
+		status.SetDryRun(nil)
+		return
+	}
+
+	d := &status.DryRun{Routes: dryRun}
+
+	all := append(append([]routes.Route(nil), applied...), dryRun...)
+
+	for i := range routers {
+		r := &routers[i]
+
+		s := status.DryRunRouter{
+			Session: status.Session{Address: r.Address, Name: r.Name, ASN: r.ASN},
+		}
+		for j := range all {
+			if ok, _ := exportDecision(cfg, &all[j], r); ok {
+				s.Exports = append(s.Exports, all[j].Prefix)
+			}
+		}
+
+		d.Routers = append(d.Routers, s)
+	}
+
+	status.SetDryRun(d)
+}
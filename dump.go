@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/model"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// StateDump is the full internal state of kube-bgp, for debugging hung
+// reconciles post-mortem
+type StateDump struct {
+	// Time is the time of the dump
+	Time time.Time `json:"time"`
+
+	// Config is the loaded configuration
+	Config *KubeBGPConfig `json:"config"`
+
+	// Phase is the step of the reconcile loop which is in progress, or `idle`
+	Phase string `json:"phase"`
+
+	// PhaseStarted is the time at which the Phase began
+	PhaseStarted time.Time `json:"phaseStarted"`
+
+	// State is the cluster state observed by the most recent reconcile
+	State *clusterState `json:"state"`
+
+	// DesiredPeers is the list of desired iBGP peers
+	DesiredPeers []Peer `json:"desiredPeers"`
+
+	// DesiredRouters is the list of Routers with which this Node should peer
+	DesiredRouters []Router `json:"desiredRouters"`
+
+	// DesiredRoutes is the list of routes which this Node should originate
+	DesiredRoutes []routes.Route `json:"desiredRoutes"`
+
+	// DesiredModel is the desired state in the backend-neutral model
+	DesiredModel *model.Desired `json:"desiredModel"`
+
+	// Applied is the list of routes which each output has accepted, by output
+	Applied map[string][]routes.Route `json:"applied"`
+
+	// Stages is the duration of each stage of the last reconcile
+	Stages []stageTiming `json:"stages"`
+
+	// RetryPending indicates that applying the desired routes failed and is to be retried
+	RetryPending bool `json:"retryPending"`
+
+	// Errors describes the errors encountered, by category
+	Errors map[errcode.Code]status.ErrorStatus `json:"errors"`
+
+	// Goroutines is the stack trace of every goroutine
+	Goroutines string `json:"goroutines"`
+}
+
+// debugState is the part of the StateDump which is maintained by the main
+// loop.  Since it is kept separately, it can be dumped even while the main
+// loop is hung.
+var debugState = struct {
+	sync.Mutex
+	dump StateDump
+}{
+	dump: StateDump{
+		Phase:   "idle",
+		Applied: make(map[string][]routes.Route),
+	},
+}
+
+// debugUpdate applies the given change to the debug state
+func debugUpdate(f func(d *StateDump)) {
+	debugState.Lock()
+	defer debugState.Unlock()
+
+	f(&debugState.dump)
+}
+
+// debugPhase records the step of the reconcile loop which is beginning
+func debugPhase(phase string) {
+	debugUpdate(func(d *StateDump) {
+		d.Phase = phase
+		d.PhaseStarted = time.Now()
+	})
+}
+
+// currentStateDump returns the current StateDump
+func currentStateDump() *StateDump {
+	debugState.Lock()
+	d := debugState.dump
+	debugState.Unlock()
+
+	applied := make(map[string][]routes.Route, len(d.Applied))
+	for k, v := range d.Applied {
+		applied[k] = v
+	}
+	d.Applied = applied
+
+	d.Time = time.Now()
+	d.Errors = status.Current().Errors
+
+	buf := make([]byte, 1<<20)
+	d.Goroutines = string(buf[:runtime.Stack(buf, true)])
+
+	return &d
+}
+
+// writeStateDump writes the current StateDump as JSON
+func writeStateDump(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(currentStateDump())
+}
+
+// dumpStateOnSignal writes the StateDump to stderr whenever kube-bgp
+// receives SIGQUIT.  Unlike the default handling of SIGQUIT, kube-bgp keeps
+// running.
+func dumpStateOnSignal(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGQUIT)
+
+	for {
+		select {
+		case <-ctx.Done():
+			signal.Stop(ch)
+			return
+		case <-ch:
+			log.Println("state dump:")
+			if err := writeStateDump(os.Stderr); err != nil {
+				log.Println("failed to write state dump:", err)
+			}
+		}
+	}
+}
+
+// stateDumpHandler returns an http.Handler which serves the StateDump.
+// Since it includes the whole configuration, it is served only by the admin
+// API.
+func stateDumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := writeStateDump(w); err != nil {
+			log.Println("failed to write state dump:", err)
+		}
+	})
+}
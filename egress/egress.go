@@ -0,0 +1,81 @@
+package egress
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+)
+
+// MaximumCheckIntervalSeconds is the maximum amount to time to wait before forcing an update check
+var MaximumCheckIntervalSeconds = 60
+
+// Watcher defines the interface for an EgressIP Watcher
+type Watcher interface {
+
+	// Changes waits for a change to the set of EgressIPs to occur
+	Changes() <-chan struct{}
+
+	// EgressIPs returns the current list of EgressIPs
+	EgressIPs() []v1alpha1.EgressIP
+
+	// Synced indicates whether the list of EgressIPs has been obtained from the API at least once
+	Synced() bool
+
+	// Listed returns the time at which the list of EgressIPs was last obtained from the API
+	Listed() time.Time
+
+	// Close shuts down the Watcher
+	Close()
+}
+
+type watcher struct {
+	*watches.Watcher
+}
+
+func (w *watcher) EgressIPs() []v1alpha1.EgressIP {
+	objects := w.Objects()
+
+	list := make([]v1alpha1.EgressIP, len(objects))
+	for i, obj := range objects {
+		list[i] = *obj.(*v1alpha1.EgressIP)
+	}
+
+	return list
+}
+
+// NewWatcher returns a new EgressIP watcher which signals whenever the set of EgressIPs changes
+func NewWatcher(ctx context.Context, client dynamic.Interface) (Watcher, error) {
+	source := watches.Dynamic(client, v1alpha1.EgressIPResource, "egress IP", time.Duration(MaximumCheckIntervalSeconds)*time.Second, func() interface{} {
+		return new(v1alpha1.EgressIP)
+	})
+
+	return &watcher{watches.NewWatcher(ctx, source)}, nil
+}
+
+// UpdateStatus replaces the status of the EgressIP
+func UpdateStatus(ctx context.Context, client dynamic.Interface, e *v1alpha1.EgressIP, status v1alpha1.EgressIPStatus) error {
+	updated := *e
+	updated.Status = status
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&updated)
+	if err != nil {
+		return errcode.Wrap(err, errcode.RenderFailed, "failed to encode egress IP")
+	}
+
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetAPIVersion(v1alpha1.Group + "/" + v1alpha1.Version)
+	u.SetKind("EgressIP")
+
+	if _, err := client.Resource(v1alpha1.EgressIPResource).UpdateStatus(ctx, u, metav1.UpdateOptions{}); err != nil {
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to update status of egress IP %s", e.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/egress"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+)
+
+// EgressAdvertisement configures the announcement of the IPs of EgressIP
+// resources from the Node which currently hosts each of them
+type EgressAdvertisement struct {
+	RouteAttributes `yaml:",inline"`
+}
+
+// routes returns the routes for the EgressIPs which should be hosted by the named Node
+func (a *EgressAdvertisement) routes(thisNode string, state *clusterState) (list []routes.Route) {
+	for i := range state.EgressIPs {
+		e := &state.EgressIPs[i]
+
+		if egressHost(e, state.Nodes) != thisNode {
+			continue
+		}
+
+		for _, s := range e.Spec.IPs {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				status.Error(errcode.New(errcode.ConfigInvalid, "invalid IP "+s+" in egress IP "+e.Name))
+				continue
+			}
+
+			r := a.RouteAttributes.route(hostPrefix(ip), "egressip/"+e.Name)
+			r.Exclusive = true
+			list = append(list, r)
+		}
+	}
+
+	return list
+}
+
+// nodeReady indicates whether the given Node is Ready
+func nodeReady(n *v1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// egressHost returns the name of the Node which should host the given
+// EgressIP, or the empty string if no Node is eligible.  The current host is
+// kept for as long as it remains eligible, so that Nodes joining the cluster
+// do not move the IPs; otherwise, the eligible Node with the lowest name is
+// chosen.  Because every Node computes the same answer from the same state,
+// failover requires no further coordination.
+func egressHost(e *v1alpha1.EgressIP, nodeList []v1.Node) string {
+	selector := labels.Everything()
+	if e.Spec.NodeSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(e.Spec.NodeSelector); err != nil {
+			status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "invalid node selector in egress IP %s", e.Name))
+			return ""
+		}
+	}
+
+	var eligible []string
+	for i := range nodeList {
+		n := &nodeList[i]
+
+		if !nodeReady(n) || !selector.Matches(labels.Set(n.Labels)) {
+			continue
+		}
+
+		if n.Name == e.Status.Node {
+			return n.Name
+		}
+
+		eligible = append(eligible, n.Name)
+	}
+
+	if len(eligible) == 0 {
+		return ""
+	}
+
+	sort.Strings(eligible)
+
+	return eligible[0]
+}
+
+// reporterNode returns the name of the Node which reports the status of
+// EgressIPs which no Node hosts: the Ready Node with the lowest name
+func reporterNode(nodeList []v1.Node) string {
+	var reporter string
+	for i := range nodeList {
+		n := &nodeList[i]
+
+		if nodeReady(n) && (reporter == "" || n.Name < reporter) {
+			reporter = n.Name
+		}
+	}
+
+	return reporter
+}
+
+// invalidIPs returns the IPs of the given EgressIP which cannot be parsed
+func invalidIPs(e *v1alpha1.EgressIP) (list []string) {
+	for _, s := range e.Spec.IPs {
+		if net.ParseIP(s) == nil {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// egressStatus returns the status of the given EgressIP when hosted by the named Node (or by no Node)
+func egressStatus(e *v1alpha1.EgressIP, host string) v1alpha1.EgressIPStatus {
+	result := v1alpha1.EgressIPStatus{
+		Node:       host,
+		Conditions: append([]metav1.Condition(nil), e.Status.Conditions...),
+	}
+
+	set := func(typ string, ok bool, reason, message string) {
+		c := metav1.Condition{
+			Type:               typ,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: e.Generation,
+			Reason:             reason,
+			Message:            message,
+		}
+		if ok {
+			c.Status = metav1.ConditionTrue
+		}
+
+		meta.SetStatusCondition(&result.Conditions, c)
+	}
+
+	if host == "" {
+		const msg = "no Ready Node matches the node selector"
+
+		set(v1alpha1.ConditionApplied, false, "NoEligibleNode", msg)
+		set(v1alpha1.ConditionDegraded, true, "NoEligibleNode", msg)
+		set(v1alpha1.ConditionReady, false, "NoEligibleNode", msg)
+
+		return result
+	}
+
+	set(v1alpha1.ConditionApplied, true, "Announced", "announced from Node "+host)
+
+	if invalid := invalidIPs(e); len(invalid) > 0 {
+		msg := "invalid IPs are not announced: " + strings.Join(invalid, ", ")
+
+		set(v1alpha1.ConditionDegraded, true, "InvalidIPs", msg)
+		set(v1alpha1.ConditionReady, false, "InvalidIPs", msg)
+
+		return result
+	}
+
+	set(v1alpha1.ConditionDegraded, false, "AsExpected", "all IPs are announced")
+	set(v1alpha1.ConditionReady, true, "Announced", "announced from Node "+host)
+
+	return result
+}
+
+// updateEgressStatus records the host and conditions of each EgressIP which
+// the named Node should host.  Those which no Node can host are reported by
+// the reporter Node alone.
+func updateEgressStatus(ctx context.Context, client dynamic.Interface, recorder events.Recorder, thisNode string, state *clusterState) {
+	reporter := reporterNode(state.Nodes)
+
+	for i := range state.EgressIPs {
+		e := &state.EgressIPs[i]
+
+		host := egressHost(e, state.Nodes)
+		if host != thisNode && (host != "" || reporter != thisNode) {
+			continue
+		}
+
+		desired := egressStatus(e, host)
+		if reflect.DeepEqual(desired, e.Status) {
+			continue
+		}
+
+		if err := egress.UpdateStatus(ctx, client, e, desired); err != nil {
+			status.Error(err)
+			continue
+		}
+
+		switch {
+		case host == "" || e.Status.Node == host:
+		case e.Status.Node == "":
+			recorder.Normal("EgressIPAssigned", "now hosting egress IP %s", e.Name)
+		default:
+			recorder.Normal("EgressIPFailover", "took over egress IP %s from node %s", e.Name, e.Status.Node)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package election elects the route reflectors of each BGPNodeGroup by means
+// of Leases, one per reflector slot of the group
+package election
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// groupLabel is the Lease label which names the BGPNodeGroup of the reflector slot
+const groupLabel = "kube-bgp.cycoresystems.com/reflector-group"
+
+// LeaseDurationSeconds is the time after its last renewal at which a reflector slot may be taken over by another Node
+var LeaseDurationSeconds int32 = 15
+
+// RenewInterval is the interval at which reflector slots are renewed and the elected reflectors are re-listed
+var RenewInterval = 5 * time.Second
+
+// Elector defines the interface for a route reflector Elector
+type Elector interface {
+
+	// Changes waits for a change to the set of elected reflectors to occur
+	Changes() <-chan struct{}
+
+	// Elected returns the names of the elected reflector Nodes, by BGPNodeGroup name
+	Elected() map[string][]string
+
+	// Close shuts down the Elector
+	Close()
+}
+
+type elector struct {
+	cancel    context.CancelFunc
+	client    kubernetes.Interface
+	namespace string
+	nodeName  string
+	candidacy func() (group string, count int)
+	signal    *dirty.Flag
+
+	mu      sync.Mutex
+	elected map[string][]string
+}
+
+func (e *elector) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		changed, err := e.round(ctx)
+		if err != nil {
+			status.Error(err)
+		}
+
+		if changed {
+			e.signal.Set()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(RenewInterval):
+		}
+	}
+}
+
+// round campaigns for a reflector slot of this Node's group, if it elects
+// any, and then updates the set of elected reflectors
+func (e *elector) round(ctx context.Context) (changed bool, err error) {
+	leases := e.client.CoordinationV1().Leases(e.namespace)
+
+	list, err := leases.List(ctx, metav1.ListOptions{LabelSelector: groupLabel})
+	if err != nil {
+		return false, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list reflector leases")
+	}
+
+	if group, count := e.candidacy(); count > 0 {
+		if err := e.campaign(ctx, group, count, list.Items); err != nil {
+			status.Error(err)
+		}
+
+		// Re-list, so that the result of the campaign is reflected at once
+		if list, err = leases.List(ctx, metav1.ListOptions{LabelSelector: groupLabel}); err != nil {
+			return false, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list reflector leases")
+		}
+	}
+
+	elected := make(map[string][]string)
+	for i := range list.Items {
+		l := &list.Items[i]
+		if !expired(l) {
+			elected[l.Labels[groupLabel]] = append(elected[l.Labels[groupLabel]], *l.Spec.HolderIdentity)
+		}
+	}
+	for _, names := range elected {
+		sort.Strings(names)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if reflect.DeepEqual(elected, e.elected) {
+		return false, nil
+	}
+
+	e.elected = elected
+	return true, nil
+}
+
+// campaign renews the reflector slot of the given group which this Node
+// holds, or, if it holds none, acquires a free or expired one
+func (e *elector) campaign(ctx context.Context, group string, count int, list []coordinationv1.Lease) error {
+	leases := e.client.CoordinationV1().Leases(e.namespace)
+
+	slots := make(map[string]*coordinationv1.Lease, count)
+	for i := range list {
+		slots[list[i].Name] = &list[i]
+	}
+
+	for i := range list {
+		l := &list[i]
+		if l.Labels[groupLabel] != group || expired(l) || *l.Spec.HolderIdentity != e.nodeName {
+			continue
+		}
+
+		if !validSlot(l.Name, group, count) {
+			// The group has fewer slots than it did, so give this one up
+			if err := leases.Delete(ctx, l.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to release reflector lease %s", l.Name)
+			}
+			continue
+		}
+
+		l.Spec.RenewTime = now()
+		if _, err := leases.Update(ctx, l, metav1.UpdateOptions{}); err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to renew reflector lease %s", l.Name)
+		}
+
+		return nil
+	}
+
+	for i := 0; i < count; i++ {
+		name := leaseName(group, i)
+
+		l, ok := slots[name]
+		if !ok {
+			_, err := leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{groupLabel: group},
+				},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &e.nodeName,
+					LeaseDurationSeconds: &LeaseDurationSeconds,
+					AcquireTime:          now(),
+					RenewTime:            now(),
+				},
+			}, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				continue // another Node got there first
+			}
+			if err != nil {
+				return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to create reflector lease %s", name)
+			}
+
+			return nil
+		}
+
+		if !expired(l) {
+			continue
+		}
+
+		var transitions int32
+		if l.Spec.LeaseTransitions != nil {
+			transitions = *l.Spec.LeaseTransitions + 1
+		}
+
+		l.Spec.HolderIdentity = &e.nodeName
+		l.Spec.LeaseDurationSeconds = &LeaseDurationSeconds
+		l.Spec.AcquireTime = now()
+		l.Spec.RenewTime = now()
+		l.Spec.LeaseTransitions = &transitions
+
+		_, err := leases.Update(ctx, l, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			continue // another Node got there first
+		}
+		if err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to acquire reflector lease %s", name)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+func (e *elector) Changes() <-chan struct{} {
+	return e.signal.C()
+}
+
+func (e *elector) Elected() map[string][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.elected
+}
+
+func (e *elector) Close() {
+	e.cancel()
+}
+
+// leaseName returns the name of the Lease of the given reflector slot of the given group
+func leaseName(group string, slot int) string {
+	return fmt.Sprintf("kube-bgp-reflector-%s-%d", group, slot)
+}
+
+// validSlot indicates whether the named Lease is one of the reflector slots of a group of the given size
+func validSlot(name, group string, count int) bool {
+	for i := 0; i < count; i++ {
+		if name == leaseName(group, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expired indicates whether the given Lease is no longer held
+func expired(l *coordinationv1.Lease) bool {
+	if l.Spec.HolderIdentity == nil || *l.Spec.HolderIdentity == "" || l.Spec.RenewTime == nil {
+		return true
+	}
+
+	duration := LeaseDurationSeconds
+	if l.Spec.LeaseDurationSeconds != nil {
+		duration = *l.Spec.LeaseDurationSeconds
+	}
+
+	return time.Since(l.Spec.RenewTime.Time) > time.Duration(duration)*time.Second
+}
+
+func now() *metav1.MicroTime {
+	t := metav1.NowMicro()
+	return &t
+}
+
+// NewElector returns a new Elector which campaigns on behalf of the named
+// Node for a reflector slot of the group returned by candidacy (if the group
+// elects any reflectors, per count), and which signals whenever the set of
+// elected reflectors changes.  The Leases are kept in the given namespace.
+func NewElector(ctx context.Context, client kubernetes.Interface, namespace, nodeName string, candidacy func() (group string, count int)) (Elector, error) {
+	localCtx, cancel := context.WithCancel(ctx)
+
+	e := &elector{
+		cancel:    cancel,
+		client:    client,
+		namespace: namespace,
+		nodeName:  nodeName,
+		candidacy: candidacy,
+		signal:    dirty.New(),
+	}
+
+	go e.run(localCtx)
+
+	return e, nil
+}
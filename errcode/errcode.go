@@ -0,0 +1,96 @@
+// Package errcode defines the categories of errors reported by kube-bgp
+package errcode
+
+import (
+	"errors"
+
+	"github.com/rotisserie/eris"
+)
+
+// Code is the category of an error encountered by kube-bgp
+type Code string
+
+const (
+	// Unknown is the Code of any error which has not been categorized
+	Unknown Code = "unknown"
+
+	// ConfigInvalid indicates that the kube-bgp configuration could not be loaded or is invalid
+	ConfigInvalid Code = "config-invalid"
+
+	// APIServerUnreachable indicates that the kubernetes API server could not be reached
+	APIServerUnreachable Code = "apiserver-unreachable"
+
+	// RenderFailed indicates that the gobgp configuration could not be generated
+	RenderFailed Code = "render-failed"
+
+	// GoBGPDUnreachable indicates that gobgpd could not be contacted
+	GoBGPDUnreachable Code = "gobgpd-unreachable"
+
+	// ApplyRejected indicates that gobgpd refused the configuration it was given
+	ApplyRejected Code = "apply-rejected"
+
+	// IPAMUnreachable indicates that the permitted pools could not be obtained from the IPAM
+	IPAMUnreachable Code = "ipam-unreachable"
+
+	// PermissionDenied indicates that the kubernetes API server refused an action for lack of permission
+	PermissionDenied Code = "permission-denied"
+)
+
+// Codes is the list of all error Codes
+var Codes = []Code{
+	Unknown,
+	ConfigInvalid,
+	APIServerUnreachable,
+	RenderFailed,
+	GoBGPDUnreachable,
+	ApplyRejected,
+	IPAMUnreachable,
+	PermissionDenied,
+}
+
+type codedError struct {
+	code Code
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// New returns a new error of the given Code
+func New(code Code, msg string) error {
+	return &codedError{code: code, err: eris.New(msg)}
+}
+
+// Wrap adds context to an error and categorizes it with the given Code
+func Wrap(err error, code Code, msg string) error {
+	if err == nil {
+		return nil
+	}
+
+	return eris.Wrap(&codedError{code: code, err: err}, msg)
+}
+
+// Wrapf adds formatted context to an error and categorizes it with the given Code
+func Wrapf(err error, code Code, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	return eris.Wrapf(&codedError{code: code, err: err}, format, args...)
+}
+
+// Of returns the Code of the given error.  If the error has been categorized
+// more than once, the outermost Code wins.
+func Of(err error) Code {
+	var c *codedError
+	if errors.As(err, &c) {
+		return c.code
+	}
+
+	return Unknown
+}
@@ -0,0 +1,57 @@
+// Package events records Kubernetes Events against the local Node
+package events
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Component is the name of the component which is reported as the source of Events
+const Component = "kube-bgp"
+
+// Recorder records Events against the local Node
+type Recorder interface {
+
+	// Normal records an informational Event
+	Normal(reason, messageFmt string, args ...interface{})
+
+	// Warning records an Event describing a problem
+	Warning(reason, messageFmt string, args ...interface{})
+}
+
+type recorder struct {
+	node     *v1.ObjectReference
+	recorder record.EventRecorder
+}
+
+func (r *recorder) Normal(reason, messageFmt string, args ...interface{}) {
+	r.recorder.Eventf(r.node, v1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func (r *recorder) Warning(reason, messageFmt string, args ...interface{}) {
+	r.recorder.Eventf(r.node, v1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+// NewRecorder returns a Recorder which records Events against the named Node
+func NewRecorder(clientset kubernetes.Interface, nodeName string) Recorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(""),
+	})
+
+	return &recorder{
+		node: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{
+			Component: Component,
+			Host:      nodeName,
+		}),
+	}
+}
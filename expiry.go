@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// routeExpiry returns the time for which routes originated on behalf of
+// dynamic objects (such as Pods and EgressIPs) remain valid without their
+// source objects being re-confirmed by a List from the apiserver, or zero if
+// route expiry is disabled
+func (c *KubeBGPConfig) routeExpiry() time.Duration {
+	return time.Duration(c.RouteExpirySeconds) * time.Second
+}
+
+// expired indicates whether the routes derived from the given kind of
+// object, which were last listed at the given time, have outlived the route
+// expiry, reporting it if so.  This is a safety net against a stuck watcher
+// leaving ghost routes behind.
+func (c *KubeBGPConfig) expired(kind string, listed time.Time) bool {
+	expiry := c.routeExpiry()
+	if expiry == 0 {
+		return false
+	}
+
+	if time.Since(listed) <= expiry {
+		return false
+	}
+
+	status.Error(errcode.New(errcode.APIServerUnreachable, "withdrawing "+kind+" routes: not re-confirmed by the apiserver since "+listed.Format(time.RFC3339)))
+
+	return true
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// runExplain implements the `explain` command, which traces the chain of
+// configuration and cluster state by which a Node has a given neighbor (the
+// Router or iBGP peer, the selector which matched, and the labels and
+// annotations of the Nodes), or else why it does not.  It returns the
+// process exit code, which is non-zero if the Node has no such neighbor.
+func runExplain(args []string) int {
+	if len(args) < 2 || args[0] != "neighbor" {
+		fmt.Fprintln(os.Stderr, "usage: kube-bgp explain neighbor <address> [-node <name>] [-config <file>] [-state <file>]") // nolint: errcheck
+		return 1
+	}
+	address := args[1]
+
+	fs := flag.NewFlagSet("explain neighbor", flag.ExitOnError)
+	node := fs.String("node", os.Getenv("NODE_NAME"), "name of the Node whose neighbor is explained")
+	config := fs.String("config", configFile, "kube-bgp configuration file")
+	cache := fs.String("state", "", "state cache file to explain from, instead of listing the cluster state from the apiserver")
+	fs.Parse(args[2:]) // nolint: errcheck
+
+	if net.ParseIP(address) == nil {
+		fmt.Fprintf(os.Stderr, "invalid address %q\n", address) // nolint: errcheck
+		return 1
+	}
+
+	if *node == "" {
+		fmt.Fprintln(os.Stderr, "a Node is required (-node, or NODE_NAME)") // nolint: errcheck
+		return 1
+	}
+
+	cfg, err := loadConfig(*config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read configuration:", err) // nolint: errcheck
+		return 1
+	}
+
+	var state *clusterState
+	if *cache != "" {
+		state, _, err = (&StateCache{Path: *cache}).load()
+		if err == nil && state == nil {
+			err = eris.Errorf("state cache %s does not exist", *cache)
+		}
+	} else {
+		state, err = listClusterStateFromAPI(context.Background(), cfg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to obtain the cluster state:", err) // nolint: errcheck
+		return 1
+	}
+
+	if findNode(*node, state.Nodes) == nil {
+		fmt.Fprintf(os.Stderr, "no node %s in the cluster state\n", *node) // nolint: errcheck
+		return 1
+	}
+
+	if !explainNeighbor(os.Stdout, cfg, state, *node, address) {
+		return 1
+	}
+
+	return 0
+}
+
+// explainNeighbor writes how the named Node comes to have the neighbor of
+// the given address, or why it does not, returning whether it does
+func explainNeighbor(w io.Writer, cfg *KubeBGPConfig, state *clusterState, thisNode, address string) (found bool) {
+	local := findNode(thisNode, state.Nodes)
+
+	var explained bool
+
+	for i := range cfg.Routers {
+		r := &cfg.Routers[i]
+		if !sameAddress(r.Address, address) {
+			continue
+		}
+		explained = true
+
+		if explainRouter(w, cfg, state, local, r, i) {
+			found = true
+		}
+	}
+
+	for _, p := range peers(thisNode, cfg, state) {
+		if !sameAddress(p.Address, address) {
+			continue
+		}
+		explained = true
+		found = true
+
+		explainPeer(w, cfg, state, local, &p)
+	}
+
+	if found {
+		return true
+	}
+
+	// The address may be that of a Node which is not peered.
+	for i := range state.Nodes {
+		n := &state.Nodes[i]
+		if !nodeHasPeerAddress(n, address) {
+			continue
+		}
+		explained = true
+
+		fmt.Fprintf(w, "Node %s has address %s, but it is not a neighbor of node %s: %s\n", n.Name, address, thisNode, unpeeredReason(cfg, state, local, n, address)) // nolint: errcheck
+	}
+
+	if !explained {
+		fmt.Fprintf(w, "No Router or Node of the configuration and cluster state has address %s.\n", address) // nolint: errcheck
+	}
+
+	fmt.Fprintf(w, "=> %s is not a neighbor of node %s\n", address, thisNode) // nolint: errcheck
+
+	return false
+}
+
+// explainRouter writes why the given Router, the i-th of the
+// configuration, is or is not a neighbor of the given Node, returning
+// whether it is
+func explainRouter(w io.Writer, cfg *KubeBGPConfig, state *clusterState, n *v1.Node, r *Router, i int) bool {
+	asn := r.ASN
+	if asn == "" {
+		asn = cfg.ASN + " (the system ASN)"
+	}
+
+	fmt.Fprintf(w, "Router %s (%s, AS %s), routers[%d] of the configuration\n", r.Ref(), r.Address, asn, i) // nolint: errcheck
+
+	reasons := routerPeeringReasons(r, n, state.NodeGroups)
+	if len(reasons) == 0 {
+		fmt.Fprintf(w, "  does not peer with node %s:\n", n.Name) // nolint: errcheck
+		for _, s := range routerNonPeeringReasons(r, n, state.NodeGroups) {
+			fmt.Fprintf(w, "    - %s\n", s) // nolint: errcheck
+		}
+
+		return false
+	}
+
+	fmt.Fprintf(w, "  peers with node %s:\n", n.Name) // nolint: errcheck
+	for _, s := range reasons {
+		fmt.Fprintf(w, "    - %s\n", s) // nolint: errcheck
+	}
+
+	var excluded string
+	switch {
+	case r.DryRun:
+		excluded = "the Router is in dry run (dryRun: true), so no session is configured"
+	case state.TunnelsDown[r.Address]:
+		excluded = "the tunnel to the Router is down, so its session is held down"
+	case state.LinksDown[r.Address]:
+		excluded = "the link to the Router is down, so its session is dropped"
+	case unprobed(r, state):
+		excluded = "the Router has not passed its reachability probe, so it is not yet a neighbor"
+	default:
+		if reason := drainReason(r, state); reason != "" {
+			excluded = "the Router is " + reason + ", so its session is drained"
+		}
+	}
+
+	if excluded != "" {
+		fmt.Fprintf(w, "  but %s\n", excluded) // nolint: errcheck
+
+		return false
+	}
+
+	fmt.Fprintf(w, "=> neighbor of node %s\n", n.Name) // nolint: errcheck
+
+	return true
+}
+
+// routerPeeringReasons returns each reason for which the given Router peers
+// with the given Node, or none if it does not
+func routerPeeringReasons(r *Router, n *v1.Node, groups []v1alpha1.BGPNodeGroup) (reasons []string) {
+	for _, ref := range routerRefs(n.Annotations) {
+		if r.Matches(ref) {
+			reasons = append(reasons, fmt.Sprintf("the %s annotation of node %s (%q) names it", routersAnnotation, n.Name, n.Annotations[routersAnnotation]))
+			break
+		}
+	}
+
+	for _, p := range r.PeerNodes {
+		if alias := matchingAlias(p, n); alias != "" {
+			reasons = append(reasons, fmt.Sprintf("its peerNodes pattern %q matches %q, a name of node %s", p, alias, n.Name))
+		}
+	}
+
+	if inNodeGroups(n, groups, r.PeerNodeGroups) {
+		g := nodeGroup(n, groups)
+		reasons = append(reasons, fmt.Sprintf("its peerNodeGroups lists BGPNodeGroup %s, whose nodeSelector %q selects node %s by its labels (%s)", g.Name, metav1.FormatLabelSelector(g.Spec.NodeSelector), n.Name, labels.Set(n.Labels)))
+	}
+
+	return reasons
+}
+
+// routerNonPeeringReasons returns the reasons for which none of the ways in
+// which a Router may peer with a Node applies to the given Router and Node
+func routerNonPeeringReasons(r *Router, n *v1.Node, groups []v1alpha1.BGPNodeGroup) (reasons []string) {
+	if refs := routerRefs(n.Annotations); len(refs) > 0 {
+		reasons = append(reasons, fmt.Sprintf("the %s annotation of node %s (%q) does not name it", routersAnnotation, n.Name, n.Annotations[routersAnnotation]))
+	} else {
+		reasons = append(reasons, fmt.Sprintf("node %s has no %s annotation", n.Name, routersAnnotation))
+	}
+
+	if len(r.PeerNodes) > 0 {
+		reasons = append(reasons, fmt.Sprintf("none of its peerNodes %q matches a name of node %s (%s)", r.PeerNodes, n.Name, strings.Join(uniqueStrings(nodeAliases(n)), ", ")))
+	} else {
+		reasons = append(reasons, "it has no peerNodes")
+	}
+
+	if len(r.PeerNodeGroups) > 0 {
+		if g := nodeGroup(n, groups); g != nil {
+			reasons = append(reasons, fmt.Sprintf("its peerNodeGroups %q does not list BGPNodeGroup %s of node %s", r.PeerNodeGroups, g.Name, n.Name))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("node %s is in no BGPNodeGroup, so its peerNodeGroups %q do not apply", n.Name, r.PeerNodeGroups))
+		}
+	}
+
+	return reasons
+}
+
+// matchingAlias returns the name of the given Node which the given
+// peerNodes pattern matches, or the empty string if it matches none
+func matchingAlias(pattern string, n *v1.Node) string {
+	for _, alias := range nodeAliases(n) {
+		if ok, _ := path.Match(strings.ToLower(pattern), alias); ok {
+			return alias
+		}
+	}
+
+	return ""
+}
+
+// explainPeer writes why the given Peer is an iBGP neighbor of the given Node
+func explainPeer(w io.Writer, cfg *KubeBGPConfig, state *clusterState, local *v1.Node, p *Peer) {
+	n := findNode(p.Name, state.Nodes)
+
+	mesh := "the primary mesh"
+	if p.Topology != "" {
+		mesh = "topology " + p.Topology
+	}
+
+	fmt.Fprintf(w, "iBGP peer %s (%s) of %s\n", p.Name, p.Address, mesh) // nolint: errcheck
+
+	if t := cfg.topology(p.Topology); t != nil {
+		fmt.Fprintf(w, "  - the nodeSelector %q of topology %s selects node %s (%s) and node %s (%s)\n", t.NodeSelector, t.Name, local.Name, labels.Set(local.Labels), n.Name, labels.Set(n.Labels)) // nolint: errcheck
+	} else {
+		reason, _ := meshReason(local, n, state)
+		fmt.Fprintf(w, "  - %s\n", reason) // nolint: errcheck
+	}
+
+	fmt.Fprintf(w, "  - its address is %s\n", peerAddressReason(cfg, state.NodeGroups, n, p.Topology)) // nolint: errcheck
+
+	switch {
+	case p.ASN == "":
+		fmt.Fprintf(w, "  - its ASN is the system ASN %s\n", cfg.ASN) // nolint: errcheck
+	case p.Topology != "":
+		fmt.Fprintf(w, "  - its ASN is %s, that of topology %s\n", p.ASN, p.Topology) // nolint: errcheck
+	default:
+		fmt.Fprintf(w, "  - its ASN is %s, that of BGPNodeGroup %s\n", p.ASN, nodeGroup(n, state.NodeGroups).Name) // nolint: errcheck
+	}
+
+	if p.RouteReflectorClient {
+		fmt.Fprintf(w, "  - node %s reflects routes to it\n", local.Name) // nolint: errcheck
+	}
+
+	fmt.Fprintf(w, "=> neighbor of node %s\n", local.Name) // nolint: errcheck
+}
+
+// meshReason returns why the given Node peers with the other given Node in
+// the primary mesh, or why it does not, and whether it does
+func meshReason(local, n *v1.Node, state *clusterState) (string, bool) {
+	if !hasReflectors(state) {
+		return "there are no route reflectors, so every Node peers with every other Node", true
+	}
+
+	localReflector, reflector := isReflector(local, state), isReflector(n, state)
+
+	switch {
+	case localReflector && reflector:
+		return fmt.Sprintf("node %s and node %s are both route reflectors (%s; %s), which peer with each other", local.Name, n.Name, reflectorDescription(local, state), reflectorDescription(n, state)), true
+	case localReflector:
+		if reflectorOf(local.Name, n, state) {
+			return fmt.Sprintf("node %s is a route reflector (%s) of its client node %s", local.Name, reflectorDescription(local, state), n.Name), true
+		}
+		return fmt.Sprintf("node %s is a route reflector, but not one elected for the BGPNodeGroup of node %s", local.Name, n.Name), false
+	case reflector:
+		if reflectorOf(n.Name, local, state) {
+			return fmt.Sprintf("node %s is a route reflector (%s) of node %s", n.Name, reflectorDescription(n, state), local.Name), true
+		}
+		return fmt.Sprintf("node %s is a route reflector, but not one elected for the BGPNodeGroup of node %s", n.Name, local.Name), false
+	default:
+		return fmt.Sprintf("neither node %s nor node %s is a route reflector, and the clients of the reflectors peer only with them", local.Name, n.Name), false
+	}
+}
+
+// reflectorDescription describes how the given Node comes to be a route reflector
+func reflectorDescription(n *v1.Node, state *clusterState) string {
+	g := nodeGroup(n, state.NodeGroups)
+	if g == nil {
+		return "in no BGPNodeGroup"
+	}
+
+	if g.Spec.RouteReflector {
+		return fmt.Sprintf("its BGPNodeGroup %s, selected by %q, has routeReflector", g.Name, metav1.FormatLabelSelector(g.Spec.NodeSelector))
+	}
+
+	return fmt.Sprintf("elected among BGPNodeGroup %s", g.Name)
+}
+
+// peerAddressReason describes the rule by which the address of the given
+// Node is chosen as its peer address, in the given topology or else in the
+// primary mesh
+func peerAddressReason(cfg *KubeBGPConfig, groups []v1alpha1.BGPNodeGroup, n *v1.Node, topology string) string {
+	if t := cfg.topology(topology); t != nil {
+		if t.PeerAddress != nil {
+			return fmt.Sprintf("the InternalIP of node %s selected by the peerAddress of topology %s", n.Name, t.Name)
+		}
+		return fmt.Sprintf("the first InternalIP of node %s", n.Name)
+	}
+
+	if s, ok := n.Annotations[peerAddressAnnotation]; ok {
+		return fmt.Sprintf("that of the %s annotation of node %s (%q)", peerAddressAnnotation, n.Name, s)
+	}
+
+	if cidr := cfg.sourceCIDR(n, groups); cidr != "" && sourceAddress(n, cidr) != nil {
+		if g := nodeGroup(n, groups); g != nil && g.Spec.SourceCIDR != "" {
+			return fmt.Sprintf("the first address of node %s within the sourceCIDR %s of its BGPNodeGroup %s", n.Name, cidr, g.Name)
+		}
+		return fmt.Sprintf("the first address of node %s within peerSourceCIDR %s", n.Name, cidr)
+	}
+
+	if cfg.PeerAddress != nil {
+		return fmt.Sprintf("the InternalIP of node %s selected by peerAddress", n.Name)
+	}
+
+	return fmt.Sprintf("the first InternalIP of node %s", n.Name)
+}
+
+// unpeeredReason returns why the given Node, which has the given address,
+// is not a neighbor of the local Node at that address
+func unpeeredReason(cfg *KubeBGPConfig, state *clusterState, local, n *v1.Node, address string) string {
+	if n.Name == local.Name {
+		return "it is the Node itself"
+	}
+
+	if cfg.Mesh == meshDisabled && len(cfg.Topologies) == 0 {
+		return "the mesh is disabled (mesh: disabled), and there are no topologies"
+	}
+
+	if cfg.Mesh != meshDisabled {
+		if reason, ok := meshReason(local, n, state); !ok {
+			return reason
+		}
+
+		if ip, err := peerAddress(n, cfg, state.NodeGroups); err == nil && !sameAddress(ip.String(), address) {
+			return fmt.Sprintf("it is peered at %s instead, %s", ip, peerAddressReason(cfg, state.NodeGroups, n, ""))
+		}
+	}
+
+	return "no topology selects both Nodes at that address"
+}
+
+// nodeHasPeerAddress indicates whether the given address is one of the
+// given Node, or that of its peer-address annotation
+func nodeHasPeerAddress(n *v1.Node, address string) bool {
+	return sameAddress(n.Annotations[peerAddressAnnotation], address) || nodeHasAddress(n, net.ParseIP(address))
+}
+
+// sameAddress indicates whether the given strings are the same IP address
+func sameAddress(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+
+	return ipA != nil && ipB != nil && ipA.Equal(ipB)
+}
+
+// uniqueStrings returns the given strings without repetition, in order
+func uniqueStrings(list []string) (out []string) {
+	for _, s := range list {
+		if !containsString(out, s) {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+)
+
+// routeFamily returns the address family in which the given route is exported
+func routeFamily(r *routes.Route) string {
+	family := "ipv4"
+	if r.IsIPv6() {
+		family = "ipv6"
+	}
+
+	if r.VRF != "" {
+		return "vpn" + strings.TrimPrefix(family, "ip")
+	}
+
+	return family
+}
+
+// routeClass returns the kind of source of the given route, such as `advertisement` or `pod`
+func routeClass(r *routes.Route) string {
+	if r.Source == "" {
+		return "unknown"
+	}
+
+	return strings.SplitN(r.Source, "/", 2)[0]
+}
+
+// countPrefixes returns the number of the given routes of each address
+// family and class, sorted by family and class
+func countPrefixes(list []routes.Route) (counts []v1alpha1.PrefixCount) {
+	index := make(map[[2]string]int)
+
+	for i := range list {
+		key := [2]string{routeFamily(&list[i]), routeClass(&list[i])}
+
+		n, ok := index[key]
+		if !ok {
+			n = len(counts)
+			index[key] = n
+			counts = append(counts, v1alpha1.PrefixCount{Family: key[0], Class: key[1]})
+		}
+		counts[n].Prefixes++
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Family != counts[j].Family {
+			return counts[i].Family < counts[j].Family
+		}
+		return counts[i].Class < counts[j].Class
+	})
+
+	return counts
+}
+
+// neighborRoutes is the list of the routes exported to one neighbor
+type neighborRoutes struct {
+	Address string
+	Name    string
+
+	// Kind is `peer` for an iBGP peer or `router` for a Router
+	Kind string
+
+	Routes []routes.Route
+}
+
+// exportedRoutes returns the given routes exported to each of the given iBGP peers and Routers
+func exportedRoutes(cfg *KubeBGPConfig, peers []Peer, routers []Router, desired []routes.Route) (neighbors []neighborRoutes) {
+	for _, p := range peers {
+		exported := desired
+
+		if t := cfg.topology(p.Topology); t != nil {
+			exported = nil
+			for i := range desired {
+				if t.exports(&desired[i]) {
+					exported = append(exported, desired[i])
+				}
+			}
+		}
+
+		neighbors = append(neighbors, neighborRoutes{Address: p.Address, Name: p.Name, Kind: "peer", Routes: exported})
+	}
+
+	for i := range routers {
+		r := &routers[i]
+
+		var exported []routes.Route
+		for j := range desired {
+			if ok, _ := exportDecision(cfg, &desired[j], r); ok {
+				exported = append(exported, desired[j])
+			}
+		}
+
+		neighbors = append(neighbors, neighborRoutes{Address: r.Address, Name: r.Name, Kind: "router", Routes: exported})
+	}
+
+	return neighbors
+}
+
+// exportCounts returns the number of the given routes exported to each of
+// the given iBGP peers and Routers, by address family and class
+func exportCounts(cfg *KubeBGPConfig, peers []Peer, routers []Router, desired []routes.Route) (neighbors []v1alpha1.NeighborExports) {
+	for _, n := range exportedRoutes(cfg, peers, routers, desired) {
+		neighbors = append(neighbors, v1alpha1.NeighborExports{
+			Address:  n.Address,
+			Name:     n.Name,
+			Kind:     n.Kind,
+			Exported: countPrefixes(n.Routes),
+		})
+	}
+
+	return neighbors
+}
+
+// publishExportCounts publishes the number of prefixes of each address
+// family and class originated by this Node and exported to each of its
+// neighbors, once the desired state has been applied
+func publishExportCounts(originated []v1alpha1.PrefixCount, neighbors []v1alpha1.NeighborExports) {
+	metrics.OriginatedPrefixes.Reset()
+	for _, c := range originated {
+		metrics.OriginatedPrefixes.WithLabelValues(c.Family, c.Class).Set(float64(c.Prefixes))
+	}
+
+	metrics.ExportedPrefixes.Reset()
+	for _, n := range neighbors {
+		for _, c := range n.Exported {
+			metrics.ExportedPrefixes.WithLabelValues(n.Address, c.Family, c.Class).Set(float64(c.Prefixes))
+		}
+	}
+}
+
+// nodeStatusWriter writes the BGPNodeStatus of this Node
+type nodeStatusWriter struct {
+	client dynamic.Interface
+	node   string
+
+	// last is the status last written, by which unchanged statuses are not rewritten
+	last *v1alpha1.BGPNodeStatusStatus
+}
+
+func newNodeStatusWriter(client dynamic.Interface, node string) *nodeStatusWriter {
+	return &nodeStatusWriter{
+		client: client,
+		node:   node,
+	}
+}
+
+// Write updates the BGPNodeStatus of this Node with the given export counts, if they have changed
+func (w *nodeStatusWriter) Write(ctx context.Context, originated []v1alpha1.PrefixCount, neighbors []v1alpha1.NeighborExports, state *clusterState) error {
+	desired := v1alpha1.BGPNodeStatusStatus{
+		Updated:    metav1.NewTime(time.Now()),
+		Originated: originated,
+		Neighbors:  neighbors,
+	}
+
+	if w.last != nil && reflect.DeepEqual(w.last.Originated, desired.Originated) && reflect.DeepEqual(w.last.Neighbors, desired.Neighbors) {
+		return nil
+	}
+
+	s := &v1alpha1.BGPNodeStatus{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.Group + "/" + v1alpha1.Version,
+			Kind:       "BGPNodeStatus",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   w.node,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "kube-bgp"},
+		},
+		Status: desired,
+	}
+
+	// The BGPNodeStatus is garbage-collected along with its Node.
+	if n := findNode(w.node, state.Nodes); n != nil {
+		s.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       n.Name,
+			UID:        n.UID,
+		}}
+	}
+
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(s)
+	if err != nil {
+		return errcode.Wrapf(err, errcode.ConfigInvalid, "failed to encode node status %s", w.node)
+	}
+	obj := &unstructured.Unstructured{Object: data}
+
+	resource := w.client.Resource(v1alpha1.BGPNodeStatusResource)
+
+	existing, err := resource.Get(ctx, w.node, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		if _, err := resource.Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to create node status %s", w.node)
+		}
+	case err != nil:
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get node status %s", w.node)
+	default:
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		if _, err := resource.Update(ctx, obj, metav1.UpdateOptions{}); err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to update node status %s", w.node)
+		}
+	}
+
+	w.last = &desired
+
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package features gates the larger subsystems of kube-bgp, so that they may
+// ship disabled by default and be enabled per cluster, by the featureGates
+// of the configuration, without separate builds
+package features
+
+import (
+	"sort"
+
+	"github.com/rotisserie/eris"
+)
+
+// Stage is the maturity of a feature
+type Stage string
+
+const (
+	// Alpha features are disabled by default, and may change or be removed
+	Alpha Stage = "alpha"
+
+	// Beta features are enabled by default, but may still be disabled
+	Beta Stage = "beta"
+
+	// GA features are always enabled; their gates remain only so that
+	// configurations naming them stay valid, and are removed in time
+	GA Stage = "ga"
+)
+
+// Gate is a feature gate, declared by New as a variable of the package
+// which it gates
+type Gate struct {
+	// Name is the name of the gate in the featureGates of the configuration
+	Name string
+
+	// Stage is the maturity of the feature, which determines its default
+	Stage Stage
+}
+
+// Default indicates whether the feature is enabled unless the configuration says otherwise
+func (g *Gate) Default() bool {
+	return g.Stage != Alpha
+}
+
+var gates = make(map[string]*Gate)
+
+// New declares a feature gate of the given name and stage
+func New(name string, stage Stage) *Gate {
+	if _, ok := gates[name]; ok {
+		panic("feature gate " + name + " declared twice")
+	}
+
+	g := &Gate{Name: name, Stage: stage}
+	gates[name] = g
+
+	return g
+}
+
+// Gates returns every declared feature gate, in order of name
+func Gates() (list []*Gate) {
+	for _, g := range gates {
+		list = append(list, g)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Set is the featureGates of a configuration: whether each feature, by
+// the name of its gate, is enabled, overriding its default
+type Set map[string]bool
+
+// Validate checks that every gate of the Set is declared, and that no GA
+// feature is disabled
+func (s Set) Validate() error {
+	for name, enabled := range s {
+		g, ok := gates[name]
+		if !ok {
+			return eris.Errorf("unknown feature gate %q", name)
+		}
+
+		if g.Stage == GA && !enabled {
+			return eris.Errorf("feature gate %q is GA and cannot be disabled", name)
+		}
+	}
+
+	return nil
+}
+
+// Enabled indicates whether the feature of the given gate is enabled
+func (s Set) Enabled(g *Gate) bool {
+	if enabled, ok := s[g.Name]; ok && g.Stage != GA {
+		return enabled
+	}
+
+	return g.Default()
+}
+
+// Effective returns whether each declared feature is enabled, by the name of its gate
+func (s Set) Effective() map[string]bool {
+	m := make(map[string]bool, len(gates))
+	for name, g := range gates {
+		m[name] = s.Enabled(g)
+	}
+
+	return m
+}
@@ -0,0 +1,76 @@
+package features
+
+import "testing"
+
+var (
+	testAlpha = New("TestAlpha", Alpha)
+	testBeta  = New("TestBeta", Beta)
+	testGA    = New("TestGA", GA)
+)
+
+func TestEnabled(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		set  Set
+		gate *Gate
+		want bool
+	}{
+		{"alpha by default", nil, testAlpha, false},
+		{"alpha enabled", Set{"TestAlpha": true}, testAlpha, true},
+		{"beta by default", nil, testBeta, true},
+		{"beta disabled", Set{"TestBeta": false}, testBeta, false},
+		{"GA by default", nil, testGA, true},
+		{"GA disabled", Set{"TestGA": false}, testGA, true},
+	} {
+		if got := tt.set.Enabled(tt.gate); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		set     Set
+		invalid bool
+	}{
+		{name: "empty"},
+		{name: "declared gates", set: Set{"TestAlpha": true, "TestBeta": false, "TestGA": true}},
+		{name: "unknown gate", set: Set{"TestUnknown": true}, invalid: true},
+		{name: "GA disabled", set: Set{"TestGA": false}, invalid: true},
+	} {
+		if err := tt.set.Validate(); (err != nil) != tt.invalid {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+func TestEffective(t *testing.T) {
+	m := Set{"TestAlpha": true}.Effective()
+
+	if len(m) != len(Gates()) {
+		t.Errorf("expected every gate, got %v", m)
+	}
+	if !m["TestAlpha"] || !m["TestBeta"] || !m["TestGA"] {
+		t.Errorf("unexpected effective gates %v", m)
+	}
+}
+
+func TestGates(t *testing.T) {
+	list := Gates()
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Name >= list[i].Name {
+			t.Errorf("gates out of order: %s before %s", list[i-1].Name, list[i].Name)
+		}
+	}
+}
+
+func TestNewTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a gate declared twice to panic")
+		}
+	}()
+
+	New("TestAlpha", Alpha)
+}
@@ -0,0 +1,90 @@
+#!/bin/sh
+# Verifies the resilience of a chaos build of kube-bgp deployed to the
+# cluster of the current kubectl context: for the given number of rounds, no
+# kube-bgp Pod may restart, and no Node may keep advertising routes other
+# than those it desires for longer than the settle limit.
+#
+# The kube-bgp image must be built with `-tags chaos` and run with faults
+# configured in KUBE_BGP_CHAOS (see chaos.go).  The routes are read from the
+# state dump of the admin API, at the given socket.  Requires kubectl and jq,
+# and curl in the kube-bgp image.
+#
+# Usage: chaos.sh [-n namespace] [-l selector] [-socket path] [-rounds n] [-interval seconds] [-settle rounds]
+set -e
+
+namespace=kube-system
+selector=app=kube-bgp
+socket=/var/run/kube-bgp/admin.sock
+rounds=60
+interval=10
+settle=3
+
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-n) namespace=$2 ;;
+	-l) selector=$2 ;;
+	-socket) socket=$2 ;;
+	-rounds) rounds=$2 ;;
+	-interval) interval=$2 ;;
+	-settle) settle=$2 ;;
+	*) echo "unknown flag $1" >&2; exit 2 ;;
+	esac
+	shift 2
+done
+
+restarts() {
+	kubectl -n "$namespace" get pods -l "$selector" \
+		-o jsonpath='{range .items[*]}{.metadata.name}{" "}{range .status.containerStatuses[*]}{.restartCount}{" "}{end}{"\n"}{end}'
+}
+
+# stale prints the routes which an output of the given Pod has accepted but
+# which the Pod does not desire, and those it desires but which an output
+# has not accepted, from its state dump
+stale() {
+	kubectl -n "$namespace" exec "$1" -- \
+		curl -sf --unix-socket "$socket" http://kube-bgp/debug/state |
+		jq -r '([.desiredRoutes[]?.prefix] | unique) as $want
+			| (.applied // {}) | to_entries[]
+			| .key as $output | ([.value[]?.prefix] | unique) as $have
+			| (($have - $want) | map("stale " + $output + " " + .))
+				+ (($want - $have) | map("missing " + $output + " " + .))
+			| .[]'
+}
+
+# unsettled holds, for each Pod, the number of consecutive rounds in which
+# its routes have not matched those it desires
+unsettled=$(mktemp -d)
+trap 'rm -rf "$unsettled"' EXIT
+
+initial=$(restarts)
+
+round=1
+while [ "$round" -le "$rounds" ]; do
+	sleep "$interval"
+
+	if [ "$(restarts)" != "$initial" ]; then
+		echo "FAIL  round $round: kube-bgp restarted"
+		restarts
+		exit 1
+	fi
+
+	for pod in $(kubectl -n "$namespace" get pods -l "$selector" -o jsonpath='{.items[*].metadata.name}'); do
+		diff=$(stale "$pod" || echo "status unavailable")
+		if [ -z "$diff" ]; then
+			rm -f "$unsettled/$pod"
+			continue
+		fi
+
+		count=$(($(cat "$unsettled/$pod" 2>/dev/null || echo 0) + 1))
+		echo "$count" > "$unsettled/$pod"
+
+		if [ "$count" -gt "$settle" ]; then
+			echo "FAIL  round $round: $pod has not settled in $settle rounds"
+			printf '%s\n' "$diff"
+			exit 1
+		fi
+	done
+
+	echo "PASS  round $round"
+	round=$((round + 1))
+done
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// freezeAnnotation is the annotation of the freeze Namespace which, while
+// present, freezes the advertisements of every Node.  Its value is the
+// reason, which is reported while frozen.
+const freezeAnnotation = "kube-bgp.cycoresystems.com/freeze"
+
+// freezeCheckInterval is the interval at which the freeze annotation is checked
+const freezeCheckInterval = 5 * time.Second
+
+// freezeGate holds the advertisements of this Node at a snapshot while the
+// cluster is frozen, such as during incident response
+type freezeGate struct {
+	namespace string
+	client    kubernetes.Interface
+	recorder  events.Recorder
+
+	// frozen indicates whether the cluster is frozen
+	frozen bool
+
+	// reason is the reason for the freeze, from its annotation
+	reason string
+
+	// last is the list of routes last desired while not frozen
+	last []routes.Route
+
+	// snapshot is the list of routes held while frozen
+	snapshot []routes.Route
+
+	// taken indicates whether the snapshot has been taken
+	taken bool
+}
+
+func newFreezeGate(namespace string, client kubernetes.Interface, recorder events.Recorder) *freezeGate {
+	return &freezeGate{
+		namespace: namespace,
+		client:    client,
+		recorder:  recorder,
+	}
+}
+
+// Check reads the freeze annotation, returning whether the freeze state has
+// changed.  If it cannot be read, the freeze state is left as it was.
+func (f *freezeGate) Check(ctx context.Context) bool {
+	ns, err := f.client.CoreV1().Namespaces().Get(ctx, f.namespace, metav1.GetOptions{})
+	if err != nil {
+		status.Error(errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to check freeze annotation of namespace %s", f.namespace))
+		return false
+	}
+
+	reason, frozen := ns.Annotations[freezeAnnotation]
+	if frozen == f.frozen && reason == f.reason {
+		return false
+	}
+
+	switch {
+	case frozen && !f.frozen:
+		log.Printf("advertisements frozen: %s", reason)
+		f.recorder.Warning("Frozen", "advertisements frozen: %s", reason)
+		metrics.Frozen.Set(1)
+	case !frozen && f.frozen:
+		log.Println("advertisements unfrozen")
+		f.recorder.Normal("Unfrozen", "advertisements unfrozen")
+		metrics.Frozen.Set(0)
+		f.snapshot = nil
+		f.taken = false
+	}
+
+	f.frozen = frozen
+	f.reason = reason
+
+	if frozen {
+		status.SetFrozen(reason)
+	} else {
+		status.SetFrozen("")
+	}
+
+	return true
+}
+
+// Apply returns the routes which this Node should originate, given those
+// which it desires.  While frozen, the routes of the snapshot taken when the
+// freeze began are held, and only Node deaths change them: if this Node is
+// not Ready, its exclusive routes are withdrawn so that they may be taken
+// over, and the routes of EgressIPs which this Node takes over from Nodes
+// which are not Ready are added.
+func (f *freezeGate) Apply(desired []routes.Route, thisNode string, state *clusterState) []routes.Route {
+	if !f.frozen {
+		f.last = desired
+		return desired
+	}
+
+	if !f.taken {
+		// If frozen since startup, nothing has yet been desired.
+		f.snapshot = f.last
+		if f.snapshot == nil {
+			f.snapshot = desired
+		}
+		f.taken = true
+	}
+
+	withdrawExclusive := true
+	if n := findNode(thisNode, state.Nodes); n != nil && nodeReady(n) {
+		withdrawExclusive = false
+	}
+
+	held := make(map[string]bool, len(f.snapshot))
+	var list []routes.Route
+
+	for _, r := range f.snapshot {
+		if r.Exclusive && withdrawExclusive {
+			continue
+		}
+
+		held[r.Prefix] = true
+		list = append(list, r)
+	}
+
+	takenOver := takeOverPrefixes(thisNode, state)
+	for _, r := range desired {
+		if !held[r.Prefix] && takenOver[r.Prefix] {
+			list = append(list, r)
+		}
+	}
+
+	// The changes of Node deaths are kept for the rest of the freeze.
+	f.snapshot = list
+
+	return list
+}
+
+// takeOverPrefixes returns the host prefixes of the EgressIPs which the named
+// Node should host in place of a previous host which is not Ready
+func takeOverPrefixes(thisNode string, state *clusterState) map[string]bool {
+	prefixes := make(map[string]bool)
+
+	for i := range state.EgressIPs {
+		e := &state.EgressIPs[i]
+
+		previous := e.Status.Node
+		if previous == "" || previous == thisNode || egressHost(e, state.Nodes) != thisNode {
+			continue
+		}
+
+		if n := findNode(previous, state.Nodes); n != nil && nodeReady(n) {
+			continue
+		}
+
+		for _, s := range e.Spec.IPs {
+			if ip := net.ParseIP(s); ip != nil {
+				prefixes[hostPrefix(ip)] = true
+			}
+		}
+	}
+
+	return prefixes
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// nopRecorder is an events.Recorder which records nothing
+type nopRecorder struct{}
+
+func (nopRecorder) Normal(reason, messageFmt string, args ...interface{}) {}
+
+func (nopRecorder) Warning(reason, messageFmt string, args ...interface{}) {}
+
+func TestFreezeGate(t *testing.T) {
+	const namespace = "kube-bgp"
+
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+	f := newFreezeGate(namespace, client, nopRecorder{})
+
+	setFreeze := func(reason *string) {
+		t.Helper()
+
+		ns, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ns.Annotations = nil
+		if reason != nil {
+			ns.Annotations = map[string]string{freezeAnnotation: *reason}
+		}
+
+		if _, err := client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := func(name string, ready bool) v1.Node {
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}},
+		}
+	}
+
+	vip := routes.Route{Prefix: "198.51.100.1/32"}
+	exclusive := routes.Route{Prefix: "198.51.100.2/32", Exclusive: true}
+	added := routes.Route{Prefix: "198.51.100.3/32"}
+	egress := routes.Route{Prefix: "203.0.113.1/32", Exclusive: true}
+
+	healthy := &clusterState{Nodes: []v1.Node{node("node-a", true), node("node-b", true)}}
+
+	if f.Check(ctx) {
+		t.Error("expected no change without the freeze annotation")
+	}
+
+	before := []routes.Route{vip, exclusive}
+	if got := f.Apply(before, "node-a", healthy); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the desired routes while not frozen, got %+v", got)
+	}
+
+	reason := "incident 42"
+	setFreeze(&reason)
+
+	if !f.Check(ctx) || !f.frozen || f.reason != reason {
+		t.Fatalf("expected to be frozen for %q, got %v %q", reason, f.frozen, f.reason)
+	}
+	if f.Check(ctx) {
+		t.Error("expected no change while the freeze annotation is unchanged")
+	}
+
+	// The routes desired before the freeze are held.
+	if got := f.Apply([]routes.Route{vip, added}, "node-a", healthy); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the snapshot %+v while frozen, got %+v", before, got)
+	}
+
+	// The EgressIP of node-b, which is no longer Ready, is taken over.
+	dead := &clusterState{
+		Nodes: []v1.Node{node("node-a", true), node("node-b", false)},
+		EgressIPs: []v1alpha1.EgressIP{{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress"},
+			Spec:       v1alpha1.EgressIPSpec{IPs: []string{"203.0.113.1"}},
+			Status:     v1alpha1.EgressIPStatus{Node: "node-b"},
+		}},
+	}
+
+	want := []routes.Route{vip, exclusive, egress}
+	if got := f.Apply([]routes.Route{vip, added, egress}, "node-a", dead); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v once the EgressIP is taken over, got %+v", want, got)
+	}
+
+	// If this Node is not Ready, its exclusive routes are withdrawn.
+	notReady := &clusterState{Nodes: []v1.Node{node("node-a", false), node("node-b", true)}}
+
+	want = []routes.Route{vip}
+	if got := f.Apply([]routes.Route{vip, exclusive}, "node-a", notReady); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v once this node is not ready, got %+v", want, got)
+	}
+
+	setFreeze(nil)
+
+	if !f.Check(ctx) || f.frozen {
+		t.Fatal("expected to be unfrozen")
+	}
+
+	after := []routes.Route{vip, added}
+	if got := f.Apply(after, "node-a", healthy); !reflect.DeepEqual(got, after) {
+		t.Errorf("expected the desired routes once unfrozen, got %+v", got)
+	}
+}
@@ -3,15 +3,19 @@ module github.com/CyCoreSystems/kube-bgp
 go 1.15
 
 require (
+	github.com/osrg/gobgp/v3 v3.20.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rotisserie/eris v0.4.1
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a // indirect
-	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d // indirect
 	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e // indirect
+	google.golang.org/grpc v1.31.1
+	google.golang.org/protobuf v1.24.0
 	gopkg.in/yaml.v2 v2.2.8
 	k8s.io/api v0.19.0
 	k8s.io/apimachinery v0.19.0
-	k8s.io/client-go v11.0.0+incompatible
+	k8s.io/client-go v0.19.0
 	k8s.io/klog v1.0.0 // indirect
 	k8s.io/utils v0.0.0-20200821003339-5e75c0163111 // indirect
 )
@@ -0,0 +1,39 @@
+//go:build embedded
+// +build embedded
+
+package gobgp
+
+import (
+	"context"
+	"net"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	api "github.com/osrg/gobgp/v3/api"
+	"github.com/osrg/gobgp/v3/pkg/server"
+)
+
+// EmbeddedSupported indicates whether the gobgp BGP server is compiled in
+const EmbeddedSupported = true
+
+// StartEmbedded runs the gobgp BGP server within kube-bgp, serving the
+// gobgpd API at the given address, until the given context is done.  It is
+// then controlled through that API, by a Client, just as a gobgpd would be.
+func StartEmbedded(ctx context.Context, address string) error {
+	// The server exits the process if it cannot listen, so the address is
+	// checked first.
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return errcode.Wrapf(err, errcode.ConfigInvalid, "cannot serve the embedded gobgp API at %s", address)
+	}
+	l.Close() // nolint: errcheck
+
+	s := server.NewBgpServer(server.GrpcListenAddress(address))
+	go s.Serve()
+
+	go func() {
+		<-ctx.Done()
+		s.StopBgp(context.Background(), &api.StopBgpRequest{}) // nolint: errcheck
+	}()
+
+	return nil
+}
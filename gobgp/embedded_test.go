@@ -0,0 +1,250 @@
+//go:build embedded
+// +build embedded
+
+package gobgp
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// startEmbedded starts the embedded gobgp server on a free local port, with
+// a global configuration which accepts no BGP sessions (so that the test
+// needs no privileged port), and returns a Client of its API
+func startEmbedded(t *testing.T) *Client {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close() // nolint: errcheck
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if err := StartEmbedded(ctx, address); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(address)
+	t.Cleanup(func() { c.Close() }) // nolint: errcheck
+
+	// A listen port of -1 (sign-extended, as an int32 is) disables the BGP listener.
+	global := message(nil).uint(1, 64512).string(2, "192.0.2.1").uint(3, ^uint64(0))
+
+	// The API is served asynchronously, so it is retried until it is up.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, _, err = c.call(ctx, "StartBgp", message(nil).message(1, global))
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("failed to start the embedded server:", err)
+	}
+
+	return c
+}
+
+func TestEmbeddedNeighbor(t *testing.T) {
+	c := startEmbedded(t)
+	ctx := context.Background()
+
+	asn, routerID, err := c.Global(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asn != 64512 || routerID != "192.0.2.1" {
+		t.Errorf("unexpected global configuration %d %s", asn, routerID)
+	}
+
+	n := NeighborConfig{
+		Address:     "192.0.2.2",
+		ASN:         64513,
+		Description: "router-a",
+		Families:    []string{"ipv4-unicast"},
+	}
+	if err := c.AddNeighborConfig(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := c.Neighbors(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected 1 neighbor, got %+v", list)
+	}
+
+	got := list[0]
+	if got.Address != n.Address || got.ASN != n.ASN || got.Config.Description != n.Description {
+		t.Errorf("neighbor %+v does not match %+v", got, n)
+	}
+	if len(got.Config.Families) != 1 || got.Config.Families[0] != "ipv4-unicast" {
+		t.Errorf("unexpected families %v", got.Config.Families)
+	}
+
+	n.Description = "router-b"
+	if err := c.UpdateNeighborConfig(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := c.Neighbors(ctx); err != nil || len(list) != 1 || list[0].Config.Description != n.Description {
+		t.Errorf("expected the neighbor to be updated in place, got %+v, %v", list, err)
+	}
+
+	if err := c.SoftResetIn(ctx, n.Address); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.DeleteNeighbor(ctx, n.Address); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := c.Neighbors(ctx); err != nil || len(list) != 0 {
+		t.Errorf("expected no neighbors once deleted, got %+v, %v", list, err)
+	}
+}
+
+// The tests below round-trip the hand-encoded messages of the client
+// through the real gobgp server, so that their field numbers are verified
+// against the gobgp API itself rather than only against the client's own
+// decoding.
+
+func TestEmbeddedPaths(t *testing.T) {
+	c := startEmbedded(t)
+	ctx := context.Background()
+
+	pref, aigp := uint32(200), uint32(10)
+
+	v4 := routes.Route{
+		Prefix:      "198.51.100.0/24",
+		Origin:      routes.OriginIncomplete,
+		NextHop:     "192.0.2.1",
+		ASPath:      []uint32{64512, 64513},
+		LocalPref:   &pref,
+		AIGP:        &aigp,
+		Communities: []string{"64512:100", "64512:200"},
+	}
+	v6 := routes.Route{
+		Prefix:  "2001:db8::/64",
+		Origin:  routes.OriginIGP,
+		NextHop: "2001:db8::1",
+	}
+
+	for _, r := range []routes.Route{v4, v6} {
+		if err := c.AddPath(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for family, want := range map[string]routes.Route{"ipv4": v4, "ipv6": v6} {
+		list, err := c.Originated(ctx, family)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list) != 1 || !reflect.DeepEqual(list[0], want) {
+			t.Errorf("%s: expected %+v, got %+v", family, want, list)
+		}
+	}
+
+	if err := c.DeletePath(ctx, v4); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := c.Originated(ctx, "ipv4"); err != nil || len(list) != 0 {
+		t.Errorf("expected no ipv4 paths once deleted, got %+v, %v", list, err)
+	}
+}
+
+func TestEmbeddedPolicy(t *testing.T) {
+	c := startEmbedded(t)
+	ctx := context.Background()
+
+	prefixes := []string{"198.51.100.0/24", "2001:db8::/64"}
+	if err := c.SetPrefixSet(ctx, "kube-bgp-router-a", prefixes); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := c.PrefixSets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sets["kube-bgp-router-a"], prefixes) {
+		t.Errorf("expected prefix set %v, got %v", prefixes, sets)
+	}
+
+	p := Policy{Name: "kube-bgp-router-a", PrefixSets: []string{"kube-bgp-router-a"}, Disposition: AcceptRoute}
+	if err := c.AddPolicy(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := c.Policies(ctx); err != nil || !reflect.DeepEqual(list, []string{p.Name}) {
+		t.Errorf("expected policy %s, got %v, %v", p.Name, list, err)
+	}
+
+	n := NeighborConfig{
+		Address:       "192.0.2.2",
+		ASN:           64513,
+		ExportPolicy:  p.Name,
+		DefaultExport: RejectRoute,
+	}
+	if err := c.AddNeighborConfig(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := c.Neighbors(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Config.ExportPolicy != n.ExportPolicy || list[0].Config.DefaultExport != n.DefaultExport {
+		t.Errorf("expected export policy %s (default %s), got %+v", n.ExportPolicy, n.DefaultExport, list)
+	}
+
+	if err := c.DeleteNeighbor(ctx, n.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeletePolicy(ctx, p.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeletePrefixSet(ctx, "kube-bgp-router-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := c.Policies(ctx); err != nil || len(list) != 0 {
+		t.Errorf("expected no policies once deleted, got %v, %v", list, err)
+	}
+}
+
+func TestEmbeddedVRF(t *testing.T) {
+	c := startEmbedded(t)
+	ctx := context.Background()
+
+	for _, want := range []bool{false, true} {
+		replaced, err := c.AddVRF(ctx, "tenant-a", "64512:1", []string{"64512:1", "192.0.2.1:2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replaced != want {
+			t.Errorf("expected replaced %v, got %v", want, replaced)
+		}
+	}
+
+	if err := c.AddPath(ctx, routes.Route{Prefix: "198.51.100.0/24", VRF: "tenant-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteVRF(ctx, "tenant-a"); err != nil {
+		t.Fatal(err)
+	}
+}
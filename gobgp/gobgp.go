@@ -0,0 +1,997 @@
+// Package gobgp controls a running gobgpd through its gRPC API
+package gobgp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// apiPackages are the packages of the gobgp API, by which its methods are
+// named: that of gobgp 3, and that of gobgp 2
+var apiPackages = []string{"apipb", "gobgpapi"}
+
+// apiGenerations are the gobgp releases of the apiPackages
+var apiGenerations = map[string]string{
+	"apipb":    "v3",
+	"gobgpapi": "v2",
+}
+
+// Client controls gobgpd through its gRPC API
+type Client struct {
+	// Address is the address of the gobgpd gRPC API
+	Address string
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn
+
+	// pkg is the package of the API served by gobgpd, once it is known
+	pkg string
+}
+
+// New returns a new Client for the gobgpd API at the given address
+func New(address string) *Client {
+	return &Client{
+		Address: address,
+	}
+}
+
+// Close closes the connection to gobgpd, if any
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn, c.pkg = nil, ""
+
+	return err
+}
+
+// connect returns the connection to gobgpd, dialing it if need be, along
+// with the package of the API which it serves
+func (c *Client) connect(ctx context.Context) (*grpc.ClientConn, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return nil, "", errcode.Wrapf(err, errcode.ConfigInvalid, "invalid gobgpd API address %s", c.Address)
+		}
+
+		conn, err := grpc.DialContext(ctx, c.Address, grpc.WithInsecure())
+		if err != nil {
+			return nil, "", errcode.Wrapf(err, errcode.GoBGPDUnreachable, "failed to dial gobgpd at %s", c.Address)
+		}
+
+		c.conn = conn
+	}
+
+	if c.pkg != "" {
+		return c.conn, c.pkg, nil
+	}
+
+	// The package is that under which gobgpd answers GetBgp.
+	for _, pkg := range apiPackages {
+		err := c.conn.Invoke(ctx, method(pkg, "GetBgp"), new(message), new(message), grpc.ForceCodec(codec{}))
+		if status.Code(err) == codes.Unimplemented {
+			continue
+		}
+		if err != nil {
+			return nil, "", errcode.Wrap(err, classify(err), "gobgpd GetBgp")
+		}
+
+		c.pkg = pkg
+		return c.conn, pkg, nil
+	}
+
+	return nil, "", errcode.New(errcode.GoBGPDUnreachable, "gobgpd at "+c.Address+" serves no known version of the gobgp API")
+}
+
+// method returns the full name of the given method of the given API package
+func method(pkg, name string) string {
+	return "/" + pkg + ".GobgpApi/" + name
+}
+
+// call calls the given unary method of the gobgpd API, returning its response
+func (c *Client) call(ctx context.Context, name string, req message) (fields, string, error) {
+	conn, pkg, err := c.connect(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var resp message
+	if err := conn.Invoke(ctx, method(pkg, name), &req, &resp, grpc.ForceCodec(codec{})); err != nil {
+		return nil, pkg, errcode.Wrap(err, classify(err), "gobgpd "+name)
+	}
+
+	f, err := decode(resp)
+	return f, pkg, err
+}
+
+// list calls the given server-streaming method of the gobgpd API, passing
+// each of its responses to the given function
+func (c *Client) list(ctx context.Context, name string, req message, each func(pkg string, resp fields)) error {
+	conn, pkg, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method(pkg, name), grpc.ForceCodec(codec{}))
+	if err != nil {
+		return errcode.Wrap(err, classify(err), "gobgpd "+name)
+	}
+
+	if err := stream.SendMsg(&req); err != nil {
+		return errcode.Wrap(err, classify(err), "gobgpd "+name)
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return errcode.Wrap(err, classify(err), "gobgpd "+name)
+	}
+
+	for {
+		var resp message
+		if err := stream.RecvMsg(&resp); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errcode.Wrap(err, classify(err), "gobgpd "+name)
+		}
+
+		f, err := decode(resp)
+		if err != nil {
+			return err
+		}
+
+		each(pkg, f)
+	}
+}
+
+// classify determines whether a gobgpd API failure was due to gobgpd
+// rejecting the request or due to an inability to reach it.  Since gobgpd
+// returns most of its own errors without a status code, any other failure
+// is left uncategorized, and so retried like an unreachable gobgpd.
+func classify(err error) errcode.Code {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return errcode.GoBGPDUnreachable
+	case codes.InvalidArgument, codes.AlreadyExists, codes.NotFound, codes.FailedPrecondition, codes.OutOfRange:
+		return errcode.ApplyRejected
+	}
+
+	return errcode.Unknown
+}
+
+// codec passes the hand-encoded messages of the gobgpd API through gRPC as they are
+type codec struct{}
+
+// Marshal implements encoding.Codec
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*message), nil
+}
+
+// Unmarshal implements encoding.Codec
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*message) = append(message(nil), data...)
+	return nil
+}
+
+// Name implements encoding.Codec.  The messages are protobuf messages, and
+// so are sent with the content-subtype of protobuf.
+func (codec) Name() string {
+	return "proto"
+}
+
+// Version returns the generation of the gobgp API which gobgpd serves
+// (`v2` or `v3`, by the gobgp release)
+func (c *Client) Version(ctx context.Context) (string, error) {
+	_, pkg, err := c.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return apiGenerations[pkg], nil
+}
+
+// Table types of the gobgp API
+const (
+	tableGlobal = 0
+	tableAdjOut = 3
+	tableVRF    = 4
+)
+
+// family encodes the address family (`ipv4` or `ipv6`) as a unicast Family of the gobgp API
+func family(name string) message {
+	afi := uint64(1)
+	if name == "ipv6" {
+		afi = 2
+	}
+
+	return message(nil).uint(1, afi).uint(2, 1)
+}
+
+// familyOf returns the address family of the given Route
+func familyOf(r routes.Route) string {
+	if r.IsIPv6() {
+		return "ipv6"
+	}
+
+	return "ipv4"
+}
+
+// pathOrigins maps the origin codes of the ORIGIN attribute to their names
+var pathOrigins = []routes.Origin{routes.OriginIGP, routes.OriginEGP, routes.OriginIncomplete}
+
+// path encodes the given Route as a Path of the given API package
+func path(pkg string, r routes.Route) (message, error) {
+	ip, network, err := net.ParseCIDR(r.Prefix)
+	if err != nil {
+		return nil, errcode.Wrapf(err, errcode.ApplyRejected, "invalid prefix %s", r.Prefix)
+	}
+	ones, _ := network.Mask.Size()
+
+	nlri := message(nil).uint(1, uint64(ones)).string(2, network.IP.String())
+
+	var origin uint64
+	for i, o := range pathOrigins {
+		if o == r.Origin {
+			origin = uint64(i)
+		}
+	}
+
+	// The NLRI is field 1 of the Path, and each path attribute field 2.
+	p := message(nil).
+		any(1, pkg, "IPAddressPrefix", nlri).
+		any(2, pkg, "OriginAttribute", message(nil).uint(1, origin))
+
+	nextHop := r.NextHop
+	if ip.To4() != nil {
+		if nextHop == "" {
+			nextHop = "0.0.0.0"
+		}
+		p = p.any(2, pkg, "NextHopAttribute", message(nil).string(1, nextHop))
+	} else {
+		if nextHop == "" {
+			nextHop = "::"
+		}
+		p = p.any(2, pkg, "MpReachNLRIAttribute", message(nil).
+			message(1, family("ipv6")).
+			strings(2, []string{nextHop}).
+			any(3, pkg, "IPAddressPrefix", nlri))
+	}
+
+	if len(r.ASPath) > 0 {
+		segment := message(nil).uint(1, 2).uints(2, r.ASPath) // AS_SEQUENCE
+		p = p.any(2, pkg, "AsPathAttribute", message(nil).message(1, segment))
+	}
+
+	if r.LocalPref != nil {
+		p = p.any(2, pkg, "LocalPrefAttribute", message(nil).uint(1, uint64(*r.LocalPref)))
+	}
+
+	if r.AIGP != nil {
+		tlv := message(nil).any(1, pkg, "AigpTLVIGPMetric", message(nil).uint(1, uint64(*r.AIGP)))
+		p = p.any(2, pkg, "AigpAttribute", tlv)
+	}
+
+	if len(r.Communities) > 0 {
+		list := make([]uint32, 0, len(r.Communities))
+		for _, s := range r.Communities {
+			v, err := routes.ParseCommunity(s)
+			if err != nil {
+				return nil, errcode.Wrap(err, errcode.ApplyRejected, "invalid community of "+r.Prefix)
+			}
+			list = append(list, v)
+		}
+		p = p.any(2, pkg, "CommunitiesAttribute", message(nil).uints(1, list))
+	}
+
+	return p.message(9, family(familyOf(r))), nil
+}
+
+// AddPath implements routes.Speaker
+func (c *Client) AddPath(ctx context.Context, r routes.Route) error {
+	_, pkg, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	p, err := path(pkg, r)
+	if err != nil {
+		return err
+	}
+
+	req := message(nil).uint(1, table(r)).string(2, r.VRF).message(3, p)
+
+	_, _, err = c.call(ctx, "AddPath", req)
+	return errcode.Wrap(err, errcode.Of(err), "failed to add path "+r.Prefix)
+}
+
+// DeletePath implements routes.Speaker
+func (c *Client) DeletePath(ctx context.Context, r routes.Route) error {
+	_, pkg, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	p, err := path(pkg, r)
+	if err != nil {
+		return err
+	}
+
+	req := message(nil).uint(1, table(r)).string(2, r.VRF).message(3, family(familyOf(r))).message(4, p)
+
+	_, _, err = c.call(ctx, "DeletePath", req)
+	return errcode.Wrap(err, errcode.Of(err), "failed to delete path "+r.Prefix)
+}
+
+// table returns the table type of the RIB of the given route: that of its
+// VRF, or else the global RIB
+func table(r routes.Route) uint64 {
+	if r.VRF != "" {
+		return tableVRF
+	}
+
+	return tableGlobal
+}
+
+// AddVRF creates a VRF with the given route distinguisher, whose routes are
+// exported with the given route targets.  An existing VRF of the same name
+// (such as one left by a previous kube-bgp) is replaced, along with its
+// routes, in which case replaced is true.
+func (c *Client) AddVRF(ctx context.Context, name, rd string, routeTargets []string) (replaced bool, err error) {
+	_, pkg, err := c.connect(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	vrf, err := vrfMessage(pkg, name, rd, routeTargets)
+	if err != nil {
+		return false, err
+	}
+
+	_, _, err = c.call(ctx, "AddVrf", message(nil).message(1, vrf))
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		return false, err
+	}
+
+	if err := c.DeleteVRF(ctx, name); err != nil {
+		return false, err
+	}
+
+	_, _, err = c.call(ctx, "AddVrf", message(nil).message(1, vrf))
+	return true, err
+}
+
+// vrfMessage encodes the described VRF as a Vrf of the given API package
+func vrfMessage(pkg, name, rd string, routeTargets []string) (message, error) {
+	admin, assigned, err := splitVPNValue(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	vrf := message(nil).string(1, name)
+
+	switch ip := net.ParseIP(admin); {
+	case ip != nil:
+		vrf = vrf.any(2, pkg, "RouteDistinguisherIPAddress", message(nil).string(1, admin).uint(2, assigned))
+	default:
+		asn, _ := strconv.ParseUint(admin, 10, 32)
+		typ := "RouteDistinguisherTwoOctetASN"
+		if asn > 0xffff {
+			typ = "RouteDistinguisherFourOctetASN"
+		}
+		vrf = vrf.any(2, pkg, typ, message(nil).uint(1, asn).uint(2, assigned))
+	}
+
+	for _, rt := range routeTargets {
+		admin, assigned, err := splitVPNValue(rt)
+		if err != nil {
+			return nil, err
+		}
+
+		// The route target subtype of the transitive extended communities
+		const routeTarget = 2
+
+		switch ip := net.ParseIP(admin); {
+		case ip != nil:
+			vrf = vrf.any(4, pkg, "IPv4AddressSpecificExtended", message(nil).bool(1, true).uint(2, routeTarget).string(3, admin).uint(4, assigned))
+		default:
+			asn, _ := strconv.ParseUint(admin, 10, 32)
+			typ := "TwoOctetAsSpecificExtended"
+			if asn > 0xffff {
+				typ = "FourOctetAsSpecificExtended"
+			}
+			vrf = vrf.any(4, pkg, typ, message(nil).bool(1, true).uint(2, routeTarget).uint(3, asn).uint(4, assigned))
+		}
+	}
+
+	return vrf, nil
+}
+
+// splitVPNValue splits a route distinguisher or route target, of the form
+// `<asn>:<value>` or `<ipv4>:<value>`, into its administrator and value
+func splitVPNValue(s string) (admin string, value uint64, err error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", 0, errcode.New(errcode.ApplyRejected, "invalid route distinguisher or target "+s)
+	}
+
+	value, err = strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return "", 0, errcode.Wrap(err, errcode.ApplyRejected, "invalid route distinguisher or target "+s)
+	}
+
+	return s[:i], value, nil
+}
+
+// DeleteVRF deletes the given VRF, along with its routes
+func (c *Client) DeleteVRF(ctx context.Context, name string) error {
+	_, _, err := c.call(ctx, "DeleteVrf", message(nil).string(1, name))
+	return err
+}
+
+// SoftResetOut re-sends every route to the neighbor with the given address,
+// without resetting the session
+func (c *Client) SoftResetOut(ctx context.Context, address string) error {
+	const directionOut = 1
+
+	_, _, err := c.call(ctx, "ResetPeer", message(nil).string(1, address).bool(3, true).uint(4, directionOut))
+	return err
+}
+
+// SoftResetIn re-evaluates every route received from the neighbor with the
+// given address against its import policies, without resetting the session
+func (c *Client) SoftResetIn(ctx context.Context, address string) error {
+	const directionIn = 0
+
+	_, _, err := c.call(ctx, "ResetPeer", message(nil).string(1, address).bool(3, true).uint(4, directionIn))
+	return err
+}
+
+// ensure Client implements routes.Speaker
+var _ routes.Speaker = (*Client)(nil)
+
+// Global returns the ASN and router-id of gobgpd, or a zero ASN if its BGP
+// instance has not been started
+func (c *Client) Global(ctx context.Context) (asn uint32, routerID string, err error) {
+	resp, _, err := c.call(ctx, "GetBgp", nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	g := resp.message(1)
+
+	return uint32(g.uint(1)), g.string(2), nil
+}
+
+// StartGlobal starts the BGP instance of gobgpd with the given ASN and router-id
+func (c *Client) StartGlobal(ctx context.Context, asn uint32, routerID string) error {
+	g := message(nil).uint(1, uint64(asn)).string(2, routerID)
+
+	_, _, err := c.call(ctx, "StartBgp", message(nil).message(1, g))
+	return err
+}
+
+// SessionState is the BGP FSM state of a neighbor
+type SessionState string
+
+// SessionEstablished is the SessionState of an established session
+const SessionEstablished SessionState = "established"
+
+// sessionStates maps the numeric session states of the gobgp API to their names
+var sessionStates = []SessionState{"unknown", "idle", "connect", "active", "opensent", "openconfirm", SessionEstablished}
+
+// Neighbor describes the state of a single neighbor of gobgpd
+type Neighbor struct {
+	// Address is the address of the neighbor
+	Address string
+
+	// ASN is the ASN of the neighbor
+	ASN uint32
+
+	// State is the state of the BGP session with the neighbor
+	State SessionState
+
+	// Config is the running configuration of the neighbor, as gobgpd
+	// reports it, with its defaults filled in
+	Config NeighborConfig
+
+	// Families is the count of the paths received from the neighbor in
+	// each of its address families
+	Families []FamilyCount
+}
+
+// FamilyCount is the number of the paths of an address family which gobgpd
+// holds in the Adj-RIB-In of a neighbor, and the number of those which it
+// accepted (those not rejected by its import policy)
+type FamilyCount struct {
+	Family   string
+	Received uint64
+	Accepted uint64
+}
+
+// Neighbors returns the current list of neighbors of gobgpd
+func (c *Client) Neighbors(ctx context.Context) ([]Neighbor, error) {
+	var list []Neighbor
+
+	err := c.list(ctx, "ListPeer", nil, func(_ string, resp fields) {
+		list = append(list, neighbor(resp.message(1)))
+	})
+
+	return list, err
+}
+
+// neighbor decodes the state of a neighbor from a Peer of the gobgp API
+func neighbor(p fields) Neighbor {
+	n := Neighbor{
+		Config: neighborConfig(p),
+	}
+	n.Address, n.ASN = n.Config.Address, n.Config.ASN
+
+	if s := p.message(5).uint(13); s < uint64(len(sessionStates)) {
+		n.State = sessionStates[s]
+	} else {
+		n.State = sessionStates[0]
+	}
+
+	for _, afiSafi := range p.messages(10) {
+		state := afiSafi.message(3)
+
+		family := state.message(1)
+		if _, ok := family[1]; !ok {
+			family = afiSafi.message(2).message(1)
+		}
+
+		for name, f := range afiSafis {
+			if f[0] == family.uint(1) && f[1] == family.uint(2) {
+				n.Families = append(n.Families, FamilyCount{Family: name, Received: state.uint(3), Accepted: state.uint(4)})
+			}
+		}
+	}
+	sort.Slice(n.Families, func(i, j int) bool { return n.Families[i].Family < n.Families[j].Family })
+
+	return n
+}
+
+// Route dispositions of the policies of gobgpd
+const (
+	AcceptRoute = "accept-route"
+	RejectRoute = "reject-route"
+)
+
+// routeActions maps the route dispositions to the RouteActions of the gobgp API
+var routeActions = map[string]uint64{
+	AcceptRoute: 1,
+	RejectRoute: 2,
+}
+
+// NeighborConfig describes the configuration of a neighbor added to gobgpd
+type NeighborConfig struct {
+	// Address is the address of the neighbor
+	Address string
+
+	// ASN is the ASN of the neighbor
+	ASN uint32
+
+	// LocalASN is the ASN by which gobgpd peers with the neighbor.
+	// If zero, that of gobgpd is used.
+	LocalASN uint32
+
+	// Description is the description of the neighbor
+	Description string
+
+	// Password is the TCP MD5 password of the session, if any
+	Password string
+
+	// Port is the remote port of the session.
+	// If zero, the standard BGP port is used.
+	Port int
+
+	// LocalAddress and BindInterface are the source address and the
+	// interface of the session, if any
+	LocalAddress  string
+	BindInterface string
+
+	// MinAdvertisementInterval is the MRAI in seconds.
+	// If zero, that of gobgpd is used.
+	MinAdvertisementInterval int
+
+	// RestartTime is the time, in seconds, for which the routes of the
+	// neighbor are retained as a graceful restart helper, or zero for none
+	RestartTime int
+
+	// Families is the list of the address families (such as
+	// `ipv4-unicast`) of the neighbor.  If empty, those of gobgpd are used.
+	Families []string
+
+	// RouteReflectorClient indicates that the neighbor is a route reflector client
+	RouteReflectorClient bool
+
+	// ClusterID is the cluster ID of a route reflector client.
+	// If empty, the router-id of gobgpd is used.
+	ClusterID string
+
+	// ExportPolicy is the name of the export policy of the neighbor, if
+	// any, and DefaultExport the disposition (AcceptRoute or RejectRoute)
+	// of the routes which it does not match
+	ExportPolicy  string
+	DefaultExport string
+}
+
+// afiSafis maps the names of the address families to their AFI and SAFI
+var afiSafis = map[string][2]uint64{
+	"ipv4-unicast":       {1, 1},
+	"ipv6-unicast":       {2, 1},
+	"l3vpn-ipv4-unicast": {1, 128},
+	"l3vpn-ipv6-unicast": {2, 128},
+}
+
+// peer encodes the described neighbor as a Peer of the gobgp API
+func peer(n NeighborConfig) (message, error) {
+	p := message(nil).message(2, message(nil).
+		string(1, n.Password).
+		string(2, n.Description).
+		uint(3, uint64(n.LocalASN)).
+		string(4, n.Address).
+		uint(5, uint64(n.ASN)))
+
+	if n.RouteReflectorClient {
+		p = p.message(4, message(nil).bool(1, true).string(2, n.ClusterID))
+	}
+
+	if n.MinAdvertisementInterval != 0 {
+		p = p.message(6, message(nil).message(1, message(nil).uint(4, uint64(n.MinAdvertisementInterval))))
+	}
+
+	if n.Port != 0 || n.LocalAddress != "" || n.BindInterface != "" {
+		p = p.message(7, message(nil).string(1, n.LocalAddress).uint(6, uint64(n.Port)).string(8, n.BindInterface))
+	}
+
+	if n.RestartTime != 0 {
+		p = p.message(9, message(nil).bool(1, true).uint(2, uint64(n.RestartTime)).bool(3, true))
+	}
+
+	for _, name := range n.Families {
+		f, ok := afiSafis[name]
+		if !ok {
+			return nil, errcode.New(errcode.ApplyRejected, "unknown address family "+name)
+		}
+
+		family := message(nil).uint(1, f[0]).uint(2, f[1])
+		p = p.message(10, message(nil).message(2, message(nil).message(1, family).bool(2, true)))
+	}
+
+	if n.ExportPolicy != "" {
+		const directionExport = 2
+
+		assignment := message(nil).
+			uint(2, directionExport).
+			message(3, message(nil).string(1, n.ExportPolicy)).
+			uint(4, routeActions[n.DefaultExport])
+		p = p.message(1, message(nil).message(2, assignment))
+	}
+
+	return p, nil
+}
+
+// neighborConfig decodes the configuration of the given Peer of the gobgp API
+func neighborConfig(p fields) NeighborConfig {
+	conf := p.message(2)
+
+	n := NeighborConfig{
+		Password:    conf.string(1),
+		Description: conf.string(2),
+		LocalASN:    uint32(conf.uint(3)),
+		Address:     conf.string(4),
+		ASN:         uint32(conf.uint(5)),
+	}
+
+	rr := p.message(4)
+	n.RouteReflectorClient, n.ClusterID = rr.bool(1), rr.string(2)
+
+	n.MinAdvertisementInterval = int(p.message(6).message(1).uint(4))
+
+	transport := p.message(7)
+	n.LocalAddress, n.Port, n.BindInterface = transport.string(1), int(transport.uint(6)), transport.string(8)
+
+	if gr := p.message(9); gr.bool(1) {
+		n.RestartTime = int(gr.uint(2))
+	}
+
+	for _, a := range p.messages(10) {
+		afiSafi := a.message(2)
+		if !afiSafi.bool(2) {
+			continue
+		}
+
+		family := afiSafi.message(1)
+		for name, f := range afiSafis {
+			if f[0] == family.uint(1) && f[1] == family.uint(2) {
+				n.Families = append(n.Families, name)
+			}
+		}
+	}
+	sort.Strings(n.Families)
+
+	export := p.message(1).message(2)
+	if policies := export.messages(3); len(policies) > 0 {
+		n.ExportPolicy = policies[0].string(1)
+
+		for name, action := range routeActions {
+			if action == export.uint(4) {
+				n.DefaultExport = name
+			}
+		}
+	}
+
+	return n
+}
+
+// AddNeighbor adds a neighbor of the given address and ASN to gobgpd
+func (c *Client) AddNeighbor(ctx context.Context, address string, asn uint32) error {
+	return c.AddNeighborConfig(ctx, NeighborConfig{Address: address, ASN: asn})
+}
+
+// AddNeighborConfig adds the described neighbor to gobgpd
+func (c *Client) AddNeighborConfig(ctx context.Context, n NeighborConfig) error {
+	p, err := peer(n)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.call(ctx, "AddPeer", message(nil).message(1, p))
+	return err
+}
+
+// UpdateNeighborConfig changes the configuration of the described neighbor
+// of gobgpd in place.  Gobgpd resets its session only if the change
+// requires it; if the change requires only the routes received from the
+// neighbor to be re-evaluated (such as one of its policies), they are, by
+// an inbound soft reset.
+func (c *Client) UpdateNeighborConfig(ctx context.Context, n NeighborConfig) error {
+	p, err := peer(n)
+	if err != nil {
+		return err
+	}
+
+	resp, _, err := c.call(ctx, "UpdatePeer", message(nil).message(1, p))
+	if err != nil {
+		return err
+	}
+
+	// needs_soft_reset_in
+	if resp.bool(1) {
+		return c.SoftResetIn(ctx, n.Address)
+	}
+
+	return nil
+}
+
+// DeleteNeighbor removes the neighbor of the given address from gobgpd
+func (c *Client) DeleteNeighbor(ctx context.Context, address string) error {
+	_, _, err := c.call(ctx, "DeletePeer", message(nil).string(1, address))
+	return err
+}
+
+// prefixSet encodes the prefix set of the given name as a DefinedSet of the
+// gobgp API, each of whose prefixes is matched exactly
+func prefixSet(name string, prefixes []string) message {
+	set := message(nil).string(2, name) // a prefix set is of the zero type
+
+	for _, prefix := range prefixes {
+		p := message(nil).string(1, prefix)
+		if _, network, err := net.ParseCIDR(prefix); err == nil {
+			ones, _ := network.Mask.Size()
+			p = p.uint(2, uint64(ones)).uint(3, uint64(ones))
+		}
+
+		set = set.message(4, p)
+	}
+
+	return set
+}
+
+// PrefixSets returns the prefixes of each prefix set of gobgpd, by name
+func (c *Client) PrefixSets(ctx context.Context) (map[string][]string, error) {
+	sets := make(map[string][]string)
+
+	err := c.list(ctx, "ListDefinedSet", nil, func(_ string, resp fields) {
+		set := resp.message(1)
+
+		var prefixes []string
+		for _, p := range set.messages(4) {
+			prefixes = append(prefixes, p.string(1))
+		}
+
+		sets[set.string(2)] = prefixes
+	})
+
+	return sets, err
+}
+
+// SetPrefixSet creates the prefix set of the given name, or replaces the
+// prefixes of an existing one, so that its policies match the new prefixes
+// at once
+func (c *Client) SetPrefixSet(ctx context.Context, name string, prefixes []string) error {
+	_, _, err := c.call(ctx, "AddDefinedSet", message(nil).message(1, prefixSet(name, prefixes)).bool(2, true))
+	return err
+}
+
+// DeletePrefixSet deletes the prefix set of the given name
+func (c *Client) DeletePrefixSet(ctx context.Context, name string) error {
+	_, _, err := c.call(ctx, "DeleteDefinedSet", message(nil).message(1, prefixSet(name, nil)).bool(2, true))
+	return err
+}
+
+// Policy is a policy of gobgpd, by which the routes of its prefix sets are
+// given its disposition
+type Policy struct {
+	Name       string
+	PrefixSets []string
+
+	// Disposition is AcceptRoute or RejectRoute
+	Disposition string
+}
+
+// Policies returns the names of the policies of gobgpd
+func (c *Client) Policies(ctx context.Context) ([]string, error) {
+	var list []string
+
+	err := c.list(ctx, "ListPolicy", nil, func(_ string, resp fields) {
+		list = append(list, resp.message(1).string(1))
+	})
+
+	return list, err
+}
+
+// AddPolicy adds the given policy to gobgpd, with a statement for each of
+// its prefix sets.  The statements are named after the policy, since
+// gobgpd requires the names of all statements to be distinct.
+func (c *Client) AddPolicy(ctx context.Context, p Policy) error {
+	policy := message(nil).string(1, p.Name)
+
+	for i, set := range p.PrefixSets {
+		statement := message(nil).
+			string(1, p.Name+"-"+strconv.Itoa(i)).
+			message(2, message(nil).message(1, message(nil).string(2, set))).
+			message(3, message(nil).uint(1, routeActions[p.Disposition]))
+
+		policy = policy.message(2, statement)
+	}
+
+	_, _, err := c.call(ctx, "AddPolicy", message(nil).message(1, policy))
+	return err
+}
+
+// DeletePolicy deletes the policy of the given name, along with its statements
+func (c *Client) DeletePolicy(ctx context.Context, name string) error {
+	_, _, err := c.call(ctx, "DeletePolicy", message(nil).message(1, message(nil).string(1, name)).bool(3, true))
+	return err
+}
+
+// paths lists the paths of the given table of gobgpd, passing each, along
+// with its prefix, to the given function
+func (c *Client) paths(ctx context.Context, tableType uint64, name, familyName string, prefixes []string, each func(prefix string, path fields)) error {
+	req := message(nil).uint(1, tableType).string(2, name).message(3, family(familyName))
+	for _, prefix := range prefixes {
+		req = req.message(4, message(nil).string(1, prefix))
+	}
+
+	return c.list(ctx, "ListPath", req, func(_ string, resp fields) {
+		d := resp.message(1)
+		for _, p := range d.messages(2) {
+			each(d.string(1), p)
+		}
+	})
+}
+
+// local indicates whether the given path is originated by gobgpd itself,
+// rather than received from a neighbor
+func local(p fields) bool {
+	ip := net.ParseIP(p.string(15))
+	return ip == nil || ip.IsUnspecified()
+}
+
+// Originated returns the routes of the given address family (`ipv4` or
+// `ipv6`) of the global RIB which gobgpd originates itself, with their
+// path attributes
+func (c *Client) Originated(ctx context.Context, family string) ([]routes.Route, error) {
+	var list []routes.Route
+	seen := make(map[string]bool)
+
+	err := c.paths(ctx, tableGlobal, "", family, nil, func(prefix string, p fields) {
+		if !local(p) || seen[prefix] {
+			return
+		}
+		seen[prefix] = true
+
+		r := routes.Route{Prefix: prefix}
+		for _, a := range p.messages(2) {
+			typ, attr := a.any()
+
+			switch typ {
+			case "OriginAttribute":
+				if o := attr.uint(1); o < uint64(len(pathOrigins)) {
+					r.Origin = pathOrigins[o]
+				}
+			case "AsPathAttribute":
+				for _, seg := range attr.messages(1) {
+					for _, asn := range seg.uints(2) {
+						r.ASPath = append(r.ASPath, uint32(asn))
+					}
+				}
+			case "NextHopAttribute":
+				r.NextHop = attr.string(1)
+			case "MpReachNLRIAttribute":
+				if hops := attr.strings(2); len(hops) > 0 {
+					r.NextHop = hops[0]
+				}
+			case "LocalPrefAttribute":
+				pref := uint32(attr.uint(1))
+				r.LocalPref = &pref
+			case "AigpAttribute":
+				for _, tlv := range attr.messages(1) {
+					if typ, metric := tlv.any(); typ == "AigpTLVIGPMetric" {
+						m := uint32(metric.uint(1))
+						r.AIGP = &m
+					}
+				}
+			case "CommunitiesAttribute":
+				for _, c := range attr.uints(1) {
+					r.Communities = append(r.Communities, strconv.FormatUint(c>>16, 10)+":"+strconv.FormatUint(c&0xffff, 10))
+				}
+			}
+		}
+
+		list = append(list, r)
+	})
+
+	return list, err
+}
+
+// ReceivedFrom returns the addresses of the neighbors from which gobgpd has
+// received a path for the given prefix, ignoring the paths which it
+// originates itself
+func (c *Client) ReceivedFrom(ctx context.Context, prefix string) ([]string, error) {
+	var list []string
+
+	err := c.paths(ctx, tableGlobal, "", familyOf(routes.Route{Prefix: prefix}), []string{prefix}, func(_ string, p fields) {
+		if !local(p) {
+			list = append(list, net.ParseIP(p.string(15)).String())
+		}
+	})
+
+	return list, err
+}
+
+// AdvertisedTo returns the prefixes of the paths of the given address family
+// (`ipv4` or `ipv6`) which gobgpd originates itself and which are in its
+// adj-RIB-out for the neighbor with the given address, that is, which it
+// advertises to the neighbor once its export policy has been applied
+func (c *Client) AdvertisedTo(ctx context.Context, address, family string) ([]string, error) {
+	var list []string
+	seen := make(map[string]bool)
+
+	err := c.paths(ctx, tableAdjOut, address, family, nil, func(prefix string, p fields) {
+		if local(p) && !seen[prefix] {
+			seen[prefix] = true
+			list = append(list, prefix)
+		}
+	})
+
+	return list, err
+}
@@ -0,0 +1,269 @@
+package gobgp
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want errcode.Code
+	}{
+		{status.Error(codes.Unavailable, "connection refused"), errcode.GoBGPDUnreachable},
+		{status.Error(codes.DeadlineExceeded, "timeout"), errcode.GoBGPDUnreachable},
+		{status.Error(codes.Canceled, "cancelled"), errcode.GoBGPDUnreachable},
+		{status.Error(codes.InvalidArgument, "invalid"), errcode.ApplyRejected},
+		{status.Error(codes.AlreadyExists, "exists"), errcode.ApplyRejected},
+		{status.Error(codes.NotFound, "not found"), errcode.ApplyRejected},
+		{status.Error(codes.FailedPrecondition, "not started"), errcode.ApplyRejected},
+		{status.Error(codes.OutOfRange, "out of range"), errcode.ApplyRejected},
+		{status.Error(codes.Internal, "internal"), errcode.Unknown},
+		{status.Error(codes.Unimplemented, "unimplemented"), errcode.Unknown},
+		{errors.New("plain"), errcode.Unknown},
+	} {
+		if got := classify(tt.err); got != tt.want {
+			t.Errorf("%v: expected %s, got %s", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestPeerRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		n    NeighborConfig
+	}{
+		{"minimal", NeighborConfig{Address: "10.0.0.254", ASN: 65000}},
+		{"router", NeighborConfig{
+			Address:                  "10.0.0.254",
+			ASN:                      65000,
+			LocalASN:                 64512,
+			Description:              "edge-a",
+			Password:                 "secret",
+			Port:                     1179,
+			LocalAddress:             "10.0.0.1",
+			BindInterface:            "eth1",
+			MinAdvertisementInterval: 5,
+			Families:                 []string{"ipv4-unicast", "ipv6-unicast", "l3vpn-ipv4-unicast"},
+			ExportPolicy:             "kube-bgp-edge-a-0123abcd",
+			DefaultExport:            AcceptRoute,
+		}},
+		{"reflector client", NeighborConfig{
+			Address:              "10.0.0.2",
+			ASN:                  64512,
+			RestartTime:          120,
+			RouteReflectorClient: true,
+			ClusterID:            "10.0.0.1",
+			ExportPolicy:         "kube-bgp-topology",
+			DefaultExport:        RejectRoute,
+		}},
+	} {
+		p, err := peer(tt.n)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		f, err := decode(p)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := neighborConfig(f); !reflect.DeepEqual(got, tt.n) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.n, got)
+		}
+	}
+}
+
+func TestPeerUnknownFamily(t *testing.T) {
+	_, err := peer(NeighborConfig{Address: "10.0.0.254", ASN: 65000, Families: []string{"evpn"}})
+	if errcode.Of(err) != errcode.ApplyRejected {
+		t.Errorf("expected %s, got %v", errcode.ApplyRejected, err)
+	}
+}
+
+func TestNeighbor(t *testing.T) {
+	afiSafi := func(afi, safi uint64, inState bool, received, accepted uint64) message {
+		family := message(nil).uint(1, afi).uint(2, safi)
+
+		state := message(nil).uint(3, received).uint(4, accepted)
+		if inState {
+			state = message(nil).message(1, family).uint(3, received).uint(4, accepted)
+		}
+
+		return message(nil).message(2, message(nil).message(1, family).bool(2, true)).message(3, state)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		p        message
+		state    SessionState
+		families []FamilyCount
+	}{
+		{
+			name:  "established",
+			p:     message(nil).message(2, message(nil).string(4, "10.0.0.254").uint(5, 65000)).message(5, message(nil).uint(13, 6)),
+			state: SessionEstablished,
+		},
+		{
+			name:  "unknown state",
+			p:     message(nil).message(2, message(nil).string(4, "10.0.0.254").uint(5, 65000)).message(5, message(nil).uint(13, 42)),
+			state: "unknown",
+		},
+		{
+			name: "counts",
+			p: message(nil).
+				message(2, message(nil).string(4, "10.0.0.254").uint(5, 65000)).
+				message(5, message(nil).uint(13, 1)).
+				message(10, afiSafi(2, 1, true, 3, 1)).
+				message(10, afiSafi(1, 1, false, 10, 8)),
+			state: "idle",
+			families: []FamilyCount{
+				{Family: "ipv4-unicast", Received: 10, Accepted: 8},
+				{Family: "ipv6-unicast", Received: 3, Accepted: 1},
+			},
+		},
+	} {
+		f, err := decode(tt.p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := neighbor(f)
+		if n.Address != "10.0.0.254" || n.ASN != 65000 || n.State != tt.state {
+			t.Errorf("%s: unexpected neighbor %+v", tt.name, n)
+		}
+		if !reflect.DeepEqual(n.Families, tt.families) {
+			t.Errorf("%s: expected counts %v, got %v", tt.name, tt.families, n.Families)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	aigp := uint32(20)
+	localPref := uint32(200)
+
+	for _, tt := range []struct {
+		name       string
+		route      routes.Route
+		afi        uint64
+		attributes []string
+		invalid    bool
+	}{
+		{
+			name:       "ipv4",
+			route:      routes.Route{Prefix: "10.1.2.3/32"},
+			afi:        1,
+			attributes: []string{"OriginAttribute", "NextHopAttribute"},
+		},
+		{
+			name:       "ipv6",
+			route:      routes.Route{Prefix: "2001:db8::1/128"},
+			afi:        2,
+			attributes: []string{"OriginAttribute", "MpReachNLRIAttribute"},
+		},
+		{
+			name:       "every attribute",
+			route:      routes.Route{Prefix: "10.1.0.0/16", ASPath: []uint32{64512}, LocalPref: &localPref, AIGP: &aigp, Communities: []string{"64512:100"}},
+			afi:        1,
+			attributes: []string{"OriginAttribute", "NextHopAttribute", "AsPathAttribute", "LocalPrefAttribute", "AigpAttribute", "CommunitiesAttribute"},
+		},
+		{name: "invalid prefix", route: routes.Route{Prefix: "10.1.2.3"}, invalid: true},
+		{name: "invalid community", route: routes.Route{Prefix: "10.1.2.3/32", Communities: []string{"x"}}, invalid: true},
+	} {
+		m, err := path("apipb", tt.route)
+		if tt.invalid {
+			if errcode.Of(err) != errcode.ApplyRejected {
+				t.Errorf("%s: expected %s, got %v", tt.name, errcode.ApplyRejected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		f, err := decode(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.message(9).uint(1) != tt.afi {
+			t.Errorf("%s: expected AFI %d, got %d", tt.name, tt.afi, f.message(9).uint(1))
+		}
+
+		_, network, _ := net.ParseCIDR(tt.route.Prefix)
+		ones, _ := network.Mask.Size()
+
+		if typ, nlri := f.message(1).any(); typ != "IPAddressPrefix" || nlri.string(2) != network.IP.String() || nlri.uint(1) != uint64(ones) {
+			t.Errorf("%s: unexpected NLRI %s %v", tt.name, typ, nlri)
+		}
+
+		var attributes []string
+		for _, a := range f.messages(2) {
+			typ, _ := a.any()
+			attributes = append(attributes, typ)
+		}
+		if !reflect.DeepEqual(attributes, tt.attributes) {
+			t.Errorf("%s: expected attributes %v, got %v", tt.name, tt.attributes, attributes)
+		}
+	}
+}
+
+func TestPrefixSet(t *testing.T) {
+	f, err := decode(prefixSet("kube-bgp-edge-a-ipv4", []string{"10.1.2.3/32", "10.2.0.0/16"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := f.string(2); name != "kube-bgp-edge-a-ipv4" {
+		t.Errorf("unexpected name %s", name)
+	}
+
+	list := f.messages(4)
+	if len(list) != 2 {
+		t.Fatalf("expected 2 prefixes, got %d", len(list))
+	}
+
+	// Each prefix matches exactly: its mask length is both the minimum and the maximum.
+	for i, want := range []uint64{32, 16} {
+		if list[i].uint(2) != want || list[i].uint(3) != want {
+			t.Errorf("%s: expected mask length %d, got %d-%d", list[i].string(1), want, list[i].uint(2), list[i].uint(3))
+		}
+	}
+}
+
+func TestSplitVPNValue(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		admin   string
+		value   uint64
+		invalid bool
+	}{
+		{s: "64512:100", admin: "64512", value: 100},
+		{s: "10.0.0.1:5", admin: "10.0.0.1", value: 5},
+		{s: "4200000000:1", admin: "4200000000", value: 1},
+		{s: "64512", invalid: true},
+		{s: "64512:x", invalid: true},
+	} {
+		admin, value, err := splitVPNValue(tt.s)
+		if tt.invalid {
+			if errcode.Of(err) != errcode.ApplyRejected {
+				t.Errorf("%s: expected %s, got %v", tt.s, errcode.ApplyRejected, err)
+			}
+			continue
+		}
+
+		if err != nil || admin != tt.admin || value != tt.value {
+			t.Errorf("%s: expected %s %d, got %s %d (%v)", tt.s, tt.admin, tt.value, admin, value, err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !embedded
+// +build !embedded
+
+package gobgp
+
+import (
+	"context"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+)
+
+// Without the embedded build tag, the gobgp BGP server is not compiled in.
+
+const EmbeddedSupported = false
+
+func StartEmbedded(ctx context.Context, address string) error {
+	return errcode.New(errcode.ConfigInvalid, "the gobgp BGP server is not compiled in; build kube-bgp with -tags embedded")
+}
@@ -0,0 +1,227 @@
+package gobgp
+
+import (
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message is an encoded protobuf message of the gobgpd API.  The messages
+// are encoded by hand, by the field numbers of the gobgp API definition,
+// rather than with generated code, so that only the embedded build depends
+// upon the gobgp module (and its release) to talk to gobgpd.  The tests of
+// the embedded build verify the encoding against the gobgp server itself.
+type message []byte
+
+// uint appends a varint field, unless it is zero
+func (m message) uint(num protowire.Number, v uint64) message {
+	if v == 0 {
+		return m
+	}
+
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, v)
+}
+
+// bool appends a boolean field, unless it is false
+func (m message) bool(num protowire.Number, v bool) message {
+	return m.uint(num, protowire.EncodeBool(v))
+}
+
+// string appends a string field, unless it is empty
+func (m message) string(num protowire.Number, s string) message {
+	if s == "" {
+		return m
+	}
+
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendString(m, s)
+}
+
+// strings appends a repeated string field
+func (m message) strings(num protowire.Number, list []string) message {
+	for _, s := range list {
+		m = protowire.AppendTag(m, num, protowire.BytesType)
+		m = protowire.AppendString(m, s)
+	}
+
+	return m
+}
+
+// uints appends a packed repeated varint field
+func (m message) uints(num protowire.Number, list []uint32) message {
+	if len(list) == 0 {
+		return m
+	}
+
+	var packed []byte
+	for _, v := range list {
+		packed = protowire.AppendVarint(packed, uint64(v))
+	}
+
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, packed)
+}
+
+// message appends an embedded message field, even if it is empty, since
+// the presence of a message may be significant
+func (m message) message(num protowire.Number, sub message) message {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, sub)
+}
+
+// any appends an Any field holding the given message of the given type of
+// the gobgp API package
+func (m message) any(num protowire.Number, pkg, typ string, sub message) message {
+	return m.message(num, message(nil).string(1, typeURL(pkg, typ)).message(2, sub))
+}
+
+// typeURL returns the type URL of an Any holding the given message type of the given gobgp API package
+func typeURL(pkg, typ string) string {
+	return "type.googleapis.com/" + pkg + "." + typ
+}
+
+// field is a single value of a decoded field
+type field struct {
+	typ protowire.Type
+	v   uint64
+	b   []byte
+}
+
+// fields is a decoded protobuf message: the values of each of its fields, by number
+type fields map[protowire.Number][]field
+
+// decode decodes a message.  Fields of unknown types are skipped.
+func decode(b []byte) (fields, error) {
+	f := make(fields)
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, errcode.Wrap(protowire.ParseError(n), errcode.GoBGPDUnreachable, "malformed gobgpd API message")
+		}
+		b = b[n:]
+
+		var v field
+		v.typ = typ
+
+		switch typ {
+		case protowire.VarintType:
+			v.v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, n = protowire.ConsumeFixed32(b)
+			v.v = uint64(x)
+		case protowire.Fixed64Type:
+			v.v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v.b, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, errcode.Wrap(protowire.ParseError(n), errcode.GoBGPDUnreachable, "malformed gobgpd API message")
+		}
+		b = b[n:]
+
+		f[num] = append(f[num], v)
+	}
+
+	return f, nil
+}
+
+// uint returns the last value of a varint field, or zero if it is absent
+func (f fields) uint(num protowire.Number) uint64 {
+	list := f[num]
+	if len(list) == 0 {
+		return 0
+	}
+
+	return list[len(list)-1].v
+}
+
+// bool returns the last value of a boolean field, or false if it is absent
+func (f fields) bool(num protowire.Number) bool {
+	return f.uint(num) != 0
+}
+
+// string returns the last value of a string field, or the empty string if it is absent
+func (f fields) string(num protowire.Number) string {
+	list := f[num]
+	if len(list) == 0 {
+		return ""
+	}
+
+	return string(list[len(list)-1].b)
+}
+
+// strings returns the values of a repeated string field
+func (f fields) strings(num protowire.Number) (list []string) {
+	for _, v := range f[num] {
+		list = append(list, string(v.b))
+	}
+
+	return list
+}
+
+// uints returns the values of a repeated varint field, whether packed or not
+func (f fields) uints(num protowire.Number) (list []uint64) {
+	for _, v := range f[num] {
+		if v.typ != protowire.BytesType {
+			list = append(list, v.v)
+			continue
+		}
+
+		for b := v.b; len(b) > 0; {
+			x, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				break
+			}
+
+			list = append(list, x)
+			b = b[n:]
+		}
+	}
+
+	return list
+}
+
+// message returns the last value of an embedded message field, which is
+// empty if the field is absent or malformed
+func (f fields) message(num protowire.Number) fields {
+	list := f[num]
+	if len(list) == 0 {
+		return fields{}
+	}
+
+	sub, err := decode(list[len(list)-1].b)
+	if err != nil {
+		return fields{}
+	}
+
+	return sub
+}
+
+// messages returns the values of a repeated embedded message field,
+// skipping those which are malformed
+func (f fields) messages(num protowire.Number) (list []fields) {
+	for _, v := range f[num] {
+		if sub, err := decode(v.b); err == nil {
+			list = append(list, sub)
+		}
+	}
+
+	return list
+}
+
+// any returns the message type (without its package) and the message of an Any
+func (f fields) any() (typ string, sub fields) {
+	url := f.string(1)
+	for i := len(url) - 1; i >= 0; i-- {
+		if url[i] == '.' || url[i] == '/' {
+			typ = url[i+1:]
+			break
+		}
+	}
+
+	return typ, f.message(2)
+}
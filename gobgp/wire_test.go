@@ -0,0 +1,117 @@
+package gobgp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := message(nil).
+		uint(1, 65001).
+		bool(2, true).
+		string(3, "edge-a").
+		strings(4, []string{"a", "b"}).
+		uints(5, []uint32{1, 300, 70000}).
+		message(6, message(nil).string(1, "nested")).
+		message(7, nil)
+
+	f, err := decode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"uint", f.uint(1), uint64(65001)},
+		{"bool", f.bool(2), true},
+		{"string", f.string(3), "edge-a"},
+		{"strings", f.strings(4), []string{"a", "b"}},
+		{"uints", f.uints(5), []uint64{1, 300, 70000}},
+		{"message", f.message(6).string(1), "nested"},
+		{"empty message", len(f[7]), 1},
+		{"absent uint", f.uint(9), uint64(0)},
+		{"absent string", f.string(9), ""},
+		{"absent message", len(f.message(9)), 0},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, tt.got)
+		}
+	}
+}
+
+func TestZeroValuesOmitted(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		m    message
+	}{
+		{"uint", message(nil).uint(1, 0)},
+		{"bool", message(nil).bool(1, false)},
+		{"string", message(nil).string(1, "")},
+		{"strings", message(nil).strings(1, nil)},
+		{"uints", message(nil).uints(1, nil)},
+	} {
+		if len(tt.m) != 0 {
+			t.Errorf("%s: expected nothing to be encoded, got %v", tt.name, tt.m)
+		}
+	}
+}
+
+func TestUnpackedUints(t *testing.T) {
+	m := message(nil).uint(1, 5).uint(1, 7)
+
+	f, err := decode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.uints(1); !reflect.DeepEqual(got, []uint64{5, 7}) {
+		t.Errorf("expected [5 7], got %v", got)
+	}
+	if got := f.uint(1); got != 7 {
+		t.Errorf("expected the last value, got %d", got)
+	}
+}
+
+func TestAny(t *testing.T) {
+	for _, pkg := range apiPackages {
+		f, err := decode(message(nil).any(1, pkg, "OriginAttribute", message(nil).uint(1, 2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		any := f.message(1)
+		if url := any.string(1); url != "type.googleapis.com/"+pkg+".OriginAttribute" {
+			t.Errorf("%s: unexpected type URL %s", pkg, url)
+		}
+
+		typ, sub := any.any()
+		if typ != "OriginAttribute" || sub.uint(1) != 2 {
+			t.Errorf("%s: unexpected Any %s %v", pkg, typ, sub)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"truncated varint", []byte{0x08, 0x80}},
+		{"truncated bytes", []byte{0x0a, 5, 'a'}},
+	} {
+		_, err := decode(tt.b)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+
+		if code := errcode.Of(err); code != errcode.GoBGPDUnreachable {
+			t.Errorf("%s: expected %s, got %s", tt.name, errcode.GoBGPDUnreachable, code)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+)
+
+const (
+	// gobgpdConfigFile is the GoBGPDConfig mode in which the neighbors are
+	// written to the configuration file of gobgpd, which is then notified
+	gobgpdConfigFile = "file"
+
+	// gobgpdConfigAPI is the GoBGPDConfig mode in which the neighbors are
+	// applied to gobgpd incrementally through its API
+	gobgpdConfigAPI = "api"
+)
+
+// gobgpdPolicyPrefix is the prefix of the names of the policies and prefix
+// sets which kube-bgp adds to gobgpd, and so owns
+const gobgpdPolicyPrefix = "kube-bgp-"
+
+// notifiesGoBGPD indicates whether gobgpd is notified of changes to its
+// configuration file, rather than configured through its API
+func (c *KubeBGPConfig) notifiesGoBGPD() bool {
+	return c.hasOutput(speakerGoBGPD) && c.GoBGPDConfig != gobgpdConfigAPI
+}
+
+// gobgpdSessionApplier applies the neighbors of the desired gobgpd
+// configuration through the gobgpd API, adding, changing, and deleting only
+// those which changed, rather than by way of its configuration file
+type gobgpdSessionApplier struct {
+	client *gobgp.Client
+
+	// applied is the configuration of each neighbor which kube-bgp owns, as
+	// last applied, by address: those which it added, and those which were
+	// already present and which it desires
+	applied map[string]gobgp.NeighborConfig
+}
+
+func newGoBGPDSessionApplier(client *gobgp.Client) *gobgpdSessionApplier {
+	return &gobgpdSessionApplier{
+		client:  client,
+		applied: make(map[string]gobgp.NeighborConfig),
+	}
+}
+
+// Apply starts the BGP instance of gobgpd, if it has not been, and brings
+// its export policies and the neighbors which kube-bgp owns into line with
+// those of the given configuration.  A neighbor which cannot be applied
+// does not prevent the others from being applied, and is tried again by
+// the next Apply.
+func (a *gobgpdSessionApplier) Apply(ctx context.Context, c *gobgpdConfig) error {
+	asn, id, err := a.client.Global(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case asn == 0:
+		if err := a.client.StartGlobal(ctx, c.ASN, c.RouterID); err != nil {
+			return errcode.Wrap(err, errcode.Of(err), "failed to start gobgpd")
+		}
+	case asn != c.ASN || id != c.RouterID:
+		return errcode.New(errcode.ApplyRejected, fmt.Sprintf("gobgpd is running as AS %d with router-id %s rather than AS %d with router-id %s; it must be restarted to change them", asn, id, c.ASN, c.RouterID))
+	}
+
+	policies, err := a.applyPolicies(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	list, err := a.client.Neighbors(ctx)
+	if err != nil {
+		return err
+	}
+
+	running := make(map[string]gobgp.NeighborConfig, len(list))
+	for _, n := range list {
+		running[n.Address] = n.Config
+	}
+
+	want := make(map[string]gobgp.NeighborConfig, len(c.Neighbors))
+	for _, nb := range c.Neighbors {
+		want[nb.Address] = nb.neighborConfig(policies)
+	}
+
+	var failed []error
+
+	// Only the neighbors which kube-bgp owns are deleted.
+	for addr := range a.applied {
+		if _, ok := want[addr]; ok {
+			continue
+		}
+
+		if _, ok := running[addr]; ok {
+			if err := a.client.DeleteNeighbor(ctx, addr); err != nil {
+				failed = append(failed, errcode.Wrapf(err, errcode.Of(err), "failed to delete neighbor %s", addr))
+				continue
+			}
+		}
+
+		delete(a.applied, addr)
+	}
+
+	for addr, w := range want {
+		r, present := running[addr]
+
+		switch applied, owned := a.applied[addr]; {
+		case !present:
+			if err := a.client.AddNeighborConfig(ctx, w); err != nil {
+				failed = append(failed, errcode.Wrapf(err, errcode.Of(err), "failed to add neighbor %s", addr))
+				continue
+			}
+		case owned && reflect.DeepEqual(applied, w):
+			continue
+		case !owned && !neighborDrifted(r, w, c):
+			// A neighbor already present, such as from before kube-bgp
+			// restarted, is adopted as it is, so that its session is not reset.
+		case r.ASN != w.ASN:
+			// A change of the ASN is not an update of the same neighbor.
+			if err := a.client.DeleteNeighbor(ctx, addr); err != nil {
+				a.applied[addr] = r
+				failed = append(failed, errcode.Wrapf(err, errcode.Of(err), "failed to delete neighbor %s", addr))
+				continue
+			}
+			delete(a.applied, addr)
+
+			if err := a.client.AddNeighborConfig(ctx, w); err != nil {
+				failed = append(failed, errcode.Wrapf(err, errcode.Of(err), "failed to add neighbor %s", addr))
+				continue
+			}
+		default:
+			// The neighbor is owned from now on, though its configuration
+			// is that which it was found with until it is updated.
+			if err := a.client.UpdateNeighborConfig(ctx, w); err != nil {
+				a.applied[addr] = r
+				failed = append(failed, errcode.Wrapf(err, errcode.Of(err), "failed to update neighbor %s", addr))
+				continue
+			}
+		}
+
+		a.applied[addr] = w
+	}
+
+	if err := a.removeStalePolicies(ctx, c, policies); err != nil {
+		failed = append(failed, err)
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, len(failed))
+	for i, err := range failed {
+		msgs[i] = err.Error()
+	}
+	sort.Strings(msgs)
+
+	return errcode.New(errcode.Of(failed[0]), strings.Join(msgs, "; "))
+}
+
+// neighborConfig returns the configuration of the neighbor in the gobgpd
+// API, naming its export policy by the given names under which the
+// policies were added
+func (nb *gobgpdNeighbor) neighborConfig(policies map[string]string) gobgp.NeighborConfig {
+	n := gobgp.NeighborConfig{
+		Address:                  nb.Address,
+		ASN:                      nb.ASN,
+		LocalASN:                 nb.LocalASN,
+		Description:              nb.Description,
+		Password:                 nb.Password,
+		Port:                     nb.Port,
+		LocalAddress:             nb.LocalAddress,
+		BindInterface:            nb.BindInterface,
+		MinAdvertisementInterval: nb.MinAdvertisementInterval,
+		RestartTime:              nb.RestartTime,
+		Families:                 append([]string(nil), nb.Families...),
+		RouteReflectorClient:     nb.RouteReflectorClient,
+		ClusterID:                nb.ClusterID,
+	}
+	sort.Strings(n.Families)
+
+	if nb.ExportPolicy != "" {
+		n.ExportPolicy, n.DefaultExport = policies[nb.ExportPolicy], nb.DefaultExport
+	}
+
+	return n
+}
+
+// neighborDrifted indicates whether the running configuration of a
+// neighbor, as gobgpd reports it, differs from the given configuration of
+// the given gobgpd.  The settings which gobgpd fills in with its defaults
+// are compared by their effect.
+func neighborDrifted(running, want gobgp.NeighborConfig, c *gobgpdConfig) bool {
+	normalize := func(n gobgp.NeighborConfig) gobgp.NeighborConfig {
+		if n.LocalASN == 0 {
+			n.LocalASN = c.ASN
+		}
+
+		if n.Port == 0 {
+			n.Port = bgpPort
+		}
+
+		if ip := net.ParseIP(n.LocalAddress); ip != nil && ip.IsUnspecified() {
+			n.LocalAddress = ""
+		}
+
+		if !n.RouteReflectorClient {
+			n.ClusterID = ""
+		} else if n.ClusterID == "" {
+			n.ClusterID = c.RouterID
+		}
+
+		if n.ExportPolicy == "" {
+			n.DefaultExport = ""
+		}
+
+		return n
+	}
+
+	running, want = normalize(running), normalize(want)
+
+	// The advertisement interval and families of gobgpd apply when none are given.
+	if want.MinAdvertisementInterval == 0 {
+		running.MinAdvertisementInterval = 0
+	}
+
+	if len(want.Families) == 0 {
+		running.Families = nil
+	}
+
+	return !reflect.DeepEqual(running, want)
+}
+
+// applyPolicies adds the prefix sets and export policies of the given
+// configuration to gobgpd, returning the name under which each policy was
+// added.  The prefixes of an existing prefix set are replaced in place.  A
+// policy is added under a name of its own statements, so that a policy
+// whose statements change is added afresh, and its neighbors moved to it,
+// rather than being changed beneath them.
+func (a *gobgpdSessionApplier) applyPolicies(ctx context.Context, c *gobgpdConfig) (map[string]string, error) {
+	names := make(map[string]string, len(c.Policies))
+	if len(c.Policies) == 0 {
+		return names, nil
+	}
+
+	sets, err := a.client.PrefixSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, set := range c.PrefixSets {
+		if samePrefixes(sets[set.Name], set.Prefixes) {
+			continue
+		}
+
+		if err := a.client.SetPrefixSet(ctx, set.Name, set.Prefixes); err != nil {
+			return nil, errcode.Wrapf(err, errcode.Of(err), "failed to set prefix set %s", set.Name)
+		}
+	}
+
+	list, err := a.client.Policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(list))
+	for _, name := range list {
+		existing[name] = true
+	}
+
+	for _, p := range c.Policies {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%s %s", p.Disposition, strings.Join(p.PrefixSets, " ")) // nolint: errcheck
+
+		name := fmt.Sprintf("%s-%08x", p.Name, h.Sum32())
+		names[p.Name] = name
+
+		if existing[name] {
+			continue
+		}
+
+		if err := a.client.AddPolicy(ctx, gobgp.Policy{Name: name, PrefixSets: p.PrefixSets, Disposition: p.Disposition}); err != nil {
+			return nil, errcode.Wrapf(err, errcode.Of(err), "failed to add policy %s", name)
+		}
+	}
+
+	return names, nil
+}
+
+// removeStalePolicies deletes the policies and prefix sets of kube-bgp which
+// are not those of the given configuration, under the given names, once the
+// neighbors have been moved off them
+func (a *gobgpdSessionApplier) removeStalePolicies(ctx context.Context, c *gobgpdConfig, policies map[string]string) error {
+	inUse := make(map[string]bool)
+	for _, name := range policies {
+		inUse[name] = true
+	}
+	for _, set := range c.PrefixSets {
+		inUse[set.Name] = true
+	}
+
+	list, err := a.client.Policies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range list {
+		if !strings.HasPrefix(name, gobgpdPolicyPrefix) || inUse[name] {
+			continue
+		}
+
+		if err := a.client.DeletePolicy(ctx, name); err != nil {
+			return errcode.Wrapf(err, errcode.Of(err), "failed to delete policy %s", name)
+		}
+	}
+
+	sets, err := a.client.PrefixSets(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name := range sets {
+		if !strings.HasPrefix(name, gobgpdPolicyPrefix) || inUse[name] {
+			continue
+		}
+
+		if err := a.client.DeletePrefixSet(ctx, name); err != nil {
+			return errcode.Wrapf(err, errcode.Of(err), "failed to delete prefix set %s", name)
+		}
+	}
+
+	return nil
+}
+
+// samePrefixes indicates whether the given lists hold the same prefixes, in any order and form
+func samePrefixes(a, b []string) bool {
+	canonical := func(list []string) []string {
+		out := make([]string, 0, len(list))
+		for _, s := range list {
+			if _, network, err := net.ParseCIDR(s); err == nil {
+				s = network.String()
+			}
+			out = append(out, s)
+		}
+		sort.Strings(out)
+
+		return out
+	}
+
+	return reflect.DeepEqual(canonical(a), canonical(b))
+}
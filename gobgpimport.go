@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rotisserie/eris"
+	"gopkg.in/yaml.v2"
+)
+
+// runImport implements the `import` command, which converts a hand-written
+// gobgpd configuration (in TOML) into the equivalent kube-bgp
+// configuration, flagging whatever has no equivalent, to ease the adoption
+// of kube-bgp on Nodes which already run gobgpd
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "", "gobgpd configuration file (TOML) to convert")
+	fs.Parse(args) // nolint: errcheck
+
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "-from is required")
+		return 1
+	}
+
+	data, err := ioutil.ReadFile(*from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read gobgpd configuration:", err)
+		return 1
+	}
+
+	doc, err := parseTOML(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse %s: %v\n", *from, err)
+		return 1
+	}
+
+	cfg, unsupported := importGoBGPConfig(doc)
+
+	if err := writeImport(os.Stdout, cfg, unsupported); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write configuration:", err)
+		return 1
+	}
+
+	for _, u := range unsupported {
+		fmt.Fprintln(os.Stderr, "unsupported:", u)
+	}
+
+	return 0
+}
+
+// writeImport writes the imported configuration, preceded by the stanzas
+// which could not be converted as comments
+func writeImport(w io.Writer, cfg yaml.MapSlice, unsupported []string) error {
+	for _, u := range unsupported {
+		if _, err := fmt.Fprintf(w, "# unsupported: %s\n", u); err != nil {
+			return err
+		}
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+// importGoBGPConfig converts the given gobgpd configuration into a kube-bgp
+// configuration, returning the stanzas which have no equivalent
+func importGoBGPConfig(doc map[string]interface{}) (cfg yaml.MapSlice, unsupported []string) {
+	global := tomlTable(tomlTable(doc["global"])["config"])
+
+	asn := tomlString(global["as"])
+	if asn != "" {
+		cfg = append(cfg, yaml.MapItem{Key: "asn", Value: asn})
+	}
+
+	for key := range global {
+		switch key {
+		case "as":
+		case "router-id":
+			// The configuration is shared by every Node, each of which needs its own router-id.
+			unsupported = append(unsupported, "global.config.router-id: the router-id of each Node is determined by kube-bgp (see routerIDPool)")
+		default:
+			unsupported = append(unsupported, "global.config."+key)
+		}
+	}
+
+	for key := range tomlTable(doc["global"]) {
+		if key != "config" {
+			unsupported = append(unsupported, "global."+key)
+		}
+	}
+
+	var routers []yaml.MapSlice
+
+	for _, n := range tomlTables(doc["neighbors"]) {
+		r, skipped := importNeighbor(n, asn)
+		routers = append(routers, r)
+		unsupported = append(unsupported, skipped...)
+	}
+
+	if len(routers) > 0 {
+		cfg = append(cfg, yaml.MapItem{Key: "routers", Value: routers})
+	}
+
+	for key := range doc {
+		if key != "global" && key != "neighbors" {
+			unsupported = append(unsupported, key)
+		}
+	}
+
+	sort.Strings(unsupported)
+
+	return cfg, unsupported
+}
+
+// importNeighbor converts a gobgpd neighbor into a Router, returning the
+// settings of the neighbor which have no equivalent
+func importNeighbor(n map[string]interface{}, globalASN string) (r yaml.MapSlice, unsupported []string) {
+	config := tomlTable(n["config"])
+
+	address := tomlString(config["neighbor-address"])
+	r = append(r, yaml.MapItem{Key: "address", Value: address})
+
+	if desc := tomlString(config["description"]); desc != "" {
+		r = append(r, yaml.MapItem{Key: "name", Value: desc})
+	}
+
+	if asn := tomlString(config["peer-as"]); asn != "" {
+		if asn == globalASN {
+			unsupported = append(unsupported, "neighbor "+address+" is an iBGP peer; if it is another Node, remove it, since the mesh replaces it")
+		} else {
+			r = append(r, yaml.MapItem{Key: "asn", Value: asn})
+		}
+	}
+
+	if tomlString(config["auth-password"]) != "" {
+		r = append(r, yaml.MapItem{Key: "passwordFile", Value: "/etc/kube-bgp/passwords/" + address})
+		unsupported = append(unsupported, "neighbors.config.auth-password of "+address+": move the password into the passwordFile")
+	}
+
+	for key := range config {
+		switch key {
+		case "neighbor-address", "description", "peer-as", "auth-password":
+		default:
+			unsupported = append(unsupported, "neighbors.config."+key+" of "+address)
+		}
+	}
+
+	if port := tomlString(tomlTable(tomlTable(n["transport"])["config"])["remote-port"]); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			r = append(r, yaml.MapItem{Key: "port", Value: p})
+		}
+	}
+
+	if mrai := tomlString(tomlTable(tomlTable(n["timers"])["config"])["minimum-advertisement-interval"]); mrai != "" {
+		if i, err := strconv.ParseFloat(mrai, 64); err == nil {
+			r = append(r, yaml.MapItem{Key: "advertisementIntervalSeconds", Value: int(i)})
+		}
+	}
+
+	for key, v := range n {
+		switch key {
+		case "config":
+		case "transport":
+			unsupported = append(unsupported, otherKeys("neighbors.transport", address, tomlTable(v), "remote-port")...)
+		case "timers":
+			unsupported = append(unsupported, otherKeys("neighbors.timers", address, tomlTable(v), "minimum-advertisement-interval")...)
+		default:
+			unsupported = append(unsupported, "neighbors."+key+" of "+address)
+		}
+	}
+
+	return r, unsupported
+}
+
+// otherKeys lists the settings of the given neighbor section (and its
+// config) other than those given, which are converted
+func otherKeys(section, address string, t map[string]interface{}, converted ...string) (list []string) {
+	for key, v := range t {
+		if key != "config" {
+			list = append(list, section+"."+key+" of "+address)
+			continue
+		}
+
+		for name := range tomlTable(v) {
+			if !hasOption(converted, name) {
+				list = append(list, section+".config."+name+" of "+address)
+			}
+		}
+	}
+
+	return list
+}
+
+// tomlTable returns the given value as a table, or an empty table if it is not one
+func tomlTable(v interface{}) map[string]interface{} {
+	if t, ok := v.(map[string]interface{}); ok {
+		return t
+	}
+
+	return map[string]interface{}{}
+}
+
+// tomlTables returns the given value as an array of tables
+func tomlTables(v interface{}) []map[string]interface{} {
+	list, _ := v.([]map[string]interface{}) // nolint: errcheck
+	return list
+}
+
+// tomlString returns the given scalar value as a string
+func tomlString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// parseTOML parses the subset of TOML used by gobgpd configurations:
+// tables, arrays of tables, and key/value pairs of strings, numbers,
+// booleans, inline tables, and (possibly multi-line) arrays of them
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	current := root
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	var lineNumber int
+
+	for sc.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripTOMLComment(sc.Text()))
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			array := strings.HasPrefix(line, "[[")
+
+			name := strings.TrimSpace(strings.Trim(line, "[]"))
+			if name == "" {
+				return nil, eris.Errorf("line %d: empty table name", lineNumber)
+			}
+
+			t, err := tomlResolve(root, strings.Split(name, "."), array)
+			if err != nil {
+				return nil, eris.Wrapf(err, "line %d", lineNumber)
+			}
+			current = t
+
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, eris.Errorf("line %d: expected key = value", lineNumber)
+		}
+
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		value := strings.TrimSpace(line[i+1:])
+
+		// An array continues until its brackets balance.
+		for strings.HasPrefix(value, "[") && strings.Count(value, "[") > strings.Count(value, "]") && sc.Scan() {
+			lineNumber++
+			value += " " + strings.TrimSpace(stripTOMLComment(sc.Text()))
+		}
+
+		v, err := parseTOMLValue(value)
+		if err != nil {
+			return nil, eris.Wrapf(err, "line %d", lineNumber)
+		}
+
+		current[key] = v
+	}
+
+	return root, sc.Err()
+}
+
+// tomlResolve returns the table of the given path, creating it (or, for an
+// array of tables, appending a new one) as needed.  Intermediate arrays of
+// tables resolve to their last element.
+func tomlResolve(root map[string]interface{}, path []string, array bool) (map[string]interface{}, error) {
+	t := root
+
+	for i, key := range path {
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		last := i == len(path)-1
+
+		if last && array {
+			list, ok := t[key].([]map[string]interface{})
+			if !ok && t[key] != nil {
+				return nil, eris.Errorf("%s is not an array of tables", key)
+			}
+
+			next := make(map[string]interface{})
+			t[key] = append(list, next)
+
+			return next, nil
+		}
+
+		switch v := t[key].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			t[key] = next
+			t = next
+		case map[string]interface{}:
+			t = v
+		case []map[string]interface{}:
+			t = v[len(v)-1]
+		default:
+			return nil, eris.Errorf("%s is not a table", key)
+		}
+	}
+
+	return t, nil
+}
+
+// parseTOMLValue parses a TOML string, number, boolean, array, or inline
+// table.  Numbers are kept as the text of the number.
+func parseTOMLValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, eris.New("missing value")
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		return strings.Trim(s, "'"), nil
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, "["):
+		inner := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+
+		var list []interface{}
+		for _, item := range splitTOMLArray(inner) {
+			v, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+
+		return list, nil
+	case strings.HasPrefix(s, "{"):
+		inner := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}"))
+
+		t := make(map[string]interface{})
+		for _, item := range splitTOMLArray(inner) {
+			i := strings.Index(item, "=")
+			if i < 0 {
+				return nil, eris.Errorf("expected key = value in %q", item)
+			}
+
+			v, err := parseTOMLValue(strings.TrimSpace(item[i+1:]))
+			if err != nil {
+				return nil, err
+			}
+			t[strings.Trim(strings.TrimSpace(item[:i]), `"`)] = v
+		}
+
+		return t, nil
+	}
+
+	return s, nil
+}
+
+// splitTOMLArray splits the items of an array (or inline table), outside of
+// any strings, nested arrays, or inline tables
+func splitTOMLArray(s string) (items []string) {
+	var depth int
+	var quote rune
+	start := 0
+
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		items = append(items, rest)
+	}
+
+	return items
+}
+
+// stripTOMLComment removes the comment, if any, from the given line
+func stripTOMLComment(line string) string {
+	var quote rune
+
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
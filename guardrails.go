@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+)
+
+// Default guardrail thresholds
+const (
+	defaultMaxGoroutines            = 1000
+	defaultMaxHeapMB                = 512
+	defaultMaxQueueDepth            = 1000
+	defaultGuardrailIntervalSeconds = 15
+)
+
+// Guardrails describes the thresholds of the resource usage of kube-bgp
+// itself beyond which it warns, so that a leak or a stalled reconcile loop
+// is noticed before it takes the Node's routes down with it
+type Guardrails struct {
+	// MaxGoroutines is the number of goroutines above which to warn.
+	// If not supplied, it defaults to 1000.
+	MaxGoroutines int `yaml:"maxGoroutines"`
+
+	// MaxHeapMB is the size of the heap, in MiB, above which to warn.
+	// If not supplied, it defaults to 512.
+	MaxHeapMB int `yaml:"maxHeapMB"`
+
+	// MaxQueueDepth is the number of objects (such as Services) whose
+	// changes await processing by the watchers above which to warn, since
+	// they pile up only while the processing is stuck or failing.  A relist
+	// queues every object at once, so it should exceed the number of
+	// Services and Endpoints.  If not supplied, it defaults to 1000.
+	MaxQueueDepth int `yaml:"maxQueueDepth"`
+
+	// IntervalSeconds is the interval at which the resource usage is
+	// checked.  If not supplied, it defaults to 15.
+	IntervalSeconds int `yaml:"intervalSeconds"`
+}
+
+func (g *Guardrails) validate() error {
+	if g.MaxGoroutines < 0 {
+		return eris.Errorf("invalid maxGoroutines %d", g.MaxGoroutines)
+	}
+	if g.MaxHeapMB < 0 {
+		return eris.Errorf("invalid maxHeapMB %d", g.MaxHeapMB)
+	}
+	if g.MaxQueueDepth < 0 {
+		return eris.Errorf("invalid maxQueueDepth %d", g.MaxQueueDepth)
+	}
+	if g.IntervalSeconds < 0 {
+		return eris.Errorf("invalid intervalSeconds %d", g.IntervalSeconds)
+	}
+
+	return nil
+}
+
+// thresholds returns the guardrail thresholds, with the defaults applied
+func (g *Guardrails) thresholds() (goroutines int, heapMB int, queueDepth int, interval time.Duration) {
+	goroutines, heapMB, queueDepth, seconds := defaultMaxGoroutines, defaultMaxHeapMB, defaultMaxQueueDepth, defaultGuardrailIntervalSeconds
+
+	if g != nil {
+		if g.MaxGoroutines > 0 {
+			goroutines = g.MaxGoroutines
+		}
+		if g.MaxHeapMB > 0 {
+			heapMB = g.MaxHeapMB
+		}
+		if g.MaxQueueDepth > 0 {
+			queueDepth = g.MaxQueueDepth
+		}
+		if g.IntervalSeconds > 0 {
+			seconds = g.IntervalSeconds
+		}
+	}
+
+	return goroutines, heapMB, queueDepth, time.Duration(seconds) * time.Second
+}
+
+// guardrailMonitor checks the resource usage of kube-bgp against its
+// Guardrails.  It runs independently of the reconcile loop, so that it can
+// report the loop itself being stuck.
+type guardrailMonitor struct {
+	guardrails *Guardrails
+	recorder   events.Recorder
+
+	// queues return the number of objects whose changes await processing
+	// in each queue of changes
+	queues []func() int
+
+	// exceeded is the set of resources whose guardrails were exceeded when last checked
+	exceeded map[string]bool
+}
+
+func newGuardrailMonitor(guardrails *Guardrails, recorder events.Recorder, queues ...func() int) *guardrailMonitor {
+	return &guardrailMonitor{
+		guardrails: guardrails,
+		recorder:   recorder,
+		queues:     queues,
+		exceeded:   make(map[string]bool),
+	}
+}
+
+// queueDepth returns the number of objects whose changes await processing
+func (m *guardrailMonitor) queueDepth() (depth int) {
+	for _, pending := range m.queues {
+		depth += pending()
+	}
+
+	return depth
+}
+
+func (m *guardrailMonitor) run(ctx context.Context) {
+	_, _, _, interval := m.guardrails.thresholds()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check measures the resource usage, warning of each guardrail which has
+// newly been exceeded
+func (m *guardrailMonitor) Check() {
+	maxGoroutines, maxHeapMB, maxQueueDepth, _ := m.guardrails.thresholds()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	goroutines := runtime.NumGoroutine()
+	heapMB := int(mem.HeapAlloc >> 20)
+	depth := m.queueDepth()
+
+	metrics.ReconcileQueueDepth.Set(float64(depth))
+
+	var warnings []string
+
+	check := func(resource string, exceeded bool, warning string) {
+		if exceeded {
+			warnings = append(warnings, warning)
+			metrics.GuardrailExceeded.WithLabelValues(resource).Set(1)
+
+			if !m.exceeded[resource] {
+				log.Println("guardrail exceeded:", warning)
+				m.recorder.Warning("GuardrailExceeded", "%s", warning)
+			}
+		} else {
+			metrics.GuardrailExceeded.WithLabelValues(resource).Set(0)
+
+			if m.exceeded[resource] {
+				log.Printf("guardrail of %s no longer exceeded", resource)
+			}
+		}
+
+		m.exceeded[resource] = exceeded
+	}
+
+	check("goroutines", goroutines > maxGoroutines, fmt.Sprintf("%d goroutines are running (guardrail %d): goroutines may be leaking", goroutines, maxGoroutines))
+	check("heap", heapMB > maxHeapMB, fmt.Sprintf("heap is %d MiB (guardrail %d MiB): memory may be leaking", heapMB, maxHeapMB))
+	check("queue", depth > maxQueueDepth, fmt.Sprintf("changes of %d objects are awaiting processing (guardrail %d): the watchers may be stuck", depth, maxQueueDepth))
+
+	status.SetResourceWarnings(warnings)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestGuardrailQueueDepth(t *testing.T) {
+	services, endpoints := 0, 0
+
+	m := newGuardrailMonitor(&Guardrails{MaxQueueDepth: 10}, nopRecorder{}, func() int { return services }, func() int { return endpoints })
+
+	for _, tt := range []struct {
+		services, endpoints int
+		want                bool
+	}{
+		{services: 0, endpoints: 0},
+		{services: 6, endpoints: 4},
+		{services: 6, endpoints: 5, want: true},
+		{services: 0, endpoints: 3},
+	} {
+		services, endpoints = tt.services, tt.endpoints
+		m.Check()
+
+		if m.exceeded["queue"] != tt.want {
+			t.Errorf("%d services and %d endpoints pending: expected exceeded %v", tt.services, tt.endpoints, tt.want)
+		}
+	}
+}
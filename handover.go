@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+)
+
+// instanceEnv is the environment variable which names the kube-bgp instance
+// of a Node, where several run side by side during a handover
+const instanceEnv = "KUBE_BGP_INSTANCE"
+
+// defaultHandoverDirectory is the default directory of the handover sockets and the record of the owner
+const defaultHandoverDirectory = "/var/run/kube-bgp/instances"
+
+// handoverCheckInterval is the interval at which a standby instance compares its desired state with that of the owner
+var handoverCheckInterval = 5 * time.Second
+
+// handoverTimeout is the time allowed for each request between instances
+const handoverTimeout = 5 * time.Second
+
+// Handover configures the handover of a Node between kube-bgp instances,
+// such as from the old to the new version of the agent during a blue/green
+// upgrade.  Of the instances of a Node, only the owner applies its state;
+// a new instance starts as a standby, computing its state without applying
+// it, and takes ownership once its state is identical to that of the owner,
+// whereupon the old instance exits.
+type Handover struct {
+	// Enabled indicates that several instances may run on each Node
+	Enabled bool `yaml:"enabled"`
+
+	// Directory is the directory of the handover socket of each instance
+	// and of the record of the owner, which must be shared by the instances.
+	// This is optional, and defaults to /var/run/kube-bgp/instances.
+	Directory string `yaml:"directory"`
+}
+
+func (h *Handover) directory() string {
+	if h.Directory != "" {
+		return h.Directory
+	}
+
+	return defaultHandoverDirectory
+}
+
+// handoverState is the description of an instance served on its handover socket
+type handoverState struct {
+	// Instance is the name of the instance
+	Instance string `json:"instance"`
+
+	// Version is the version of kube-bgp of the instance
+	Version string `json:"version"`
+
+	// Owner indicates whether the instance is the owner
+	Owner bool `json:"owner"`
+
+	// AppliedHash is the short hash of the desired state last applied by the instance
+	AppliedHash string `json:"appliedHash,omitempty"`
+}
+
+// handoverManager maintains the ownership of the Node by this instance
+type handoverManager struct {
+	cfg *Handover
+
+	// instance is the name of this instance
+	instance string
+
+	mu    sync.Mutex
+	owner bool
+
+	// lastMismatch is the description of the last difference found between
+	// the state of this standby and that of the owner
+	lastMismatch string
+
+	released    chan struct{}
+	releaseOnce sync.Once
+}
+
+// newHandoverManager returns the handoverManager of this instance.  If the
+// handover is not enabled, this instance is always the owner.
+func newHandoverManager(cfg *Handover) *handoverManager {
+	instance := os.Getenv(instanceEnv)
+	if instance == "" {
+		instance = version + "-" + strconv.Itoa(os.Getpid())
+	}
+
+	h := &handoverManager{
+		cfg:      cfg,
+		instance: instance,
+		owner:    cfg == nil || !cfg.Enabled,
+	}
+
+	// Only an instance which may hand over the Node is ever released.
+	if !h.owner {
+		h.released = make(chan struct{})
+	}
+
+	return h
+}
+
+// Start serves the handover socket of this instance and determines whether
+// it is the owner: it is if no other instance is the owner, or if the
+// recorded owner is no longer running
+func (h *handoverManager) Start(ctx context.Context) error {
+	if h.cfg == nil || !h.cfg.Enabled {
+		return nil
+	}
+
+	if strings.ContainsAny(h.instance, `/\`) {
+		return eris.Errorf("invalid %s %q", instanceEnv, h.instance)
+	}
+
+	if err := os.MkdirAll(h.cfg.directory(), 0755); err != nil {
+		return eris.Wrapf(err, "failed to create handover directory %s", h.cfg.directory())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/handover/state", h.serveState)
+	mux.HandleFunc("/handover/release", h.serveRelease)
+
+	go func() {
+		if err := serveUnix(ctx, h.socket(h.instance), 0600, mux); err != nil {
+			log.Println("failed to serve handover socket:", err)
+		}
+	}()
+
+	owner := h.recordedOwner()
+	if owner == "" || owner == h.instance {
+		return h.claim()
+	}
+
+	if _, err := h.query(ctx, owner); err != nil {
+		log.Printf("instance %s owned this node, but is not running; taking ownership", owner)
+		return h.claim()
+	}
+
+	log.Printf("instance %s owns this node; starting as its standby", owner)
+
+	return nil
+}
+
+// Owner indicates whether this instance is the owner, and so applies its state
+func (h *handoverManager) Owner() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.owner
+}
+
+// Released is closed once this instance has handed the Node over to another
+func (h *handoverManager) Released() <-chan struct{} {
+	return h.released
+}
+
+// Verify compares the given hash of the desired state of this standby with
+// that of the state applied by the owner, and if they are identical, takes
+// ownership from it.  It returns whether this instance is now the owner.
+func (h *handoverManager) Verify(ctx context.Context, stateHash string) bool {
+	if h.Owner() {
+		return true
+	}
+
+	owner := h.recordedOwner()
+
+	state, err := h.query(ctx, owner)
+	if err != nil {
+		log.Printf("instance %s is no longer running; taking ownership", owner)
+		return h.claim() == nil
+	}
+
+	if state.AppliedHash != stateHash {
+		mismatch := "state " + stateHash + " of this instance differs from state " + state.AppliedHash + " of instance " + state.Instance + " (" + state.Version + ")"
+		if mismatch != h.lastMismatch {
+			log.Println("not taking ownership:", mismatch)
+		}
+		h.lastMismatch = mismatch
+
+		return false
+	}
+
+	if err := h.request(ctx, owner, http.MethodPost, "/handover/release", nil); err != nil {
+		log.Printf("instance %s refused to release ownership: %v", owner, err)
+		return false
+	}
+
+	if err := h.claim(); err != nil {
+		log.Println("failed to record ownership:", err)
+		return false
+	}
+
+	log.Printf("took ownership from instance %s (%s) with identical state %s", state.Instance, state.Version, stateHash)
+
+	return true
+}
+
+// claim records this instance as the owner
+func (h *handoverManager) claim() error {
+	dir := h.cfg.directory()
+
+	tmp := filepath.Join(dir, ".owner."+h.instance)
+	if err := ioutil.WriteFile(tmp, []byte(h.instance+"\n"), 0644); err != nil {
+		return eris.Wrap(err, "failed to record owner")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, "owner")); err != nil {
+		return eris.Wrap(err, "failed to record owner")
+	}
+
+	h.mu.Lock()
+	h.owner = true
+	h.mu.Unlock()
+
+	return nil
+}
+
+// recordedOwner returns the name of the recorded owner, if any
+func (h *handoverManager) recordedOwner() string {
+	data, err := ioutil.ReadFile(filepath.Join(h.cfg.directory(), "owner"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// socket returns the path of the handover socket of the named instance
+func (h *handoverManager) socket(instance string) string {
+	return filepath.Join(h.cfg.directory(), instance+".sock")
+}
+
+// query returns the description of the named instance
+func (h *handoverManager) query(ctx context.Context, instance string) (*handoverState, error) {
+	state := new(handoverState)
+
+	if err := h.request(ctx, instance, http.MethodGet, "/handover/state", state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// request performs a request of the handover socket of the named instance,
+// decoding the response into the given value, if any
+func (h *handoverManager) request(ctx context.Context, instance, method, path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, handoverTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, "http://kube-bgp"+path, nil)
+	if err != nil {
+		return eris.Wrap(err, "failed to create request")
+	}
+
+	resp, err := unixClient(h.socket(instance)).Do(req.WithContext(ctx))
+	if err != nil {
+		return eris.Wrapf(err, "failed to contact instance %s", instance)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return eris.Errorf("instance %s: %s", instance, resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return eris.Wrap(json.NewDecoder(resp.Body).Decode(v), "failed to decode response")
+}
+
+func (h *handoverManager) serveState(w http.ResponseWriter, r *http.Request) {
+	state := handoverState{
+		Instance: h.instance,
+		Version:  version,
+		Owner:    h.Owner(),
+	}
+
+	if state.Owner {
+		state.AppliedHash = status.Current().AppliedHash
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Println("failed to encode handover state:", err)
+	}
+}
+
+// serveRelease stops this instance from applying its state, so that another
+// may take ownership, and lets it exit
+func (h *handoverManager) serveRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST is required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.Lock()
+	owner := h.owner
+	h.owner = false
+	h.mu.Unlock()
+
+	if !owner {
+		http.Error(w, "this instance is not the owner", http.StatusConflict)
+		return
+	}
+
+	h.releaseOnce.Do(func() { close(h.released) })
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveStatusWhenFree serves the status endpoint once its address has been
+// freed by the instance from which this one took ownership
+func serveStatusWhenFree(ctx context.Context, addr, gobgpAddress string) {
+	for {
+		l, err := net.Listen("tcp", addr)
+		if err == nil {
+			if err := http.Serve(l, statusMux(gobgpAddress)); err != nil {
+				log.Println("failed to serve status endpoint:", err)
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ipvsInterface is the dummy interface to which kube-proxy, in IPVS mode, binds Service addresses
+const ipvsInterface = "kube-ipvs0"
+
+// sysctlRoot is the location of the sysctl tree
+var sysctlRoot = "/proc/sys"
+
+// hazardMonitor detects configurations known to break advertised traffic,
+// surfacing them as warnings in the status report and as Events on the Node
+// rather than leaving them to cause silent traffic loss
+type hazardMonitor struct {
+	recorder events.Recorder
+
+	// reported is the set of hazards which have already been recorded as Events
+	reported map[string]bool
+}
+
+func newHazardMonitor(recorder events.Recorder) *hazardMonitor {
+	return &hazardMonitor{
+		recorder: recorder,
+		reported: make(map[string]bool),
+	}
+}
+
+// Check detects the current hazards, including those of the given announced
+// Services, recording an Event for each new one
+func (m *hazardMonitor) Check(serviceList []v1.Service) {
+	found := append(nodeHazards(), serviceHazards(serviceList)...)
+
+	current := make(map[string]bool, len(found))
+	for _, h := range found {
+		current[h] = true
+
+		if !m.reported[h] {
+			m.recorder.Warning("TrafficHazard", "%s", h)
+		}
+	}
+
+	m.reported = current
+
+	status.SetWarnings(found)
+}
+
+// nodeHazards returns descriptions of the hazards detected on this Node
+func nodeHazards() (list []string) {
+	if _, err := net.InterfaceByName(ipvsInterface); err == nil {
+		// kube-proxy in IPVS mode binds every Service address to a local
+		// interface, so unless ARP is restricted, the Node answers ARP for
+		// addresses which are routed to other Nodes.
+		if v, err := readSysctl("net/ipv4/conf/all/arp_ignore"); err == nil && v < 1 {
+			list = append(list, "kube-proxy IPVS mode is in use but net.ipv4.conf.all.arp_ignore is "+strconv.Itoa(v)+" (should be 1): this Node answers ARP for advertised Service addresses")
+		}
+
+		if v, err := readSysctl("net/ipv4/conf/all/arp_announce"); err == nil && v < 2 {
+			list = append(list, "kube-proxy IPVS mode is in use but net.ipv4.conf.all.arp_announce is "+strconv.Itoa(v)+" (should be 2): this Node may source ARP from advertised Service addresses")
+		}
+	}
+
+	return list
+}
+
+// serviceHazard returns a description of the hazard posed by the given
+// Service if it is announced per-endpoint (that is, only from the Nodes
+// running its endpoints) while kube-proxy may forward its traffic to any
+// Node, or the empty string if there is none
+func serviceHazard(svc *v1.Service) string {
+	if svc.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeLocal {
+		return ""
+	}
+
+	if _, ok := svc.Annotations[announceFromAnnotation]; !ok {
+		return ""
+	}
+
+	return "service " + svc.Namespace + "/" + svc.Name + " is announced only from selected Nodes but has externalTrafficPolicy " + string(svc.Spec.ExternalTrafficPolicy) + ": its traffic is SNATed and forwarded between Nodes, hiding client addresses; use externalTrafficPolicy Local"
+}
+
+// serviceHazards returns descriptions of the hazards posed by the given Services
+func serviceHazards(serviceList []v1.Service) (list []string) {
+	for i := range serviceList {
+		if h := serviceHazard(&serviceList[i]); h != "" {
+			list = append(list, h)
+		}
+	}
+
+	return list
+}
+
+// readSysctl reads an integer sysctl, given by its path within the sysctl tree
+func readSysctl(name string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(sysctlRoot, name))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,123 @@
+// Package history keeps a short history of the state transitions of each BGP
+// peer, so that flaps which monitoring sampled over can still be debugged
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the default number of transitions kept for each peer
+const DefaultSize = 32
+
+// Transition is a single state transition of a peer
+type Transition struct {
+	// Time is the time at which the transition was observed
+	Time time.Time `json:"time"`
+
+	// State is the state into which the peer transitioned
+	State string `json:"state"`
+
+	// Reason is the reason for the transition, if known
+	Reason string `json:"reason,omitempty"`
+}
+
+var (
+	mu    sync.Mutex
+	size  = DefaultSize
+	peers = make(map[string][]Transition)
+)
+
+// SetSize sets the number of transitions kept for each peer
+func SetSize(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	size = n
+
+	for peer, list := range peers {
+		if len(list) > size {
+			peers[peer] = append([]Transition(nil), list[len(list)-size:]...)
+		}
+	}
+}
+
+// Record records the transition of the given peer into the given state,
+// discarding its oldest transition if its history is full
+func Record(peer, state, reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := append(peers[peer], Transition{
+		Time:   time.Now(),
+		State:  state,
+		Reason: reason,
+	})
+	if len(list) > size {
+		list = list[len(list)-size:]
+	}
+
+	peers[peer] = list
+}
+
+// Last returns the most recently recorded state of the given peer, or the empty string if there is none
+func Last(peer string) string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := peers[peer]
+	if len(list) == 0 {
+		return ""
+	}
+
+	return list[len(list)-1].State
+}
+
+// Snapshot returns a copy of the transition history of every peer, oldest first
+func Snapshot() map[string][]Transition {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out := make(map[string][]Transition, len(peers))
+	for peer, list := range peers {
+		out[peer] = append([]Transition(nil), list...)
+	}
+
+	return out
+}
+
+// Dump writes the transition history of every peer in human-readable form
+func Dump(w io.Writer) {
+	snap := Snapshot()
+
+	names := make([]string, 0, len(snap))
+	for peer := range snap {
+		names = append(names, peer)
+	}
+	sort.Strings(names)
+
+	for _, peer := range names {
+		fmt.Fprintf(w, "%s:\n", peer) // nolint: errcheck
+
+		for _, t := range snap[peer] {
+			fmt.Fprintf(w, "  %s %s %s\n", t.Time.Format(time.RFC3339Nano), t.State, t.Reason) // nolint: errcheck
+		}
+	}
+}
+
+// Handler returns an http.Handler which serves the transition history of every peer as JSON
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(Snapshot()); err != nil {
+			log.Println("failed to write peer history:", err)
+		}
+	})
+}
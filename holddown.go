@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// holdDownGate holds back the routes of each Advertisement with a hold-down
+// which have been withdrawn (such as because its Pod went away), until they
+// have been desired again continuously for the hold-down, so that a
+// crash-looping workload does not make its routes oscillate upstream
+type holdDownGate struct {
+	recorder events.Recorder
+
+	// announced is the set of Advertisements (by name) whose routes were last announced
+	announced map[string]bool
+
+	// withdrawn is the set of Advertisements (by name) whose routes have
+	// been withdrawn and not yet released from their hold-down
+	withdrawn map[string]bool
+
+	// since is the time since which the routes of each withdrawn
+	// Advertisement have been desired continuously
+	since map[string]time.Time
+}
+
+func newHoldDownGate(recorder events.Recorder) *holdDownGate {
+	return &holdDownGate{
+		recorder:  recorder,
+		announced: make(map[string]bool),
+		withdrawn: make(map[string]bool),
+		since:     make(map[string]time.Time),
+	}
+}
+
+// Apply returns the given desired routes without those of the Advertisements
+// which are held down, along with the time until the next of them is
+// released, or zero if none is held down
+func (g *holdDownGate) Apply(cfg *KubeBGPConfig, desired []routes.Route, now time.Time) (list []routes.Route, next time.Duration) {
+	wanted := make(map[string]bool)
+	for _, r := range desired {
+		wanted[r.Source] = true
+	}
+
+	held := make(map[string]bool)
+
+	for _, a := range cfg.Advertisements {
+		if a.HoldDownSeconds == 0 {
+			continue
+		}
+
+		hold := time.Duration(a.HoldDownSeconds) * time.Second
+
+		switch {
+		case !wanted["advertisement/"+a.Name]:
+			if g.announced[a.Name] {
+				g.recorder.Warning("HoldDown", "routes of advertisement %s withdrawn; re-advertising only once desired for %s", a.Name, hold)
+				g.withdrawn[a.Name] = true
+			}
+			g.announced[a.Name] = false
+			delete(g.since, a.Name)
+		case !g.withdrawn[a.Name]:
+			g.announced[a.Name] = true
+		default:
+			since, ok := g.since[a.Name]
+			if !ok {
+				since = now
+				g.since[a.Name] = now
+			}
+
+			if remaining := hold - now.Sub(since); remaining > 0 {
+				held["advertisement/"+a.Name] = true
+				if next == 0 || remaining < next {
+					next = remaining
+				}
+				continue
+			}
+
+			g.recorder.Normal("HoldDownReleased", "routes of advertisement %s re-advertised after being desired for %s", a.Name, hold)
+			delete(g.withdrawn, a.Name)
+			delete(g.since, a.Name)
+			g.announced[a.Name] = true
+		}
+	}
+
+	if len(held) == 0 {
+		return desired, next
+	}
+
+	for _, r := range desired {
+		if !held[r.Source] {
+			list = append(list, r)
+		}
+	}
+
+	return list, next
+}
@@ -0,0 +1,162 @@
+// Package ipam synchronises the permitted address pools from an external IPAM,
+// so that the advertised address ranges always match its source of truth
+package ipam
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// Source is an external IPAM from which the permitted pools are obtained
+type Source interface {
+
+	// Pools returns the list of permitted pools, as CIDRs
+	Pools(ctx context.Context) ([]string, error)
+}
+
+// Syncer periodically obtains the permitted pools from a Source
+type Syncer interface {
+
+	// Changes waits for a change to the set of pools to occur
+	Changes() <-chan struct{}
+
+	// Pools returns the current list of pools, sorted, or nil if they have not yet been obtained
+	Pools() []string
+
+	// Synced indicates whether the pools have been obtained from the Source at least once
+	Synced() bool
+
+	// Close shuts down the Syncer
+	Close()
+}
+
+type syncer struct {
+	cancel   context.CancelFunc
+	source   Source
+	interval time.Duration
+	signal   *dirty.Flag
+
+	mu     sync.Mutex
+	pools  []string
+	synced bool
+}
+
+func (s *syncer) run(ctx context.Context) {
+	for {
+		changed, err := s.update(ctx)
+		if err != nil {
+			// The last known pools are kept until the IPAM is reachable again.
+			status.Error(errcode.Wrap(err, errcode.IPAMUnreachable, "failed to obtain pools from IPAM"))
+		}
+
+		if changed {
+			s.signal.Set()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *syncer) update(ctx context.Context) (changed bool, err error) {
+	list, err := s.source.Pools(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	pools := make([]string, 0, len(list))
+	for _, p := range list {
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "invalid pool %q from IPAM", p))
+			continue
+		}
+
+		pools = append(pools, network.String())
+	}
+	sort.Strings(pools)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.synced && reflect.DeepEqual(pools, s.pools) {
+		return false, nil
+	}
+
+	s.synced = true
+	s.pools = pools
+
+	return true, nil
+}
+
+func (s *syncer) Changes() <-chan struct{} {
+	return s.signal.C()
+}
+
+func (s *syncer) Pools() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pools
+}
+
+func (s *syncer) Synced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.synced
+}
+
+func (s *syncer) Close() {
+	s.cancel()
+}
+
+// NewSyncer returns a Syncer which obtains the pools from the given Source at the given interval
+func NewSyncer(ctx context.Context, source Source, interval time.Duration) Syncer {
+	localCtx, cancel := context.WithCancel(ctx)
+
+	s := &syncer{
+		cancel:   cancel,
+		source:   source,
+		interval: interval,
+		signal:   dirty.New(),
+	}
+
+	go s.run(localCtx)
+
+	return s
+}
+
+// Permitted indicates whether the given prefix lies wholly within one of the given pools
+func Permitted(prefix string, pools []string) bool {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+
+	for _, p := range pools {
+		_, pool, err := net.ParseCIDR(p)
+		if err != nil {
+			continue
+		}
+
+		poolOnes, poolBits := pool.Mask.Size()
+		if poolBits == bits && poolOnes <= ones && pool.Contains(network.IP) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+// netboxTimeout is the maximum time to wait for each request of the Netbox API
+const netboxTimeout = 30 * time.Second
+
+// Netbox is a Source which obtains the pools from the prefixes of a Netbox IPAM
+type Netbox struct {
+	// URL is the base URL of Netbox (e.g. `https://netbox.example.com`)
+	URL string
+
+	// Token is the API token with which Netbox is queried
+	Token string
+
+	// Tag is the tag of the prefixes which are permitted pools
+	Tag string
+}
+
+// netboxPrefixes is a page of the prefix list of the Netbox API
+type netboxPrefixes struct {
+	Next    *string `json:"next"`
+	Results []struct {
+		Prefix string `json:"prefix"`
+	} `json:"results"`
+}
+
+// Pools implements Source
+func (n *Netbox) Pools(ctx context.Context) ([]string, error) {
+	q := url.Values{"limit": {"1000"}}
+	if n.Tag != "" {
+		q.Set("tag", n.Tag)
+	}
+
+	next := strings.TrimSuffix(n.URL, "/") + "/api/ipam/prefixes/?" + q.Encode()
+
+	client := &http.Client{Timeout: netboxTimeout}
+
+	var pools []string
+
+	for next != "" {
+		page, err := n.get(ctx, client, next)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range page.Results {
+			pools = append(pools, r.Prefix)
+		}
+
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+
+	return pools, nil
+}
+
+func (n *Netbox) get(ctx context.Context, client *http.Client, u string) (*netboxPrefixes, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to create Netbox request")
+	}
+	req.Header.Set("Accept", "application/json")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Token "+n.Token)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to query Netbox")
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body) // nolint: errcheck
+		return nil, eris.Errorf("Netbox returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	page := new(netboxPrefixes)
+	if err := json.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, eris.Wrap(err, "failed to decode Netbox prefixes")
+	}
+
+	return page, nil
+}
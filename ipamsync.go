@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/ipam"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+)
+
+// defaultIPAMIntervalSeconds is the default interval at which the permitted pools are obtained from the IPAM
+const defaultIPAMIntervalSeconds = 300
+
+// IPAM configures the synchronisation of the permitted pools from an
+// external IPAM.  Only the routes within these pools are advertised.
+type IPAM struct {
+	// Netbox obtains the pools from the prefixes of a Netbox IPAM
+	Netbox *NetboxIPAM `yaml:"netbox"`
+
+	// IntervalSeconds is the interval at which the pools are obtained.
+	// This is optional, and defaults to 300.
+	IntervalSeconds int `yaml:"intervalSeconds"`
+}
+
+// NetboxIPAM describes a Netbox IPAM
+type NetboxIPAM struct {
+	// URL is the base URL of Netbox
+	URL string `yaml:"url"`
+
+	// TokenFile is the file holding the API token of Netbox.  This is
+	// optional, and if not supplied, Netbox is queried anonymously.
+	TokenFile string `yaml:"tokenFile"`
+
+	// Tag is the tag of the prefixes which are permitted pools.  This is
+	// optional, and if not supplied, every prefix is a permitted pool.
+	Tag string `yaml:"tag"`
+}
+
+func (i *IPAM) validate() error {
+	if i.Netbox == nil {
+		return eris.New("an IPAM source (netbox) is required")
+	}
+
+	if u, err := url.Parse(i.Netbox.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return eris.Errorf("invalid netbox url %q", i.Netbox.URL)
+	}
+
+	if i.IntervalSeconds < 0 {
+		return eris.Errorf("invalid intervalSeconds %d", i.IntervalSeconds)
+	}
+
+	return nil
+}
+
+// interval returns the interval at which the pools are obtained
+func (i *IPAM) interval() time.Duration {
+	if i.IntervalSeconds == 0 {
+		return defaultIPAMIntervalSeconds * time.Second
+	}
+
+	return time.Duration(i.IntervalSeconds) * time.Second
+}
+
+// source returns the configured IPAM Source
+func (i *IPAM) source() (ipam.Source, error) {
+	n := &ipam.Netbox{
+		URL: i.Netbox.URL,
+		Tag: i.Netbox.Tag,
+	}
+
+	if i.Netbox.TokenFile != "" {
+		data, err := ioutil.ReadFile(i.Netbox.TokenFile)
+		if err != nil {
+			return nil, errcode.Wrapf(err, errcode.ConfigInvalid, "failed to read netbox token file %s", i.Netbox.TokenFile)
+		}
+
+		n.Token = strings.TrimSpace(string(data))
+	}
+
+	return n, nil
+}
+
+// permittedRoutes returns those of the given routes which lie within the
+// given pools.  Until the pools have been obtained (nil), none are.
+func permittedRoutes(list []routes.Route, pools []string) (permitted []routes.Route) {
+	if pools == nil {
+		return nil
+	}
+
+	for _, r := range list {
+		if !ipam.Permitted(r.Prefix, pools) {
+			status.Error(errcode.New(errcode.ConfigInvalid, r.Prefix+" (from "+r.Source+") is not within any pool permitted by the IPAM; not advertising it"))
+			continue
+		}
+
+		permitted = append(permitted, r)
+	}
+
+	return permitted
+}
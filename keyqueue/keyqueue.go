@@ -0,0 +1,179 @@
+// Package keyqueue processes the changes to the objects of a watch by key,
+// through a rate-limited workqueue, for the watchers of resources which may
+// number in the thousands (such as Services), for which relisting on every
+// change, as the other watchers do, would not scale
+package keyqueue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Handler processes the latest state of the object of the given key, which
+// is nil if the object has been deleted.  If it returns an error, the key is
+// retried with backoff, unless a newer change supersedes it.
+type Handler func(key string, obj runtime.Object) error
+
+// entry is the latest change of a key
+type entry struct {
+	obj runtime.Object
+}
+
+// Queue is a rate-limited queue of the changes to objects, by key.  Only the
+// latest change of each key is retained, so that a burst of changes to one
+// object is processed once, and the memory held is bounded by the number of
+// objects rather than by the number of changes.
+type Queue struct {
+	name    string
+	handler Handler
+	queue   workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	pending map[string]*entry
+}
+
+// New returns a Queue, identified by the given name in its metrics, whose
+// changes are processed by the given Handler
+func New(name string, handler Handler) *Queue {
+	return &Queue{
+		name:    name,
+		handler: handler,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+		pending: make(map[string]*entry),
+	}
+}
+
+// Key returns the key of the given object: `<namespace>/<name>`, or `<name>`
+// if it is cluster-scoped
+func Key(obj runtime.Object) (string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+
+	if m.GetNamespace() == "" {
+		return m.GetName(), nil
+	}
+
+	return m.GetNamespace() + "/" + m.GetName(), nil
+}
+
+// Update queues the given object, superseding any change of its key not yet processed
+func (q *Queue) Update(key string, obj runtime.Object) {
+	q.mu.Lock()
+	q.pending[key] = &entry{obj: obj}
+	q.mu.Unlock()
+
+	q.queue.Add(key)
+}
+
+// Delete queues the deletion of the object of the given key
+func (q *Queue) Delete(key string) {
+	q.Update(key, nil)
+}
+
+// Feed queues each change delivered by the given watch, until the watch ends
+// (returning nil, after which the caller should watch again) or fails
+// (returning its error), or the context is cancelled
+func (q *Queue) Feed(ctx context.Context, w watch.Interface) error {
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			if ev.Type == watch.Error {
+				return apierrors.FromObject(ev.Object)
+			}
+
+			key, err := Key(ev.Object)
+			if err != nil || key == "" {
+				continue
+			}
+
+			if ev.Type == watch.Deleted {
+				q.Delete(key)
+			} else {
+				q.Update(key, ev.Object)
+			}
+		}
+	}
+}
+
+// Len returns the number of keys awaiting processing
+func (q *Queue) Len() int {
+	return q.queue.Len()
+}
+
+// Pending returns the number of keys whose latest change has not yet been
+// processed, including those being processed or retried with backoff
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+// Run processes the queued changes with the given number of workers until
+// the context is cancelled.  No key is processed by more than one worker at
+// a time.
+func (q *Queue) Run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for q.processNext() {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	q.queue.ShutDown()
+}
+
+func (q *Queue) processNext() bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(item)
+
+	key := item.(string)
+
+	q.mu.Lock()
+	e, ok := q.pending[key]
+	q.mu.Unlock()
+
+	if !ok {
+		return true // already processed
+	}
+
+	if err := q.handler(key, e.obj); err != nil {
+		metrics.KeyQueueRetries.WithLabelValues(q.name).Inc()
+		q.queue.AddRateLimited(key)
+		return true
+	}
+
+	q.queue.Forget(key)
+
+	// A change queued while this one was processed is kept for the next.
+	q.mu.Lock()
+	if q.pending[key] == e {
+		delete(q.pending, key)
+	}
+	q.mu.Unlock()
+
+	metrics.KeyQueueDepth.WithLabelValues(q.name).Set(float64(q.queue.Len()))
+
+	return true
+}
@@ -0,0 +1,184 @@
+package keyqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func service(namespace, name, resourceVersion string) *v1.Service {
+	return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: resourceVersion}}
+}
+
+func TestKey(t *testing.T) {
+	for _, tt := range []struct {
+		obj  runtime.Object
+		want string
+	}{
+		{service("default", "web", ""), "default/web"},
+		{&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, "node-a"},
+	} {
+		got, err := Key(tt.obj)
+		if err != nil {
+			t.Errorf("%s: %v", tt.want, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("expected %s, got %s", tt.want, got)
+		}
+	}
+}
+
+// recorder is a Handler which records the resource version of the latest
+// object handled for each key (or "deleted"), failing the given number of
+// times first
+type recorder struct {
+	mu       sync.Mutex
+	handled  map[string][]string
+	failures int
+}
+
+func newRecorder(failures int) *recorder {
+	return &recorder{handled: make(map[string][]string), failures: failures}
+}
+
+func (r *recorder) handle(key string, obj runtime.Object) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("failed")
+	}
+
+	version := "deleted"
+	if obj != nil {
+		version = obj.(*v1.Service).ResourceVersion
+	}
+	r.handled[key] = append(r.handled[key], version)
+
+	return nil
+}
+
+func (r *recorder) get(key string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.handled[key]...)
+}
+
+// waitFor waits for the given condition to hold, failing the test if it does not within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestQueue(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		failures int
+		changes  []string
+		want     string
+	}{
+		{name: "single change", changes: []string{"1"}, want: "1"},
+		{name: "latest change of a burst", changes: []string{"1", "2", "3"}, want: "3"},
+		{name: "deleted", changes: []string{"1", "deleted"}, want: "deleted"},
+		{name: "retried", failures: 2, changes: []string{"1"}, want: "1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecorder(tt.failures)
+			q := New("test", r.handle)
+
+			for _, version := range tt.changes {
+				if version == "deleted" {
+					q.Delete("default/web")
+				} else {
+					q.Update("default/web", service("default", "web", version))
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go q.Run(ctx, 2)
+
+			waitFor(t, "the change to be handled", func() bool { return len(r.get("default/web")) > 0 })
+
+			if got := r.get("default/web"); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("expected only %s to be handled, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFeed(t *testing.T) {
+	r := newRecorder(0)
+	q := New("test", r.handle)
+
+	w := watch.NewFakeWithChanSize(3, false)
+	w.Add(service("default", "a", "1"))
+	w.Modify(service("default", "a", "2"))
+	w.Delete(service("default", "b", "1"))
+	w.Stop()
+
+	if err := q.Feed(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("expected 2 keys queued, got %d", q.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, 1)
+
+	waitFor(t, "both keys to be handled", func() bool { return len(r.get("default/a")) > 0 && len(r.get("default/b")) > 0 })
+
+	if a, b := r.get("default/a"), r.get("default/b"); a[0] != "2" || b[0] != "deleted" {
+		t.Errorf("unexpected changes handled: %v, %v", a, b)
+	}
+}
+
+func TestFeedError(t *testing.T) {
+	q := New("test", newRecorder(0).handle)
+
+	w := watch.NewFakeWithChanSize(1, false)
+	w.Error(&metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonExpired, Code: 410})
+
+	if err := q.Feed(context.Background(), w); err == nil {
+		t.Error("expected the error of the watch to be returned")
+	}
+}
+
+func TestPending(t *testing.T) {
+	r := newRecorder(0)
+	q := New("test", r.handle)
+
+	q.Update("default/a", service("default", "a", "1"))
+	q.Update("default/a", service("default", "a", "2"))
+	q.Delete("default/b")
+
+	if got := q.Pending(); got != 2 {
+		t.Errorf("expected 2 keys pending, got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, 1)
+
+	waitFor(t, "no key to be pending", func() bool { return q.Pending() == 0 })
+}
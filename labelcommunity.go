@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// LabelCommunity describes the standard community which carries the value
+// of a Node label on the routes originated by the Node, such as the zone of
+// the Node, so that routers can engineer traffic by Kubernetes topology
+type LabelCommunity struct {
+	// ASN is the upper half of the community, which namespaces it
+	ASN int `yaml:"asn"`
+
+	// Values maps the values of the label to the lower half of the
+	// community.  Values which are not listed must be numbers, which are
+	// used directly.  This is optional.
+	Values map[string]int `yaml:"values"`
+}
+
+func (c *LabelCommunity) validate() error {
+	if c.ASN < 0 || c.ASN > 65535 {
+		return eris.Errorf("invalid asn %d: must be between 0 and 65535", c.ASN)
+	}
+
+	for value, v := range c.Values {
+		if v < 0 || v > 65535 {
+			return eris.Errorf("invalid value %d for %q: must be between 0 and 65535", v, value)
+		}
+	}
+
+	return nil
+}
+
+// community returns the community for the given value of the label
+func (c *LabelCommunity) community(value string) (string, bool) {
+	v, ok := c.Values[value]
+	if !ok {
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return "", false
+		}
+		v = int(n)
+	}
+
+	return strconv.Itoa(c.ASN) + ":" + strconv.Itoa(v), true
+}
+
+// labelCommunities returns the communities for the labels of the given Node,
+// in order of label.  Label values which have no community are reported and
+// skipped.
+func (c *KubeBGPConfig) labelCommunities(n *v1.Node) (list []string) {
+	if n == nil {
+		return nil
+	}
+
+	var keys []string
+	for key := range c.LabelCommunityMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := n.Labels[key]
+		if !ok {
+			continue
+		}
+
+		lc := c.LabelCommunityMap[key]
+
+		community, ok := lc.community(value)
+		if !ok {
+			status.Error(errcode.New(errcode.ConfigInvalid, "value "+strconv.Quote(value)+" of label "+key+" of node "+n.Name+" is not a number and is not in labelCommunityMap; not attaching it"))
+			continue
+		}
+
+		list = append(list, community)
+	}
+
+	return list
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/rotisserie/eris"
+)
+
+// linkCheckInterval is the interval at which the links to the Routers are
+// checked, in case a change of their state was not notified
+const linkCheckInterval = 10 * time.Second
+
+// sysNetRoot is the location of the network interfaces in sysfs
+var sysNetRoot = "/sys/class/net"
+
+// Link describes the local interface by which a Router (such as either TOR
+// of a dual-homed Node) is directly attached.  The session to the Router is
+// bound to it, and while the link is down, the session, and so the
+// advertisements to it and the routes learned from it, are dropped at once
+// rather than once its hold timer expires.
+type Link struct {
+	// Interface is the name of the local interface (e.g. `eth1`)
+	Interface string `yaml:"interface"`
+
+	// LocalAddress is the local address of the session.  This is optional,
+	// and if not supplied, the first address of the interface in the family
+	// of the Router is used.
+	LocalAddress string `yaml:"localAddress"`
+}
+
+func (l *Link) validate() error {
+	if l.Interface == "" {
+		return eris.New("interface is required")
+	}
+
+	if l.LocalAddress != "" && net.ParseIP(l.LocalAddress) == nil {
+		return eris.Errorf("invalid localAddress %q", l.LocalAddress)
+	}
+
+	return nil
+}
+
+// up checks the state of the link, returning an error describing why it is down
+func (l *Link) up() error {
+	iface, err := net.InterfaceByName(l.Interface)
+	if err != nil {
+		return eris.Wrapf(err, "interface %s not found", l.Interface)
+	}
+
+	if iface.Flags&net.FlagUp == 0 {
+		return eris.Errorf("interface %s is administratively down", l.Interface)
+	}
+
+	// The operational state reflects the carrier.  Virtual interfaces, which
+	// report it as unknown, are up while administratively up.
+	data, err := ioutil.ReadFile(filepath.Join(sysNetRoot, l.Interface, "operstate"))
+	if err != nil {
+		return nil
+	}
+
+	if state := strings.TrimSpace(string(data)); state != "up" && state != "unknown" {
+		return eris.Errorf("interface %s is %s", l.Interface, state)
+	}
+
+	return nil
+}
+
+// localAddress returns the local address of the session to the Router at
+// the given address, or nil if none could be found
+func (l *Link) localAddress(address string) net.IP {
+	if l.LocalAddress != "" {
+		return net.ParseIP(l.LocalAddress)
+	}
+
+	remote := net.ParseIP(address)
+	if remote == nil {
+		return nil
+	}
+
+	iface, err := net.InterfaceByName(l.Interface)
+	if err != nil {
+		return nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if (ipNet.IP.To4() != nil) == (remote.To4() != nil) {
+			return ipNet.IP
+		}
+	}
+
+	return nil
+}
+
+// hasLinks indicates whether any Router declares the Link by which it is attached
+func (c *KubeBGPConfig) hasLinks() bool {
+	for _, r := range c.Routers {
+		if r.Link != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// linkMonitor checks the state of the links to which Routers are attached,
+// reporting changes in it
+type linkMonitor struct {
+	recorder events.Recorder
+
+	mu sync.Mutex
+
+	// down is the set of Router addresses whose links are down
+	down map[string]bool
+}
+
+func newLinkMonitor(recorder events.Recorder) *linkMonitor {
+	return &linkMonitor{
+		recorder: recorder,
+		down:     make(map[string]bool),
+	}
+}
+
+// Check checks the link of each of the given Routers, recording an Event
+// whenever one goes down or comes back up, and returns whether any has done so
+func (m *linkMonitor) Check(routers []Router) (changed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range routers {
+		if r.Link == nil {
+			continue
+		}
+
+		err := r.Link.up()
+		if err != nil {
+			metrics.RouterLinkUp.WithLabelValues(r.Ref()).Set(0)
+
+			if !m.down[r.Address] {
+				m.recorder.Warning("LinkDown", "link to router %s is down; dropping its session: %v", r.Ref(), err)
+				m.down[r.Address] = true
+				changed = true
+			}
+
+			continue
+		}
+
+		metrics.RouterLinkUp.WithLabelValues(r.Ref()).Set(1)
+
+		if m.down[r.Address] {
+			m.recorder.Normal("LinkUp", "link to router %s is up", r.Ref())
+			delete(m.down, r.Address)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Down returns the set of Router addresses whose links are down
+func (m *linkMonitor) Down() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	down := make(map[string]bool, len(m.down))
+	for addr := range m.down {
+		down[addr] = true
+	}
+
+	return down
+}
+
+// watchLinks returns a channel which receives whenever the state of any
+// network interface changes, as notified by netlink.  Where netlink is not
+// available, it returns nil, and the links are only checked periodically.
+func watchLinks(ctx context.Context) <-chan struct{} {
+	changes, err := linkChanges(ctx)
+	if err != nil {
+		log.Println("failed to watch for link changes; checking links periodically only:", err)
+		return nil
+	}
+
+	return changes
+}
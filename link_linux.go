@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/rotisserie/eris"
+)
+
+// rtmgrpLink is the rtnetlink multicast group of link notifications (RTMGRP_LINK)
+const rtmgrpLink = 0x1
+
+// linkChanges subscribes to the link notifications of rtnetlink, returning a
+// channel which receives whenever any interface changes state
+func linkChanges(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to open netlink socket")
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpLink}); err != nil {
+		syscall.Close(fd) // nolint: errcheck
+		return nil, eris.Wrap(err, "failed to subscribe to netlink link notifications")
+	}
+
+	changes := dirty.New()
+
+	go func() {
+		<-ctx.Done()
+		syscall.Close(fd) // nolint: errcheck
+	}()
+
+	go func() {
+		buf := make([]byte, 1<<16)
+
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if ctx.Err() != nil {
+				return
+			}
+
+			switch {
+			case err == syscall.EINTR:
+				continue
+			case err == syscall.ENOBUFS:
+				// Notifications were lost, so the links are checked anyway.
+			case err != nil:
+				return
+			case !linkMessage(buf[:n]):
+				continue
+			}
+
+			changes.Set()
+		}
+	}()
+
+	return changes.C(), nil
+}
+
+// linkMessage indicates whether the given netlink datagram notifies a change of a link
+func linkMessage(data []byte) bool {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return false
+	}
+
+	for _, m := range msgs {
+		if m.Header.Type == syscall.RTM_NEWLINK || m.Header.Type == syscall.RTM_DELLINK {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+
+	"github.com/rotisserie/eris"
+)
+
+// linkChanges is not supported without netlink
+func linkChanges(ctx context.Context) (<-chan struct{}, error) {
+	return nil, eris.New("link notifications require netlink")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultEndpointMbps is the default bandwidth advertised per ready endpoint
+const defaultEndpointMbps = 1000
+
+// LinkBandwidth configures the link-bandwidth extended community attached to
+// the routes of Service VIPs, in proportion to the number of ready endpoints
+// of the Service on the announcing Node, so that routers performing weighted
+// ECMP send more traffic to the Nodes with more endpoints.
+type LinkBandwidth struct {
+	// EndpointMbps is the bandwidth advertised per ready endpoint, in Mbit/s.
+	// This is optional, and defaults to 1000.
+	EndpointMbps int `yaml:"endpointMbps"`
+}
+
+func (l *LinkBandwidth) validate() error {
+	if l.EndpointMbps < 0 {
+		return eris.Errorf("invalid endpointMbps %d", l.EndpointMbps)
+	}
+
+	return nil
+}
+
+// bandwidth returns the link bandwidth, in bytes per second, of the ready
+// endpoints of the given Endpoints (if any) which are on the named Node
+func (l *LinkBandwidth) bandwidth(ep *v1.Endpoints, thisNode string) float32 {
+	if ep == nil {
+		return 0
+	}
+
+	mbps := l.EndpointMbps
+	if mbps == 0 {
+		mbps = defaultEndpointMbps
+	}
+
+	return float32(localReadyEndpoints(ep, thisNode)) * float32(mbps) * 1e6 / 8
+}
+
+// localReadyEndpoints returns the number of ready endpoints of the given Endpoints which are on the named Node
+func localReadyEndpoints(ep *v1.Endpoints, thisNode string) (count int) {
+	for _, subset := range ep.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.NodeName != nil && *addr.NodeName == thisNode {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+// serviceEndpoints returns the Endpoints of the given Services, so that only
+// those by which their routes are weighted are kept in the cluster state
+func serviceEndpoints(serviceList []v1.Service, list []v1.Endpoints) (filtered []v1.Endpoints) {
+	announced := make(map[string]bool, len(serviceList))
+	for i := range serviceList {
+		announced[serviceList[i].Namespace+"/"+serviceList[i].Name] = true
+	}
+
+	for i := range list {
+		if announced[list[i].Namespace+"/"+list[i].Name] {
+			filtered = append(filtered, list[i])
+		}
+	}
+
+	return filtered
+}
+
+// endpointsByService returns the given Endpoints by the key of their Service
+func endpointsByService(list []v1.Endpoints) map[string]*v1.Endpoints {
+	m := make(map[string]*v1.Endpoints, len(list))
+	for i := range list {
+		m[list[i].Namespace+"/"+list[i].Name] = &list[i]
+	}
+
+	return m
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/rotisserie/eris"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// verifyLocalNode checks that the named Node exists and is this machine, by
+// finding at least one of its addresses on a local interface.  A wrong
+// NODE_NAME (such as from a misconfigured Downward API fieldRef) would
+// otherwise silently produce the configuration of some other Node.
+func verifyLocalNode(ctx context.Context, clientset kubernetes.Interface, nodeName string) error {
+	n, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get Node %s named by NODE_NAME", nodeName)
+	}
+
+	local, err := net.InterfaceAddrs()
+	if err != nil {
+		return eris.Wrap(err, "failed to list local interface addresses")
+	}
+
+	var nodeAddrs []string
+	for _, addr := range n.Status.Addresses {
+		ip := net.ParseIP(addr.Address)
+		if ip == nil {
+			continue
+		}
+
+		nodeAddrs = append(nodeAddrs, addr.Address)
+
+		for _, a := range local {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return nil
+			}
+		}
+	}
+
+	return errcode.New(errcode.ConfigInvalid, "Node "+nodeName+" named by NODE_NAME is not this machine: none of its addresses ("+strings.Join(nodeAddrs, ", ")+") is on a local interface")
+}
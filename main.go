@@ -2,13 +2,40 @@ package main
 
 import (
 	"context"
+	"flag"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/egress"
+	"github.com/CyCoreSystems/kube-bgp/election"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/features"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/history"
+	"github.com/CyCoreSystems/kube-bgp/ipam"
+	"github.com/CyCoreSystems/kube-bgp/maintenance"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/nodegroups"
 	"github.com/CyCoreSystems/kube-bgp/nodes"
+	"github.com/CyCoreSystems/kube-bgp/pods"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/services"
+	"github.com/CyCoreSystems/kube-bgp/speaker"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/CyCoreSystems/kube-bgp/tenants"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rotisserie/eris"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -16,8 +43,25 @@ import (
 var configFile = "/etc/kube-bgp/kube-bgp.yaml"
 var outputFile = "/etc/gobgp/gobgp.conf"
 
+// embeddedBGPFlag is set by the --embedded-bgp flag of the daemon, which
+// enables embeddedBGP whatever the configuration file says
+var embeddedBGPFlag bool
+
+// defaultGoBGPAPIAddress is the default address of the gobgpd gRPC API
+const defaultGoBGPAPIAddress = "127.0.0.1:50051"
+
+// defaultLeaseNamespace is the default namespace of the Leases by which route reflectors are elected
+const defaultLeaseNamespace = "kube-system"
+
+// defaultStatusAddress is the default address on which the status and metrics endpoints are served
+const defaultStatusAddress = ":8080"
+
 // Router is an eBGP router to which we whould peer
-var Router struct {
+type Router struct {
+	// Name is the name by which the router may be referenced elsewhere in the configuration.
+	// This is optional, and if not supplied, the router may be referenced by its Address.
+	Name string `yaml:"name"`
+
 	// Address is the address of the router
 	Address string `yaml:"address"`
 
@@ -25,17 +69,83 @@ var Router struct {
 	// This is optional, and if not supplied, the system ASN will be used.
 	ASN string `yaml:"asn"`
 
-	// PeerNodes is the list of Node names which should peer with this Router
+	// Port is the remote TCP port of the BGP session to the router.
+	// This is optional, and if not supplied, the standard BGP port (179) will be used.
+	Port int `yaml:"port"`
+
+	// SoftReconfigurationInbound retains the routes received from the router
+	// before they are accepted (its Adj-RIB-In), so that those received may be
+	// compared with those accepted.  gobgpd always retains them; the built-in
+	// speaker only retains their prefixes with this set, and never accepts any.
+	SoftReconfigurationInbound bool `yaml:"softReconfigurationInbound"`
+
+	// AdvertisementIntervalSeconds is the minimum interval between the
+	// batches of UPDATEs sent to the router (its MRAI), so that bursts of
+	// route changes are sent together, as older TOR hardware requires.
+	// This is optional, and if not supplied, changes are sent at once.
+	AdvertisementIntervalSeconds int `yaml:"advertisementIntervalSeconds"`
+
+	// PeerNodes is the list of Nodes which should peer with this Router.
+	// Each entry is matched, case-insensitively, against the Node's name, its
+	// hostname label, and its provider ID, and it may be a glob pattern (such
+	// as `edge-*`).
 	PeerNodes []string `yaml:"peerNodes"`
+
+	// PeerNodeGroups is the list of BGPNodeGroups (by name) whose Nodes
+	// should peer with this Router.
+	PeerNodeGroups []string `yaml:"peerNodeGroups"`
+
+	// Tunnel describes the local tunnel interface through which the router
+	// is reached.  This is optional.
+	Tunnel *Tunnel `yaml:"tunnel"`
+
+	// Link describes the local interface by which the router is directly
+	// attached, such as either TOR of a dual-homed Node.  This is optional.
+	Link *Link `yaml:"link"`
+
+	// PasswordFile is the file (such as a mounted Secret) holding the TCP-MD5
+	// password of the session to the router.  This is optional.
+	PasswordFile string `yaml:"passwordFile"`
+
+	// Disabled drains the sessions of every Node with the router, such as
+	// while it is under maintenance
+	Disabled bool `yaml:"disabled"`
+
+	// DryRun reports the session with the router, and the prefixes which
+	// would be exported to it, in the status report, without configuring it
+	DryRun bool `yaml:"dryRun"`
 }
 
 // Peer describes an iBGP peer with which we should exchange routes.
-var Peer struct {
+type Peer struct {
 	// Address is the address of the iBGP peer
 	Address string `yaml:"address"`
 
 	// Name is the kubernetes Node name of the iBGP peer
 	Name string `yaml:"name"`
+
+	// Description identifies the Node of the iBGP peer in the session description
+	Description string `yaml:"description"`
+
+	// ASN is the ASN of the peer, if it differs from the system ASN by virtue of its BGPNodeGroup
+	ASN string `yaml:"asn"`
+
+	// LocalASN is the local ASN of the session, if it differs from the
+	// system ASN by virtue of its Topology
+	LocalASN string `yaml:"localASN"`
+
+	// Topology is the name of the Topology of the session, or empty for the primary mesh
+	Topology string `yaml:"topology"`
+
+	// RouteReflectorClient indicates that this Node reflects routes to the peer
+	RouteReflectorClient bool `yaml:"routeReflectorClient"`
+
+	// HoldSeconds is the time for which the routes of the peer are retained
+	// after its session drops, or zero if they are withdrawn at once
+	HoldSeconds int `yaml:"holdSeconds"`
+
+	// PasswordFile is the file holding the TCP-MD5 password of the session, if any
+	PasswordFile string `yaml:"passwordFile"`
 }
 
 // KubeBGPConfig describes the configuration structure of Kube-BGP
@@ -48,6 +158,11 @@ type KubeBGPConfig struct {
 	// If supplied, the supplied value will override any // auto-calculated one.
 	RouterID string `yaml:"routerID"`
 
+	// RouterIDPool is the pool from which a unique, stable router-id is
+	// allocated to each Node.  This is optional, and may not be combined
+	// with RouterID.
+	RouterIDPool *RouterIDPool `yaml:"routerIDPool"`
+
 	// Routers is the list of eBGP routers to which we should reflect routes.
 	// This is optional.
 	Routers []Router `yaml:"routers"`
@@ -56,11 +171,322 @@ type KubeBGPConfig struct {
 	// This should not be supplied by the user.
 	// It will be automatically calculated based on the Nodes in the cluster.
 	Peers []Peer `yaml:"-"`
+
+	// ClusterName is the name of the cluster, which is included in the
+	// descriptions of BGP sessions, the `kube_bgp_info` metric, and the status
+	// report, so that several clusters can be told apart.  This is optional.
+	ClusterName string `yaml:"clusterName"`
+
+	// ClusterCommunity is a standard community (`<asn>:<value>`) attached to
+	// every route originated by the cluster, so that routers can tell which
+	// cluster originated which routes.  This is optional.
+	ClusterCommunity string `yaml:"clusterCommunity"`
+
+	// LabelCommunityMap maps Node label keys to the communities by which
+	// their values are attached to every route originated by each Node.
+	// This is optional.
+	LabelCommunityMap map[string]LabelCommunity `yaml:"labelCommunityMap"`
+
+	// RackLabel is the Node label whose value identifies the rack of each
+	// Node, which is included in the descriptions of BGP sessions.  This is
+	// optional.
+	RackLabel string `yaml:"rackLabel"`
+
+	// PeerSourceCIDR is the network (usually the routing fabric subnet) in
+	// which BGP sessions are established.  If supplied, each Node is peered by
+	// its address within this CIDR, and this Node uses its own address within
+	// it as the source of its sessions and the next-hop of the routes it
+	// originates.  This is optional.
+	PeerSourceCIDR string `yaml:"peerSourceCIDR"`
+
+	// MeshPasswordFile is the file (such as a mounted Secret) holding the
+	// TCP-MD5 password of the sessions between Nodes, in the mesh and in
+	// each Topology.  This is optional.
+	MeshPasswordFile string `yaml:"meshPasswordFile"`
+
+	// RequireAuth refuses any configuration which would generate a session
+	// without a TCP-MD5 password
+	RequireAuth bool `yaml:"requireAuth"`
+
+	// PeerAddress describes how the iBGP peer address of each Node is chosen.
+	// This is optional, and if not supplied, the first InternalIP of each Node is used.
+	PeerAddress *PeerAddressSelection `yaml:"peerAddress"`
+
+	// StatusAddress is the address on which the status (`/status`) and
+	// Prometheus metrics (`/metrics`) endpoints are served.
+	// This is optional, and defaults to ":8080".
+	StatusAddress string `yaml:"statusAddress"`
+
+	// AdminSocket is the path of the unix socket on which the admin API, by
+	// which the CLI subcommands act on the running kube-bgp, is served.  It
+	// is accessible only to the user of kube-bgp.
+	// This is optional, and defaults to "/var/run/kube-bgp/admin.sock".
+	AdminSocket string `yaml:"adminSocket"`
+
+	// AdminTokenFile is the file holding a token which callers of the admin
+	// API must also present.  This is optional, and if not supplied, the
+	// permissions of the socket are its only protection.
+	AdminTokenFile string `yaml:"adminTokenFile"`
+
+	// GoBGPAPIAddress is the address of the gobgpd gRPC API.
+	// This is optional, and defaults to "127.0.0.1:50051".
+	GoBGPAPIAddress string `yaml:"gobgpAPIAddress"`
+
+	// GoBGPDConfig selects how the neighbors are configured in gobgpd:
+	// `file` (the default), by writing its configuration file and notifying
+	// it, or `api`, by adding and deleting them through its API, so that no
+	// configuration file (nor reload) is needed.
+	GoBGPDConfig string `yaml:"gobgpdConfig"`
+
+	// EmbeddedBGP runs the gobgp BGP server within kube-bgp, in place of a
+	// gobgpd container, serving the gobgpd API at GoBGPAPIAddress.  It
+	// requires a build with the `embedded` tag, the gobgpd speaker (or
+	// output) with gobgpdConfig `api`, and the EmbeddedSpeaker feature gate.
+	// This is optional, and is also enabled by the --embedded-bgp flag.
+	EmbeddedBGP bool `yaml:"embeddedBGP"`
+
+	// RouterProbe configures the optional reachability probe of Routers.
+	// This is optional.
+	RouterProbe *RouterProbe `yaml:"routerProbe"`
+
+	// Handover configures the handover of each Node between kube-bgp
+	// instances, such as during a blue/green upgrade.  This is optional.
+	Handover *Handover `yaml:"handover"`
+
+	// LogLevel is the level of logging: `info` or `debug`, at which the
+	// changes made by each reconcile are logged.  This is optional, and
+	// defaults to `info`.
+	LogLevel string `yaml:"logLevel"`
+
+	// PeerProbe configures the optional probe of the dataplane path to each iBGP peer.
+	// This is optional.
+	PeerProbe *PeerProbe `yaml:"peerProbe"`
+
+	// NodeConditions configures the Node conditions (such as those of
+	// node-problem-detector) which withdraw the service advertisements of
+	// the Node.  This is optional.
+	NodeConditions *NodeConditions `yaml:"nodeConditions"`
+
+	// Advertisements is the list of classes of routes which are announced by every Node.
+	// This is optional.
+	Advertisements []Advertisement `yaml:"advertisements"`
+
+	// ZoneSummaries is the list of summaries of the pod CIDRs of the Nodes of
+	// each zone into aggregates, which are advertised by the route reflectors
+	// of the zone.  This is optional.
+	ZoneSummaries []ZoneSummary `yaml:"zoneSummaries"`
+
+	// Pods configures the announcement of the IPs listed in the
+	// `kube-bgp.cycoresystems.com/advertise-ips` annotation of Pods, from the
+	// Node on which each Pod runs.  This is optional, and if not supplied,
+	// Pods are not watched.
+	Pods *PodAdvertisement `yaml:"pods"`
+
+	// PodCIDRs configures the announcement of the pod CIDRs of each Node
+	// from the Node itself.  This is optional, and if not supplied, they
+	// are not announced.
+	PodCIDRs *PodCIDRAdvertisement `yaml:"podCIDRs"`
+
+	// Services configures the announcement of the ingress IPs of
+	// LoadBalancer Services, which requires the ServiceAdvertisement
+	// feature gate.  This is optional, and if not supplied, Services are not
+	// watched.
+	Services *ServiceAdvertisement `yaml:"services"`
+
+	// EgressIPs configures the announcement of the IPs of EgressIP resources
+	// from the Node which currently hosts each of them.  This is optional,
+	// and if not supplied, EgressIPs are not watched.
+	EgressIPs *EgressAdvertisement `yaml:"egressIPs"`
+
+	// NodeGroups enables BGPNodeGroup resources, which apply common settings
+	// (ASN, communities, route reflector role, and source CIDR) to the Nodes
+	// they select.  If not set, BGPNodeGroups are not watched.
+	NodeGroups bool `yaml:"nodeGroups"`
+
+	// Tenants enables BGPTenant resources, which export the routes within
+	// the VIP pools of each tenant in its MPLS VPN.  If not set, BGPTenants
+	// are not watched.
+	Tenants bool `yaml:"tenants"`
+
+	// RouterMaintenance enables BGPRouterMaintenance resources, which drain
+	// the sessions of every Node with a Router while it is under
+	// maintenance.  If not set, BGPRouterMaintenances are not watched.
+	RouterMaintenance bool `yaml:"routerMaintenance"`
+
+	// NodeStatus writes a BGPNodeStatus for each Node, named after it,
+	// recording the number of prefixes it exports to each neighbor.  If not
+	// set, the counts are exported only as metrics.
+	NodeStatus bool `yaml:"nodeStatus"`
+
+	// VerifyAdjRIBOut verifies, after each apply to gobgpd, that its
+	// adj-RIB-out for each established neighbor holds exactly the prefixes
+	// which should be exported to it, reporting the discrepancies (such as
+	// the routes silently dropped by a policy) in the status report and the
+	// metrics.  This costs a query of gobgpd per neighbor on every reconcile.
+	VerifyAdjRIBOut bool `yaml:"verifyAdjRIBOut"`
+
+	// LeaseNamespace is the namespace of the Leases by which the route
+	// reflectors of BGPNodeGroups are elected.
+	// This is optional, and defaults to "kube-system".
+	LeaseNamespace string `yaml:"leaseNamespace"`
+
+	// LinkBandwidth attaches the link-bandwidth extended community to the
+	// routes of Service VIPs, weighted by the number of local ready
+	// endpoints.  This is optional, and requires services and the built-in
+	// speaker, since gobgpd cannot be given the community.
+	LinkBandwidth *LinkBandwidth `yaml:"linkBandwidth"`
+
+	// AnnounceAPI serves the announcement API on a unix socket, by which
+	// applications on this Node may announce the IPs they own while they are
+	// healthy.  This is optional, and if not supplied, the API is not served.
+	AnnounceAPI *AnnounceAPI `yaml:"announceAPI"`
+
+	// IPAM synchronises the permitted pools from an external IPAM, so that
+	// only the routes within them are advertised.  This is optional, and if
+	// not supplied, every route is advertised.
+	IPAM *IPAM `yaml:"ipam"`
+
+	// FreezeNamespace is the Namespace whose
+	// `kube-bgp.cycoresystems.com/freeze` annotation, while present, freezes
+	// the advertisements of every Node, such as during incident response.
+	// This is optional, and if not supplied, advertisements cannot be frozen.
+	FreezeNamespace string `yaml:"freezeNamespace"`
+
+	// Bootstrap describes the minimum health of the cluster which must be
+	// reached after startup before the advertisements are made.  This is
+	// optional, and if not supplied, they are made at once.
+	Bootstrap *Bootstrap `yaml:"bootstrap"`
+
+	// Mesh selects whether the Nodes peer with each other over iBGP:
+	// `enabled` (the default) or `disabled`, for eBGP-only designs in which
+	// kube-bgp manages only the sessions to Routers and the advertisements.
+	Mesh string `yaml:"mesh"`
+
+	// Topologies is the list of additional iBGP meshes, each among the Nodes
+	// which it selects, alongside the primary mesh.  This is optional.
+	Topologies []Topology `yaml:"topologies"`
+
+	// PeerDownHoldSeconds is the time for which the routes learned from an
+	// iBGP peer are retained after its session drops while its Node is still
+	// Ready, so that a transient TCP reset does not withdraw them.  The
+	// routes of a peer whose Node is not Ready are withdrawn at once.  This is
+	// optional, and if not supplied, routes are withdrawn as soon as the
+	// session drops.
+	PeerDownHoldSeconds int `yaml:"peerDownHoldSeconds"`
+
+	// DeferTakeoverSeconds is the longest time for which the announcement of
+	// an exclusive route (such as that of an EgressIP) which this Node takes
+	// over is deferred while gobgpd still receives it from another Node.
+	// This is optional, and if not supplied, takeovers are not deferred.
+	DeferTakeoverSeconds int `yaml:"deferTakeoverSeconds"`
+
+	// RouteExpirySeconds is the time for which routes originated on behalf of
+	// dynamic objects (Pods and EgressIPs) remain valid without their source
+	// objects being re-confirmed by the apiserver, after which they are
+	// withdrawn.  This is optional, and if not supplied, such routes never
+	// expire.
+	RouteExpirySeconds int `yaml:"routeExpirySeconds"`
+
+	// StateCache configures a local cache of the last known-good cluster
+	// state, which is applied at startup until the state has been obtained
+	// from the apiserver.  This is optional.
+	StateCache *StateCache `yaml:"stateCache"`
+
+	// PeerHistorySize is the number of state transitions kept for each peer
+	// in the peer history.  If not supplied, it defaults to 32.
+	PeerHistorySize int `yaml:"peerHistorySize"`
+
+	// Outputs is the list of additional backends to which the same desired
+	// state is applied simultaneously, such as during a migration between
+	// speakers: `gobgpd` or `builtin` (whichever is not the Speaker), and
+	// `status`, which publishes the desired state in the status report.
+	// This is optional.
+	Outputs []string `yaml:"outputs"`
+
+	// StrictCapabilities rejects a configuration with any setting which a
+	// backend in use cannot express, rather than warning that it is ignored
+	StrictCapabilities bool `yaml:"strictCapabilities"`
+
+	// Profile selects a built-in configuration for a common fabric design
+	// (`calico-style-mesh`, `tor-ebgp-per-rack`, or `metallb-replacement`),
+	// which supplies the defaults for the rest of the configuration.  This is
+	// optional.
+	Profile string `yaml:"profile"`
+
+	// FeatureGates enables or disables features, by the name of their
+	// gates, overriding the default of their stage: alpha features are
+	// disabled by default, and beta features enabled.  This is optional.
+	FeatureGates features.Set `yaml:"featureGates"`
+
+	// Speaker selects the BGP speaker which announces routes: `gobgpd` (the
+	// default), in which an external gobgpd is configured and controlled, or
+	// `builtin`, in which a minimal, announce-only speaker runs within
+	// kube-bgp itself and peers only with Routers.  The built-in speaker
+	// supports advertise-only use cases without any gobgpd at all.
+	Speaker string `yaml:"speaker"`
+
+	// DNS configures the publication of the advertised host routes as
+	// DNSEndpoints for external-dns.  This is optional.
+	DNS *DNSExport `yaml:"dns"`
+
+	// Guardrails describes the thresholds of the resource usage of kube-bgp
+	// itself beyond which it warns.  This is optional, and if not supplied,
+	// the default thresholds apply.
+	Guardrails *Guardrails `yaml:"guardrails"`
+
+	// ReloadCommand is the command (and its arguments) which is run to have
+	// gobgpd reload its configuration, with the path of the configuration
+	// file in the KUBE_BGP_CONFIG_FILE environment variable.  This is
+	// optional, and if not supplied, the gobgpd process is signalled with
+	// SIGHUP instead, which requires a shared process namespace and is not
+	// possible on Windows.
+	ReloadCommand []string `yaml:"reloadCommand"`
+
+	// GracefulRestartSeconds is the restart time advertised by the built-in
+	// speaker in the graceful restart capability, for which Routers retain
+	// its routes while it restarts or is swapped with another speaker.  This
+	// is optional, and if not supplied, graceful restart is not advertised.
+	GracefulRestartSeconds int `yaml:"gracefulRestartSeconds"`
 }
 
 func main() {
 	ctx := context.Background()
 
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "refresh":
+			os.Exit(runRefresh(os.Args[2:]))
+		case "router":
+			os.Exit(runRouter(os.Args[2:]))
+		case "test":
+			os.Exit(runTest(os.Args[2:]))
+		case "dashboard":
+			os.Exit(runDashboard(os.Args[2:]))
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "scale":
+			os.Exit(runScale(os.Args[2:]))
+		case "version":
+			os.Exit(runVersion(os.Args[2:]))
+		case "config-schema":
+			os.Exit(runConfigSchema(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "explain":
+			os.Exit(runExplain(os.Args[2:]))
+		case "materialize":
+			os.Exit(runMaterialize(os.Args[2:]))
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+	}
+
+	fs := flag.NewFlagSet("kube-bgp", flag.ExitOnError)
+	fs.BoolVar(&embeddedBGPFlag, "embedded-bgp", false, "run the gobgp BGP server in-process, as with embeddedBGP (requires a build with the embedded tag)")
+	fs.Parse(os.Args[1:]) // nolint: errcheck
+
 	nodeName := os.Getenv("NODE_NAME")
 	if nodeName == "" {
 		log.Fatalln("NODE_NAME must be set")
@@ -71,14 +497,80 @@ func main() {
 		log.Fatalln("failed to read configuration:", err)
 	}
 
-	kubeconfig, err := rest.InClusterConfig()
+	status.SetIdentity(cfg.ClusterName, nodeName)
+	metrics.Info.WithLabelValues(cfg.ClusterName, nodeName).Set(1)
+
+	status.SetFeatures(cfg.FeatureGates.Effective())
+	for _, g := range features.Gates() {
+		enabled := 0.0
+		if cfg.FeatureGates.Enabled(g) {
+			enabled = 1
+		}
+		metrics.FeatureEnabled.WithLabelValues(g.Name, string(g.Stage)).Set(enabled)
+	}
+
+	if cfg.PeerHistorySize > 0 {
+		history.SetSize(cfg.PeerHistorySize)
+	}
+
+	// The kubernetes clients are created once, here, and injected wherever they are used.
+	kubeconfig, err := kubeConfig()
 	if err != nil {
-		log.Fatalln("failed to acquire kubernetes config:", err)
+		log.Fatalln("failed to create kubernetes client:", err)
 	}
 
-	clientset, err := kubernetes.NewForConfig(kubeconfig)
+	clientset, err := newClientset(kubeconfig)
 	if err != nil {
-		log.Fatalln("failed to create the kubernetes clientset:", err)
+		log.Fatalln("failed to create kubernetes client:", err)
+	}
+
+	var dynamicClient dynamic.Interface
+	if cfg.EgressIPs != nil || cfg.NodeGroups || cfg.Tenants || cfg.RouterMaintenance || cfg.NodeStatus || cfg.DNS != nil {
+		dynamicClient, err = newDynamicClient(kubeconfig)
+		if err != nil {
+			log.Fatalln("failed to create kubernetes client:", err)
+		}
+	}
+
+	refreshRequests := make(chan *refreshRequest)
+
+	handover := newHandoverManager(cfg.Handover)
+	if err := handover.Start(ctx); err != nil {
+		log.Fatalln("failed to start handover:", err)
+	}
+
+	// A standby serves neither its status nor its admin API until it takes
+	// ownership, since their addresses are held by the owner.
+	if handover.Owner() {
+		go serveStatus(cfg.StatusAddress, cfg.GoBGPAPIAddress)
+
+		go serveAdmin(ctx, cfg, refreshRequests, dynamicClient)
+	}
+
+	go dumpHistoryOnSignal(ctx)
+
+	go dumpStateOnSignal(ctx)
+
+	debugUpdate(func(d *StateDump) { d.Config = cfg })
+
+	if err := verifyLocalNode(ctx, clientset, nodeName); err != nil {
+		if cfg.StateCache == nil || errcode.Of(err) != errcode.APIServerUnreachable {
+			log.Fatalln("invalid NODE_NAME:", err)
+		}
+
+		// The apiserver is down; carry on so that the cached state may be applied.
+		status.Error(err)
+	}
+
+	if cfg.RouterIDPool != nil {
+		if _, err := allocateRouterID(ctx, clientset, cfg.LeaseNamespace, nodeName, cfg.RouterIDPool); err != nil {
+			if cfg.StateCache == nil || errcode.Of(err) != errcode.APIServerUnreachable {
+				log.Fatalln("failed to allocate router-id:", err)
+			}
+
+			// A router-id allocated previously is read from the Node once the apiserver is back.
+			status.Error(err)
+		}
 	}
 
 	nodeWatcher, err := nodes.NewWatcher(ctx, clientset)
@@ -86,57 +578,1203 @@ func main() {
 		log.Fatalln("failed to create node watcher:", err)
 	}
 
-	// Run once to begin
-	if err := export(nodeName, cfg, nodeWatcher.Nodes()); err != nil {
-		log.Fatalln("failed to export config:", err)
+	podList := func() []v1.Pod { return nil }
+	podsSynced := func() bool { return true }
+	podsListed := func() time.Time { return time.Time{} }
+	var podChanges <-chan struct{}
+
+	if cfg.watchesPods() {
+		podWatcher, err := pods.NewWatcher(ctx, clientset, nodeName)
+		if err != nil {
+			log.Fatalln("failed to create pod watcher:", err)
+		}
+
+		podList = podWatcher.Pods
+		podsSynced = podWatcher.Synced
+		podsListed = podWatcher.Listed
+		podChanges = podWatcher.Changes()
 	}
 
-	// Notify gobgp of updated config.
-	// Because we cannot guarantee gobgp is up yet, this command should be allowed to fail.
-	notify(outputFile) // nolint: errcheck
+	// queues return the number of objects whose changes await processing by
+	// each watcher with a keyed queue of changes, for the guardrails.
+	var queues []func() int
 
-	for ctx.Err() == nil {
-		<-nodeWatcher.Changes()
+	serviceList := func() []v1.Service { return nil }
+	servicesSynced := func() bool { return true }
+	var serviceChanges <-chan struct{}
+
+	if cfg.Services != nil {
+		serviceWatcher, err := services.NewWatcher(ctx, clientset)
+		if err != nil {
+			log.Fatalln("failed to create service watcher:", err)
+		}
+
+		serviceList = serviceWatcher.Services
+		servicesSynced = serviceWatcher.Synced
+		serviceChanges = serviceWatcher.Changes()
+		queues = append(queues, serviceWatcher.Pending)
+	}
+
+	endpointsList := func() []v1.Endpoints { return nil }
+	endpointsSynced := func() bool { return true }
+	var endpointsChanges <-chan struct{}
+
+	if cfg.Services != nil && cfg.LinkBandwidth != nil {
+		endpointsWatcher, err := services.NewEndpointsWatcher(ctx, clientset)
+		if err != nil {
+			log.Fatalln("failed to create endpoints watcher:", err)
+		}
+
+		endpointsList = func() []v1.Endpoints { return serviceEndpoints(serviceList(), endpointsWatcher.Endpoints()) }
+		endpointsSynced = endpointsWatcher.Synced
+		endpointsChanges = endpointsWatcher.Changes()
+		queues = append(queues, endpointsWatcher.Pending)
+	}
+
+	announcedList := func() map[string]string { return nil }
+	var announceChanges <-chan struct{}
+
+	if cfg.AnnounceAPI != nil {
+		announcer := newAnnounceServer(cfg.AnnounceAPI, clientset)
+		go func() {
+			if err := announcer.Serve(ctx); err != nil {
+				log.Fatalln("failed to serve announcement API:", err)
+			}
+		}()
+
+		announcedList = announcer.Announced
+		announceChanges = announcer.Changes()
+	}
+
+	poolList := func() []string { return nil }
+	var poolChanges <-chan struct{}
+
+	if cfg.IPAM != nil {
+		source, err := cfg.IPAM.source()
+		if err != nil {
+			log.Fatalln("failed to create IPAM source:", err)
+		}
+
+		syncer := ipam.NewSyncer(ctx, source, cfg.IPAM.interval())
+
+		poolList = syncer.Pools
+		poolChanges = syncer.Changes()
+	}
+
+	egressList := func() []v1alpha1.EgressIP { return nil }
+	egressSynced := func() bool { return true }
+	egressListed := func() time.Time { return time.Time{} }
+	var egressChanges <-chan struct{}
+
+	if cfg.EgressIPs != nil {
+		egressWatcher, err := egress.NewWatcher(ctx, dynamicClient)
+		if err != nil {
+			log.Fatalln("failed to create egress IP watcher:", err)
+		}
+
+		egressList = egressWatcher.EgressIPs
+		egressSynced = egressWatcher.Synced
+		egressListed = egressWatcher.Listed
+		egressChanges = egressWatcher.Changes()
+	}
+
+	nodeGroupList := func() []v1alpha1.BGPNodeGroup { return nil }
+	nodeGroupsSynced := func() bool { return true }
+	var nodeGroupChanges <-chan struct{}
+
+	if cfg.NodeGroups {
+		nodeGroupWatcher, err := nodegroups.NewWatcher(ctx, dynamicClient)
+		if err != nil {
+			log.Fatalln("failed to create node group watcher:", err)
+		}
+
+		nodeGroupList = nodeGroupWatcher.Groups
+		nodeGroupsSynced = nodeGroupWatcher.Synced
+		nodeGroupChanges = nodeGroupWatcher.Changes()
+	}
+
+	tenantList := func() []v1alpha1.BGPTenant { return nil }
+	tenantsSynced := func() bool { return true }
+	var tenantChanges <-chan struct{}
+
+	if cfg.Tenants {
+		tenantWatcher, err := tenants.NewWatcher(ctx, dynamicClient)
+		if err != nil {
+			log.Fatalln("failed to create tenant watcher:", err)
+		}
+
+		tenantList = tenantWatcher.Tenants
+		tenantsSynced = tenantWatcher.Synced
+		tenantChanges = tenantWatcher.Changes()
+	}
+
+	maintenanceList := func() []v1alpha1.BGPRouterMaintenance { return nil }
+	maintenancesSynced := func() bool { return true }
+	var maintenanceChanges <-chan struct{}
+
+	if cfg.RouterMaintenance {
+		maintenanceWatcher, err := maintenance.NewWatcher(ctx, dynamicClient)
+		if err != nil {
+			log.Fatalln("failed to create router maintenance watcher:", err)
+		}
+
+		maintenanceList = maintenanceWatcher.Maintenances
+		maintenancesSynced = maintenanceWatcher.Synced
+		maintenanceChanges = maintenanceWatcher.Changes()
+	}
+
+	// The elector is created once observe exists, since it campaigns according to the observed state.
+	reflectors := func() map[string][]string { return nil }
+	var reflectorChanges <-chan struct{}
+
+	// synced indicates whether the state of the cluster has been obtained from the API
+	synced := func() bool {
+		return nodeWatcher.Synced() && podsSynced() && servicesSynced() && endpointsSynced() && egressSynced() && nodeGroupsSynced() && tenantsSynced() && maintenancesSynced()
+	}
+
+	// cached is the cached state of the cluster, which stands in for the
+	// observed state until it has been obtained from the API
+	var cached *clusterState
+
+	if cfg.StateCache != nil {
+		if cfg.StateCache.ThresholdSeconds != 0 {
+			log.Println("warning: stateCache.thresholdSeconds is ignored; the cached state is applied at startup")
+		}
+
+		state, observed, err := cfg.StateCache.load()
+		if err != nil {
+			status.Error(err)
+		} else if state != nil {
+			log.Printf("using the state cached at %s until synced with the apiserver", observed.Format(time.RFC3339))
+			cached = state
+		}
+	}
+
+	// observe returns the current observed state of the cluster, or the
+	// cached state if no state has yet been obtained from the API
+	recorder := events.NewRecorder(clientset, nodeName)
+
+	tunnels := newTunnelMonitor(recorder)
+	links := newLinkMonitor(recorder)
+	prober := newRouterProber(cfg.RouterProbe, recorder)
+
+	observe := func() *clusterState {
+		if cached != nil && !synced() {
+			// Announcements, pools, tunnels, and links are not from the apiserver, so they are never stale.
+			state := *cached
+			state.Announced = announcedList()
+			state.Pools = poolList()
+			state.TunnelsDown = tunnels.Down()
+			state.LinksDown = links.Down()
+			state.RoutersReachable = prober.Reachable()
+			return &state
+		}
+
+		return &clusterState{
+			Nodes:              nodeWatcher.Nodes(),
+			Pods:               podList(),
+			Services:           serviceList(),
+			Endpoints:          endpointsList(),
+			EgressIPs:          egressList(),
+			NodeGroups:         validNodeGroups(nodeGroupList()),
+			Tenants:            validTenants(tenantList()),
+			RouterMaintenances: validRouterMaintenances(cfg, maintenanceList()),
+			Reflectors:         reflectors(),
+			Announced:          announcedList(),
+			Pools:              poolList(),
+			TunnelsDown:        tunnels.Down(),
+			LinksDown:          links.Down(),
+			RoutersReachable:   prober.Reachable(),
+			PodsListed:         podsListed(),
+			EgressIPsListed:    egressListed(),
+		}
+	}
+
+	if cfg.NodeGroups {
+		elector, err := election.NewElector(ctx, clientset, cfg.LeaseNamespace, nodeName, func() (string, int) {
+			return reflectorCandidacy(nodeName, observe())
+		})
+		if err != nil {
+			log.Fatalln("failed to create reflector elector:", err)
+		}
+
+		reflectors = elector.Elected
+		reflectorChanges = elector.Changes()
+	}
+
+	conditions := newConditionMonitor(cfg.NodeConditions, recorder)
+
+	hazards := newHazardMonitor(recorder)
+
+	bootstrap := newBootstrapGate(cfg.Bootstrap, clientset, recorder)
+
+	freeze := newFreezeGate(cfg.FreezeNamespace, clientset, recorder)
+
+	holdDown := newHoldDownGate(recorder)
+
+	guardrails := newGuardrailMonitor(cfg.Guardrails, recorder, queues...)
+	go guardrails.run(ctx)
+
+	var dns *dnsPublisher
+	if cfg.DNS != nil {
+		dns = newDNSPublisher(cfg.DNS, dynamicClient, nodeName)
+	}
+
+	var nodeStatus *nodeStatusWriter
+	if cfg.NodeStatus {
+		nodeStatus = newNodeStatusWriter(dynamicClient, nodeName)
+	}
+
+	if cfg.EmbeddedBGP {
+		if err := gobgp.StartEmbedded(ctx, cfg.GoBGPAPIAddress); err != nil {
+			log.Fatalln("failed to start the embedded gobgp:", err)
+		}
+	}
+
+	gobgpClient := gobgp.New(cfg.GoBGPAPIAddress)
+
+	vrfs := newVRFProvisioner(gobgpClient)
+
+	ribs := newRIBVerifier(gobgpClient)
+
+	var takeoverClient *gobgp.Client
+	if cfg.DeferTakeoverSeconds > 0 && cfg.hasOutput(speakerGoBGPD) {
+		takeoverClient = gobgpClient
+	}
+	takeover := newTakeoverGate(recorder, takeoverClient, time.Duration(cfg.DeferTakeoverSeconds)*time.Second)
+
+	// The desired routes are applied to each speaker in use.
+	outputs := new(speakerOutputs)
+
+	addGoBGPD := func() {
+		outputs.gobgpd = gobgpClient
+		outputs.gobgpAdvertiser = routes.NewAdvertiser(gobgpClient)
+		outputs.advertisers = append(outputs.advertisers, outputs.gobgpAdvertiser)
+		outputs.names = append(outputs.names, speakerGoBGPD)
+	}
+
+	// addBuiltin starts the built-in speaker.  If restarting, it takes over
+	// the sessions of the previous speaker of this Node.
+	addBuiltin := func(restarting bool) error {
+		b, err := newBuiltinSpeaker(ctx, clientset, nodeName, cfg, restarting)
+		if err != nil {
+			return err
+		}
+
+		outputs.builtin = b
+		outputs.advertisers = append(outputs.advertisers, routes.NewAdvertiser(b))
+		outputs.names = append(outputs.names, speakerBuiltin)
+
+		return nil
+	}
+
+	if cfg.hasOutput(speakerGoBGPD) {
+		addGoBGPD()
+	}
+
+	if cfg.hasOutput(speakerBuiltin) {
+		if err := addBuiltin(false); err != nil {
+			log.Fatalln("failed to create built-in speaker:", err)
+		}
+	}
+
+	notifier := chaosNotifier(newNotifier(cfg))
+
+	// A gobgpd which cannot be notified is reported at once, rather than
+	// only once its configuration first changes.
+	if cfg.notifiesGoBGPD() {
+		if err := notifier.Check(); err != nil {
+			status.Error(err)
+		}
+	}
+
+	gobgpdSessions := newGoBGPDSessionApplier(gobgpClient)
+
+	// applySessions applies the desired BGP sessions of the given reconcile
+	// to the speakers, returning whether they could not all be applied
+	// through the gobgpd API, in which case they are retried along with the
+	// routes.
+	applySessions := func(ctx context.Context, r *reconcileRun) (failed bool) {
+		if outputs.builtin != nil {
+			outputs.builtin.SetNeighbors(builtinNeighbors(cfg, r.routers))
+		}
+
+		if !cfg.hasOutput(speakerGoBGPD) {
+			return false
+		}
+
+		if cfg.GoBGPDConfig == gobgpdConfigAPI {
+			c, err := gobgpdConfiguration(nodeName, cfg, r.state, r.peers, r.routers)
+			if err == nil {
+				err = gobgpdSessions.Apply(ctx, c)
+			}
+			if err != nil {
+				status.Error(err)
+				return true
+			}
+
+			return false
+		}
+
+		if err := export(outputFile, nodeName, cfg, r.state, r.peers, r.routers); err != nil {
+			status.Error(err)
+			return false
+		}
+
+		chaosCorruptRender(outputFile)
+
+		if err := notifier.Notify(outputFile); err != nil {
+			status.Error(err)
+		}
+
+		return false
+	}
+
+	var restarts <-chan struct{}
+
+	// monitorGoBGPD starts the monitoring of gobgpd, once it is in use
+	monitorGoBGPD := func() {
+		if restarts != nil {
+			return
+		}
+
+		restarts = watchGoBGPDRestarts(ctx)
+
+		go pollGoBGPDSessions(ctx, gobgpClient)
+
+		if cfg.Mesh == meshEnabled {
+			mesh := &meshMonitor{
+				client:   gobgpClient,
+				recorder: recorder,
+				expected: func() []Peer {
+					return peers(nodeName, cfg, observe())
+				},
+			}
+			go mesh.run(ctx)
+
+			if cfg.PeerProbe != nil && cfg.PeerProbe.Enabled {
+				go newPeerProber(cfg.PeerProbe, cfg.StatusAddress, gobgpClient, recorder, mesh.expected).run(ctx)
+			}
+		}
+	}
+
+	if cfg.hasOutput(speakerGoBGPD) {
+		monitorGoBGPD()
+	}
+
+	// swapOutputs changes the speaker and outputs to those of the given
+	// configuration at runtime.  The new speaker is started before the old
+	// is stopped, and neither withdraws its routes, so that with graceful
+	// restart the Routers keep forwarding throughout.
+	swapOutputs := func(next *KubeBGPConfig) {
+		if !swappableConfig(cfg, next) {
+			log.Println("configuration changed; changes other than speaker, outputs, and gracefulRestartSeconds require a restart")
+		}
+
+		addingGoBGPD := next.hasOutput(speakerGoBGPD) && !cfg.hasOutput(speakerGoBGPD)
+		removingGoBGPD := !next.hasOutput(speakerGoBGPD) && cfg.hasOutput(speakerGoBGPD)
+		addingBuiltin := next.hasOutput(speakerBuiltin) && !cfg.hasOutput(speakerBuiltin)
+		removingBuiltin := !next.hasOutput(speakerBuiltin) && cfg.hasOutput(speakerBuiltin)
+
+		cfg.Speaker = next.Speaker
+		cfg.Outputs = next.Outputs
+		cfg.GracefulRestartSeconds = next.GracefulRestartSeconds
+
+		if addingBuiltin {
+			logOutputSwap(cfg, speakerBuiltin, true)
+			if err := addBuiltin(cfg.GracefulRestartSeconds > 0); err != nil {
+				status.Error(err)
+			}
+		}
+
+		if addingGoBGPD {
+			logOutputSwap(cfg, speakerGoBGPD, true)
+			addGoBGPD()
+			monitorGoBGPD()
+		}
 
-		if err := export(nodeName, cfg, nodeWatcher.Nodes()); err != nil {
-			log.Fatalln("failed to export config:", err)
+		if removingBuiltin && outputs.builtin != nil {
+			logOutputSwap(cfg, speakerBuiltin, false)
+			outputs.builtin.Handoff()
+			outputs.builtin = nil
+			outputs.remove(speakerBuiltin)
 		}
 
-		if err := notify(outputFile); err != nil {
-			log.Println("failed to notify gobgp of updated config:", err)
+		if removingGoBGPD {
+			// gobgpd keeps its routes until it is stopped, at which point the
+			// Routers hold them under its own graceful restart.
+			logOutputSwap(cfg, speakerGoBGPD, false)
+			outputs.gobgpd = nil
+			outputs.gobgpAdvertiser = nil
+			outputs.remove(speakerGoBGPD)
 		}
 	}
+
+	configChanges := watchConfig(ctx, configFile)
+
+	// The changes made by each reconcile are logged at the debug level,
+	// along with the event which triggered them.
+	trigger := "startup"
+
+	// The ages of the last apply and of the oldest change not yet applied are published for alerting.
+	applies := new(applyTracker)
+
+	// A reconcile runs as a pipeline of stages: the cluster state is
+	// observed, the desired sessions and routes derived from it, compared
+	// with those last applied (and with those of the owner, by a standby),
+	// applied, and what was applied is then published.
+	desire := &desireStage{
+		cfg:        cfg,
+		nodeName:   nodeName,
+		conditions: conditions,
+		bootstrap:  bootstrap,
+		holdDown:   holdDown,
+		takeover:   takeover,
+		freeze:     freeze,
+	}
+
+	diff := &diffStage{
+		cfg:       cfg,
+		nodeName:  nodeName,
+		diffs:     newReconcileDiffer(cfg),
+		applies:   applies,
+		ownership: handover,
+		outputs:   outputs,
+		vrfs:      vrfs,
+		serve: func(ctx context.Context) {
+			go serveStatusWhenFree(ctx, cfg.StatusAddress, cfg.GoBGPAPIAddress)
+			go serveAdmin(ctx, cfg, refreshRequests, dynamicClient)
+		},
+	}
+
+	// Routes are re-applied with backoff until gobgpd accepts them, since it
+	// may not be up yet (or may be restarting).
+	apply := &applyStage{
+		cfg:           cfg,
+		nodeName:      nodeName,
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		recorder:      recorder,
+		synced:        synced,
+		dns:           dns,
+		vrfs:          vrfs,
+		outputs:       outputs,
+		sessions:      applySessions,
+		backoff:       minReapplyBackoff,
+	}
+
+	pipeline := newReconcilePipeline(
+		&observeStage{
+			cfg:       cfg,
+			observe:   observe,
+			synced:    synced,
+			hazards:   hazards,
+			ownership: handover,
+		},
+		desire,
+		diff,
+		apply,
+		&verifyStage{
+			cfg:        cfg,
+			synced:     synced,
+			applies:    applies,
+			ribs:       ribs,
+			nodeStatus: nodeStatus,
+			outputs:    outputs,
+		},
+	)
+
+	// advertise re-advertises the routes for the current state of the
+	// cluster, without reconfiguring the BGP sessions
+	advertise := func() {
+		pipeline.Run(ctx, &reconcileRun{trigger: trigger}) // nolint: errcheck
+	}
+
+	// reconcile reconfigures the BGP sessions and re-advertises the routes
+	// for the current state of the cluster.  The errors of each stage are
+	// reported as they are encountered.
+	reconcile := func() {
+		pipeline.Run(ctx, &reconcileRun{trigger: trigger, sessions: true}) // nolint: errcheck
+	}
+
+	// Routes of dynamic objects are re-evaluated periodically, so that they
+	// expire even if nothing else happens.
+	var expiryCheck <-chan time.Time
+
+	if expiry := cfg.routeExpiry(); expiry > 0 {
+		expiryCheck = time.NewTicker(expiry / 4).C
+	}
+
+	// Until the cluster has bootstrapped, the conditions are re-checked periodically.
+	var bootstrapCheck <-chan time.Time
+
+	if !bootstrap.open {
+		bootstrapCheck = time.NewTicker(bootstrapCheckInterval).C
+	}
+
+	// The tunnels of Routers are checked periodically.
+	var tunnelCheck <-chan time.Time
+
+	if cfg.hasTunnels() {
+		state := observe()
+		state.TunnelsDown = nil
+		tunnels.Check(ctx, localRouters(nodeName, cfg, state))
+		tunnelCheck = time.NewTicker(tunnelCheckInterval).C
+	}
+
+	// The links of Routers are checked whenever netlink notifies a change
+	// of any interface, and periodically in case a notification is lost.
+	var linkChanges <-chan struct{}
+	var linkCheck <-chan time.Time
+
+	if cfg.hasLinks() {
+		linkChanges = watchLinks(ctx)
+
+		state := observe()
+		state.LinksDown = nil
+		links.Check(localRouters(nodeName, cfg, state))
+		linkCheck = time.NewTicker(linkCheckInterval).C
+	}
+
+	// The Routers are probed periodically, in the background, and once before
+	// the first reconcile so that the reachable ones are peered with at once.
+	var routerProbeCheck <-chan time.Time
+
+	if prober.enabled() {
+		state := observe()
+		state.RoutersReachable = nil
+		prober.Probe(ctx, localRouters(nodeName, cfg, state))
+		routerProbeCheck = time.NewTicker(prober.interval()).C
+	}
+
+	// The freeze annotation is checked periodically.
+	var freezeCheck <-chan time.Time
+
+	if cfg.FreezeNamespace != "" {
+		freeze.Check(ctx)
+		freezeCheck = time.NewTicker(freezeCheckInterval).C
+	}
+
+	// Run once to begin.
+	// Because we cannot guarantee gobgp is up yet, this is allowed to fail.
+	reconcile()
+
+	for ctx.Err() == nil {
+		debugPhase("idle")
+
+		select {
+		case <-nodeWatcher.Changes():
+			trigger = "node"
+		case <-podChanges:
+			trigger = "pod"
+		case <-serviceChanges:
+			trigger = "service"
+		case <-endpointsChanges:
+			trigger = "endpoints"
+		case <-egressChanges:
+			trigger = "egressip"
+		case <-nodeGroupChanges:
+			trigger = "nodegroup"
+		case <-tenantChanges:
+			trigger = "tenant"
+		case <-maintenanceChanges:
+			trigger = "maintenance"
+		case <-reflectorChanges:
+			trigger = "reflector"
+		case <-announceChanges:
+			trigger = "announce"
+			advertise()
+			continue
+		case <-poolChanges:
+			trigger = "ipam"
+			advertise()
+			continue
+		case <-apply.retry:
+			trigger = "retry"
+		case <-restarts:
+			trigger = "gobgpd-restart"
+			if outputs.gobgpAdvertiser == nil {
+				continue
+			}
+
+			// gobgpd has lost all the state we gave it, so re-apply everything immediately
+			log.Println("gobgpd restart detected; re-applying desired state")
+			outputs.gobgpAdvertiser.Reset()
+			vrfs.Reset()
+			apply.backoff = minReapplyBackoff
+
+			// The API which it serves is detected afresh, in case gobgpd was upgraded.
+			gobgpClient.Close() // nolint: errcheck
+		case next := <-configChanges:
+			trigger = "config"
+			swapOutputs(next)
+		case <-expiryCheck:
+			trigger = "expiry"
+			advertise()
+			continue
+		case <-desire.holdDownRelease:
+			trigger = "hold-down"
+			advertise()
+			continue
+		case <-desire.scheduleChange:
+			trigger = "schedule"
+			advertise()
+			continue
+		case <-desire.takeoverCheck:
+			trigger = "takeover"
+			advertise()
+			continue
+		case <-diff.handoverCheck:
+			trigger = "handover"
+			advertise()
+			continue
+		case <-handover.Released():
+			// gobgpd keeps the routes, which the new owner has taken over.
+			log.Println("handed this node over to another instance; exiting")
+			return
+		case <-tunnelCheck:
+			trigger = "tunnel"
+			// Every Router which this Node would peer with is checked, whether or not its session is held down.
+			state := observe()
+			state.TunnelsDown = nil
+			if !tunnels.Check(ctx, localRouters(nodeName, cfg, state)) {
+				continue
+			}
+		case <-linkChanges:
+			trigger = "link"
+			// Every Router which this Node would peer with is checked, whether or not its session is dropped.
+			state := observe()
+			state.LinksDown = nil
+			if !links.Check(localRouters(nodeName, cfg, state)) {
+				continue
+			}
+		case <-linkCheck:
+			trigger = "link"
+			state := observe()
+			state.LinksDown = nil
+			if !links.Check(localRouters(nodeName, cfg, state)) {
+				continue
+			}
+		case <-routerProbeCheck:
+			// Every Router which this Node would peer with is probed, whether or not it has passed.
+			state := observe()
+			state.RoutersReachable = nil
+			go prober.Probe(ctx, localRouters(nodeName, cfg, state))
+			continue
+		case <-prober.Changes():
+			trigger = "router probe"
+		case <-freezeCheck:
+			trigger = "freeze"
+			if freeze.Check(ctx) {
+				advertise()
+			}
+			continue
+		case <-bootstrapCheck:
+			trigger = "bootstrap"
+			advertise()
+			if bootstrap.open {
+				bootstrapCheck = nil
+			}
+			continue
+		case req := <-refreshRequests:
+			req.result <- refresh(ctx, req, outputs.gobgpd, outputs.builtin, outputs.advertisers)
+			continue
+		}
+
+		reconcile()
+	}
+}
+
+// kubeConfig returns the configuration of the kubernetes clients, from the
+// ServiceAccount of the Pod.  This is the only place from which it is
+// acquired; the clients created from it are passed to whatever uses them.
+func kubeConfig() (*rest.Config, error) {
+	kubeconfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to acquire kubernetes config")
+	}
+
+	kubeconfig.WrapTransport = chaosTransport
+
+	return kubeconfig, nil
+}
+
+func newClientset(kubeconfig *rest.Config) (kubernetes.Interface, error) {
+	clientset, err := kubernetes.NewForConfig(kubeconfig)
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to create the kubernetes clientset")
+	}
+
+	return clientset, nil
+}
+
+func newDynamicClient(kubeconfig *rest.Config) (dynamic.Interface, error) {
+	client, err := dynamic.NewForConfig(kubeconfig)
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to create the kubernetes dynamic client")
+	}
+
+	return client, nil
 }
 
 func loadConfig(filename string) (*KubeBGPConfig, error) {
-	f, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, eris.Wrapf(err, "failed to open config file %s", filename)
+		return nil, errcode.Wrapf(err, errcode.ConfigInvalid, "failed to open config file %s", filename)
 	}
 
-	cfg := new(KubeBGPConfig)
-	if err := yaml.NewDecoder(f).Decode(cfg); err != nil {
-		return nil, eris.Wrap(err, "failed to decode config file")
+	cfg := &KubeBGPConfig{
+		StatusAddress:   defaultStatusAddress,
+		AdminSocket:     defaultAdminSocket,
+		GoBGPAPIAddress: defaultGoBGPAPIAddress,
+		GoBGPDConfig:    gobgpdConfigFile,
+		LeaseNamespace:  defaultLeaseNamespace,
+		Mesh:            meshEnabled,
+		Speaker:         speakerGoBGPD,
+	}
+	if err := applyProfile(cfg, data); err != nil {
+		return nil, errcode.Wrap(err, errcode.ConfigInvalid, "invalid profile")
+	}
+	// Unknown keys are refused, so that a misspelt setting is not silently ignored.
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, errcode.Wrap(err, errcode.ConfigInvalid, "failed to decode config file")
+	}
+
+	if embeddedBGPFlag {
+		cfg.EmbeddedBGP = true
 	}
 
+	if err := cfg.validate(); err != nil {
+		return nil, errcode.Wrap(err, errcode.ConfigInvalid, "invalid configuration")
+	}
+
+	cfg.logUnsupportedFeatures()
+
 	return cfg, nil
 }
 
-var configTemplateString = `
-[global.config]
-router-id = {{ .RouterID }}
-as = {{ .ASN }}
+func (c *KubeBGPConfig) validate() error {
+	if err := c.FeatureGates.Validate(); err != nil {
+		return eris.Wrap(err, "invalid featureGates")
+	}
+
+	switch c.Speaker {
+	case speakerGoBGPD, speakerBuiltin:
+	default:
+		return eris.Errorf("invalid speaker %q", c.Speaker)
+	}
+
+	if c.hasOutput(speakerBuiltin) && !c.FeatureGates.Enabled(speaker.Gate) {
+		return eris.Errorf("the built-in speaker requires the %s feature gate", speaker.Gate.Name)
+	}
+
+	if c.ClusterCommunity != "" {
+		if _, err := routes.ParseCommunity(c.ClusterCommunity); err != nil {
+			return eris.Wrap(err, "invalid clusterCommunity")
+		}
+	}
+
+	for key, lc := range c.LabelCommunityMap {
+		if err := lc.validate(); err != nil {
+			return eris.Wrapf(err, "invalid labelCommunityMap entry %s", key)
+		}
+	}
+
+	if c.RouterIDPool != nil {
+		if c.RouterID != "" {
+			return eris.New("routerIDPool may not be combined with routerID")
+		}
+
+		if err := c.RouterIDPool.validate(); err != nil {
+			return eris.Wrap(err, "invalid routerIDPool")
+		}
+	}
+
+	if c.PeerDownHoldSeconds < 0 || c.PeerDownHoldSeconds > maxGracefulRestartSeconds {
+		return eris.Errorf("invalid peerDownHoldSeconds %d: must be between 0 and %d", c.PeerDownHoldSeconds, maxGracefulRestartSeconds)
+	}
+
+	if c.DeferTakeoverSeconds < 0 {
+		return eris.Errorf("invalid deferTakeoverSeconds %d", c.DeferTakeoverSeconds)
+	}
+
+	if c.PeerHistorySize < 0 {
+		return eris.Errorf("invalid peerHistorySize %d", c.PeerHistorySize)
+	}
+
+	if err := c.validateOutputs(); err != nil {
+		return err
+	}
+
+	switch c.GoBGPDConfig {
+	case gobgpdConfigFile, gobgpdConfigAPI:
+	default:
+		return eris.Errorf("invalid gobgpdConfig %q", c.GoBGPDConfig)
+	}
+
+	if c.EmbeddedBGP {
+		switch {
+		case !gobgp.EmbeddedSupported:
+			return eris.New("embeddedBGP requires a build with the embedded tag")
+		case !c.FeatureGates.Enabled(speaker.Gate):
+			return eris.Errorf("embeddedBGP requires the %s feature gate", speaker.Gate.Name)
+		case !c.hasOutput(speakerGoBGPD) || c.GoBGPDConfig != gobgpdConfigAPI:
+			return eris.Errorf("embeddedBGP requires the %s speaker (or output) with gobgpdConfig %q", speakerGoBGPD, gobgpdConfigAPI)
+		}
+	}
+
+	if len(c.ReloadCommand) == 0 && !signalSupported && c.notifiesGoBGPD() {
+		return eris.New("reloadCommand is required to notify gobgpd on this platform")
+	}
+
+	if err := c.validateGracefulRestart(); err != nil {
+		return err
+	}
+
+	if c.Guardrails != nil {
+		if err := c.Guardrails.validate(); err != nil {
+			return eris.Wrap(err, "invalid guardrails")
+		}
+	}
+
+	switch c.LogLevel {
+	case "", logLevelInfo, logLevelDebug:
+	default:
+		return eris.Errorf("invalid logLevel %q", c.LogLevel)
+	}
+
+	switch c.Mesh {
+	case meshEnabled, meshDisabled:
+	default:
+		return eris.Errorf("invalid mesh %q", c.Mesh)
+	}
+
+	if c.PeerProbe != nil {
+		if err := c.PeerProbe.validate(); err != nil {
+			return eris.Wrap(err, "invalid peerProbe")
+		}
+	}
+
+	if c.NodeConditions != nil {
+		if err := c.NodeConditions.validate(); err != nil {
+			return eris.Wrap(err, "invalid nodeConditions")
+		}
+	}
+
+	if c.ASN != "" {
+		if _, err := parseASN(c.ASN); err != nil {
+			return err
+		}
+	}
+
+	refs := make(map[string]bool)
+
+	for _, r := range c.Routers {
+		for _, ref := range []string{r.Name, r.Address} {
+			if ref == "" {
+				continue
+			}
+
+			if refs[ref] {
+				return eris.Errorf("duplicate router name or address %s", ref)
+			}
+			refs[ref] = true
+		}
+
+		if r.ASN != "" {
+			if _, err := parseASN(r.ASN); err != nil {
+				return eris.Wrapf(err, "invalid ASN for router %s", r.Ref())
+			}
+		}
+
+		if r.Port < 0 || r.Port > 65535 {
+			return eris.Errorf("invalid port %d for router %s", r.Port, r.Ref())
+		}
+
+		if r.AdvertisementIntervalSeconds < 0 {
+			return eris.Errorf("invalid advertisementIntervalSeconds %d for router %s", r.AdvertisementIntervalSeconds, r.Ref())
+		}
+
+		if r.Tunnel != nil {
+			if err := r.Tunnel.validate(); err != nil {
+				return eris.Wrapf(err, "invalid tunnel for router %s", r.Ref())
+			}
+		}
+
+		if r.Link != nil {
+			if err := r.Link.validate(); err != nil {
+				return eris.Wrapf(err, "invalid link for router %s", r.Ref())
+			}
+		}
+
+		for _, p := range r.PeerNodes {
+			if _, err := path.Match(p, ""); err != nil {
+				return eris.Wrapf(err, "invalid peerNodes pattern %q for router %s", p, r.Ref())
+			}
+		}
+
+		if len(r.PeerNodeGroups) > 0 && !c.NodeGroups {
+			return eris.Errorf("router %s references node groups, but nodeGroups is not enabled", r.Ref())
+		}
+	}
+
+	if c.Pods != nil {
+		if err := c.Pods.validate(); err != nil {
+			return eris.Wrap(err, "invalid pods advertisement")
+		}
+	}
+
+	if c.PodCIDRs != nil {
+		if err := c.PodCIDRs.validate(); err != nil {
+			return eris.Wrap(err, "invalid podCIDRs advertisement")
+		}
+	}
+
+	if c.Services != nil {
+		if !c.FeatureGates.Enabled(services.Gate) {
+			return eris.Errorf("services requires the %s feature gate", services.Gate.Name)
+		}
+
+		if err := c.Services.validate(); err != nil {
+			return eris.Wrap(err, "invalid services advertisement")
+		}
+	}
+
+	if c.RouteExpirySeconds < 0 {
+		return eris.Errorf("invalid routeExpirySeconds %d", c.RouteExpirySeconds)
+	}
+
+	if c.RouteExpirySeconds > 0 && c.RouteExpirySeconds < 2*pods.MaximumCheckIntervalSeconds {
+		// Pods are only re-listed every MaximumCheckIntervalSeconds while nothing changes
+		return eris.Errorf("routeExpirySeconds must be at least %d", 2*pods.MaximumCheckIntervalSeconds)
+	}
+
+	if c.StateCache != nil {
+		if err := c.StateCache.validate(); err != nil {
+			return eris.Wrap(err, "invalid stateCache")
+		}
+	}
+
+	if c.EgressIPs != nil {
+		if err := c.EgressIPs.RouteAttributes.validate(); err != nil {
+			return eris.Wrap(err, "invalid egressIPs advertisement")
+		}
+	}
+
+	if c.AnnounceAPI != nil {
+		if err := c.AnnounceAPI.validate(); err != nil {
+			return eris.Wrap(err, "invalid announceAPI")
+		}
+	}
+
+	if c.IPAM != nil {
+		if err := c.IPAM.validate(); err != nil {
+			return eris.Wrap(err, "invalid ipam")
+		}
+	}
+
+	if c.DNS != nil {
+		if err := c.DNS.validate(); err != nil {
+			return eris.Wrap(err, "invalid dns")
+		}
+	}
+
+	if c.Bootstrap != nil {
+		if err := c.Bootstrap.validate(); err != nil {
+			return eris.Wrap(err, "invalid bootstrap")
+		}
+	}
+
+	if c.LinkBandwidth != nil {
+		if err := c.LinkBandwidth.validate(); err != nil {
+			return eris.Wrap(err, "invalid linkBandwidth")
+		}
+
+		if c.Services == nil {
+			return eris.New("linkBandwidth requires services")
+		}
+	}
+
+	if c.PeerSourceCIDR != "" {
+		if _, _, err := net.ParseCIDR(c.PeerSourceCIDR); err != nil {
+			return eris.Wrapf(err, "invalid peerSourceCIDR %q", c.PeerSourceCIDR)
+		}
+	}
+
+	if c.PeerAddress != nil {
+		if err := c.PeerAddress.validate(); err != nil {
+			return eris.Wrap(err, "invalid peerAddress")
+		}
+	}
+
+	if err := c.validateTopologies(); err != nil {
+		return err
+	}
+
+	if err := c.validateAuth(); err != nil {
+		return err
+	}
+
+	if _, err := c.unsupportedFeatures(); err != nil {
+		return err
+	}
+
+	for _, z := range c.ZoneSummaries {
+		if err := z.validate(); err != nil {
+			return eris.Wrapf(err, "invalid zone summary %s", z.Name)
+		}
+	}
+
+	for _, a := range c.Advertisements {
+		if err := a.validate(); err != nil {
+			return eris.Wrapf(err, "invalid advertisement %s", a.Name)
+		}
+
+		for _, ref := range a.Routers {
+			if c.router(ref) == nil {
+				return eris.Errorf("advertisement %s references unknown router %s", a.Name, ref)
+			}
+		}
+
+		if len(a.NodeGroups) > 0 && !c.NodeGroups {
+			return eris.Errorf("advertisement %s references node groups, but nodeGroups is not enabled", a.Name)
+		}
+
+		if a.Standby != nil && a.Standby.PrependCount > 0 && c.ASN == "" {
+			return eris.Errorf("advertisement %s prepends the AS path of its backups, but no asn is configured", a.Name)
+		}
+	}
+
+	return nil
+}
+
+// router returns the Router with the given name or address, or nil if there is none
+func (c *KubeBGPConfig) router(ref string) *Router {
+	for i := range c.Routers {
+		if c.Routers[i].Matches(ref) {
+			return &c.Routers[i]
+		}
+	}
+
+	return nil
+}
+
+// clusterState is the observed state of the cluster from which the desired BGP state is derived
+type clusterState struct {
+	// Nodes is the list of Nodes in the cluster
+	Nodes []v1.Node
+
+	// Pods is the list of Pods on this Node, if Pods are watched
+	Pods []v1.Pod
+
+	// Services is the list of LoadBalancer Services with ingress IPs, if Services are watched
+	Services []v1.Service
+
+	// Endpoints is the list of the Endpoints of the Services, if their
+	// routes are weighted by linkBandwidth
+	Endpoints []v1.Endpoints
+
+	// EgressIPs is the list of EgressIPs, if EgressIPs are watched
+	EgressIPs []v1alpha1.EgressIP
+
+	// NodeGroups is the list of valid BGPNodeGroups, if BGPNodeGroups are watched
+	NodeGroups []v1alpha1.BGPNodeGroup
+
+	// Tenants is the list of valid BGPTenants, if BGPTenants are watched
+	Tenants []v1alpha1.BGPTenant
+
+	// RouterMaintenances is the list of valid BGPRouterMaintenances, if BGPRouterMaintenances are watched
+	RouterMaintenances []v1alpha1.BGPRouterMaintenance
+
+	// Reflectors is the list of elected route reflector Nodes, by BGPNodeGroup name
+	Reflectors map[string][]string
+
+	// Announced is the set of IPs announced through the announcement API,
+	// with the ServiceAccount which announced each
+	Announced map[string]string
+
+	// Pools is the list of pools permitted by the IPAM, if the IPAM is
+	// synchronised, or nil if they have not yet been obtained
+	Pools []string
+
+	// TunnelsDown is the set of addresses of the Routers whose tunnels are
+	// dead, whose sessions are held down
+	TunnelsDown map[string]bool
+
+	// LinksDown is the set of addresses of the Routers whose links are
+	// down, whose sessions are dropped
+	LinksDown map[string]bool
+
+	// RoutersReachable is the set of addresses of the Routers which passed
+	// their last reachability probe, or nil if Routers are not probed
+	RoutersReachable map[string]bool
+
+	// PodsListed is the time at which the Pods were last listed
+	PodsListed time.Time
+
+	// EgressIPsListed is the time at which the EgressIPs were last listed
+	EgressIPsListed time.Time
+}
+
+// findNode returns the named Node from the list, or nil if it is not present
+func findNode(name string, nodeList []v1.Node) *v1.Node {
+	for i := range nodeList {
+		if nodeList[i].Name == name {
+			return &nodeList[i]
+		}
+	}
+
+	return nil
+}
+
+// localRouters returns the Routers with which the named Node should peer
+func localRouters(thisNode string, cfg *KubeBGPConfig, state *clusterState) (routers []Router) {
+	n := findNode(thisNode, state.Nodes)
+	if n == nil {
+		return nil
+	}
+
+	for _, ref := range routerRefs(n.Annotations) {
+		if cfg.router(ref) == nil {
+			status.Error(errcode.New(errcode.ConfigInvalid, "node "+n.Name+" references unknown router "+ref))
+		}
+	}
+
+	for _, r := range cfg.Routers {
+		if r.DryRun || state.TunnelsDown[r.Address] || state.LinksDown[r.Address] || unprobed(&r, state) || drainReason(&r, state) != "" {
+			continue
+		}
+
+		if r.PeersWith(n) || inNodeGroups(n, state.NodeGroups, r.PeerNodeGroups) {
+			routers = append(routers, r)
+		}
+	}
+
+	return routers
+}
+
+// unprobed indicates whether the given Router is probed but has not passed
+// its last probe, so that it is not yet added as a neighbor
+func unprobed(r *Router, state *clusterState) bool {
+	return state.RoutersReachable != nil && !state.RoutersReachable[r.Address]
+}
+
+// serveStatus serves the status and metrics endpoints on the given address,
+// describing the gobgp API served at the other
+func serveStatus(addr, gobgpAddress string) {
+	if err := http.ListenAndServe(addr, statusMux(gobgpAddress)); err != nil {
+		log.Println("failed to serve status endpoint:", err)
+	}
+}
 
-{{ if .IsReflector }}
-{{ for _, r := .Routers }}
-[[neighbors]]
-  [neighbors.config]
-    neighbor-address = "{{ r.Address }}"
-	 peer-as = {{ r.ASN }}
-{{ end }}
-{{ end }}
-`
+// statusMux returns the handler of the status endpoint, describing the gobgp
+// API served at the given address
+func statusMux(gobgpAddress string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/status", status.Handler())
+	mux.Handle("/status/peers", history.Handler())
+	mux.Handle("/version", versionHandler(gobgpAddress))
 
-func export(thisNode string, cfg *KubeBGPConfig, nodeList []v1.Node) error {
-	return eris.New("TODO: export unimplemented")
+	return mux
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "known keys",
+			config: "asn: \"64512\"\nrouters:\n  - address: 10.0.0.1\n    asn: \"64500\"\n    peerNodes: [\"*\"]\n",
+		},
+		{
+			name:    "misspelt top-level key",
+			config:  "asn: \"64512\"\nrouter:\n  - address: 10.0.0.1\n",
+			wantErr: "field router not found",
+		},
+		{
+			name:    "misspelt nested key",
+			config:  "asn: \"64512\"\nrouters:\n  - address: 10.0.0.1\n    asn: \"64500\"\n    peerNode: [\"*\"]\n",
+			wantErr: "field peerNode not found",
+		},
+		{
+			name:    "l2 advertisement",
+			config:  "asn: \"64512\"\nadvertisements:\n  - name: pool\n    prefixes: [\"192.0.2.0/28\"]\n    l2: true\n",
+			wantErr: "field l2 not found",
+		},
+		{
+			name:   "profile",
+			config: "profile: calico-style-mesh\nasn: \"64512\"\n",
+		},
+	} {
+		filename := filepath.Join(t.TempDir(), "kube-bgp.yaml")
+		if err := ioutil.WriteFile(filename, []byte(tt.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadConfig(filename)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
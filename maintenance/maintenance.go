@@ -0,0 +1,56 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	"k8s.io/client-go/dynamic"
+)
+
+// MaximumCheckIntervalSeconds is the maximum amount to time to wait before forcing an update check
+var MaximumCheckIntervalSeconds = 60
+
+// Watcher defines the interface for a BGPRouterMaintenance Watcher
+type Watcher interface {
+
+	// Changes waits for a change to the set of BGPRouterMaintenances to occur
+	Changes() <-chan struct{}
+
+	// Maintenances returns the current list of BGPRouterMaintenances
+	Maintenances() []v1alpha1.BGPRouterMaintenance
+
+	// Synced indicates whether the list of BGPRouterMaintenances has been obtained from the API at least once
+	Synced() bool
+
+	// Listed returns the time at which the list of BGPRouterMaintenances was last obtained from the API
+	Listed() time.Time
+
+	// Close shuts down the Watcher
+	Close()
+}
+
+type watcher struct {
+	*watches.Watcher
+}
+
+func (w *watcher) Maintenances() []v1alpha1.BGPRouterMaintenance {
+	objects := w.Objects()
+
+	list := make([]v1alpha1.BGPRouterMaintenance, len(objects))
+	for i, obj := range objects {
+		list[i] = *obj.(*v1alpha1.BGPRouterMaintenance)
+	}
+
+	return list
+}
+
+// NewWatcher returns a new BGPRouterMaintenance watcher which signals whenever the set of BGPRouterMaintenances changes
+func NewWatcher(ctx context.Context, client dynamic.Interface) (Watcher, error) {
+	source := watches.Dynamic(client, v1alpha1.BGPRouterMaintenanceResource, "router maintenance", time.Duration(MaximumCheckIntervalSeconds)*time.Second, func() interface{} {
+		return new(v1alpha1.BGPRouterMaintenance)
+	})
+
+	return &watcher{watches.NewWatcher(ctx, source)}, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// materializedPrefix is the prefix of the name of the ConfigMap of each Node
+	materializedPrefix = "kube-bgp-gobgpd-"
+
+	// materializedKey is the key of the gobgpd configuration within a ConfigMap
+	materializedKey = "gobgpd.conf"
+
+	// materializedNodeLabel is the label of a materialized ConfigMap which names its Node
+	materializedNodeLabel = "kube-bgp.cycoresystems.com/node"
+
+	// managedByLabel is the label of the objects which kube-bgp manages
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// runMaterialize implements the `materialize` command, which renders the
+// gobgpd configuration of every Node from the cluster state (including its
+// BGPNodeGroups, BGPTenants, and BGPRouterMaintenances) into a ConfigMap
+// per Node, so that Nodes which still run gobgpd from a file can adopt the
+// custom resources before they run kube-bgp
+func runMaterialize(args []string) int {
+	fs := flag.NewFlagSet("materialize", flag.ExitOnError)
+	config := fs.String("config", configFile, "kube-bgp configuration file")
+	namespace := fs.String("namespace", "kube-system", "namespace of the ConfigMaps")
+	interval := fs.Duration("interval", 0, "interval at which to materialize the ConfigMaps again; if zero, they are materialized once")
+	fs.Parse(args) // nolint: errcheck
+
+	cfg, err := loadConfig(*config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read configuration:", err)
+		return 1
+	}
+
+	kubeconfig, err := kubeConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	clientset, err := newClientset(kubeconfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	dynamicClient, err := newDynamicClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	for {
+		failed := false
+
+		state, err := listClusterState(ctx, cfg, clientset, dynamicClient)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to list the cluster state:", err)
+			failed = true
+		} else if errs := materialize(ctx, clientset, *namespace, cfg, state); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			failed = true
+		}
+
+		if *interval <= 0 {
+			if failed {
+				return 1
+			}
+			return 0
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+// materializedConfigMaps returns the ConfigMap of the gobgpd configuration
+// of each Node of the given state, along with the errors of the Nodes whose
+// configuration could not be rendered
+func materializedConfigMaps(namespace string, cfg *KubeBGPConfig, state *clusterState) (list []v1.ConfigMap, errs []error) {
+	for i := range state.Nodes {
+		name := state.Nodes[i].Name
+
+		c, err := gobgpdConfiguration(name, cfg, state, peers(name, cfg, state), localRouters(name, cfg, state))
+		if err != nil {
+			errs = append(errs, errcode.Wrapf(err, errcode.Of(err), "failed to render the configuration of node %s", name))
+			continue
+		}
+
+		// ConfigMaps are not secret, so passwords are never written to them.
+		var secret bool
+		for _, nb := range c.Neighbors {
+			if nb.Password != "" {
+				secret = true
+				break
+			}
+		}
+		if secret {
+			errs = append(errs, errcode.New(errcode.ConfigInvalid, "the configuration of node "+name+" has passwords, which cannot be materialized into a ConfigMap"))
+			continue
+		}
+
+		data, err := c.render()
+		if err != nil {
+			errs = append(errs, errcode.Wrapf(err, errcode.Of(err), "failed to render the configuration of node %s", name))
+			continue
+		}
+
+		list = append(list, v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      materializedPrefix + name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					managedByLabel:        "kube-bgp",
+					materializedNodeLabel: name,
+				},
+			},
+			Data: map[string]string{materializedKey: string(data)},
+		})
+	}
+
+	return list, errs
+}
+
+// materialize brings the materialized ConfigMaps of the given namespace in
+// line with the gobgpd configuration of each Node of the given state,
+// deleting those of Nodes which no longer exist.  The ConfigMap of a Node
+// whose configuration cannot be rendered is left as it is.
+func materialize(ctx context.Context, clientset kubernetes.Interface, namespace string, cfg *KubeBGPConfig, state *clusterState) []error {
+	desired, errs := materializedConfigMaps(namespace, cfg, state)
+
+	nodes := make(map[string]bool, len(state.Nodes))
+	for i := range state.Nodes {
+		nodes[state.Nodes[i].Name] = true
+	}
+
+	for i := range desired {
+		if err := applyConfigMap(ctx, clientset, &desired[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	existing, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: materializedNodeLabel + "," + managedByLabel + "=kube-bgp"})
+	if err != nil {
+		return append(errs, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list materialized configmaps"))
+	}
+
+	for _, cm := range existing.Items {
+		if nodes[cm.Labels[materializedNodeLabel]] {
+			continue
+		}
+
+		if err := clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to delete configmap %s", cm.Name))
+		}
+	}
+
+	return errs
+}
+
+// applyConfigMap creates the given ConfigMap, or updates it if its data has changed
+func applyConfigMap(ctx context.Context, clientset kubernetes.Interface, cm *v1.ConfigMap) error {
+	client := clientset.CoreV1().ConfigMaps(cm.Namespace)
+
+	current, err := client.Get(ctx, cm.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := client.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to create configmap %s", cm.Name)
+		}
+		return nil
+	}
+	if err != nil {
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get configmap %s", cm.Name)
+	}
+
+	if current.Data[materializedKey] == cm.Data[materializedKey] && current.Labels[materializedNodeLabel] == cm.Labels[materializedNodeLabel] {
+		return nil
+	}
+
+	current.Data = cm.Data
+	if current.Labels == nil {
+		current.Labels = make(map[string]string)
+	}
+	for k, v := range cm.Labels {
+		current.Labels[k] = v
+	}
+
+	if _, err := client.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to update configmap %s", cm.Name)
+	}
+
+	return nil
+}
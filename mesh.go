@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+const (
+	// meshEnabled is the Mesh mode in which every Node peers with every other Node over iBGP
+	meshEnabled = "enabled"
+
+	// meshDisabled is the Mesh mode in which there are no Node-to-Node sessions at all, for eBGP-only designs
+	meshDisabled = "disabled"
+)
+
+// meshCheckInterval is the interval at which the completeness of the iBGP mesh is checked
+var meshCheckInterval = 30 * time.Second
+
+// meshMonitor compares the expected iBGP sessions, derived from the Node
+// list, against those established in gobgpd, since partial meshes cause
+// hard-to-debug blackholes
+type meshMonitor struct {
+	client   *gobgp.Client
+	recorder events.Recorder
+
+	// expected returns the current list of expected iBGP peers
+	expected func() []Peer
+
+	// lastMissing is the description of the peers found missing by the previous check
+	lastMissing string
+}
+
+func (m *meshMonitor) run(ctx context.Context) {
+	for {
+		if err := m.check(ctx); err != nil {
+			status.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(meshCheckInterval):
+		}
+	}
+}
+
+func (m *meshMonitor) check(ctx context.Context) error {
+	neighbors, err := m.client.Neighbors(ctx)
+	if err != nil {
+		return err
+	}
+
+	established := make(map[string]bool)
+	for _, n := range neighbors {
+		if n.State == gobgp.SessionEstablished {
+			established[n.Address] = true
+		}
+	}
+
+	expected := m.expected()
+
+	var missing []string
+	for _, p := range expected {
+		if !established[p.Address] {
+			missing = append(missing, p.Name+" ("+p.Address+")")
+		}
+	}
+	sort.Strings(missing)
+
+	metrics.MeshPeersExpected.Set(float64(len(expected)))
+	metrics.MeshPeersEstablished.Set(float64(len(expected) - len(missing)))
+
+	if len(expected) == 0 {
+		metrics.MeshCompleteness.Set(1)
+	} else {
+		metrics.MeshCompleteness.Set(float64(len(expected)-len(missing)) / float64(len(expected)))
+	}
+
+	desc := strings.Join(missing, ", ")
+	if desc != m.lastMissing {
+		if desc == "" {
+			m.recorder.Normal("MeshComplete", "all %d iBGP peers are established", len(expected))
+		} else {
+			m.recorder.Warning("MeshIncomplete", "%d of %d iBGP peers are not established: %s", len(missing), len(expected), desc)
+		}
+	}
+	m.lastMissing = desc
+
+	return nil
+}
@@ -0,0 +1,289 @@
+// Package metrics defines the Prometheus metrics exported by kube-bgp
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "kube_bgp"
+
+// Errors counts the errors encountered by kube-bgp, by category
+var Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "errors_total",
+	Help:      "Number of errors encountered, by category",
+}, []string{"category"})
+
+func init() {
+	// Initialize every category so that each series exists from startup
+	for _, c := range errcode.Codes {
+		Errors.WithLabelValues(string(c))
+	}
+}
+
+// RouterReachable indicates, for each Router this Node peers with, whether
+// the last reachability probe succeeded (1) or failed (0)
+var RouterReachable = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "router_reachable",
+	Help:      "Whether the last reachability probe of the router succeeded",
+}, []string{"router"})
+
+// RouterTunnelUp indicates, for each Router reached through a tunnel,
+// whether the tunnel was alive when last checked (1) or dead (0)
+var RouterTunnelUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "router_tunnel_up",
+	Help:      "Whether the tunnel through which the router is reached is alive",
+}, []string{"router"})
+
+// RouterLinkUp indicates, for each Router attached by a declared link,
+// whether the link was up when last checked (1) or down (0)
+var RouterLinkUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "router_link_up",
+	Help:      "Whether the link by which the router is attached is up",
+}, []string{"router"})
+
+// MeshPeersExpected is the number of iBGP peers this Node is expected to have
+var MeshPeersExpected = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "mesh_peers_expected",
+	Help:      "Number of iBGP peers this node is expected to have",
+})
+
+// MeshPeersEstablished is the number of expected iBGP peers with which this Node has an established session
+var MeshPeersEstablished = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "mesh_peers_established",
+	Help:      "Number of expected iBGP peers with an established session",
+})
+
+// MeshCompleteness is the ratio of established to expected iBGP sessions
+var MeshCompleteness = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "mesh_completeness",
+	Help:      "Ratio of established to expected iBGP sessions (1 is a complete mesh)",
+})
+
+// PeerReachable indicates, for each iBGP peer, whether the last probe of
+// the dataplane path to it succeeded (1) or failed (0)
+var PeerReachable = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "peer_reachable",
+	Help:      "Whether the last dataplane probe of the iBGP peer succeeded",
+}, []string{"peer"})
+
+// PeerBlackholed indicates, for each iBGP peer, whether its session is
+// established while the last probe of the dataplane path to it failed, so
+// that the routes through it are blackholed (1) or not (0)
+var PeerBlackholed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "peer_blackholed",
+	Help:      "Whether the iBGP peer has an established session but failed its dataplane probe",
+}, []string{"peer"})
+
+// Info identifies the cluster and Node of this kube-bgp, so that alerts from
+// several clusters can be told apart.  Its value is always 1.
+var Info = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "info",
+	Help:      "Identity of this kube-bgp, by cluster and node",
+}, []string{"cluster", "node"})
+
+// FeatureEnabled indicates, for each feature gate, whether its feature is
+// enabled (1) or disabled (0)
+var FeatureEnabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "feature_enabled",
+	Help:      "Whether the feature of each feature gate is enabled",
+}, []string{"feature", "stage"})
+
+// RouteConflict identifies, as gathered by the dashboard, each Node which
+// claims exclusive origination of a prefix which another Node also claims.
+// Its value is always 1.
+var RouteConflict = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "route_conflict",
+	Help:      "Node claiming exclusive origination of a prefix also claimed by another node",
+}, []string{"prefix", "node"})
+
+// Frozen indicates whether the advertisements are frozen
+var Frozen = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "frozen",
+	Help:      "Whether the advertisements of this kube-bgp are frozen",
+})
+
+// ReconcileQueueDepth is the number of objects whose changes await
+// processing, across the keyed queues of changes
+var ReconcileQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "reconcile_queue_depth",
+	Help:      "Number of objects whose changes await processing",
+})
+
+// GuardrailExceeded indicates, for each monitored resource (`goroutines`,
+// `heap`, and `queue`), whether its guardrail threshold is exceeded
+var GuardrailExceeded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "guardrail_exceeded",
+	Help:      "Whether the resource usage of kube-bgp exceeds the guardrail threshold, by resource",
+}, []string{"resource"})
+
+// AppliedState identifies, by short hashes of the configuration and of the
+// desired state, what this Node last applied successfully, so that Nodes
+// which have diverged from the rest of the cluster can be told apart.  Its
+// value is always 1.
+var AppliedState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "applied_state",
+	Help:      "Short hashes of the configuration and desired state last applied by this node",
+}, []string{"config", "state"})
+
+// applyTimes is the time of the last successful apply of the desired state,
+// and that of the oldest change not yet applied, from which the ages are
+// computed when scraped
+var applyTimes = struct {
+	sync.Mutex
+	lastApplied     time.Time
+	oldestUnapplied time.Time
+}{lastApplied: time.Now()}
+
+// SetApplyTimes records the time of the last successful apply of the desired
+// state, if it has been, and that of the oldest change not yet applied, or
+// the zero time if every change has been applied
+func SetApplyTimes(lastApplied, oldestUnapplied time.Time) {
+	applyTimes.Lock()
+	defer applyTimes.Unlock()
+
+	if !lastApplied.IsZero() {
+		applyTimes.lastApplied = lastApplied
+	}
+	applyTimes.oldestUnapplied = oldestUnapplied
+}
+
+// LastApplyAge is the number of seconds since the desired state was last
+// applied successfully, or since startup if it has not yet been
+var LastApplyAge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "last_apply_age_seconds",
+	Help:      "Seconds since the desired state was last applied successfully",
+}, func() float64 {
+	applyTimes.Lock()
+	defer applyTimes.Unlock()
+
+	return time.Since(applyTimes.lastApplied).Seconds()
+})
+
+// OldestUnappliedChangeAge is the number of seconds since the oldest change
+// of the desired state which has not yet been applied, or 0 if every change
+// has been applied
+var OldestUnappliedChangeAge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "oldest_unapplied_change_age_seconds",
+	Help:      "Seconds since the oldest change of the desired state not yet applied (0 if none)",
+}, func() float64 {
+	applyTimes.Lock()
+	defer applyTimes.Unlock()
+
+	if applyTimes.oldestUnapplied.IsZero() {
+		return 0
+	}
+
+	return time.Since(applyTimes.oldestUnapplied).Seconds()
+})
+
+// OriginatedPrefixes is the number of prefixes originated by this Node, by
+// address family and class (the kind of source, such as `advertisement`)
+var OriginatedPrefixes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "originated_prefixes",
+	Help:      "Number of prefixes originated by this node, by address family and class",
+}, []string{"family", "class"})
+
+// ExportedPrefixes is the number of prefixes exported to each neighbor of
+// this Node, by address family and class, as for its prefix limit
+var ExportedPrefixes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "exported_prefixes",
+	Help:      "Number of prefixes exported to the neighbor, by address family and class",
+}, []string{"neighbor", "family", "class"})
+
+// KeyQueueDepth is the number of keys awaiting processing in each keyed
+// queue of changes (such as that of the Services)
+var KeyQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "key_queue_depth",
+	Help:      "Number of keys awaiting processing, by queue",
+}, []string{"queue"})
+
+// KeyQueueRetries counts the keys of each keyed queue whose processing failed, and which were retried with backoff
+var KeyQueueRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "key_queue_retries_total",
+	Help:      "Number of keys whose processing failed and was retried, by queue",
+}, []string{"queue"})
+
+// ConditionWithdrawn indicates whether the service advertisements of this
+// Node are withdrawn because it meets one of the configured Node conditions
+var ConditionWithdrawn = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "condition_withdrawn",
+	Help:      "Whether the service advertisements of this node are withdrawn by its conditions",
+})
+
+// ReconcileStageDuration is the duration of each stage of the reconciles of
+// this Node (`observe`, `desire`, `diff`, `apply`, and `verify`)
+var ReconcileStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "reconcile_stage_duration_seconds",
+	Help:      "Duration of each stage of the reconciles of this node",
+	Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+}, []string{"stage"})
+
+// ReconcileStageFailures counts the reconciles which failed at each stage
+var ReconcileStageFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "reconcile_stage_failures_total",
+	Help:      "Number of reconciles which failed at each stage",
+}, []string{"stage"})
+
+// RIBMissingPrefixes is the number of prefixes which should be, but are not,
+// in the adj-RIB-out of gobgpd for each neighbor, such as those dropped by a
+// policy
+var RIBMissingPrefixes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rib_missing_prefixes",
+	Help:      "Number of prefixes which should be, but are not, advertised to the neighbor",
+}, []string{"neighbor"})
+
+// RIBUnexpectedPrefixes is the number of prefixes originated by this Node in
+// the adj-RIB-out of gobgpd for each neighbor which should not be, such as
+// those not withdrawn
+var RIBUnexpectedPrefixes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rib_unexpected_prefixes",
+	Help:      "Number of prefixes originated by this node which are advertised to the neighbor, though they should not be",
+}, []string{"neighbor"})
+
+// ReceivedPrefixes is the number of prefixes of each address family received
+// from each Router, before any is accepted
+var ReceivedPrefixes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "received_prefixes",
+	Help:      "Number of prefixes of the address family received from the router",
+}, []string{"neighbor", "family", "speaker"})
+
+// AcceptedPrefixes is the number of the prefixes of each address family
+// received from each Router which were accepted
+var AcceptedPrefixes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "accepted_prefixes",
+	Help:      "Number of prefixes of the address family received from the router which were accepted",
+}, []string{"neighbor", "family", "speaker"})
@@ -0,0 +1,140 @@
+package conformance
+
+import (
+	"fmt"
+
+	"github.com/CyCoreSystems/kube-bgp/model"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// The ASN and router-id of the Desired states of the suite
+const (
+	ASN      = 64512
+	RouterID = "192.0.2.254"
+)
+
+// state returns a Desired state of the suite with the given neighbors and routes
+func state(neighbors []model.Neighbor, list ...routes.Route) *model.Desired {
+	return &model.Desired{
+		ASN:       ASN,
+		RouterID:  RouterID,
+		Neighbors: neighbors,
+		Routes:    list,
+	}
+}
+
+func uint32Ptr(v uint32) *uint32 { return &v }
+
+func float32Ptr(v float32) *float32 { return &v }
+
+// bulk returns the given number of /32 routes
+func bulk(n int) (list []routes.Route) {
+	for i := 0; i < n; i++ {
+		list = append(list, routes.Route{Prefix: fmt.Sprintf("198.51.%d.%d/32", i/256, i%256)})
+	}
+
+	return list
+}
+
+// Cases is the conformance suite.  The addresses of its neighbors are of
+// TEST-NET-1, so that no session is ever established with them.
+var Cases = []Case{
+	{
+		Name: "announce",
+		Steps: []*model.Desired{
+			state(nil, routes.Route{Prefix: "198.51.100.1/32"}),
+			state(nil, routes.Route{Prefix: "198.51.100.1/32"}, routes.Route{Prefix: "203.0.113.0/24"}),
+		},
+	},
+	{
+		Name: "withdraw",
+		Steps: []*model.Desired{
+			state(nil, routes.Route{Prefix: "198.51.100.1/32"}, routes.Route{Prefix: "203.0.113.0/24"}),
+			state(nil, routes.Route{Prefix: "203.0.113.0/24"}),
+			state(nil),
+		},
+	},
+	{
+		Name:     "attributes",
+		Requires: []model.Feature{model.FeatureAttributes},
+		Steps: []*model.Desired{
+			state(nil, routes.Route{
+				Prefix:    "198.51.100.1/32",
+				Origin:    routes.OriginIncomplete,
+				ASPath:    []uint32{64513, 64514},
+				NextHop:   "192.0.2.10",
+				LocalPref: uint32Ptr(200),
+			}),
+			state(nil, routes.Route{
+				Prefix:    "198.51.100.1/32",
+				Origin:    routes.OriginIGP,
+				NextHop:   "192.0.2.11",
+				LocalPref: uint32Ptr(300),
+			}),
+		},
+	},
+	{
+		Name:     "communities",
+		Requires: []model.Feature{model.FeatureCommunities},
+		Steps: []*model.Desired{
+			state(nil, routes.Route{Prefix: "198.51.100.1/32", Communities: []string{"64512:100", "64512:200"}}),
+			state(nil, routes.Route{Prefix: "198.51.100.1/32", Communities: []string{"64512:300"}}),
+			state(nil, routes.Route{Prefix: "198.51.100.1/32"}),
+		},
+	},
+	{
+		Name:     "link-bandwidth",
+		Requires: []model.Feature{model.FeatureLinkBandwidth},
+		Steps: []*model.Desired{
+			state(nil, routes.Route{Prefix: "198.51.100.1/32", LinkBandwidth: float32Ptr(1.25e8)}),
+			state(nil, routes.Route{Prefix: "198.51.100.1/32", LinkBandwidth: float32Ptr(2.5e8)}),
+		},
+	},
+	{
+		Name:     "ipv6",
+		Requires: []model.Feature{model.FeatureIPv6},
+		Steps: []*model.Desired{
+			state(nil, routes.Route{Prefix: "2001:db8::1/128"}, routes.Route{Prefix: "198.51.100.1/32"}),
+			state(nil, routes.Route{Prefix: "198.51.100.1/32"}),
+		},
+	},
+	{
+		Name:     "neighbors",
+		Requires: []model.Feature{model.FeatureNeighbors},
+		Steps: []*model.Desired{
+			state([]model.Neighbor{
+				{Address: "192.0.2.1", ASN: 64513},
+				{Address: "192.0.2.2", ASN: ASN},
+			}),
+			state([]model.Neighbor{
+				{Address: "192.0.2.2", ASN: ASN},
+			}),
+			state([]model.Neighbor{
+				{Address: "192.0.2.2", ASN: 64514},
+			}),
+			state(nil),
+		},
+	},
+	{
+		Name:     "export-policy",
+		Requires: []model.Feature{model.FeatureNeighbors, model.FeatureExportPolicy},
+		Steps: []*model.Desired{
+			state([]model.Neighbor{
+				{Address: "192.0.2.1", ASN: 64513, Export: &model.Policy{Prefixes: []string{"198.51.100.1/32"}}},
+				{Address: "192.0.2.2", ASN: 64513},
+			}, routes.Route{Prefix: "198.51.100.1/32"}, routes.Route{Prefix: "203.0.113.0/24"}),
+			state([]model.Neighbor{
+				{Address: "192.0.2.1", ASN: 64513, Export: &model.Policy{Prefixes: []string{"203.0.113.0/24"}}},
+				{Address: "192.0.2.2", ASN: 64513, Export: &model.Policy{}},
+			}, routes.Route{Prefix: "198.51.100.1/32"}, routes.Route{Prefix: "203.0.113.0/24"}),
+		},
+	},
+	{
+		Name: "bulk",
+		Steps: []*model.Desired{
+			state(nil, bulk(1000)...),
+			state(nil, bulk(10)...),
+			state(nil),
+		},
+	},
+}
@@ -0,0 +1,240 @@
+// Package conformance is the conformance suite of the backends of the
+// desired-state model: each case applies a sequence of Desired states to a
+// Backend, checking after each that the Backend holds exactly that state, so
+// that every backend is held to the same behaviour
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/model"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+)
+
+// Case is a single case of the conformance suite
+type Case struct {
+	// Name identifies the Case
+	Name string
+
+	// Requires is the list of the features exercised by the Case, which is
+	// skipped by a Backend which does not support every one
+	Requires []model.Feature
+
+	// Steps is the sequence of Desired states applied
+	Steps []*model.Desired
+}
+
+// Result is the outcome of a Case for a Backend
+type Result struct {
+	Case string
+
+	// Unsupported is the list of the features required by the Case which
+	// the Backend does not support, in which case it was skipped
+	Unsupported []model.Feature
+
+	// Err describes how the Backend failed the Case, if it did
+	Err error
+}
+
+// Run runs every Case of the suite against the given Backend, leaving it
+// with an empty Desired state
+func Run(ctx context.Context, b model.Backend) []Result {
+	var results []Result
+
+	for _, c := range Cases {
+		results = append(results, RunCase(ctx, b, c))
+	}
+
+	if err := b.Apply(ctx, &model.Desired{ASN: ASN, RouterID: RouterID}); err != nil {
+		results = append(results, Result{Case: "cleanup", Err: err})
+	}
+
+	return results
+}
+
+// RunCase runs the given Case against the given Backend
+func RunCase(ctx context.Context, b model.Backend, c Case) Result {
+	res := Result{Case: c.Name}
+
+	for _, f := range c.Requires {
+		if !model.Supports(b, f) {
+			res.Unsupported = append(res.Unsupported, f)
+		}
+	}
+	if len(res.Unsupported) > 0 {
+		return res
+	}
+
+	for i, step := range c.Steps {
+		if err := b.Apply(ctx, step); err != nil {
+			res.Err = eris.Wrapf(err, "step %d: failed to apply", i+1)
+			return res
+		}
+
+		observed, err := b.Observe(ctx)
+		if err != nil {
+			res.Err = eris.Wrapf(err, "step %d: failed to observe", i+1)
+			return res
+		}
+
+		if diffs := compare(b, step, observed); len(diffs) > 0 {
+			res.Err = eris.Errorf("step %d: %s", i+1, strings.Join(diffs, "; "))
+			return res
+		}
+	}
+
+	return res
+}
+
+// compare returns the differences between the desired and observed states,
+// in the features which the given Backend supports
+func compare(b model.Backend, desired, observed *model.Desired) (diffs []string) {
+	want := make(map[string]routes.Route, len(desired.Routes))
+	for _, r := range desired.Routes {
+		want[r.Prefix] = r
+	}
+	got := make(map[string]routes.Route, len(observed.Routes))
+	for _, r := range observed.Routes {
+		got[r.Prefix] = r
+	}
+
+	for _, prefix := range sortedKeys(want) {
+		g, ok := got[prefix]
+		if !ok {
+			diffs = append(diffs, "route "+prefix+" is missing")
+			continue
+		}
+
+		diffs = append(diffs, compareRoute(b, want[prefix], g)...)
+	}
+	for _, prefix := range sortedKeys(got) {
+		if _, ok := want[prefix]; !ok {
+			diffs = append(diffs, "route "+prefix+" is not desired")
+		}
+	}
+
+	if !model.Supports(b, model.FeatureNeighbors) {
+		return diffs
+	}
+
+	for i := range desired.Neighbors {
+		n := &desired.Neighbors[i]
+
+		o := observed.Neighbor(n.Address)
+		if o == nil {
+			diffs = append(diffs, "neighbor "+n.Address+" is missing")
+			continue
+		}
+
+		if o.ASN != n.ASN {
+			diffs = append(diffs, fmt.Sprintf("neighbor %s has ASN %d, not %d", n.Address, o.ASN, n.ASN))
+		}
+
+		if model.Supports(b, model.FeatureExportPolicy) {
+			var exported []string
+			if o.Export != nil {
+				exported = append(exported, o.Export.Prefixes...)
+			}
+			sort.Strings(exported)
+
+			if expected := desired.Exports(n); !equalStrings(exported, expected) {
+				diffs = append(diffs, fmt.Sprintf("neighbor %s is exported %v, not %v", n.Address, exported, expected))
+			}
+		}
+	}
+	for i := range observed.Neighbors {
+		if desired.Neighbor(observed.Neighbors[i].Address) == nil {
+			diffs = append(diffs, "neighbor "+observed.Neighbors[i].Address+" is not desired")
+		}
+	}
+
+	return diffs
+}
+
+// compareRoute returns the differences between the desired and observed
+// attributes of a route.  Only the attributes which are set in the desired
+// route are compared, since backends may fill in defaults for the others.
+func compareRoute(b model.Backend, want, got routes.Route) (diffs []string) {
+	differs := func(attr string, w, g interface{}) {
+		diffs = append(diffs, fmt.Sprintf("route %s has %s %v, not %v", want.Prefix, attr, g, w))
+	}
+
+	if model.Supports(b, model.FeatureAttributes) {
+		if want.Origin != "" && got.Origin != want.Origin {
+			differs("origin", want.Origin, got.Origin)
+		}
+		if len(want.ASPath) > 0 && !reflect.DeepEqual(got.ASPath, want.ASPath) {
+			differs("AS path", want.ASPath, got.ASPath)
+		}
+		if want.NextHop != "" && got.NextHop != want.NextHop {
+			differs("next hop", want.NextHop, got.NextHop)
+		}
+		if want.LocalPref != nil && (got.LocalPref == nil || *got.LocalPref != *want.LocalPref) {
+			differs("local preference", *want.LocalPref, uint32Value(got.LocalPref))
+		}
+	}
+
+	if model.Supports(b, model.FeatureCommunities) {
+		w := append([]string(nil), want.Communities...)
+		g := append([]string(nil), got.Communities...)
+		sort.Strings(w)
+		sort.Strings(g)
+
+		if !equalStrings(w, g) {
+			differs("communities", w, g)
+		}
+	}
+
+	if model.Supports(b, model.FeatureLinkBandwidth) && want.LinkBandwidth != nil {
+		if got.LinkBandwidth == nil || *got.LinkBandwidth != *want.LinkBandwidth {
+			differs("link bandwidth", *want.LinkBandwidth, float32Value(got.LinkBandwidth))
+		}
+	}
+
+	return diffs
+}
+
+func sortedKeys(m map[string]routes.Route) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func uint32Value(p *uint32) interface{} {
+	if p == nil {
+		return nil
+	}
+
+	return *p
+}
+
+func float32Value(p *float32) interface{} {
+	if p == nil {
+		return nil
+	}
+
+	return *p
+}
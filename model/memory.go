@@ -0,0 +1,63 @@
+package model
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// Memory is the reference Backend, which holds the Desired state in memory
+// and supports every feature, as a baseline for the conformance suite
+type Memory struct {
+	mu      sync.Mutex
+	desired Desired
+}
+
+// Name implements Backend
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+// Features implements Backend
+func (m *Memory) Features() []Feature {
+	return []Feature{FeatureNeighbors, FeatureAttributes, FeatureCommunities, FeatureLinkBandwidth, FeatureIPv6, FeatureExportPolicy}
+}
+
+// Apply implements Backend
+func (m *Memory) Apply(ctx context.Context, d *Desired) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.desired = Desired{
+		ASN:       d.ASN,
+		RouterID:  d.RouterID,
+		Neighbors: append([]Neighbor(nil), d.Neighbors...),
+		Routes:    append([]routes.Route(nil), d.Routes...),
+	}
+
+	return nil
+}
+
+// Observe implements Backend
+func (m *Memory) Observe(ctx context.Context) (*Desired, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d := &Desired{
+		ASN:      m.desired.ASN,
+		RouterID: m.desired.RouterID,
+		Routes:   append([]routes.Route(nil), m.desired.Routes...),
+	}
+
+	for i := range m.desired.Neighbors {
+		n := m.desired.Neighbors[i]
+		n.Export = &Policy{Prefixes: m.desired.Exports(&n)}
+		d.Neighbors = append(d.Neighbors, n)
+	}
+
+	return d, nil
+}
+
+// ensure Memory implements Backend
+var _ Backend = (*Memory)(nil)
@@ -0,0 +1,168 @@
+// Package model describes the desired BGP state of a Node independently of
+// the backend which applies it (gobgpd, the built-in speaker, or any other),
+// so that every backend is given the same state, and may be checked against
+// it by the conformance suite of model/conformance
+package model
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// Family is a BGP address family, by its gobgp name
+type Family string
+
+// Address families
+const (
+	IPv4Unicast  Family = "ipv4-unicast"
+	IPv6Unicast  Family = "ipv6-unicast"
+	VPNv4Unicast Family = "l3vpn-ipv4-unicast"
+	VPNv6Unicast Family = "l3vpn-ipv6-unicast"
+)
+
+// Feature is a part of the model which a backend may not support
+type Feature string
+
+// Features of the model
+const (
+	// FeatureNeighbors is the configuration of the neighbors
+	FeatureNeighbors Feature = "neighbors"
+
+	// FeatureAttributes is the path attributes of the routes (origin, AS
+	// path, next hop, and local preference)
+	FeatureAttributes Feature = "attributes"
+
+	// FeatureCommunities is the communities of the routes
+	FeatureCommunities Feature = "communities"
+
+	// FeatureLinkBandwidth is the link-bandwidth extended community of the routes
+	FeatureLinkBandwidth Feature = "link-bandwidth"
+
+	// FeatureIPv6 is the routes of IPv6 prefixes
+	FeatureIPv6 Feature = "ipv6"
+
+	// FeatureExportPolicy is the export policy of each neighbor
+	FeatureExportPolicy Feature = "export-policy"
+)
+
+// Policy selects the routes exported to a neighbor
+type Policy struct {
+	// Prefixes is the list of the prefixes exported.  Every other prefix is rejected.
+	Prefixes []string `json:"prefixes"`
+}
+
+// Permits indicates whether the Policy exports the given prefix
+func (p *Policy) Permits(prefix string) bool {
+	if p == nil {
+		return true
+	}
+
+	_, want, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return false
+	}
+
+	for _, s := range p.Prefixes {
+		if _, n, err := net.ParseCIDR(s); err == nil && n.String() == want.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Neighbor is a BGP neighbor: an iBGP peer or a Router
+type Neighbor struct {
+	// Address is the address of the neighbor
+	Address string `json:"address"`
+
+	// Name is the name of the neighbor, if it has one
+	Name string `json:"name,omitempty"`
+
+	// ASN is the ASN of the neighbor
+	ASN uint32 `json:"asn"`
+
+	// LocalASN is the local ASN of the session, or zero for that of the Node
+	LocalASN uint32 `json:"localASN,omitempty"`
+
+	// Port is the TCP port of the neighbor, or zero for the default
+	Port int `json:"port,omitempty"`
+
+	// Families is the list of the address families of the session, or
+	// empty for that of the address of the neighbor
+	Families []Family `json:"families,omitempty"`
+
+	// Export is the export policy of the neighbor, or nil if every route is exported
+	Export *Policy `json:"export,omitempty"`
+}
+
+// Desired is the desired BGP state of a Node
+type Desired struct {
+	// ASN is the ASN of the Node
+	ASN uint32 `json:"asn"`
+
+	// RouterID is the router-id of the Node
+	RouterID string `json:"routerID"`
+
+	// Neighbors is the list of the neighbors of the Node
+	Neighbors []Neighbor `json:"neighbors"`
+
+	// Routes is the list of the routes which the Node originates
+	Routes []routes.Route `json:"routes"`
+}
+
+// Neighbor returns the Neighbor of the given address, or nil if there is none
+func (d *Desired) Neighbor(address string) *Neighbor {
+	for i := range d.Neighbors {
+		if d.Neighbors[i].Address == address {
+			return &d.Neighbors[i]
+		}
+	}
+
+	return nil
+}
+
+// Exports returns the prefixes of the routes exported to the given
+// Neighbor, sorted
+func (d *Desired) Exports(n *Neighbor) (list []string) {
+	for i := range d.Routes {
+		if n.Export.Permits(d.Routes[i].Prefix) {
+			list = append(list, d.Routes[i].Prefix)
+		}
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// Backend applies the desired state to a BGP implementation
+type Backend interface {
+	// Name identifies the Backend
+	Name() string
+
+	// Features returns the features of the model which the Backend supports.
+	// The parts of the Desired state of other features are ignored.
+	Features() []Feature
+
+	// Apply makes the given Desired state that of the Backend
+	Apply(ctx context.Context, d *Desired) error
+
+	// Observe returns the state which the Backend holds, as far as it can
+	// tell: the Neighbors (with the prefixes exported to each, as the
+	// Prefixes of its Export policy, if it supports them) and the Routes
+	Observe(ctx context.Context) (*Desired, error)
+}
+
+// Supports indicates whether the given Backend supports the given Feature
+func Supports(b Backend, f Feature) bool {
+	for _, s := range b.Features() {
+		if s == f {
+			return true
+		}
+	}
+
+	return false
+}
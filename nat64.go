@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+
+	"github.com/rotisserie/eris"
+)
+
+// defaultNAT64Prefix is the well-known NAT64 prefix (RFC 6052)
+const defaultNAT64Prefix = "64:ff9b::/96"
+
+// NAT64 translation modes
+const (
+	// nat64IPv6 advertises IPv4 prefixes as their IPv6 equivalents within the NAT64 prefix
+	nat64IPv6 = "ipv6"
+
+	// nat64IPv4 advertises IPv6 prefixes within the NAT64 prefix as their IPv4 equivalents
+	nat64IPv4 = "ipv4"
+
+	// nat64Both advertises each translatable prefix in both families
+	nat64Both = "both"
+)
+
+// NAT64 configures the translation of the prefixes of an Advertisement
+// between IPv4 and their IPv4-embedded IPv6 equivalents, for NAT64/DNS64
+// networks in which they must be advertised into the other family
+type NAT64 struct {
+	// Mode is the direction of the translation: `ipv6`, in which IPv4
+	// prefixes are advertised as their IPv6 equivalents instead; `ipv4`, in
+	// which IPv6 prefixes within the NAT64 prefix are advertised as their
+	// IPv4 equivalents instead; or `both`, in which each translatable prefix
+	// is advertised in both families.  Prefixes which cannot be translated
+	// are advertised unchanged.
+	Mode string `yaml:"mode"`
+
+	// Prefix is the NAT64 prefix, which must be a /96.
+	// This is optional, and defaults to the well-known "64:ff9b::/96".
+	Prefix string `yaml:"prefix"`
+}
+
+func (n *NAT64) validate() error {
+	switch n.Mode {
+	case nat64IPv6, nat64IPv4, nat64Both:
+	default:
+		return eris.Errorf("invalid mode %q", n.Mode)
+	}
+
+	if _, err := n.network(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// network returns the NAT64 prefix
+func (n *NAT64) network() (*net.IPNet, error) {
+	prefix := n.Prefix
+	if prefix == "" {
+		prefix = defaultNAT64Prefix
+	}
+
+	ip, network, err := net.ParseCIDR(prefix)
+	if err != nil || ip.To4() != nil {
+		return nil, eris.Errorf("invalid NAT64 prefix %q", prefix)
+	}
+
+	if ones, _ := network.Mask.Size(); ones != 96 {
+		return nil, eris.Errorf("NAT64 prefix %q must be a /96", prefix)
+	}
+
+	return network, nil
+}
+
+// translate returns the prefixes to be advertised for the given prefix
+func (n *NAT64) translate(prefix string) []string {
+	nat64, err := n.network()
+	if err != nil {
+		return []string{prefix} // validated by the caller
+	}
+
+	ip, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return []string{prefix}
+	}
+	ones, _ := network.Mask.Size()
+
+	var translated *net.IPNet
+
+	switch {
+	case ip.To4() != nil && n.Mode != nat64IPv4:
+		embedded := make(net.IP, net.IPv6len)
+		copy(embedded, nat64.IP.To16()[:12])
+		copy(embedded[12:], network.IP.To4())
+
+		translated = &net.IPNet{IP: embedded, Mask: net.CIDRMask(96+ones, 128)}
+	case ip.To4() == nil && n.Mode != nat64IPv6 && ones >= 96 && nat64.Contains(network.IP):
+		translated = &net.IPNet{IP: net.IP(network.IP.To16()[12:]), Mask: net.CIDRMask(ones-96, 32)}
+	default:
+		return []string{prefix}
+	}
+
+	if n.Mode == nat64Both {
+		return []string{prefix, translated.String()}
+	}
+
+	return []string{translated.String()}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNAT64Translate(t *testing.T) {
+	for _, tt := range []struct {
+		nat64  NAT64
+		prefix string
+		want   []string
+	}{
+		{nat64: NAT64{Mode: nat64IPv6}, prefix: "192.0.2.1/32", want: []string{"64:ff9b::c000:201/128"}},
+		{nat64: NAT64{Mode: nat64IPv6}, prefix: "192.0.2.0/24", want: []string{"64:ff9b::c000:200/120"}},
+		{nat64: NAT64{Mode: nat64IPv6, Prefix: "2001:db8:64::/96"}, prefix: "198.51.100.0/24", want: []string{"2001:db8:64::c633:6400/120"}},
+		{nat64: NAT64{Mode: nat64IPv6}, prefix: "2001:db8::/64", want: []string{"2001:db8::/64"}},
+		{nat64: NAT64{Mode: nat64IPv4}, prefix: "64:ff9b::c000:201/128", want: []string{"192.0.2.1/32"}},
+		{nat64: NAT64{Mode: nat64IPv4}, prefix: "64:ff9b::c000:200/120", want: []string{"192.0.2.0/24"}},
+		{nat64: NAT64{Mode: nat64IPv4}, prefix: "192.0.2.0/24", want: []string{"192.0.2.0/24"}},
+
+		// A prefix outside of the NAT64 prefix, or shorter than it, cannot be translated.
+		{nat64: NAT64{Mode: nat64IPv4}, prefix: "2001:db8::1/128", want: []string{"2001:db8::1/128"}},
+		{nat64: NAT64{Mode: nat64IPv4}, prefix: "64:ff9b::/64", want: []string{"64:ff9b::/64"}},
+
+		{nat64: NAT64{Mode: nat64Both}, prefix: "192.0.2.1/32", want: []string{"192.0.2.1/32", "64:ff9b::c000:201/128"}},
+		{nat64: NAT64{Mode: nat64Both}, prefix: "64:ff9b::c000:201/128", want: []string{"64:ff9b::c000:201/128", "192.0.2.1/32"}},
+		{nat64: NAT64{Mode: nat64Both}, prefix: "not-a-prefix", want: []string{"not-a-prefix"}},
+	} {
+		if err := tt.nat64.validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tt.nat64.translate(tt.prefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s: expected %v, got %v", tt.nat64.Mode, tt.prefix, tt.want, got)
+		}
+	}
+}
+
+func TestNAT64Validate(t *testing.T) {
+	for _, n := range []NAT64{
+		{},
+		{Mode: "ipv5"},
+		{Mode: nat64IPv6, Prefix: "192.0.2.0/24"},
+		{Mode: nat64IPv6, Prefix: "64:ff9b::/64"},
+		{Mode: nat64IPv6, Prefix: "64:ff9b::"},
+	} {
+		if err := n.validate(); err == nil {
+			t.Errorf("%+v: expected an error", n)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !chaos
+// +build !chaos
+
+package main
+
+import "net/http"
+
+// Without the chaos build tag, no faults are injected.
+
+func chaosNotifier(n Notifier) Notifier { return n }
+
+func chaosTransport(rt http.RoundTripper) http.RoundTripper { return rt }
+
+func chaosCorruptRender(filename string) {}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// NodeConditions configures the withdrawal of the service advertisements of
+// a Node while it reports any of the given conditions, such as those of
+// node-problem-detector for dataplane faults.  Only the anycast routes which
+// other Nodes announce as well (those of advertisements, the announcement
+// API, and the advertise-ips annotation of Pods) are withdrawn, so that the
+// traffic moves to the other Nodes.  The routes which only this Node can
+// carry (its Pod host routes and EgressIPs) and its zone summaries (its Pod
+// CIDRs) are kept.
+type NodeConditions struct {
+	// Withdraw is the list of the Node conditions which withdraw the
+	// advertisements, each either `<type>`, which is met while its status
+	// is True, or `<type>=<status>` (such as `Ready=Unknown`)
+	Withdraw []string `yaml:"withdraw"`
+}
+
+func (c *NodeConditions) validate() error {
+	if len(c.Withdraw) == 0 {
+		return eris.New("withdraw is required")
+	}
+
+	for _, w := range c.Withdraw {
+		condition, s := parseNodeCondition(w)
+		if condition == "" {
+			return eris.Errorf("invalid condition %q", w)
+		}
+
+		switch s {
+		case v1.ConditionTrue, v1.ConditionFalse, v1.ConditionUnknown:
+		default:
+			return eris.Errorf("invalid status %q of condition %s: must be True, False, or Unknown", s, condition)
+		}
+	}
+
+	return nil
+}
+
+// parseNodeCondition parses a condition `<type>` or `<type>=<status>`
+func parseNodeCondition(s string) (v1.NodeConditionType, v1.ConditionStatus) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) == 1 {
+		return v1.NodeConditionType(parts[0]), v1.ConditionTrue
+	}
+
+	return v1.NodeConditionType(parts[0]), v1.ConditionStatus(parts[1])
+}
+
+// met returns the conditions of the given Node which withdraw its
+// advertisements, each as `<type>=<status>`
+func (c *NodeConditions) met(n *v1.Node) (list []string) {
+	if n == nil {
+		return nil
+	}
+
+	for _, w := range c.Withdraw {
+		condition, s := parseNodeCondition(w)
+
+		for _, nc := range n.Status.Conditions {
+			if nc.Type == condition && nc.Status == s {
+				list = append(list, string(condition)+"="+string(s))
+			}
+		}
+	}
+
+	return list
+}
+
+// routes returns the given routes of the given Node, less the anycast
+// routes, if the Node meets any of the conditions
+func (c *NodeConditions) routes(n *v1.Node, list []routes.Route) []routes.Route {
+	if len(c.met(n)) == 0 {
+		return list
+	}
+
+	var kept []routes.Route
+	for _, r := range list {
+		if r.Exclusive || strings.HasPrefix(r.Source, "zonesummary/") {
+			kept = append(kept, r)
+		}
+	}
+
+	return kept
+}
+
+// conditionMonitor reports the withdrawal of the advertisements of this
+// Node by its conditions, and their restoration
+type conditionMonitor struct {
+	cfg      *NodeConditions
+	recorder events.Recorder
+
+	// last is the description of the conditions last met
+	last string
+}
+
+func newConditionMonitor(cfg *NodeConditions, recorder events.Recorder) *conditionMonitor {
+	return &conditionMonitor{
+		cfg:      cfg,
+		recorder: recorder,
+	}
+}
+
+// Check reports a change in the conditions of the given Node by which its advertisements are withdrawn
+func (m *conditionMonitor) Check(n *v1.Node) {
+	if m.cfg == nil || n == nil {
+		return
+	}
+
+	met := strings.Join(m.cfg.met(n), ", ")
+	if met == m.last {
+		return
+	}
+
+	switch {
+	case met != "":
+		log.Printf("advertisements withdrawn by node conditions %s", met)
+		m.recorder.Warning("AdvertisementsWithdrawn", "advertisements withdrawn by node conditions %s", met)
+		metrics.ConditionWithdrawn.Set(1)
+	default:
+		log.Println("advertisements restored; no withdrawing node condition is met")
+		m.recorder.Normal("AdvertisementsRestored", "advertisements restored; no withdrawing node condition is met")
+		metrics.ConditionWithdrawn.Set(0)
+	}
+
+	m.last = met
+}
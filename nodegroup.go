@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func validateNodeGroup(g *v1alpha1.BGPNodeGroup) error {
+	if g.Spec.NodeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(g.Spec.NodeSelector); err != nil {
+			return eris.Wrap(err, "invalid node selector")
+		}
+	}
+
+	if g.Spec.Reflectors < 0 {
+		return eris.Errorf("invalid reflectors %d", g.Spec.Reflectors)
+	}
+
+	if g.Spec.Reflectors > 0 && g.Spec.RouteReflector {
+		return eris.New("reflectors may not be combined with routeReflector")
+	}
+
+	if g.Spec.ASN != "" {
+		if _, err := parseASN(g.Spec.ASN); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range g.Spec.Communities {
+		if _, err := routes.ParseCommunity(c); err != nil {
+			return err
+		}
+	}
+
+	if g.Spec.SourceCIDR != "" {
+		if _, _, err := net.ParseCIDR(g.Spec.SourceCIDR); err != nil {
+			return eris.Wrapf(err, "invalid sourceCIDR %q", g.Spec.SourceCIDR)
+		}
+	}
+
+	return nil
+}
+
+// validNodeGroups returns the BGPNodeGroups of the list which are valid,
+// reporting and skipping the rest, so that a single bad group does not
+// affect the Nodes of the others
+func validNodeGroups(list []v1alpha1.BGPNodeGroup) (valid []v1alpha1.BGPNodeGroup) {
+	for i := range list {
+		if err := validateNodeGroup(&list[i]); err != nil {
+			status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "invalid node group %s", list[i].Name))
+			continue
+		}
+
+		valid = append(valid, list[i])
+	}
+
+	return valid
+}
+
+// nodeGroup returns the BGPNodeGroup of the given Node: the first, by name,
+// whose selector matches it, or nil if it belongs to no group
+func nodeGroup(n *v1.Node, groups []v1alpha1.BGPNodeGroup) (group *v1alpha1.BGPNodeGroup) {
+	if n == nil {
+		return nil
+	}
+
+	for i := range groups {
+		g := &groups[i]
+
+		if g.Spec.NodeSelector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(g.Spec.NodeSelector)
+		if err != nil {
+			continue // validated by validNodeGroups
+		}
+
+		if !selector.Matches(labels.Set(n.Labels)) {
+			continue
+		}
+
+		if group == nil || g.Name < group.Name {
+			group = g
+		}
+	}
+
+	return group
+}
+
+// inNodeGroups indicates whether the given Node belongs to one of the named BGPNodeGroups
+func inNodeGroups(n *v1.Node, groups []v1alpha1.BGPNodeGroup, names []string) bool {
+	g := nodeGroup(n, groups)
+	if g == nil {
+		return false
+	}
+
+	return containsString(names, g.Name)
+}
+
+// isReflector indicates whether the given Node is a route reflector, either
+// by virtue of its BGPNodeGroup or by election within it
+func isReflector(n *v1.Node, state *clusterState) bool {
+	g := nodeGroup(n, state.NodeGroups)
+	if g == nil {
+		return false
+	}
+
+	return g.Spec.RouteReflector || containsString(state.Reflectors[g.Name], n.Name)
+}
+
+// hasReflectors indicates whether there are any route reflectors, in which
+// case the iBGP sessions form a hub-and-spoke topology rather than a full
+// mesh
+func hasReflectors(state *clusterState) bool {
+	for i := range state.NodeGroups {
+		if state.NodeGroups[i].Spec.RouteReflector {
+			return true
+		}
+	}
+
+	for _, names := range state.Reflectors {
+		if len(names) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reflectorOf indicates whether the named reflector Node reflects routes to
+// the given client Node: the elected reflectors of its own BGPNodeGroup do,
+// if it has any, or else every reflector does
+func reflectorOf(reflector string, n *v1.Node, state *clusterState) bool {
+	if g := nodeGroup(n, state.NodeGroups); g != nil && len(state.Reflectors[g.Name]) > 0 {
+		return containsString(state.Reflectors[g.Name], reflector)
+	}
+
+	return true
+}
+
+// containsString indicates whether the list contains the given string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reflectorCandidacy returns the BGPNodeGroup of the named Node and the
+// number of reflectors it elects, or zero if it elects none
+func reflectorCandidacy(thisNode string, state *clusterState) (group string, count int) {
+	g := nodeGroup(findNode(thisNode, state.Nodes), state.NodeGroups)
+	if g == nil {
+		return "", 0
+	}
+
+	return g.Name, g.Spec.Reflectors
+}
+
+// sourceCIDR returns the network in which the given Node establishes its BGP
+// sessions: that of its BGPNodeGroup, if set, or else the global PeerSourceCIDR
+func (c *KubeBGPConfig) sourceCIDR(n *v1.Node, groups []v1alpha1.BGPNodeGroup) string {
+	if g := nodeGroup(n, groups); g != nil && g.Spec.SourceCIDR != "" {
+		return g.Spec.SourceCIDR
+	}
+
+	return c.PeerSourceCIDR
+}
+
+// nodeASN returns the ASN of the given Node, if it differs from the system
+// ASN by virtue of its BGPNodeGroup, or else the empty string
+func (c *KubeBGPConfig) nodeASN(n *v1.Node, groups []v1alpha1.BGPNodeGroup) string {
+	if g := nodeGroup(n, groups); g != nil && g.Spec.ASN != c.ASN {
+		return g.Spec.ASN
+	}
+
+	return ""
+}
@@ -0,0 +1,56 @@
+package nodegroups
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	"k8s.io/client-go/dynamic"
+)
+
+// MaximumCheckIntervalSeconds is the maximum amount to time to wait before forcing an update check
+var MaximumCheckIntervalSeconds = 60
+
+// Watcher defines the interface for a BGPNodeGroup Watcher
+type Watcher interface {
+
+	// Changes waits for a change to the set of BGPNodeGroups to occur
+	Changes() <-chan struct{}
+
+	// Groups returns the current list of BGPNodeGroups
+	Groups() []v1alpha1.BGPNodeGroup
+
+	// Synced indicates whether the list of BGPNodeGroups has been obtained from the API at least once
+	Synced() bool
+
+	// Listed returns the time at which the list of BGPNodeGroups was last obtained from the API
+	Listed() time.Time
+
+	// Close shuts down the Watcher
+	Close()
+}
+
+type watcher struct {
+	*watches.Watcher
+}
+
+func (w *watcher) Groups() []v1alpha1.BGPNodeGroup {
+	objects := w.Objects()
+
+	list := make([]v1alpha1.BGPNodeGroup, len(objects))
+	for i, obj := range objects {
+		list[i] = *obj.(*v1alpha1.BGPNodeGroup)
+	}
+
+	return list
+}
+
+// NewWatcher returns a new BGPNodeGroup watcher which signals whenever the set of BGPNodeGroups changes
+func NewWatcher(ctx context.Context, client dynamic.Interface) (Watcher, error) {
+	source := watches.Dynamic(client, v1alpha1.BGPNodeGroupResource, "node group", time.Duration(MaximumCheckIntervalSeconds)*time.Second, func() interface{} {
+		return new(v1alpha1.BGPNodeGroup)
+	})
+
+	return &watcher{watches.NewWatcher(ctx, source)}, nil
+}
@@ -2,33 +2,22 @@ package nodes
 
 import (
 	"context"
-	"log"
+	"sync"
 	"time"
 
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/CyCoreSystems/kube-bgp/watches"
 	"github.com/rotisserie/eris"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 // MaximumCheckIntervalSeconds is the maximum amount to time to wait before forcing an update check
 var MaximumCheckIntervalSeconds = 60
 
-func getClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, eris.Wrap(err, "failed to acquire kubernetes config")
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, eris.Wrap(err, "failed to create the kubernetes clientset")
-	}
-
-	return clientset, nil
-}
-
 // Watcher defines the interface for a Node Watcher
 type Watcher interface {
 
@@ -38,73 +27,137 @@ type Watcher interface {
 	// Nodes returns the current list of Nodes
 	Nodes() []v1.Node
 
+	// Synced indicates whether the list of Nodes has been obtained from the API at least once
+	Synced() bool
+
+	// Listed returns the time at which the list of Nodes was last obtained from the API
+	Listed() time.Time
+
+	// Subscribe returns a channel which receives a Snapshot of the Nodes
+	// selected by the given filter whenever they change (beginning with the
+	// current Snapshot, if the Nodes have been listed), independently of any
+	// other subscriber, and a function which cancels the subscription.  A
+	// subscriber which falls behind receives only the latest Snapshot.  The
+	// channel is closed once the subscription is cancelled or the Watcher is
+	// closed.
+	Subscribe(filter NodeFilter) (<-chan Snapshot, func())
+
 	// Close shuts down the Watcher
 	Close()
 }
 
 type watcher struct {
 	cancel    context.CancelFunc
-	clientSet *kubernetes.Clientset
-	nodeList  []v1.Node
-	sigChan   chan struct{}
+	clientSet kubernetes.Interface
+	signal    *dirty.Flag
+
+	// mu protects the list of Nodes and the subscriptions
+	mu       sync.Mutex
+	nodeList []v1.Node
+	synced   bool
+	listed   time.Time
+	closed   bool
+
+	subscriptions    map[int]*subscription
+	nextSubscription int
+
+	// resourceVersion is the resourceVersion of the last list
+	resourceVersion string
 }
 
 func (w *watcher) run(ctx context.Context) {
-	for {
-		if err := w.watchOnce(ctx); err != nil {
-			log.Println(err)
-
-			// Prevent runaway short loop.
-			// TODO: handle this better
-			time.Sleep(time.Second)
-		}
+	defer w.closeSubscriptions()
 
+	for ctx.Err() == nil {
 		changed, err := w.updateList(ctx)
 		if err != nil {
-			log.Println("failed to update node list:", err)
+			status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "failed to update node list"))
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
 			continue
 		}
 
 		if changed {
-			w.sigChan <- struct{}{}
+			w.signal.Set()
+			w.publish()
+		}
+
+		// The watch begins at the resourceVersion of the list, so that no
+		// change in between is missed.
+		if err := w.watchOnce(ctx); err != nil {
+			status.Error(err)
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
 		}
 	}
 }
 
 func (w *watcher) watchOnce(ctx context.Context) error {
-	wtch, err := w.clientSet.CoreV1().Nodes().Watch(metav1.ListOptions{})
+	wtch, err := w.clientSet.CoreV1().Nodes().Watch(ctx, metav1.ListOptions{ResourceVersion: w.resourceVersion})
 	if err != nil {
-		return eris.Wrap(err, "failed to create node watcher")
+		if watches.Expired(err) {
+			return nil // relist
+		}
+
+		return errcode.Wrap(err, errcode.APIServerUnreachable, "failed to create node watcher")
 	}
-	defer wtch.Stop()
 
-	select {
-	case <-ctx.Done():
-	case <-time.After(time.Duration(MaximumCheckIntervalSeconds) * time.Second):
-	case <-wtch.ResultChan():
+	if err := watches.Await(ctx, wtch, "node", time.Duration(MaximumCheckIntervalSeconds)*time.Second); err != nil {
+		return errcode.Wrap(err, errcode.APIServerUnreachable, "node watch failed")
 	}
 
 	return nil
 }
 
 func (w *watcher) Changes() <-chan struct{} {
-	return w.sigChan
+	return w.signal.C()
 }
 
 func (w *watcher) Nodes() []v1.Node {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	return w.nodeList
 }
 
+func (w *watcher) Synced() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.synced
+}
+
+func (w *watcher) Listed() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.listed
+}
+
 func (w *watcher) Close() {
 	w.cancel()
 }
 
 func (w *watcher) updateList(ctx context.Context) (changed bool, err error) {
-	newList, err := w.clientSet.CoreV1().Nodes().List(metav1.ListOptions{})
+	newList, err := w.clientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return false, eris.Wrap(err, "failed to obtain list of nodes")
 	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.listed = time.Now()
+	w.resourceVersion = newList.ResourceVersion
+
+	if !w.synced {
+		w.synced = true
+		w.nodeList = newList.Items
+		return true, nil
+	}
+
 	if len(newList.Items) != len(w.nodeList) {
 		w.nodeList = newList.Items
 		return true, nil
@@ -117,7 +170,8 @@ func (w *watcher) updateList(ctx context.Context) (changed bool, err error) {
 			if oldNode.Name == newNode.Name {
 				newNodeFound = true
 
-				if addressesDiffer(newNode.Status.Addresses, oldNode.Status.Addresses) {
+				if addressesDiffer(newNode.Status.Addresses, oldNode.Status.Addresses) || conditionsDiffer(newNode.Status.Conditions, oldNode.Status.Conditions) || podCIDRsDiffer(&newNode.Spec, &oldNode.Spec) || metadataDiffers(&newNode, &oldNode) {
+					w.nodeList = newList.Items
 					return true, nil
 				}
 
@@ -126,6 +180,7 @@ func (w *watcher) updateList(ctx context.Context) (changed bool, err error) {
 		}
 
 		if !newNodeFound {
+			w.nodeList = newList.Items
 			return true, nil
 		}
 	}
@@ -156,19 +211,74 @@ func addressesDiffer(a, b []v1.NodeAddress) bool {
 	return false
 }
 
-// NewWatcher returns a new Nodes watcher which signals whenever the set of Nodes or the IPs of existing Nodes change
-func NewWatcher(ctx context.Context, clientSet *kubernetes.Clientset) (Watcher, error) {
-	clientSet, err := getClient()
-	if err != nil {
-		return nil, eris.Wrap(err, "failed to create client")
+// conditionsDiffer indicates whether the statuses of the given conditions
+// differ, ignoring their heartbeats
+func conditionsDiffer(a, b []v1.NodeCondition) bool {
+	if len(a) != len(b) {
+		return true
+	}
+
+	statuses := make(map[v1.NodeConditionType]v1.ConditionStatus, len(b))
+	for _, c := range b {
+		statuses[c.Type] = c.Status
+	}
+
+	for _, c := range a {
+		if s, ok := statuses[c.Type]; !ok || s != c.Status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podCIDRsDiffer indicates whether the pod CIDRs allocated to a Node differ
+func podCIDRsDiffer(a, b *v1.NodeSpec) bool {
+	if a.PodCIDR != b.PodCIDR || len(a.PodCIDRs) != len(b.PodCIDRs) {
+		return true
 	}
 
+	for i := range a.PodCIDRs {
+		if a.PodCIDRs[i] != b.PodCIDRs[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metadataDiffers indicates whether the labels, annotations, or provider ID
+// of a Node differ, by which it is selected, aliased, and configured
+func metadataDiffers(a, b *v1.Node) bool {
+	return a.Spec.ProviderID != b.Spec.ProviderID || stringMapsDiffer(a.Labels, b.Labels) || stringMapsDiffer(a.Annotations, b.Annotations)
+}
+
+// stringMapsDiffer indicates whether the given maps differ, treating nil as empty
+func stringMapsDiffer(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return true
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewWatcher returns a new Nodes watcher which signals whenever the set of
+// Nodes, the IPs, pod CIDRs, labels, annotations, or provider IDs of existing
+// Nodes, or the statuses of their conditions change
+func NewWatcher(ctx context.Context, clientSet kubernetes.Interface) (Watcher, error) {
 	localCtx, cancel := context.WithCancel(ctx)
 
 	w := &watcher{
-		cancel:    cancel,
-		clientSet: clientSet,
-		sigChan:   make(chan struct{}, 1),
+		cancel:        cancel,
+		clientSet:     clientSet,
+		signal:        dirty.New(),
+		subscriptions: make(map[int]*subscription),
 	}
 
 	go w.run(localCtx)
@@ -0,0 +1,149 @@
+package nodes
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NodeFilter selects the Nodes of a Snapshot.  A nil NodeFilter selects every Node.
+type NodeFilter func(n *v1.Node) bool
+
+// MatchLabels returns a NodeFilter which selects the Nodes whose labels match the given selector
+func MatchLabels(selector labels.Selector) NodeFilter {
+	return func(n *v1.Node) bool {
+		return selector.Matches(labels.Set(n.Labels))
+	}
+}
+
+// MatchNames returns a NodeFilter which selects the named Nodes
+func MatchNames(names ...string) NodeFilter {
+	return func(n *v1.Node) bool {
+		for _, name := range names {
+			if n.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Snapshot is the list of the Nodes selected by a NodeFilter, as of the time at which they were listed
+type Snapshot struct {
+	// Nodes is the list of selected Nodes
+	Nodes []v1.Node
+
+	// Listed is the time at which the Nodes were listed
+	Listed time.Time
+}
+
+// subscription is a consumer of the Snapshots of a filter
+type subscription struct {
+	filter NodeFilter
+	ch     chan Snapshot
+
+	// last is the last Snapshot sent, by which unchanged Snapshots are suppressed
+	last []v1.Node
+}
+
+// offer sends the Snapshot of the given list of Nodes, if it differs from the
+// last sent.  A Snapshot not yet received is replaced, so that a slow
+// consumer receives only the latest.
+func (s *subscription) offer(list []v1.Node, listed time.Time) {
+	selected := list
+	if s.filter != nil {
+		selected = nil
+		for i := range list {
+			if s.filter(&list[i]) {
+				selected = append(selected, list[i])
+			}
+		}
+	}
+
+	if s.last != nil && sameNodes(s.last, selected) {
+		return
+	}
+	s.last = selected
+	if s.last == nil {
+		s.last = []v1.Node{}
+	}
+
+	select {
+	case <-s.ch:
+	default:
+	}
+
+	s.ch <- Snapshot{Nodes: selected, Listed: listed}
+}
+
+// sameNodes indicates whether the given lists hold the same versions of the same Nodes
+func sameNodes(a, b []v1.Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].ResourceVersion != b[i].ResourceVersion {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *watcher) Subscribe(filter NodeFilter) (<-chan Snapshot, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := &subscription{
+		filter: filter,
+		ch:     make(chan Snapshot, 1),
+	}
+
+	if w.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+
+	if w.synced {
+		s.offer(w.nodeList, w.listed)
+	}
+
+	w.nextSubscription++
+	id := w.nextSubscription
+	w.subscriptions[id] = s
+
+	return s.ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if _, ok := w.subscriptions[id]; ok {
+			delete(w.subscriptions, id)
+			close(s.ch)
+		}
+	}
+}
+
+// publish offers the current list of Nodes to every subscription
+func (w *watcher) publish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, s := range w.subscriptions {
+		s.offer(w.nodeList, w.listed)
+	}
+}
+
+// closeSubscriptions closes the channel of every subscription, once the Watcher is closed
+func (w *watcher) closeSubscriptions() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+
+	for id, s := range w.subscriptions {
+		delete(w.subscriptions, id)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !scale
+// +build !scale
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Without the scale build tag, the scale harness (and the fake clientset on
+// which it runs) is not compiled in.
+
+func runScale(args []string) int {
+	fmt.Fprintln(os.Stderr, "the scale harness is not built in; build kube-bgp with -tags scale")
+	return 1
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/rotisserie/eris"
+)
+
+// gobgpdProcessName is the name of the gobgpd process which should be notified of configuration changes
+var gobgpdProcessName = "gobgpd"
+
+// Notifier tells gobgpd to reload its configuration
+type Notifier interface {
+
+	// Notify tells gobgpd to reload its configuration from the given file
+	Notify(filename string) error
+
+	// Check verifies that gobgpd can be notified, without notifying it
+	Check() error
+}
+
+// newNotifier returns the Notifier for the given configuration: the
+// ReloadCommand, if there is one, or else the signalling of the gobgpd
+// process
+func newNotifier(cfg *KubeBGPConfig) Notifier {
+	if len(cfg.ReloadCommand) > 0 {
+		return &commandNotifier{command: cfg.ReloadCommand}
+	}
+
+	return &signalNotifier{process: gobgpdProcessName}
+}
+
+// signalNotifier notifies gobgpd by signalling its process with SIGHUP.
+// gobgpd must be visible in our process namespace (i.e. the Pod must set
+// `shareProcessNamespace`).
+type signalNotifier struct {
+	process string
+}
+
+func (n *signalNotifier) Notify(filename string) error {
+	pid, err := findProcess(n.process)
+	if err != nil {
+		return errcode.Wrapf(err, errcode.GoBGPDUnreachable, "failed to find gobgpd to reload %s", filename)
+	}
+
+	if err := signalReload(pid); err != nil {
+		return errcode.Wrapf(err, errcode.GoBGPDUnreachable, "failed to signal gobgpd to reload %s", filename)
+	}
+
+	return nil
+}
+
+func (n *signalNotifier) Check() error {
+	if !signalSupported {
+		return errcode.New(errcode.ConfigInvalid, "gobgpd cannot be signalled on this platform; reloadCommand is required")
+	}
+
+	if _, err := findProcess(n.process); err != nil {
+		return errcode.Wrap(err, errcode.GoBGPDUnreachable, "gobgpd cannot be notified")
+	}
+
+	return nil
+}
+
+// commandNotifier notifies gobgpd by running a command, such as where
+// gobgpd runs as a service which cannot be signalled directly.  The path of
+// the configuration file is passed to the command in the
+// KUBE_BGP_CONFIG_FILE environment variable.
+type commandNotifier struct {
+	command []string
+}
+
+func (n *commandNotifier) Notify(filename string) error {
+	cmd := exec.Command(n.command[0], n.command[1:]...) // nolint: gosec
+	cmd.Env = append(os.Environ(), "KUBE_BGP_CONFIG_FILE="+filename)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errcode.Wrapf(err, errcode.GoBGPDUnreachable, "reload command failed for %s: %s", filename, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (n *commandNotifier) Check() error {
+	if _, err := exec.LookPath(n.command[0]); err != nil {
+		return errcode.Wrapf(err, errcode.ConfigInvalid, "reload command %s not found", n.command[0])
+	}
+
+	return nil
+}
+
+// findProcess returns the PID of the first process with the given name
+func findProcess(name string) (int, error) {
+	comms, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to list processes")
+	}
+
+	for _, comm := range comms {
+		data, err := ioutil.ReadFile(comm)
+		if err != nil {
+			continue // process has probably exited
+		}
+
+		if strings.TrimSpace(string(data)) != name {
+			continue
+		}
+
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(comm)))
+		if err != nil {
+			continue
+		}
+
+		return pid, nil
+	}
+
+	return 0, eris.Errorf("no %s process found", name)
+}
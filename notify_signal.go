@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// signalSupported indicates whether gobgpd can be signalled to reload on this platform
+const signalSupported = true
+
+// historySignal is the signal on which the peer history is dumped to the log
+var historySignal os.Signal = syscall.SIGUSR1
+
+// signalReload signals the process of the given PID to reload its configuration
+func signalReload(pid int) error {
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// expectSIGHUP waits for the test process to receive SIGHUP on the given channel
+func expectSIGHUP(t *testing.T, ch <-chan os.Signal) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP was not received")
+	}
+}
+
+func TestSignalReload(t *testing.T) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	if err := signalReload(os.Getpid()); err != nil {
+		t.Fatalf("failed to signal: %v", err)
+	}
+
+	expectSIGHUP(t, ch)
+}
+
+// TestSignalNotifier has the test process stand in for gobgpd, so that it
+// is the process which is found and signalled
+func TestSignalNotifier(t *testing.T) {
+	comm, err := ioutil.ReadFile("/proc/self/comm")
+	if err != nil {
+		t.Skip("processes cannot be listed on this platform")
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	n := &signalNotifier{process: strings.TrimSpace(string(comm))}
+
+	if err := n.Check(); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	if err := n.Notify("gobgpd.conf"); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	expectSIGHUP(t, ch)
+}
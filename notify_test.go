@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+)
+
+// fakeReloadCommand writes a shell script which stands in for a reload
+// command, recording the configuration file it was given and exiting with
+// the given status, and returns its path along with that of its record
+func fakeReloadCommand(t *testing.T, exit string) (command, record string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell on this platform")
+	}
+
+	dir, err := ioutil.TempDir("", "kube-bgp-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) }) // nolint: errcheck
+
+	command = filepath.Join(dir, "reload")
+	record = filepath.Join(dir, "record")
+
+	script := "#!/bin/sh\necho \"$KUBE_BGP_CONFIG_FILE\" > " + record + "\necho reload output\nexit " + exit + "\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return command, record
+}
+
+func TestNewNotifier(t *testing.T) {
+	if _, ok := newNotifier(&KubeBGPConfig{}).(*signalNotifier); !ok {
+		t.Error("expected gobgpd to be signalled without a reloadCommand")
+	}
+
+	n, ok := newNotifier(&KubeBGPConfig{ReloadCommand: []string{"systemctl", "reload", "gobgpd"}}).(*commandNotifier)
+	if !ok {
+		t.Fatal("expected the reloadCommand to be run")
+	}
+	if strings.Join(n.command, " ") != "systemctl reload gobgpd" {
+		t.Errorf("unexpected command %v", n.command)
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	command, record := fakeReloadCommand(t, "0")
+
+	n := &commandNotifier{command: []string{command}}
+
+	if err := n.Check(); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	if err := n.Notify("/etc/gobgpd/gobgpd.conf"); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(record)
+	if err != nil {
+		t.Fatalf("reload command was not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "/etc/gobgpd/gobgpd.conf" {
+		t.Errorf("reload command was given %q", got)
+	}
+}
+
+func TestCommandNotifierFailure(t *testing.T) {
+	command, _ := fakeReloadCommand(t, "3")
+
+	err := (&commandNotifier{command: []string{command}}).Notify("gobgpd.conf")
+	if err == nil {
+		t.Fatal("expected a failing reload command to fail")
+	}
+
+	if errcode.Of(err) != errcode.GoBGPDUnreachable {
+		t.Errorf("unexpected error code %s", errcode.Of(err))
+	}
+	if !strings.Contains(err.Error(), "reload output") {
+		t.Errorf("error does not include the output of the command: %v", err)
+	}
+}
+
+func TestCommandNotifierCheckMissing(t *testing.T) {
+	err := (&commandNotifier{command: []string{"/nonexistent/reload-gobgpd"}}).Check()
+	if err == nil {
+		t.Fatal("expected a missing reload command to fail the check")
+	}
+
+	if errcode.Of(err) != errcode.ConfigInvalid {
+		t.Errorf("unexpected error code %s", errcode.Of(err))
+	}
+}
+
+func TestSignalNotifierNoProcess(t *testing.T) {
+	n := &signalNotifier{process: "kube-bgp-no-such-process"}
+
+	if err := n.Notify("gobgpd.conf"); errcode.Of(err) != errcode.GoBGPDUnreachable {
+		t.Errorf("expected a missing process to be unreachable, got %v", err)
+	}
+
+	if err := n.Check(); err == nil {
+		t.Error("expected a missing process to fail the check")
+	}
+}
+
+func TestCheckNotify(t *testing.T) {
+	if err := checkNotify(nil); err != nil {
+		t.Errorf("unexpected error without a configuration: %v", err)
+	}
+
+	// gobgpd configured through its API is not notified
+	cfg := &KubeBGPConfig{Speaker: speakerGoBGPD, GoBGPDConfig: gobgpdConfigAPI, ReloadCommand: []string{"/nonexistent/reload-gobgpd"}}
+	if err := checkNotify(cfg); err != nil {
+		t.Errorf("unexpected error in API mode: %v", err)
+	}
+
+	cfg.GoBGPDConfig = gobgpdConfigFile
+	if err := checkNotify(cfg); err == nil {
+		t.Error("expected the missing reload command to fail the check")
+	}
+}
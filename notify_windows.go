@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rotisserie/eris"
+)
+
+// signalSupported indicates whether gobgpd can be signalled to reload on this
+// platform.  Windows has no SIGHUP, so a reloadCommand is required.
+const signalSupported = false
+
+// historySignal is the signal on which the peer history is dumped to the
+// log.  Windows has no SIGUSR1, so it is not dumped.
+var historySignal os.Signal
+
+// signalReload signals the process of the given PID to reload its configuration
+func signalReload(pid int) error {
+	return eris.New("processes cannot be signalled to reload on windows")
+}
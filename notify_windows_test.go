@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+)
+
+func TestSignalReloadUnsupported(t *testing.T) {
+	if err := signalReload(os.Getpid()); err == nil {
+		t.Error("expected signalling to fail on windows")
+	}
+}
+
+func TestSignalNotifierRequiresReloadCommand(t *testing.T) {
+	err := (&signalNotifier{process: gobgpdProcessName}).Check()
+	if errcode.Of(err) != errcode.ConfigInvalid {
+		t.Errorf("expected a reloadCommand to be required, got %v", err)
+	}
+}
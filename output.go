@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+)
+
+// outputStatus is the output in which the desired state is published in the status report
+const outputStatus = "status"
+
+// hasOutput indicates whether the desired state is applied to the named
+// backend, either because it is the Speaker or because it is one of the
+// additional Outputs
+func (c *KubeBGPConfig) hasOutput(name string) bool {
+	if c.Speaker == name {
+		return true
+	}
+
+	for _, o := range c.Outputs {
+		if o == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *KubeBGPConfig) validateOutputs() error {
+	seen := map[string]bool{c.Speaker: true}
+
+	for _, o := range c.Outputs {
+		switch o {
+		case speakerGoBGPD, speakerBuiltin, outputStatus:
+		default:
+			return eris.Errorf("invalid output %q", o)
+		}
+
+		if seen[o] {
+			return eris.Errorf("duplicate output %q", o)
+		}
+		seen[o] = true
+	}
+
+	return nil
+}
+
+// peerSessions returns the status descriptions of the sessions to the given iBGP peers
+func peerSessions(list []Peer) []status.Session {
+	sessions := make([]status.Session, 0, len(list))
+	for _, p := range list {
+		sessions = append(sessions, status.Session{
+			Address: p.Address,
+			Name:    p.Name,
+			ASN:     p.ASN,
+		})
+	}
+
+	return sessions
+}
+
+// routerSessions returns the status descriptions of the sessions to the given Routers
+func routerSessions(list []Router) []status.Session {
+	sessions := make([]status.Session, 0, len(list))
+	for _, r := range list {
+		sessions = append(sessions, status.Session{
+			Address: r.Address,
+			Name:    r.Name,
+			ASN:     r.ASN,
+		})
+	}
+
+	return sessions
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/history"
+)
+
+// sessionPollInterval is the interval at which the session states of gobgpd are polled for the peer history
+var sessionPollInterval = 5 * time.Second
+
+// stateRemoved is the state recorded in the peer history when a neighbor is removed
+const stateRemoved = "removed"
+
+// pollGoBGPDSessions records the state transitions of the neighbors of
+// gobgpd in the peer history until the context is cancelled.  Since gobgpd
+// is polled, transitions which are undone between polls are not seen, but
+// the poll is much more frequent than is usual for monitoring.
+func pollGoBGPDSessions(ctx context.Context, client *gobgp.Client) {
+	known := make(map[string]bool)
+
+	for {
+		if list, err := client.Neighbors(ctx); err == nil {
+			current := make(map[string]bool, len(list))
+
+			for _, n := range list {
+				current[n.Address] = true
+
+				if state := string(n.State); history.Last(n.Address) != state {
+					history.Record(n.Address, state, "")
+				}
+			}
+
+			for addr := range known {
+				if !current[addr] {
+					history.Record(addr, stateRemoved, "no longer configured in gobgpd")
+				}
+			}
+
+			known = current
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sessionPollInterval):
+		}
+	}
+}
+
+// dumpHistoryOnSignal writes the peer history to the log whenever kube-bgp
+// receives SIGUSR1, where there is such a signal
+func dumpHistoryOnSignal(ctx context.Context) {
+	if historySignal == nil {
+		return
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, historySignal)
+
+	for {
+		select {
+		case <-ctx.Done():
+			signal.Stop(ch)
+			return
+		case <-ch:
+			log.Println("peer state history:")
+			history.Dump(os.Stderr)
+		}
+	}
+}
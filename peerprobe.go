@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// peerProbeTCP is the PeerProbe method which connects to a TCP port of the peer
+	peerProbeTCP = "tcp"
+
+	// peerProbeICMP is the PeerProbe method which sends an ICMP echo request to the peer
+	peerProbeICMP = "icmp"
+)
+
+// defaultPeerProbeIntervalSeconds is the default interval between the probes of the iBGP peers
+const defaultPeerProbeIntervalSeconds = 10
+
+// PeerProbe configures the optional probe of the dataplane path to each iBGP
+// peer, over the address which is the next-hop of its routes, so that a path
+// which BGP believes to exist but which carries no traffic is detected
+type PeerProbe struct {
+	// Enabled indicates that the iBGP peers should be probed
+	Enabled bool `yaml:"enabled"`
+
+	// Method is the method of the probe: `tcp` (the default), which connects
+	// to Port, or `icmp`, which sends an echo request (and needs unprivileged
+	// ICMP sockets, per net.ipv4.ping_group_range, or CAP_NET_RAW).
+	Method string `yaml:"method"`
+
+	// Port is the TCP port to which the tcp probe connects.  A refused
+	// connection still shows that the peer is reachable.  This is optional,
+	// and defaults to the port of statusAddress, which every Node serves;
+	// the BGP port itself is a poor choice, since the session already shows
+	// that it is reachable.
+	Port int `yaml:"port"`
+
+	// IntervalSeconds is the interval between probes.
+	// This is optional, and defaults to 10 seconds.
+	IntervalSeconds int `yaml:"intervalSeconds"`
+
+	// TimeoutSeconds is the amount of time to wait for each probe.
+	// This is optional, and defaults to 3 seconds.
+	TimeoutSeconds int `yaml:"timeoutSeconds"`
+}
+
+func (p *PeerProbe) validate() error {
+	switch p.Method {
+	case "", peerProbeTCP, peerProbeICMP:
+	default:
+		return eris.Errorf("invalid method %q", p.Method)
+	}
+
+	if p.Port < 0 || p.Port > 65535 {
+		return eris.Errorf("invalid port %d", p.Port)
+	}
+
+	if p.IntervalSeconds < 0 {
+		return eris.Errorf("invalid intervalSeconds %d", p.IntervalSeconds)
+	}
+
+	if p.TimeoutSeconds < 0 {
+		return eris.Errorf("invalid timeoutSeconds %d", p.TimeoutSeconds)
+	}
+
+	return nil
+}
+
+// peerProber probes the dataplane path to each iBGP peer and compares the
+// result with the state of its session, reporting the peers whose sessions
+// are established but which cannot be reached: the routes through them are
+// blackholed
+type peerProber struct {
+	cfg      *PeerProbe
+	client   *gobgp.Client
+	recorder events.Recorder
+
+	// port is the port of the tcp probe
+	port int
+
+	// expected returns the current list of expected iBGP peers
+	expected func() []Peer
+
+	// lastBlackholed is the description of the peers found blackholed by the previous check
+	lastBlackholed string
+}
+
+func newPeerProber(cfg *PeerProbe, statusAddress string, client *gobgp.Client, recorder events.Recorder, expected func() []Peer) *peerProber {
+	port := cfg.Port
+	if port == 0 {
+		if _, p, err := net.SplitHostPort(statusAddress); err == nil {
+			port, _ = strconv.Atoi(p) // nolint: errcheck
+		}
+	}
+
+	return &peerProber{
+		cfg:      cfg,
+		client:   client,
+		recorder: recorder,
+		port:     port,
+		expected: expected,
+	}
+}
+
+func (p *peerProber) run(ctx context.Context) {
+	interval := time.Duration(p.cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultPeerProbeIntervalSeconds * time.Second
+	}
+
+	for {
+		if err := p.check(ctx); err != nil {
+			status.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (p *peerProber) check(ctx context.Context) error {
+	neighbors, err := p.client.Neighbors(ctx)
+	if err != nil {
+		return err
+	}
+
+	established := make(map[string]bool)
+	for _, n := range neighbors {
+		if n.State == gobgp.SessionEstablished {
+			established[n.Address] = true
+		}
+	}
+
+	var blackholed []string
+
+	for _, peer := range p.expected() {
+		err := p.probeOne(ctx, peer.Address)
+
+		reachable, blackhole := 1.0, 0.0
+		if err != nil {
+			reachable = 0
+
+			if established[peer.Address] {
+				blackhole = 1
+				blackholed = append(blackholed, peer.Name+" ("+peer.Address+"): "+err.Error())
+			}
+		}
+
+		metrics.PeerReachable.WithLabelValues(peer.Name).Set(reachable)
+		metrics.PeerBlackholed.WithLabelValues(peer.Name).Set(blackhole)
+	}
+	sort.Strings(blackholed)
+
+	desc := strings.Join(blackholed, ", ")
+	if desc != p.lastBlackholed {
+		if desc == "" {
+			p.recorder.Normal("PeersReachable", "every iBGP peer with an established session is reachable")
+		} else {
+			p.recorder.Warning("PeersBlackholed", "%d iBGP peers have established sessions but are unreachable: %s", len(blackholed), desc)
+		}
+	}
+	p.lastBlackholed = desc
+
+	return nil
+}
+
+func (p *peerProber) probeOne(ctx context.Context, addr string) error {
+	timeout := time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProbeTimeoutSeconds * time.Second
+	}
+
+	if p.cfg.Method == peerProbeICMP {
+		return ping(addr, timeout)
+	}
+
+	d := &net.Dialer{
+		Timeout: timeout,
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(p.port)))
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil // the peer answered
+		}
+
+		return eris.Wrap(err, "failed to connect")
+	}
+
+	return conn.Close()
+}
+
+// ping sends an ICMP echo request to the given address and waits for the reply
+func ping(addr string, timeout time.Duration) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return eris.Errorf("invalid address %q", addr)
+	}
+
+	network, listen, proto := "udp4", "0.0.0.0", 1 // ICMP
+	var request, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+
+	if ip.To4() == nil {
+		network, listen, proto = "udp6", "::", 58 // ICMPv6
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	c, err := icmp.ListenPacket(network, listen)
+	if err != nil {
+		return eris.Wrap(err, "failed to open ICMP socket")
+	}
+	defer c.Close() // nolint: errcheck
+
+	msg := icmp.Message{
+		Type: request,
+		Body: &icmp.Echo{
+			ID:   os.Getpid() & 0xffff,
+			Seq:  1,
+			Data: []byte("kube-bgp"),
+		},
+	}
+
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return eris.Wrap(err, "failed to build echo request")
+	}
+
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return eris.Wrap(err, "failed to set deadline")
+	}
+
+	if _, err := c.WriteTo(b, &net.UDPAddr{IP: ip}); err != nil {
+		return eris.Wrap(err, "failed to send echo request")
+	}
+
+	buf := make([]byte, 1500)
+
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return eris.Wrap(err, "no echo reply")
+		}
+
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err == nil && m.Type == reply {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// peerAddressAnnotation is the Node annotation which explicitly sets the
+// address by which the Node is peered, overriding all other selection rules
+const peerAddressAnnotation = "kube-bgp.cycoresystems.com/peer-address"
+
+// PeerAddressSelection describes how the iBGP peer address of a Node is
+// chosen from among its addresses.  The rules are applied in order: the
+// peer-address annotation, then the source CIDR of its BGPNodeGroup or the
+// global PeerSourceCIDR, then CIDRs, then Family.  If no rule selects an
+// address, the first InternalIP of the Node is used.
+type PeerAddressSelection struct {
+	// CIDRs is an ordered list of networks.  The first Node InternalIP which
+	// falls within the first matching CIDR is chosen.
+	CIDRs []string `yaml:"cidrs"`
+
+	// Family is the preferred address family: `ipv4` or `ipv6`.
+	Family string `yaml:"family"`
+}
+
+func (s *PeerAddressSelection) validate() error {
+	for _, c := range s.CIDRs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return eris.Wrapf(err, "invalid CIDR %q", c)
+		}
+	}
+
+	switch s.Family {
+	case "", "ipv4", "ipv6":
+	default:
+		return eris.Errorf("invalid family %q", s.Family)
+	}
+
+	return nil
+}
+
+// internalIPs returns the parsed InternalIP addresses of the given Node
+func internalIPs(n *v1.Node) (list []net.IP) {
+	for _, addr := range n.Status.Addresses {
+		if addr.Type != v1.NodeInternalIP {
+			continue
+		}
+
+		if ip := net.ParseIP(addr.Address); ip != nil {
+			list = append(list, ip)
+		}
+	}
+
+	return list
+}
+
+// sourceAddress returns the first address of the given Node which falls
+// within the given CIDR, or nil if there is none
+func sourceAddress(n *v1.Node, cidr string) net.IP {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range n.Status.Addresses {
+		if ip := net.ParseIP(addr.Address); ip != nil && network.Contains(ip) {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// localSourceAddress returns the address of this Node which should be used as
+// the source of its BGP sessions and the next-hop of the routes it
+// originates, or nil if no source CIDR is set or this Node has no address
+// within it.
+func localSourceAddress(thisNode *v1.Node, cfg *KubeBGPConfig, groups []v1alpha1.BGPNodeGroup) net.IP {
+	if thisNode == nil {
+		return nil
+	}
+
+	cidr := cfg.sourceCIDR(thisNode, groups)
+	if cidr == "" {
+		return nil
+	}
+
+	return sourceAddress(thisNode, cidr)
+}
+
+// peerAddress returns the address by which the given Node should be peered
+func peerAddress(n *v1.Node, cfg *KubeBGPConfig, groups []v1alpha1.BGPNodeGroup) (net.IP, error) {
+	if s, ok := n.Annotations[peerAddressAnnotation]; ok {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, eris.Errorf("invalid %s annotation %q on node %s", peerAddressAnnotation, s, n.Name)
+		}
+
+		return ip, nil
+	}
+
+	if cidr := cfg.sourceCIDR(n, groups); cidr != "" {
+		if ip := sourceAddress(n, cidr); ip != nil {
+			return ip, nil
+		}
+	}
+
+	ips := internalIPs(n)
+	if len(ips) == 0 {
+		return nil, eris.Errorf("node %s has no InternalIP", n.Name)
+	}
+
+	return selectAddress(ips, cfg.PeerAddress), nil
+}
+
+// peers returns the list of iBGP peers of the named Node: every other Node in
+// the cluster, or, if there are route reflectors, its reflectors alone (for
+// a reflector, the other reflectors and its clients), followed by its peers
+// in each of the Topologies which it joins.  Nodes whose peer address cannot
+// be determined are reported and skipped, so that a single misconfigured
+// Node does not break the whole mesh.  If the mesh is disabled, there are no
+// peers but those of the Topologies.
+func peers(thisNode string, cfg *KubeBGPConfig, state *clusterState) (list []Peer) {
+	if cfg.Mesh != meshDisabled {
+		list = meshPeers(thisNode, cfg, state)
+	}
+
+	if len(cfg.Topologies) == 0 {
+		return list
+	}
+
+	used := make(map[string]bool, len(list))
+	for _, p := range list {
+		used[p.Address] = true
+	}
+
+	return append(list, topologyPeers(thisNode, cfg, state, used)...)
+}
+
+// meshPeers returns the list of iBGP peers of the named Node in the primary mesh
+func meshPeers(thisNode string, cfg *KubeBGPConfig, state *clusterState) (list []Peer) {
+
+	local := findNode(thisNode, state.Nodes)
+	reflected := hasReflectors(state)
+	localReflector := isReflector(local, state)
+
+	for i := range state.Nodes {
+		n := &state.Nodes[i]
+
+		if n.Name == thisNode {
+			continue
+		}
+
+		reflector := isReflector(n, state)
+
+		var client bool
+		if reflected {
+			switch {
+			case localReflector && reflector:
+				// reflectors peer with each other
+			case localReflector:
+				if !reflectorOf(thisNode, n, state) {
+					continue
+				}
+				client = true
+			case reflector:
+				if !reflectorOf(n.Name, local, state) {
+					continue
+				}
+			default:
+				continue // clients peer only with their reflectors
+			}
+		}
+
+		ip, err := peerAddress(n, cfg, state.NodeGroups)
+		if err != nil {
+			status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "failed to determine peer address of node %s", n.Name))
+			continue
+		}
+
+		// The routes of a peer are held through a session drop only while
+		// its Node is Ready; a dead Node's routes are withdrawn at once.
+		var hold int
+		if nodeReady(n) {
+			hold = cfg.PeerDownHoldSeconds
+		}
+
+		list = append(list, Peer{
+			Address:              ip.String(),
+			Name:                 n.Name,
+			Description:          nodeDescription(n, cfg),
+			ASN:                  cfg.nodeASN(n, state.NodeGroups),
+			RouteReflectorClient: client,
+			HoldSeconds:          hold,
+			PasswordFile:         cfg.MeshPasswordFile,
+		})
+	}
+
+	return list
+}
+
+// nodeDescription returns the description of the given Node for BGP
+// sessions, so that each end of a session can tell which Node is which: the
+// Node name, qualified by the cluster name and the rack, where known
+func nodeDescription(n *v1.Node, cfg *KubeBGPConfig) string {
+	desc := n.Name
+	if cfg.ClusterName != "" {
+		desc = cfg.ClusterName + "/" + desc
+	}
+
+	if cfg.RackLabel != "" {
+		if rack := n.Labels[cfg.RackLabel]; rack != "" {
+			desc += " (rack " + rack + ")"
+		}
+	}
+
+	return desc
+}
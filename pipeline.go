@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/model"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+)
+
+// Names of the stages of a reconcile
+const (
+	stageObserve = "observe"
+	stageDesire  = "desire"
+	stageDiff    = "diff"
+	stageApply   = "apply"
+	stageVerify  = "verify"
+)
+
+// errHalt ends a reconcile at the stage which returns it, without failure,
+// such as while a standby awaits its handover
+var errHalt = eris.New("reconcile halted")
+
+// reconcileRun is one reconcile of this Node, which its stages fill in turn
+type reconcileRun struct {
+	// trigger is the event which triggered the reconcile
+	trigger string
+
+	// sessions indicates whether the BGP sessions are reconfigured, rather
+	// than only the routes re-advertised
+	sessions bool
+
+	// state is the observed state of the cluster
+	state *clusterState
+
+	// peers and routers are the desired iBGP peers and Routers
+	peers   []Peer
+	routers []Router
+
+	// intended is the list of the routes intended to be applied, before any
+	// is held back, and dryRun that of the routes of advertisements in dry run
+	intended []routes.Route
+	dryRun   []routes.Route
+
+	// desired is the list of the routes to be applied
+	desired []routes.Route
+
+	// model is the desired state in the backend-neutral model
+	model *model.Desired
+
+	// intendedHash is the hash of the intended state
+	intendedHash string
+}
+
+// stage is one step of a reconcile
+type stage interface {
+	// Name identifies the stage in the metrics and the state dump
+	Name() string
+
+	// Run performs the stage of the given reconcile.  An error ends the
+	// reconcile; errHalt ends it without failure.
+	Run(ctx context.Context, r *reconcileRun) error
+}
+
+// stageFunc is a stage implemented by a function
+type stageFunc struct {
+	name string
+	run  func(ctx context.Context, r *reconcileRun) error
+}
+
+func (s stageFunc) Name() string {
+	return s.name
+}
+
+func (s stageFunc) Run(ctx context.Context, r *reconcileRun) error {
+	return s.run(ctx, r)
+}
+
+// stageTiming is the duration of one stage of the last reconcile
+type stageTiming struct {
+	Stage    string        `json:"stage"`
+	Duration time.Duration `json:"duration"`
+	Failed   bool          `json:"failed,omitempty"`
+}
+
+// reconcilePipeline runs the stages of a reconcile in order, timing each
+type reconcilePipeline struct {
+	stages []stage
+}
+
+func newReconcilePipeline(stages ...stage) *reconcilePipeline {
+	return &reconcilePipeline{stages: stages}
+}
+
+// Insert adds the given stage before the named stage, or last if there is
+// no stage of that name, such as for a policy check before the apply
+func (p *reconcilePipeline) Insert(before string, s stage) {
+	for i := range p.stages {
+		if p.stages[i].Name() == before {
+			p.stages = append(p.stages[:i], append([]stage{s}, p.stages[i:]...)...)
+			return
+		}
+	}
+
+	p.stages = append(p.stages, s)
+}
+
+// Run runs the stages of the given reconcile, until one fails or halts it
+func (p *reconcilePipeline) Run(ctx context.Context, r *reconcileRun) error {
+	var timings []stageTiming
+	defer func() {
+		debugUpdate(func(d *StateDump) { d.Stages = timings })
+	}()
+
+	for _, s := range p.stages {
+		debugPhase(s.Name())
+
+		started := time.Now()
+		err := s.Run(ctx, r)
+		elapsed := time.Since(started)
+
+		metrics.ReconcileStageDuration.WithLabelValues(s.Name()).Observe(elapsed.Seconds())
+		timings = append(timings, stageTiming{Stage: s.Name(), Duration: elapsed, Failed: err != nil && err != errHalt})
+
+		switch {
+		case err == errHalt:
+			return nil
+		case err != nil:
+			metrics.ReconcileStageFailures.WithLabelValues(s.Name()).Inc()
+			return eris.Wrapf(err, "%s failed", s.Name())
+		}
+	}
+
+	return nil
+}
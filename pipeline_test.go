@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcilePipeline(t *testing.T) {
+	var ran []string
+
+	record := func(name string, err error) stage {
+		return stageFunc{name, func(ctx context.Context, r *reconcileRun) error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+
+	failure := errors.New("boom")
+
+	for _, tt := range []struct {
+		name    string
+		stages  []stage
+		insert  map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "in order",
+			stages: []stage{record(stageObserve, nil), record(stageDesire, nil), record(stageApply, nil)},
+			want:   []string{stageObserve, stageDesire, stageApply},
+		},
+		{
+			name:   "inserted",
+			stages: []stage{record(stageObserve, nil), record(stageApply, nil)},
+			insert: map[string]string{stageApply: "policy", "missing": "last"},
+			want:   []string{stageObserve, "policy", stageApply, "last"},
+		},
+		{
+			name:   "halted",
+			stages: []stage{record(stageObserve, nil), record(stageDiff, errHalt), record(stageApply, nil)},
+			want:   []string{stageObserve, stageDiff},
+		},
+		{
+			name:    "failed",
+			stages:  []stage{record(stageObserve, failure), record(stageApply, nil)},
+			want:    []string{stageObserve},
+			wantErr: "observe failed: boom",
+		},
+	} {
+		ran = nil
+
+		p := newReconcilePipeline(tt.stages...)
+		for before, name := range tt.insert {
+			p.Insert(before, record(name, nil))
+		}
+
+		err := p.Run(context.Background(), &reconcileRun{})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.wantErr, err)
+		}
+
+		if !reflect.DeepEqual(ran, tt.want) {
+			t.Errorf("%s: expected stages %v, got %v", tt.name, tt.want, ran)
+		}
+	}
+}
+
+func TestDesireStage(t *testing.T) {
+	routerA := Router{Name: "edge-a", Address: "10.0.0.254", PeerNodes: []string{"node-a"}}
+	routerB := Router{Name: "edge-b", Address: "10.0.0.253", PeerNodes: []string{"node-a"}}
+
+	cfg := &KubeBGPConfig{Routers: []Router{routerA}}
+	state := &clusterState{Nodes: []v1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}}}
+
+	s := &desireStage{
+		cfg:        cfg,
+		nodeName:   "node-a",
+		conditions: newConditionMonitor(nil, nopRecorder{}),
+		bootstrap:  newBootstrapGate(nil, nil, nopRecorder{}),
+		holdDown:   newHoldDownGate(nopRecorder{}),
+		takeover:   newTakeoverGate(nopRecorder{}, nil, 0),
+		freeze:     newFreezeGate("", nil, nopRecorder{}),
+	}
+
+	routerNames := func(list []Router) (names []string) {
+		for _, r := range list {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	run := func(sessions bool) *reconcileRun {
+		t.Helper()
+
+		r := &reconcileRun{sessions: sessions, state: state}
+		if err := s.Run(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if r.model == nil {
+			t.Error("no desired model")
+		}
+
+		return r
+	}
+
+	if r := run(true); !reflect.DeepEqual(routerNames(r.routers), []string{"edge-a"}) {
+		t.Errorf("expected routers [edge-a], got %v", routerNames(r.routers))
+	}
+
+	// The sessions last configured are kept by a reconcile which only re-advertises the routes.
+	cfg.Routers = append(cfg.Routers, routerB)
+
+	if r := run(false); !reflect.DeepEqual(routerNames(r.routers), []string{"edge-a"}) {
+		t.Errorf("expected the configured routers [edge-a] to be kept, got %v", routerNames(r.routers))
+	}
+
+	if r := run(true); !reflect.DeepEqual(routerNames(r.routers), []string{"edge-a", "edge-b"}) {
+		t.Errorf("expected routers [edge-a edge-b], got %v", routerNames(r.routers))
+	}
+
+	if s.holdDownRelease != nil || s.scheduleChange != nil || s.takeoverCheck != nil {
+		t.Error("expected no timers without hold-downs, schedules, or deferred takeovers")
+	}
+}
+
+// fakeOwnership is the ownership of a Node by a standby, which takes it over
+// once it is verified with the given hash
+type fakeOwnership struct {
+	owner    bool
+	hash     string
+	verified []string
+}
+
+func (o *fakeOwnership) Owner() bool {
+	return o.owner
+}
+
+func (o *fakeOwnership) Verify(ctx context.Context, stateHash string) bool {
+	o.verified = append(o.verified, stateHash)
+	o.owner = stateHash == o.hash
+
+	return o.owner
+}
+
+func TestDiffStageHandover(t *testing.T) {
+	cfg := &KubeBGPConfig{}
+	desired := []routes.Route{{Prefix: "198.51.100.1/32"}}
+
+	speaker := new(fakeSpeaker)
+	advertiser := routes.NewAdvertiser(speaker)
+	if err := advertiser.Apply(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+
+	owner := &fakeOwnership{hash: "other"}
+
+	var served int
+
+	s := &diffStage{
+		cfg:       cfg,
+		nodeName:  "node-a",
+		diffs:     newReconcileDiffer(cfg),
+		applies:   new(applyTracker),
+		ownership: owner,
+		outputs:   &speakerOutputs{advertisers: []*routes.Advertiser{advertiser}, names: []string{speakerGoBGPD}},
+		vrfs:      newVRFProvisioner(nil),
+		serve:     func(ctx context.Context) { served++ },
+	}
+
+	r := &reconcileRun{state: &clusterState{}, desired: desired, intended: desired}
+
+	// A standby whose state differs from that of the owner halts, and checks again later.
+	if err := s.Run(context.Background(), r); err != errHalt {
+		t.Fatalf("expected the reconcile to halt, got %v", err)
+	}
+	if s.handoverCheck == nil || served != 0 || r.sessions {
+		t.Errorf("expected a handover check and nothing taken over, got check %v, served %d, sessions %v", s.handoverCheck != nil, served, r.sessions)
+	}
+
+	// Once its state matches, it takes over the Node and re-applies everything as its own.
+	owner.hash = appliedStateHash(nil, nil, desired)
+
+	if err := s.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if s.handoverCheck != nil || served != 1 || !r.sessions {
+		t.Errorf("expected the node to be taken over, got check %v, served %d, sessions %v", s.handoverCheck != nil, served, r.sessions)
+	}
+	if len(advertiser.Applied()) != 0 {
+		t.Errorf("expected the applied routes to be forgotten, got %v", advertiser.Applied())
+	}
+	if r.intendedHash != owner.hash {
+		t.Errorf("expected intended hash %s, got %s", owner.hash, r.intendedHash)
+	}
+
+	// The owner does not verify its state again.
+	if err := s.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if len(owner.verified) != 2 || served != 1 {
+		t.Errorf("expected no further verification, got %v, served %d", owner.verified, served)
+	}
+}
+
+// fakeSpeaker is a Speaker which fails to originate or withdraw routes while it has an error
+type fakeSpeaker struct {
+	err   error
+	paths map[string]routes.Route
+}
+
+func (s *fakeSpeaker) AddPath(ctx context.Context, r routes.Route) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.paths == nil {
+		s.paths = make(map[string]routes.Route)
+	}
+	s.paths[r.Prefix] = r
+
+	return nil
+}
+
+func (s *fakeSpeaker) DeletePath(ctx context.Context, r routes.Route) error {
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.paths, r.Prefix)
+
+	return nil
+}
+
+func TestApplyStageRetry(t *testing.T) {
+	speaker := &fakeSpeaker{err: errors.New("gobgpd is down")}
+	sessionsFailed := false
+
+	s := &applyStage{
+		cfg:      &KubeBGPConfig{},
+		nodeName: "node-a",
+		synced:   func() bool { return true },
+		vrfs:     newVRFProvisioner(nil),
+		outputs:  &speakerOutputs{advertisers: []*routes.Advertiser{routes.NewAdvertiser(speaker)}, names: []string{speakerGoBGPD}},
+		sessions: func(ctx context.Context, r *reconcileRun) bool { return sessionsFailed },
+		backoff:  minReapplyBackoff,
+	}
+
+	run := func(sessions bool) error {
+		t.Helper()
+
+		return s.Run(context.Background(), &reconcileRun{
+			sessions: sessions,
+			state:    &clusterState{},
+			desired:  []routes.Route{{Prefix: "198.51.100.1/32"}},
+		})
+	}
+
+	// A failed apply is retried with backoff, doubling up to the longest.
+	want := minReapplyBackoff
+	for i := 0; i < 16; i++ {
+		if err := run(false); err == nil {
+			t.Fatal("expected the apply to fail")
+		}
+		if s.retry == nil {
+			t.Fatal("expected a retry")
+		}
+
+		if want *= 2; want > maxReapplyBackoff {
+			want = maxReapplyBackoff
+		}
+		if s.backoff != want {
+			t.Errorf("retry %d: expected backoff %s, got %s", i, want, s.backoff)
+		}
+	}
+
+	// A successful apply cancels the retry and resets the backoff.
+	speaker.err = nil
+
+	if err := run(false); err != nil {
+		t.Fatal(err)
+	}
+	if s.retry != nil || s.backoff != minReapplyBackoff {
+		t.Errorf("expected no retry and backoff %s, got %v and %s", minReapplyBackoff, s.retry != nil, s.backoff)
+	}
+	if _, ok := speaker.paths["198.51.100.1/32"]; !ok {
+		t.Error("expected the route to be originated")
+	}
+
+	// Sessions which could not be applied are retried along with the routes.
+	sessionsFailed = true
+
+	if err := run(true); err == nil || s.retry == nil {
+		t.Errorf("expected a retry of the sessions, got %v", err)
+	}
+}
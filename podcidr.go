@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	v1 "k8s.io/api/core/v1"
+)
+
+// PodCIDRAdvertisement configures the announcement of the pod CIDRs
+// allocated to each Node (its `spec.podCIDRs`), from the Node itself, so
+// that the pod networks are routable from outside the cluster
+type PodCIDRAdvertisement struct {
+	RouteAttributes `yaml:",inline"`
+}
+
+func (a *PodCIDRAdvertisement) validate() error {
+	return a.RouteAttributes.validate()
+}
+
+// routes returns the routes of the pod CIDRs of the given Node, which are
+// exclusive to it
+func (a *PodCIDRAdvertisement) routes(n *v1.Node) (list []routes.Route) {
+	if n == nil {
+		return nil
+	}
+
+	for _, network := range podCIDRs(n) {
+		r := a.RouteAttributes.route(network.String(), "podcidr/"+n.Name)
+		r.Exclusive = true
+		list = append(list, r)
+	}
+
+	return list
+}
@@ -0,0 +1,84 @@
+package pods
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MaximumCheckIntervalSeconds is the maximum amount to time to wait before forcing an update check
+var MaximumCheckIntervalSeconds = 60
+
+// Watcher defines the interface for a Pod Watcher
+type Watcher interface {
+
+	// Changes waits for a change to the set of Pods on the Node to occur
+	Changes() <-chan struct{}
+
+	// Pods returns the current list of Pods on the Node
+	Pods() []v1.Pod
+
+	// Synced indicates whether the list of Pods has been obtained from the API at least once
+	Synced() bool
+
+	// Listed returns the time at which the list of Pods was last obtained from the API
+	Listed() time.Time
+
+	// Close shuts down the Watcher
+	Close()
+}
+
+type watcher struct {
+	*watches.Watcher
+}
+
+func (w *watcher) Pods() []v1.Pod {
+	objects := w.Objects()
+
+	list := make([]v1.Pod, len(objects))
+	for i, obj := range objects {
+		list[i] = *obj.(*v1.Pod)
+	}
+
+	return list
+}
+
+// NewWatcher returns a new Pods watcher which signals whenever the set of Pods scheduled to the named Node changes
+func NewWatcher(ctx context.Context, clientSet kubernetes.Interface, nodeName string) (Watcher, error) {
+	opts := metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	}
+
+	source := watches.Source{
+		Resource: "pod",
+		List: func(ctx context.Context) ([]watches.Item, string, error) {
+			list, err := clientSet.CoreV1().Pods("").List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+
+			items := make([]watches.Item, len(list.Items))
+			for i := range list.Items {
+				pod := &list.Items[i]
+				items[i] = watches.Item{UID: pod.UID, ResourceVersion: pod.ResourceVersion, Object: pod}
+			}
+
+			return items, list.ResourceVersion, nil
+		},
+		Watch: func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+			watchOpts := opts
+			watchOpts.ResourceVersion = resourceVersion
+
+			return clientSet.CoreV1().Pods("").Watch(ctx, watchOpts)
+		},
+		Interval: time.Duration(MaximumCheckIntervalSeconds) * time.Second,
+	}
+
+	return &watcher{watches.NewWatcher(ctx, source)}, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+	"gopkg.in/yaml.v2"
+)
+
+// Policy decisions
+const (
+	policyAccept = "accept"
+	policyReject = "reject"
+)
+
+// PolicyTests is a set of operator-supplied expectations of the export
+// policy, as evaluated by the `test` command
+type PolicyTests struct {
+	Tests []PolicyTest `yaml:"tests"`
+}
+
+// PolicyTest is a single expectation of the export policy
+type PolicyTest struct {
+	// Name describes the test
+	Name string `yaml:"name"`
+
+	// Prefix is the example prefix
+	Prefix string `yaml:"prefix"`
+
+	// Router is the Router (by name or address) toward which the prefix is exported
+	Router string `yaml:"router"`
+
+	// Expect is the expected decision: `accept` or `reject`
+	Expect string `yaml:"expect"`
+}
+
+// exportDecision evaluates the export policy for the given route toward
+// the given Router, returning whether it is accepted along with a
+// description of the rule which decided it.  It follows the restrictions
+// from which the export policy is rendered.
+func exportDecision(cfg *KubeBGPConfig, route *routes.Route, r *Router) (accept bool, rule string) {
+	rule = "default: prefixes are announced to all routers"
+
+	// A route restricted by its source, such as the routers annotation of a
+	// Service, must list the router
+	if !route.ExportedTo(r.Address) {
+		return false, fmt.Sprintf("%s is restricted to routers %v", route.Source, route.Routers)
+	}
+
+	// Every restricting advertisement of the prefix, as it is announced
+	// (after any NAT64 translation), must list the router
+	for _, a := range cfg.Advertisements {
+		if len(a.Routers) == 0 || !containsPrefix(advertisedPrefixes(&a), route.Prefix) {
+			continue
+		}
+
+		var listed bool
+		for _, ref := range a.Routers {
+			if r.Matches(ref) {
+				listed = true
+				break
+			}
+		}
+
+		if !listed {
+			return false, fmt.Sprintf("advertisement %s is restricted to routers %v", a.Name, a.Routers)
+		}
+
+		rule = fmt.Sprintf("advertisement %s lists router %s", a.Name, r.Ref())
+	}
+
+	return true, rule
+}
+
+// advertisedPrefixes returns the prefixes of the routes of the given advertisement
+func advertisedPrefixes(a *Advertisement) (list []string) {
+	for _, r := range a.routes() {
+		list = append(list, r.Prefix)
+	}
+
+	return list
+}
+
+// containsPrefix indicates whether the list contains the given prefix, once normalized
+func containsPrefix(list []string, prefix string) bool {
+	_, want, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return false
+	}
+
+	for _, p := range list {
+		if _, n, err := net.ParseCIDR(p); err == nil && n.String() == want.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runTest implements the `test` command, which evaluates the configured
+// export policy against the operator's example prefixes and reports each
+// decision along with the rule which made it.  It returns the process exit
+// code, which is non-zero if any expectation was not met.
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	file := fs.String("f", "", "file of policy tests")
+	config := fs.String("config", configFile, "kube-bgp configuration file")
+	fs.Parse(args) // nolint: errcheck
+
+	cfg, err := loadConfig(*config)
+	if err != nil {
+		fmt.Println("FAIL  config:", err)
+		return 1
+	}
+
+	tests, err := loadPolicyTests(*file)
+	if err != nil {
+		fmt.Println("FAIL  tests:", err)
+		return 1
+	}
+
+	var failed bool
+
+	for _, t := range tests.Tests {
+		r := cfg.router(t.Router)
+		if r == nil {
+			failed = true
+			fmt.Printf("FAIL  %s: unknown router %s\n", t.Name, t.Router)
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(t.Prefix); err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: invalid prefix %q\n", t.Name, t.Prefix)
+			continue
+		}
+
+		accept, rule := exportDecision(cfg, &routes.Route{Prefix: t.Prefix}, r)
+
+		got := policyReject
+		if accept {
+			got = policyAccept
+		}
+
+		if got != t.Expect {
+			failed = true
+			fmt.Printf("FAIL  %s: %s to %s: expected %s, got %s (%s)\n", t.Name, t.Prefix, t.Router, t.Expect, got, rule)
+			continue
+		}
+
+		fmt.Printf("PASS  %s: %s to %s: %s (%s)\n", t.Name, t.Prefix, t.Router, got, rule)
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+func loadPolicyTests(filename string) (*PolicyTests, error) {
+	if filename == "" {
+		return nil, eris.New("a file of tests is required (-f)")
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", filename)
+	}
+
+	tests := new(PolicyTests)
+	if err := yaml.UnmarshalStrict(data, tests); err != nil {
+		return nil, eris.Wrapf(err, "failed to decode %s", filename)
+	}
+
+	for _, t := range tests.Tests {
+		if t.Expect != policyAccept && t.Expect != policyReject {
+			return nil, eris.Errorf("test %s: expect must be %s or %s", t.Name, policyAccept, policyReject)
+		}
+	}
+
+	return tests, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/rotisserie/eris"
+)
+
+// defaultProbeTimeoutSeconds is the default amount of time to wait for a Router probe to connect
+const defaultProbeTimeoutSeconds = 3
+
+// defaultProbeIntervalSeconds is the default interval between the probes of the Routers
+const defaultProbeIntervalSeconds = 10
+
+// RouterProbe configures the optional reachability probe which is made to
+// each Router before it is added as a neighbor
+type RouterProbe struct {
+	// Enabled indicates that Routers should be probed
+	Enabled bool `yaml:"enabled"`
+
+	// TimeoutSeconds is the amount of time to wait for the probe to connect.
+	// This is optional, and defaults to 3 seconds.
+	TimeoutSeconds int `yaml:"timeoutSeconds"`
+
+	// IntervalSeconds is the interval between probes.
+	// This is optional, and defaults to 10 seconds.
+	IntervalSeconds int `yaml:"intervalSeconds"`
+
+	// TTL is the IP TTL (or IPv6 hop limit) of the probe.
+	// If set, Routers which are more than this many hops away are reported as
+	// unreachable; for instance, 1 requires that the Router be directly
+	// connected.  This is optional.
+	TTL int `yaml:"ttl"`
+}
+
+// routerProber probes the BGP port of Routers, reporting changes in their
+// reachability.  Probes are made concurrently, off the reconcile path, and a
+// Router is only added as a neighbor once it has passed one.
+type routerProber struct {
+	cfg      *RouterProbe
+	recorder events.Recorder
+
+	// changes is signalled whenever a Router becomes reachable or unreachable
+	changes chan struct{}
+
+	mu sync.Mutex
+
+	// probing indicates that a round of probes is in progress
+	probing bool
+
+	// reachable is the set of Router addresses which passed their last probe
+	reachable map[string]bool
+
+	// unreachable is the set of Router addresses which failed their last probe
+	unreachable map[string]bool
+}
+
+func newRouterProber(cfg *RouterProbe, recorder events.Recorder) *routerProber {
+	return &routerProber{
+		cfg:         cfg,
+		recorder:    recorder,
+		changes:     make(chan struct{}, 1),
+		reachable:   make(map[string]bool),
+		unreachable: make(map[string]bool),
+	}
+}
+
+// enabled indicates whether Routers are probed at all
+func (p *routerProber) enabled() bool {
+	return p.cfg != nil && p.cfg.Enabled
+}
+
+// interval returns the interval between rounds of probes
+func (p *routerProber) interval() time.Duration {
+	if p.cfg.IntervalSeconds > 0 {
+		return time.Duration(p.cfg.IntervalSeconds) * time.Second
+	}
+
+	return defaultProbeIntervalSeconds * time.Second
+}
+
+// Changes returns a channel which is signalled whenever a Router becomes
+// reachable or unreachable
+func (p *routerProber) Changes() <-chan struct{} {
+	return p.changes
+}
+
+// Reachable returns the set of Router addresses which passed their last
+// probe, or nil if Routers are not probed
+func (p *routerProber) Reachable() map[string]bool {
+	if !p.enabled() {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reachable := make(map[string]bool, len(p.reachable))
+	for addr := range p.reachable {
+		reachable[addr] = true
+	}
+
+	return reachable
+}
+
+// Probe probes each of the given Routers concurrently, recording an Event
+// whenever a Router becomes unreachable or reachable again, and signalling
+// Changes if any has.  A Probe made while another is in progress does
+// nothing.
+func (p *routerProber) Probe(ctx context.Context, routers []Router) {
+	if !p.enabled() {
+		return
+	}
+
+	p.mu.Lock()
+	if p.probing {
+		p.mu.Unlock()
+		return
+	}
+	p.probing = true
+	p.mu.Unlock()
+
+	errs := make([]error, len(routers))
+
+	var wg sync.WaitGroup
+	for i := range routers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.probeOne(ctx, routers[i].Address, routers[i].RemotePort())
+		}(i)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probing = false
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	var changed bool
+
+	for i, r := range routers {
+		if err := errs[i]; err != nil {
+			metrics.RouterReachable.WithLabelValues(r.Ref()).Set(0)
+
+			if !p.unreachable[r.Address] {
+				p.recorder.Warning("RouterUnreachable", "router %s unreachable from this node: %v", r.Ref(), err)
+			}
+
+			changed = changed || p.reachable[r.Address]
+			p.unreachable[r.Address] = true
+			delete(p.reachable, r.Address)
+
+			continue
+		}
+
+		metrics.RouterReachable.WithLabelValues(r.Ref()).Set(1)
+
+		if p.unreachable[r.Address] {
+			p.recorder.Normal("RouterReachable", "router %s is reachable from this node", r.Ref())
+		}
+
+		changed = changed || !p.reachable[r.Address]
+		p.reachable[r.Address] = true
+		delete(p.unreachable, r.Address)
+	}
+
+	if changed {
+		select {
+		case p.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *routerProber) probeOne(ctx context.Context, addr string, port int) error {
+	timeout := time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProbeTimeoutSeconds * time.Second
+	}
+
+	d := &net.Dialer{
+		Timeout: timeout,
+	}
+
+	if p.cfg.TTL > 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+
+			err := c.Control(func(fd uintptr) {
+				if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+					sockErr = setsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, p.cfg.TTL)
+					return
+				}
+
+				sockErr = setsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, p.cfg.TTL)
+			})
+			if err != nil {
+				return err
+			}
+
+			return sockErr
+		}
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		return eris.Wrap(err, "failed to connect to BGP port")
+	}
+
+	return conn.Close()
+}
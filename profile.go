@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+	"gopkg.in/yaml.v2"
+)
+
+// profiles are the built-in configurations for common fabric designs, keyed
+// by name.  Each is applied over the defaults and beneath the user's own
+// configuration, so that any of its settings may be overridden.
+var profiles = map[string]string{
+	// calico-style-mesh is a full iBGP mesh of the Nodes, via gobgpd, with
+	// Pods announcing their own IPs
+	"calico-style-mesh": `
+speaker: gobgpd
+mesh: enabled
+pods: {}
+`,
+
+	// tor-ebgp-per-rack peers each Node only with the top-of-rack Routers of
+	// its rack over eBGP, with no Node-to-Node sessions.  The ToRs are
+	// expected to be directly connected, so they are probed with a TTL of 1.
+	"tor-ebgp-per-rack": `
+speaker: gobgpd
+mesh: disabled
+rackLabel: topology.kubernetes.io/zone
+routerProbe:
+  enabled: true
+  ttl: 1
+`,
+
+	// metallb-replacement announces addresses directly from each Node to the
+	// Routers with the built-in speaker, without any gobgpd or mesh
+	"metallb-replacement": `
+speaker: builtin
+mesh: disabled
+routerProbe:
+  enabled: true
+`,
+}
+
+// profileNames returns the names of the built-in profiles
+func profileNames() (list []string) {
+	for name := range profiles {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// applyProfile applies the built-in profile selected by the given
+// configuration document, if any, to the given configuration
+func applyProfile(cfg *KubeBGPConfig, data []byte) error {
+	var sel struct {
+		Profile string `yaml:"profile"`
+	}
+	if err := yaml.Unmarshal(data, &sel); err != nil {
+		return eris.Wrap(err, "failed to decode config file")
+	}
+
+	if sel.Profile == "" {
+		return nil
+	}
+
+	profile, ok := profiles[sel.Profile]
+	if !ok {
+		return eris.Errorf("unknown profile %q (known profiles: %s)", sel.Profile, strings.Join(profileNames(), ", "))
+	}
+
+	if err := yaml.UnmarshalStrict([]byte(profile), cfg); err != nil {
+		return eris.Wrapf(err, "failed to apply profile %s", sel.Profile)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+const (
+	// logLevelInfo is the default log level
+	logLevelInfo = "info"
+
+	// logLevelDebug is the log level at which the changes made by each reconcile are logged
+	logLevelDebug = "debug"
+)
+
+// reconcileDiff is the change in the desired state made by a reconcile,
+// logged as JSON at the debug level, so that what changed, and why, can be
+// reconstructed from the logs alone
+type reconcileDiff struct {
+	// Trigger is the event which caused the reconcile, such as `node` (a
+	// change to the Nodes) or `hold-down` (the release of held down routes)
+	Trigger string `json:"trigger"`
+
+	PeersAdded   []Peer `json:"peersAdded,omitempty"`
+	PeersRemoved []Peer `json:"peersRemoved,omitempty"`
+	PeersChanged []Peer `json:"peersChanged,omitempty"`
+
+	RoutersAdded   []Router `json:"routersAdded,omitempty"`
+	RoutersRemoved []Router `json:"routersRemoved,omitempty"`
+	RoutersChanged []Router `json:"routersChanged,omitempty"`
+
+	// The Source of each route describes the object which caused it
+	RoutesAdded   []routes.Route `json:"routesAdded,omitempty"`
+	RoutesRemoved []routes.Route `json:"routesRemoved,omitempty"`
+	RoutesChanged []routeChange  `json:"routesChanged,omitempty"`
+}
+
+// routeChange is a route whose attributes were changed by a reconcile
+type routeChange struct {
+	Old routes.Route `json:"old"`
+	New routes.Route `json:"new"`
+}
+
+func (d *reconcileDiff) empty() bool {
+	return len(d.PeersAdded)+len(d.PeersRemoved)+len(d.PeersChanged)+
+		len(d.RoutersAdded)+len(d.RoutersRemoved)+len(d.RoutersChanged)+
+		len(d.RoutesAdded)+len(d.RoutesRemoved)+len(d.RoutesChanged) == 0
+}
+
+// reconcileDiffer logs the changes in the desired state from one reconcile to the next
+type reconcileDiffer struct {
+	enabled bool
+
+	peers   map[string]Peer
+	routers map[string]Router
+	routes  map[string]routes.Route
+}
+
+func newReconcileDiffer(cfg *KubeBGPConfig) *reconcileDiffer {
+	return &reconcileDiffer{
+		enabled: cfg.LogLevel == logLevelDebug,
+		peers:   make(map[string]Peer),
+		routers: make(map[string]Router),
+		routes:  make(map[string]routes.Route),
+	}
+}
+
+// Log logs the changes of the given desired state from the last, if any,
+// along with the event which triggered them
+func (r *reconcileDiffer) Log(trigger string, peers []Peer, routers []Router, desired []routes.Route) {
+	if !r.enabled {
+		return
+	}
+
+	d := &reconcileDiff{Trigger: trigger}
+
+	nextPeers := make(map[string]Peer, len(peers))
+	for _, p := range peers {
+		nextPeers[p.Address] = p
+	}
+
+	nextRouters := make(map[string]Router, len(routers))
+	for _, rt := range routers {
+		nextRouters[rt.Address] = rt
+	}
+
+	nextRoutes := make(map[string]routes.Route, len(desired))
+	for _, rt := range desired {
+		nextRoutes[rt.Prefix] = rt
+	}
+
+	for _, key := range sortedKeys(r.peers, nextPeers) {
+		old, had := r.peers[key]
+		next, has := nextPeers[key]
+
+		switch {
+		case !had:
+			d.PeersAdded = append(d.PeersAdded, next)
+		case !has:
+			d.PeersRemoved = append(d.PeersRemoved, old)
+		case !reflect.DeepEqual(old, next):
+			d.PeersChanged = append(d.PeersChanged, next)
+		}
+	}
+
+	for _, key := range sortedKeys(r.routers, nextRouters) {
+		old, had := r.routers[key]
+		next, has := nextRouters[key]
+
+		switch {
+		case !had:
+			d.RoutersAdded = append(d.RoutersAdded, next)
+		case !has:
+			d.RoutersRemoved = append(d.RoutersRemoved, old)
+		case !reflect.DeepEqual(old, next):
+			d.RoutersChanged = append(d.RoutersChanged, next)
+		}
+	}
+
+	for _, key := range sortedKeys(r.routes, nextRoutes) {
+		old, had := r.routes[key]
+		next, has := nextRoutes[key]
+
+		switch {
+		case !had:
+			d.RoutesAdded = append(d.RoutesAdded, next)
+		case !has:
+			d.RoutesRemoved = append(d.RoutesRemoved, old)
+		case !reflect.DeepEqual(old, next):
+			d.RoutesChanged = append(d.RoutesChanged, routeChange{Old: old, New: next})
+		}
+	}
+
+	r.peers, r.routers, r.routes = nextPeers, nextRouters, nextRoutes
+
+	if d.empty() {
+		return
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		log.Println("failed to encode reconcile diff:", err)
+		return
+	}
+
+	log.Printf("debug: reconcile diff %s", data)
+}
+
+// sortedKeys returns the sorted union of the keys of the given maps, which
+// must be maps with string keys
+func sortedKeys(maps ...interface{}) (keys []string) {
+	seen := make(map[string]bool)
+
+	for _, m := range maps {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			if key := k.String(); !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
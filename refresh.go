@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/speaker"
+)
+
+// refreshTimeout is the maximum time to wait for a refresh to be performed
+const refreshTimeout = 30 * time.Second
+
+// refreshRequest is a request to refresh the routes sent to a single peer,
+// or to re-originate a single prefix, without resetting any session
+type refreshRequest struct {
+	// Peer is the address of the peer to which every route should be re-sent
+	Peer string
+
+	// Prefix is the prefix which should be re-originated
+	Prefix string
+
+	// VRF is the VRF of the prefix, or empty for the global table
+	VRF string
+
+	// result receives the outcome of the refresh
+	result chan error
+}
+
+// refreshHandler returns an http.Handler which passes refresh requests to
+// the main loop, by way of the given channel.  Since refreshes are actions,
+// it is served only by the admin API (such as to the `refresh` command run
+// within the kube-bgp container).
+func refreshHandler(requests chan<- *refreshRequest) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "refresh requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := &refreshRequest{
+			Peer:   r.URL.Query().Get("peer"),
+			Prefix: r.URL.Query().Get("prefix"),
+			VRF:    r.URL.Query().Get("vrf"),
+			result: make(chan error, 1),
+		}
+		if (req.Peer == "") == (req.Prefix == "") {
+			http.Error(w, "exactly one of peer and prefix is required", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), refreshTimeout)
+		defer cancel()
+
+		select {
+		case requests <- req:
+		case <-ctx.Done():
+			http.Error(w, "timed out waiting to refresh", http.StatusServiceUnavailable)
+			return
+		}
+
+		select {
+		case err := <-req.result:
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		case <-ctx.Done():
+			http.Error(w, "timed out waiting to refresh", http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "refreshed") // nolint: errcheck
+	})
+}
+
+// refresh performs the given refresh on every speaker in use
+func refresh(ctx context.Context, req *refreshRequest, gobgpClient *gobgp.Client, builtin *speaker.Speaker, advertisers []*routes.Advertiser) error {
+	if req.Prefix != "" {
+		for _, a := range advertisers {
+			if err := a.Refresh(ctx, req.VRF, req.Prefix); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// The peer need only be known to one of the speakers
+	var errs []error
+	var refreshed bool
+
+	if gobgpClient != nil {
+		if err := gobgpClient.SoftResetOut(ctx, req.Peer); err != nil {
+			errs = append(errs, err)
+		} else {
+			refreshed = true
+		}
+	}
+
+	if builtin != nil {
+		if err := builtin.Refresh(req.Peer); err != nil {
+			errs = append(errs, errcode.Wrap(err, errcode.ConfigInvalid, "failed to refresh"))
+		} else {
+			refreshed = true
+		}
+	}
+
+	if !refreshed && len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// runRefresh implements the `refresh` command, which asks the running
+// kube-bgp to re-send its routes to a single peer, or to re-originate a
+// single prefix, without resetting any session.  It returns the process exit
+// code.
+func runRefresh(args []string) int {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	peer := fs.String("peer", "", "address of the peer to which every route should be re-sent")
+	prefix := fs.String("prefix", "", "prefix which should be re-originated")
+	vrf := fs.String("vrf", "", "VRF of the prefix, if it is not in the global table")
+	fs.Parse(args) // nolint: errcheck
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read configuration:", err) // nolint: errcheck
+		return 1
+	}
+
+	if _, err := adminRequest(cfg, http.MethodPost, "/refresh", url.Values{"peer": {*peer}, "prefix": {*prefix}, "vrf": {*vrf}}); err != nil {
+		fmt.Fprintln(os.Stderr, "refresh failed:", err) // nolint: errcheck
+		return 1
+	}
+
+	fmt.Println("refreshed")
+
+	return 0
+}
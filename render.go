@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// gobgpdConfig is the gobgpd configuration of a Node, from which its
+// configuration file is rendered
+type gobgpdConfig struct {
+	RouterID string
+	ASN      uint32
+
+	// PrefixSets and Policies are the defined sets and the policy
+	// definitions of the export policies of the neighbors
+	PrefixSets []gobgpdPrefixSet
+	Policies   []gobgpdPolicy
+
+	Neighbors []gobgpdNeighbor
+}
+
+// gobgpdPrefixSet is a set of prefixes of a single address family, since
+// gobgpd refuses sets which mix them
+type gobgpdPrefixSet struct {
+	Name     string
+	Prefixes []string
+}
+
+// gobgpdPolicy is an export policy, by which the routes of its prefix sets
+// are given its disposition and those of no set its default
+type gobgpdPolicy struct {
+	Name       string
+	PrefixSets []string
+
+	// Disposition is `accept-route` or `reject-route`
+	Disposition string
+}
+
+// gobgpdNeighbor is a neighbor of gobgpd: an iBGP peer or a Router
+type gobgpdNeighbor struct {
+	Address     string
+	ASN         uint32
+	LocalASN    uint32
+	Description string
+	Password    string
+
+	// Port is the remote port, or zero for the standard BGP port
+	Port int
+
+	// LocalAddress and BindInterface are the source address and the
+	// interface of the session, if any
+	LocalAddress  string
+	BindInterface string
+
+	// MinAdvertisementInterval is the MRAI in seconds, or zero for none
+	MinAdvertisementInterval int
+
+	// RestartTime is the time, in seconds, for which the routes of the
+	// neighbor are retained as a graceful restart helper, or zero for none
+	RestartTime int
+
+	// RouteReflectorClient indicates that routes are reflected to the
+	// neighbor, in the cluster of the given ID
+	RouteReflectorClient bool
+	ClusterID            string
+
+	// Families are the address families (AFI-SAFIs) of the session
+	Families []string
+
+	// ExportPolicy is the name of the export policy of the neighbor, if any,
+	// and DefaultExport the disposition of the routes which it does not match
+	ExportPolicy  string
+	DefaultExport string
+}
+
+// gobgpdFamilies are the address families of every session; the VPN
+// families are added to the sessions of Routers for tenants
+var gobgpdFamilies = []string{"ipv4-unicast", "ipv6-unicast"}
+
+var configTemplate = template.Must(template.New("gobgpd").Funcs(template.FuncMap{
+	"quote": tomlQuote,
+	"list":  tomlList,
+}).Parse(configTemplateString))
+
+var configTemplateString = `# Rendered by kube-bgp; any change is overwritten.
+
+[global.config]
+  as = {{ .ASN }}
+  router-id = {{ quote .RouterID }}
+{{- range .PrefixSets }}
+
+[[defined-sets.prefix-sets]]
+  prefix-set-name = {{ quote .Name }}
+{{- range .Prefixes }}
+  [[defined-sets.prefix-sets.prefix-list]]
+    ip-prefix = {{ quote . }}
+{{- end }}
+{{- end }}
+{{- range .Policies }}
+{{- $policy := . }}
+
+[[policy-definitions]]
+  name = {{ quote .Name }}
+{{- range $i, $set := .PrefixSets }}
+  [[policy-definitions.statements]]
+    name = {{ quote (printf "%s-%d" $policy.Name $i) }}
+    [policy-definitions.statements.conditions.match-prefix-set]
+      prefix-set = {{ quote $set }}
+      match-set-options = "any"
+    [policy-definitions.statements.actions]
+      route-disposition = {{ quote $policy.Disposition }}
+{{- end }}
+{{- end }}
+{{- range .Neighbors }}
+
+[[neighbors]]
+  [neighbors.config]
+    neighbor-address = {{ quote .Address }}
+    peer-as = {{ .ASN }}
+{{- if .LocalASN }}
+    local-as = {{ .LocalASN }}
+{{- end }}
+{{- if .Description }}
+    description = {{ quote .Description }}
+{{- end }}
+{{- if .Password }}
+    auth-password = {{ quote .Password }}
+{{- end }}
+{{- if or .Port .LocalAddress .BindInterface }}
+  [neighbors.transport.config]
+{{- if .Port }}
+    remote-port = {{ .Port }}
+{{- end }}
+{{- if .LocalAddress }}
+    local-address = {{ quote .LocalAddress }}
+{{- end }}
+{{- if .BindInterface }}
+    bind-interface = {{ quote .BindInterface }}
+{{- end }}
+{{- end }}
+{{- if .MinAdvertisementInterval }}
+  [neighbors.timers.config]
+    minimum-advertisement-interval = {{ .MinAdvertisementInterval }}
+{{- end }}
+{{- if .RestartTime }}
+  [neighbors.graceful-restart.config]
+    enabled = true
+    helper-only = true
+    restart-time = {{ .RestartTime }}
+{{- end }}
+{{- if .RouteReflectorClient }}
+  [neighbors.route-reflector.config]
+    route-reflector-client = true
+    route-reflector-cluster-id = {{ quote .ClusterID }}
+{{- end }}
+{{- if .ExportPolicy }}
+  [neighbors.apply-policy.config]
+    export-policy-list = {{ list .ExportPolicy }}
+    default-export-policy = {{ quote .DefaultExport }}
+{{- end }}
+{{- range .Families }}
+  [[neighbors.afi-safis]]
+    [neighbors.afi-safis.config]
+      afi-safi-name = {{ quote . }}
+{{- end }}
+{{- end }}
+`
+
+// gobgpdConfiguration returns the gobgpd configuration of the named Node,
+// with the given iBGP peers and Routers as its neighbors
+func gobgpdConfiguration(thisNode string, cfg *KubeBGPConfig, state *clusterState, peers []Peer, routers []Router) (*gobgpdConfig, error) {
+	n := findNode(thisNode, state.Nodes)
+	if n == nil {
+		return nil, errcode.New(errcode.RenderFailed, "node "+thisNode+" not found")
+	}
+
+	id, err := routerID(n, cfg)
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.RenderFailed, "failed to determine router-id")
+	}
+
+	asn, err := parseASN(cfg.ASN)
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.RenderFailed, "invalid system ASN")
+	}
+
+	c := &gobgpdConfig{RouterID: id.String(), ASN: asn}
+
+	var source string
+	if ip := localSourceAddress(n, cfg, state.NodeGroups); ip != nil {
+		source = ip.String()
+	}
+
+	var meshPassword string
+	if cfg.MeshPasswordFile != "" {
+		if meshPassword, err = readPassword(cfg.MeshPasswordFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range peers {
+		nb := gobgpdNeighbor{
+			Address:              p.Address,
+			ASN:                  asn,
+			Description:          p.Description,
+			RestartTime:          p.HoldSeconds,
+			RouteReflectorClient: p.RouteReflectorClient,
+			Families:             gobgpdFamilies,
+		}
+		if p.ASN != "" {
+			nb.ASN, _ = parseASN(p.ASN) // validated at load
+		}
+		if p.LocalASN != "" {
+			nb.LocalASN, _ = parseASN(p.LocalASN) // validated at load
+		}
+		if p.RouteReflectorClient {
+			nb.ClusterID = c.RouterID
+		}
+		if p.PasswordFile != "" {
+			nb.Password = meshPassword
+		}
+		if p.Topology == "" && sameFamily(source, p.Address) {
+			nb.LocalAddress = source
+		}
+
+		if t := cfg.topology(p.Topology); t != nil && len(t.Advertisements) > 0 {
+			nb.ExportPolicy, nb.DefaultExport = c.addPolicy("kube-bgp-topology-"+t.Name, topologyPrefixes(cfg, t), "accept-route"), "reject-route"
+		}
+
+		c.Neighbors = append(c.Neighbors, nb)
+	}
+
+	// The routes of Services are restricted to the Routers named by their routers annotation
+	var restricted []routes.Route
+	if cfg.Services != nil {
+		restricted, _ = cfg.Services.routes(cfg, n, state)
+	}
+
+	for i := range routers {
+		r := &routers[i]
+
+		nb := gobgpdNeighbor{
+			Address:                  r.Address,
+			ASN:                      asn,
+			Description:              r.Ref(),
+			Port:                     r.Port,
+			MinAdvertisementInterval: r.AdvertisementIntervalSeconds,
+			Families:                 gobgpdFamilies,
+		}
+		if r.ASN != "" {
+			nb.ASN, _ = parseASN(r.ASN) // validated at load
+		}
+		if r.PasswordFile != "" {
+			if nb.Password, err = readPassword(r.PasswordFile); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.Tenants {
+			nb.Families = append(append([]string(nil), gobgpdFamilies...), "l3vpn-ipv4-unicast", "l3vpn-ipv6-unicast")
+		}
+
+		switch {
+		case r.Link != nil:
+			nb.BindInterface = r.Link.Interface
+			if ip := r.Link.localAddress(r.Address); ip != nil {
+				nb.LocalAddress = ip.String()
+			}
+		case sameFamily(source, r.Address):
+			nb.LocalAddress = source
+		}
+
+		if rejected := rejectedPrefixes(cfg, r, restricted); len(rejected) > 0 {
+			nb.ExportPolicy, nb.DefaultExport = c.addPolicy("kube-bgp-router-"+r.Ref(), rejected, "reject-route"), "accept-route"
+		}
+
+		c.Neighbors = append(c.Neighbors, nb)
+	}
+
+	return c, nil
+}
+
+// addPolicy adds the export policy of the given name, by which the given
+// prefixes are given the given disposition, unless it has already been
+// added (such as for another peer of the same Topology), returning its name
+func (c *gobgpdConfig) addPolicy(name string, prefixes []string, disposition string) string {
+	for _, p := range c.Policies {
+		if p.Name == name {
+			return name
+		}
+	}
+
+	p := gobgpdPolicy{Name: name, Disposition: disposition}
+
+	var v4, v6 []string
+	for _, prefix := range prefixes {
+		if ip, _, err := net.ParseCIDR(prefix); err == nil && ip.To4() == nil {
+			v6 = append(v6, prefix)
+		} else {
+			v4 = append(v4, prefix)
+		}
+	}
+
+	for _, set := range []gobgpdPrefixSet{{Name: name + "-ipv4", Prefixes: v4}, {Name: name + "-ipv6", Prefixes: v6}} {
+		if len(set.Prefixes) == 0 {
+			continue
+		}
+
+		c.PrefixSets = append(c.PrefixSets, set)
+		p.PrefixSets = append(p.PrefixSets, set.Name)
+	}
+
+	c.Policies = append(c.Policies, p)
+
+	return name
+}
+
+// rejectedPrefixes returns the prefixes which are not exported to the given
+// Router: those of the advertisements restricted to other Routers, and of
+// the given routes (such as those of Services) restricted to other Routers,
+// as decided by exportDecision
+func rejectedPrefixes(cfg *KubeBGPConfig, r *Router, restricted []routes.Route) (list []string) {
+	seen := make(map[string]bool)
+
+	for _, a := range cfg.Advertisements {
+		if len(a.Routers) == 0 {
+			continue
+		}
+
+		restricted = append(restricted, a.routes()...)
+	}
+
+	for i := range restricted {
+		prefix := restricted[i].Prefix
+		if seen[prefix] {
+			continue
+		}
+
+		if ok, _ := exportDecision(cfg, &restricted[i], r); !ok {
+			seen[prefix] = true
+			list = append(list, prefix)
+		}
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// topologyPrefixes returns the prefixes of the advertisements exported to
+// the peers of the given Topology
+func topologyPrefixes(cfg *KubeBGPConfig, t *Topology) (list []string) {
+	seen := make(map[string]bool)
+
+	for _, a := range cfg.Advertisements {
+		if !containsString(t.Advertisements, a.Name) {
+			continue
+		}
+
+		for _, r := range a.routes() {
+			if !seen[r.Prefix] {
+				seen[r.Prefix] = true
+				list = append(list, r.Prefix)
+			}
+		}
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// sameFamily indicates whether the given addresses are of the same address family
+func sameFamily(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+
+	return ipA != nil && ipB != nil && (ipA.To4() != nil) == (ipB.To4() != nil)
+}
+
+// render renders the given gobgpd configuration as TOML
+func (c *gobgpdConfig) render() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := configTemplate.Execute(&buf, c); err != nil {
+		return nil, errcode.Wrap(err, errcode.RenderFailed, "failed to render gobgpd configuration")
+	}
+
+	// The rendering is checked, so that gobgpd is never given a file which
+	// it cannot read.
+	if _, err := parseTOML(buf.Bytes()); err != nil {
+		return nil, errcode.Wrap(err, errcode.RenderFailed, "rendered gobgpd configuration is invalid")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// export renders the gobgpd configuration of the named Node, with the given
+// iBGP peers and Routers as its neighbors, into the given file, replacing it
+// atomically
+func export(filename, thisNode string, cfg *KubeBGPConfig, state *clusterState, peers []Peer, routers []Router) error {
+	c, err := gobgpdConfiguration(thisNode, cfg, state, peers, routers)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.render()
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".kube-bgp-render")
+	if err != nil {
+		return errcode.Wrapf(err, errcode.RenderFailed, "failed to create gobgpd configuration in %s", filepath.Dir(filename))
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+
+	if _, err := f.Write(data); err != nil {
+		f.Close() // nolint: errcheck
+		return errcode.Wrap(err, errcode.RenderFailed, "failed to write gobgpd configuration")
+	}
+
+	// gobgpd may run as another user, so the file is as readable as any it
+	// replaces.
+	if err := f.Chmod(0644); err != nil {
+		f.Close() // nolint: errcheck
+		return errcode.Wrap(err, errcode.RenderFailed, "failed to write gobgpd configuration")
+	}
+
+	if err := f.Close(); err != nil {
+		return errcode.Wrap(err, errcode.RenderFailed, "failed to write gobgpd configuration")
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return errcode.Wrapf(err, errcode.RenderFailed, "failed to replace gobgpd configuration %s", filename)
+	}
+
+	return nil
+}
+
+// tomlQuote returns the given string as a TOML basic string
+func tomlQuote(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// tomlList returns the given strings as a TOML array
+func tomlList(list ...string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = tomlQuote(s)
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
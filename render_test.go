@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the topology fixtures rather than comparing with them")
+
+func TestRender(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		neighbor gobgpdNeighbor
+		contains []string
+		lacks    []string
+		policies bool
+	}{
+		{
+			name:     "minimal",
+			neighbor: gobgpdNeighbor{Address: "10.0.0.254", ASN: 65000},
+			contains: []string{`neighbor-address = "10.0.0.254"`, "peer-as = 65000"},
+			lacks:    []string{"local-as", "[neighbors.transport.config]", "[neighbors.timers.config]", "[neighbors.graceful-restart.config]", "[neighbors.route-reflector.config]", "[neighbors.apply-policy.config]", "[[neighbors.afi-safis]]"},
+		},
+		{
+			name: "router",
+			neighbor: gobgpdNeighbor{
+				Address:                  "10.0.0.254",
+				ASN:                      65000,
+				LocalASN:                 64513,
+				Description:              `edge "a"`,
+				Password:                 "secret",
+				Port:                     1179,
+				LocalAddress:             "10.0.0.1",
+				BindInterface:            "eth1",
+				MinAdvertisementInterval: 5,
+				Families:                 gobgpdFamilies,
+				ExportPolicy:             "kube-bgp-edge-a",
+				DefaultExport:            "accept-route",
+			},
+			contains: []string{
+				"local-as = 64513",
+				`description = "edge \"a\""`,
+				`auth-password = "secret"`,
+				"remote-port = 1179",
+				`local-address = "10.0.0.1"`,
+				`bind-interface = "eth1"`,
+				"minimum-advertisement-interval = 5",
+				`export-policy-list = ["kube-bgp-edge-a"]`,
+				`default-export-policy = "accept-route"`,
+				`afi-safi-name = "ipv4-unicast"`,
+				`afi-safi-name = "ipv6-unicast"`,
+			},
+			policies: true,
+		},
+		{
+			name:     "port alone",
+			neighbor: gobgpdNeighbor{Address: "10.0.0.254", ASN: 65000, Port: 1179},
+			contains: []string{"[neighbors.transport.config]", "remote-port = 1179"},
+			lacks:    []string{"local-address", "bind-interface"},
+		},
+		{
+			name:     "reflector client",
+			neighbor: gobgpdNeighbor{Address: "10.0.0.2", ASN: 64512, RestartTime: 120, RouteReflectorClient: true, ClusterID: "10.0.0.1"},
+			contains: []string{"helper-only = true", "restart-time = 120", "route-reflector-client = true", `route-reflector-cluster-id = "10.0.0.1"`},
+		},
+	} {
+		c := &gobgpdConfig{RouterID: "10.0.0.1", ASN: 64512, Neighbors: []gobgpdNeighbor{tt.neighbor}}
+		if tt.policies {
+			c.addPolicy("kube-bgp-edge-a", []string{"10.1.0.0/16", "2001:db8::/32"}, "reject-route")
+		}
+
+		data, err := c.render()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		out := string(data)
+
+		for _, s := range append([]string{"as = 64512", `router-id = "10.0.0.1"`}, tt.contains...) {
+			if !strings.Contains(out, s) {
+				t.Errorf("%s: expected %q in\n%s", tt.name, s, out)
+			}
+		}
+		for _, s := range tt.lacks {
+			if strings.Contains(out, s) {
+				t.Errorf("%s: unexpected %q in\n%s", tt.name, s, out)
+			}
+		}
+	}
+}
+
+func TestRenderPolicies(t *testing.T) {
+	c := &gobgpdConfig{RouterID: "10.0.0.1", ASN: 64512}
+
+	name := c.addPolicy("kube-bgp-edge-a", []string{"10.1.0.0/16", "2001:db8::/32", "10.2.0.0/16"}, "reject-route")
+	if again := c.addPolicy("kube-bgp-edge-a", []string{"10.3.0.0/16"}, "accept-route"); again != name || len(c.Policies) != 1 {
+		t.Errorf("expected a policy to be added only once, got %v", c.Policies)
+	}
+
+	// gobgpd refuses prefix sets which mix address families.
+	want := []gobgpdPrefixSet{
+		{Name: "kube-bgp-edge-a-ipv4", Prefixes: []string{"10.1.0.0/16", "10.2.0.0/16"}},
+		{Name: "kube-bgp-edge-a-ipv6", Prefixes: []string{"2001:db8::/32"}},
+	}
+	if !reflect.DeepEqual(c.PrefixSets, want) {
+		t.Errorf("expected prefix sets %v, got %v", want, c.PrefixSets)
+	}
+
+	data, err := c.render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	for _, s := range []string{
+		`prefix-set-name = "kube-bgp-edge-a-ipv4"`,
+		`ip-prefix = "2001:db8::/32"`,
+		`name = "kube-bgp-edge-a-0"`,
+		`name = "kube-bgp-edge-a-1"`,
+		`prefix-set = "kube-bgp-edge-a-ipv6"`,
+		`route-disposition = "reject-route"`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in\n%s", s, out)
+		}
+	}
+}
+
+func TestTOMLQuote(t *testing.T) {
+	for _, tt := range []struct {
+		s, want string
+	}{
+		{"plain", `"plain"`},
+		{`a "quoted" \ value`, `"a \"quoted\" \\ value"`},
+		{"line\nbreak\ttab\rreturn", `"line\nbreak\ttab\rreturn"`},
+		{"bell\x07del\x7f", `"bell\u0007del\u007F"`},
+		{"ünïcode", `"ünïcode"`},
+	} {
+		if got := tomlQuote(tt.s); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.s, tt.want, got)
+		}
+	}
+
+	if got := tomlList("a", `b"c`); got != `["a", "b\"c"]` {
+		t.Errorf("unexpected list %s", got)
+	}
+}
+
+// TestGolden compares the announcement report of each topology fixture with
+// its golden file, so that changes to peering or advertisement cannot
+// silently regress any supported design.  With -update, the golden files
+// are rewritten instead; review their diff before committing it.
+//
+// Each directory of fixtures/topologies holds the kube-bgp configuration
+// (kube-bgp.yaml), the cluster state in the form of a state cache
+// (state.json), and the expected report (report.golden).
+func TestGolden(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("fixtures", "topologies", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no topology fixtures")
+	}
+
+	for _, dir := range dirs {
+		dir := dir
+
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			cfg, err := loadConfig(filepath.Join(dir, "kube-bgp.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state, _, err := (&StateCache{Path: filepath.Join(dir, "state.json")}).load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state == nil {
+				t.Fatal("no state.json")
+			}
+
+			var buf bytes.Buffer
+			writeReport(&buf, cfg, state)
+			got := strings.TrimRight(buf.String(), "\n") + "\n"
+
+			golden := filepath.Join(dir, "report.golden")
+
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+			for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+				var g, w string
+				if i < len(gotLines) {
+					g = gotLines[i]
+				}
+				if i < len(wantLines) {
+					w = wantLines[i]
+				}
+
+				if g != w {
+					t.Fatalf("report differs from %s at line %d:\n got: %q\nwant: %q\n(run go test -run TestGolden -update to rewrite it)", golden, i+1, g, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/services"
+	"github.com/rotisserie/eris"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// runReport implements the `report` command, which prints a human-readable
+// summary of what the cluster announces to, and accepts from, each peer of
+// each Node under the given configuration, for change approval.  The state
+// of the cluster is listed from the apiserver, or read from a state cache.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	config := fs.String("config", configFile, "kube-bgp configuration file")
+	cache := fs.String("state", "", "state cache file to report on, instead of listing the cluster state from the apiserver")
+	fs.Parse(args) // nolint: errcheck
+
+	cfg, err := loadConfig(*config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read configuration:", err)
+		return 1
+	}
+
+	var state *clusterState
+	if *cache != "" {
+		state, _, err = (&StateCache{Path: *cache}).load()
+		if err == nil && state == nil {
+			err = eris.Errorf("state cache %s does not exist", *cache)
+		}
+	} else {
+		state, err = listClusterStateFromAPI(context.Background(), cfg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to obtain the cluster state:", err)
+		return 1
+	}
+
+	writeReport(os.Stdout, cfg, state)
+
+	return 0
+}
+
+// listClusterStateFromAPI lists the complete state of the cluster from the apiserver of this Pod
+func listClusterStateFromAPI(ctx context.Context, cfg *KubeBGPConfig) (*clusterState, error) {
+	kubeconfig, err := kubeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := newClientset(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClient, err := newDynamicClient(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return listClusterState(ctx, cfg, clientset, dynamicClient)
+}
+
+// listClusterState lists the complete state of the cluster through the
+// given clients, including the Pods of every Node
+func listClusterState(ctx context.Context, cfg *KubeBGPConfig, clientset kubernetes.Interface, dynamicClient dynamic.Interface) (*clusterState, error) {
+
+	state := new(clusterState)
+
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list nodes")
+	}
+	state.Nodes = nodeList.Items
+
+	if cfg.watchesPods() {
+		podList, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list pods")
+		}
+		state.Pods = podList.Items
+	}
+
+	if cfg.Services != nil {
+		serviceList, err := clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list services")
+		}
+
+		for i := range serviceList.Items {
+			if services.Announced(&serviceList.Items[i]) {
+				state.Services = append(state.Services, serviceList.Items[i])
+			}
+		}
+
+		if cfg.LinkBandwidth != nil {
+			endpointsList, err := clientset.CoreV1().Endpoints("").List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list endpoints")
+			}
+
+			state.Endpoints = serviceEndpoints(state.Services, endpointsList.Items)
+		}
+	}
+
+	if cfg.EgressIPs == nil && !cfg.NodeGroups && !cfg.Tenants && !cfg.RouterMaintenance {
+		return state, nil
+	}
+
+	if cfg.EgressIPs != nil {
+		list, err := dynamicClient.Resource(v1alpha1.EgressIPResource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list egress IPs")
+		}
+
+		state.EgressIPs = make([]v1alpha1.EgressIP, len(list.Items))
+		for i := range list.Items {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &state.EgressIPs[i]); err != nil {
+				return nil, errcode.Wrapf(err, errcode.ConfigInvalid, "failed to decode egress IP %s", list.Items[i].GetName())
+			}
+		}
+	}
+
+	if cfg.NodeGroups {
+		list, err := dynamicClient.Resource(v1alpha1.BGPNodeGroupResource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list node groups")
+		}
+
+		groups := make([]v1alpha1.BGPNodeGroup, len(list.Items))
+		for i := range list.Items {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &groups[i]); err != nil {
+				return nil, errcode.Wrapf(err, errcode.ConfigInvalid, "failed to decode node group %s", list.Items[i].GetName())
+			}
+		}
+		state.NodeGroups = validNodeGroups(groups)
+	}
+
+	if cfg.Tenants {
+		list, err := dynamicClient.Resource(v1alpha1.BGPTenantResource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list tenants")
+		}
+
+		tenants := make([]v1alpha1.BGPTenant, len(list.Items))
+		for i := range list.Items {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &tenants[i]); err != nil {
+				return nil, errcode.Wrapf(err, errcode.ConfigInvalid, "failed to decode tenant %s", list.Items[i].GetName())
+			}
+		}
+		state.Tenants = validTenants(tenants)
+	}
+
+	if cfg.RouterMaintenance {
+		list, err := dynamicClient.Resource(v1alpha1.BGPRouterMaintenanceResource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list router maintenances")
+		}
+
+		maintenances := make([]v1alpha1.BGPRouterMaintenance, len(list.Items))
+		for i := range list.Items {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &maintenances[i]); err != nil {
+				return nil, errcode.Wrapf(err, errcode.ConfigInvalid, "failed to decode router maintenance %s", list.Items[i].GetName())
+			}
+		}
+		state.RouterMaintenances = validRouterMaintenances(cfg, maintenances)
+	}
+
+	return state, nil
+}
+
+// nodeState returns the given cluster state as seen by the named Node, whose
+// kube-bgp watches only its own Pods
+func nodeState(state *clusterState, nodeName string) *clusterState {
+	s := *state
+	s.Pods = nil
+
+	for _, pod := range state.Pods {
+		if pod.Spec.NodeName == nodeName {
+			s.Pods = append(s.Pods, pod)
+		}
+	}
+
+	return &s
+}
+
+// writeReport writes the summary of the announcements and acceptance of each Node
+func writeReport(w io.Writer, cfg *KubeBGPConfig, state *clusterState) {
+	title := "kube-bgp announcement report"
+	if cfg.ClusterName != "" {
+		title += " for cluster " + cfg.ClusterName
+	}
+	fmt.Fprintf(w, "%s (AS %s, speaker %s)\n", title, cfg.ASN, cfg.Speaker)
+
+	if cfg.Bootstrap != nil {
+		fmt.Fprintln(w, "Advertisements are held back at startup until the cluster has bootstrapped.")
+	}
+	for i := range state.NodeGroups {
+		if state.NodeGroups[i].Spec.Reflectors > 0 {
+			fmt.Fprintln(w, "Elected route reflectors are not known to the report, so the peers shown ignore the election.")
+			break
+		}
+	}
+	if cfg.watchesPods() && len(state.Pods) == 0 {
+		fmt.Fprintln(w, "No Pods are known, so no Pod routes are shown.")
+	}
+
+	accepts := "all routes (no import policy is applied)"
+	if !cfg.hasOutput(speakerGoBGPD) {
+		accepts = "nothing (the built-in speaker accepts no received routes)"
+	}
+
+	for i := range state.Nodes {
+		n := &state.Nodes[i]
+		s := nodeState(state, n.Name)
+
+		fmt.Fprintf(w, "\nNode %s\n", n.Name)
+
+		if cfg.NodeConditions != nil {
+			if met := cfg.NodeConditions.met(n); len(met) > 0 {
+				fmt.Fprintf(w, "  Service advertisements withdrawn by node conditions %s\n", strings.Join(met, ", "))
+			}
+		}
+
+		announced, _ := desiredRoutes(cfg, n.Name, s, true)
+
+		if cfg.hasOutput(speakerGoBGPD) {
+			byTopology := make(map[string][]Peer)
+			for _, p := range peers(n.Name, cfg, s) {
+				byTopology[p.Topology] = append(byTopology[p.Topology], p)
+			}
+
+			list := byTopology[""]
+			fmt.Fprintf(w, "  iBGP peers (%d): announces all of its routes, accepts %s\n", len(list), accepts)
+			for _, p := range list {
+				fmt.Fprintf(w, "    %s  %s%s\n", p.Address, p.Name, peerNotes(&p))
+			}
+
+			for j := range cfg.Topologies {
+				t := &cfg.Topologies[j]
+
+				list, ok := byTopology[t.Name]
+				if !ok {
+					continue
+				}
+
+				fmt.Fprintf(w, "  iBGP peers in topology %s (%d): announces %s, accepts %s\n", t.Name, len(list), topologyAnnounces(t, announced), accepts)
+				for _, p := range list {
+					fmt.Fprintf(w, "    %s  %s%s\n", p.Address, p.Name, peerNotes(&p))
+				}
+			}
+		}
+
+		routers := localRouters(n.Name, cfg, s)
+		if len(routers) == 0 {
+			fmt.Fprintln(w, "  No routers")
+		}
+
+		for j := range routers {
+			r := &routers[j]
+
+			asn := r.ASN
+			if asn == "" {
+				asn = cfg.ASN
+			}
+
+			fmt.Fprintf(w, "  Router %s (%s, AS %s)\n", r.Ref(), r.Address, asn)
+			fmt.Fprintf(w, "    accepts: %s\n", accepts)
+
+			var count int
+			for _, route := range announced {
+				if ok, _ := exportDecision(cfg, &route, r); !ok {
+					continue
+				}
+
+				if cfg.inDryRun(&route) {
+					fmt.Fprintf(w, "    would announce (dry run): %s%s\n", route.Prefix, routeNotes(&route))
+					continue
+				}
+
+				count++
+				fmt.Fprintf(w, "    announces: %s%s\n", route.Prefix, routeNotes(&route))
+			}
+			if count == 0 {
+				fmt.Fprintln(w, "    announces: nothing")
+			}
+		}
+
+		for _, r := range dryRunRouters(n.Name, cfg, s) {
+			fmt.Fprintf(w, "  Router %s (%s) dry run: no session is configured\n", r.Ref(), r.Address)
+
+			for _, route := range announced {
+				if ok, _ := exportDecision(cfg, &route, &r); ok {
+					fmt.Fprintf(w, "    would announce: %s%s\n", route.Prefix, routeNotes(&route))
+				}
+			}
+		}
+
+		for j := range cfg.Routers {
+			r := &cfg.Routers[j]
+
+			reason := drainReason(r, s)
+			if reason == "" || !(r.PeersWith(n) || inNodeGroups(n, s.NodeGroups, r.PeerNodeGroups)) {
+				continue
+			}
+
+			fmt.Fprintf(w, "  Router %s (%s) drained: %s\n", r.Ref(), r.Address, reason)
+		}
+	}
+}
+
+// topologyAnnounces describes the routes of those given which are announced over the given Topology
+func topologyAnnounces(t *Topology, announced []routes.Route) string {
+	if len(t.Advertisements) == 0 {
+		return "all of its routes"
+	}
+
+	var prefixes []string
+	for i := range announced {
+		if t.exports(&announced[i]) {
+			prefixes = append(prefixes, announced[i].Prefix)
+		}
+	}
+
+	if len(prefixes) == 0 {
+		return "nothing"
+	}
+
+	return strings.Join(prefixes, ", ")
+}
+
+// peerNotes describes the notable settings of the given Peer
+func peerNotes(p *Peer) (notes string) {
+	if p.ASN != "" {
+		notes += " (AS " + p.ASN + ")"
+	}
+	if p.RouteReflectorClient {
+		notes += " (route reflector client)"
+	}
+
+	return notes
+}
+
+// routeNotes describes the attributes of the given Route
+func routeNotes(r *routes.Route) string {
+	var attrs []string
+
+	if r.NextHop != "" {
+		attrs = append(attrs, "next-hop "+r.NextHop)
+	}
+	if r.Origin != "" {
+		attrs = append(attrs, "origin "+string(r.Origin))
+	}
+	if r.AIGP != nil {
+		attrs = append(attrs, fmt.Sprintf("aigp %d", *r.AIGP))
+	}
+	if len(r.ASPath) > 0 {
+		attrs = append(attrs, fmt.Sprintf("as-path %v", r.ASPath))
+	}
+	if r.LocalPref != nil {
+		attrs = append(attrs, fmt.Sprintf("local-pref %d", *r.LocalPref))
+	}
+	if len(r.Communities) > 0 {
+		attrs = append(attrs, "communities "+strings.Join(r.Communities, ","))
+	}
+	if r.VRF != "" {
+		attrs = append(attrs, "vrf "+r.VRF)
+	}
+	if r.Source != "" {
+		attrs = append(attrs, "from "+r.Source)
+	}
+
+	if len(attrs) == 0 {
+		return ""
+	}
+
+	return " (" + strings.Join(attrs, ", ") + ")"
+}
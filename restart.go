@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/rotisserie/eris"
+)
+
+// gobgpdRestartCheckInterval is the interval at which gobgpd is checked for restarts
+var gobgpdRestartCheckInterval = 5 * time.Second
+
+const (
+	// minReapplyBackoff is the initial delay before re-applying desired state which gobgpd failed to accept
+	minReapplyBackoff = time.Second
+
+	// maxReapplyBackoff is the maximum delay before re-applying desired state which gobgpd failed to accept
+	maxReapplyBackoff = time.Minute
+)
+
+// watchGoBGPDRestarts returns a channel which is signaled whenever a new
+// gobgpd process is detected, replacing one which was previously seen
+func watchGoBGPDRestarts(ctx context.Context) <-chan struct{} {
+	restarted := dirty.New()
+
+	go func() {
+		var last string
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(gobgpdRestartCheckInterval):
+			}
+
+			id, err := gobgpdIdentity()
+			if err != nil {
+				continue // gobgpd is not running; it will be detected once it starts
+			}
+
+			if last != "" && id != last {
+				restarted.Set()
+			}
+
+			last = id
+		}
+	}()
+
+	return restarted.C()
+}
+
+// gobgpdIdentity returns a string which uniquely identifies the running
+// gobgpd process: its PID and its start time, so that PID reuse is not
+// mistaken for the same process.
+func gobgpdIdentity() (string, error) {
+	pid, err := findProcess(gobgpdProcessName)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", eris.Wrap(err, "failed to read gobgpd process status")
+	}
+
+	// The process name may contain spaces, so the fields are counted from the
+	// end of the parenthesized name.  The start time is field 22, which is
+	// the 20th field following the name.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return "", eris.New("failed to parse gobgpd process status")
+	}
+
+	return strconv.Itoa(pid) + "@" + fields[19], nil
+}
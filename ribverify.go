@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// ribVerifier verifies that the adj-RIB-out of gobgpd for each of its
+// established neighbors holds exactly the prefixes which should be exported
+// to it, so that the routes which gobgpd accepted but silently drops (such as
+// by a policy), or did not withdraw, are caught
+type ribVerifier struct {
+	client *gobgp.Client
+
+	// last is the list of the discrepancies last found, by which changes are logged
+	last []status.RIBDiscrepancy
+}
+
+func newRIBVerifier(client *gobgp.Client) *ribVerifier {
+	return &ribVerifier{client: client}
+}
+
+// Verify compares the adj-RIB-out of each of the given neighbors with the
+// routes which should be exported to it.  The routes of VRFs are not
+// verified, and neither is a neighbor whose session is not established,
+// since it is advertised nothing.
+func (v *ribVerifier) Verify(ctx context.Context, neighbors []neighborRoutes) error {
+	list, err := v.client.Neighbors(ctx)
+	if err != nil {
+		return err
+	}
+
+	established := make(map[string]bool, len(list))
+	for _, n := range list {
+		established[n.Address] = n.State == gobgp.SessionEstablished
+	}
+
+	var discrepancies []status.RIBDiscrepancy
+
+	metrics.RIBMissingPrefixes.Reset()
+	metrics.RIBUnexpectedPrefixes.Reset()
+
+	for _, n := range neighbors {
+		if !established[n.Address] {
+			continue
+		}
+
+		expected := globalPrefixes(n.Routes)
+
+		advertised := make(map[string]bool)
+		for _, family := range verifiedFamilies(n.Routes) {
+			prefixes, err := v.client.AdvertisedTo(ctx, n.Address, family)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range prefixes {
+				advertised[p] = true
+			}
+		}
+
+		d := status.RIBDiscrepancy{
+			Session: status.Session{Address: n.Address, Name: n.Name},
+		}
+		for p := range expected {
+			if !advertised[p] {
+				d.Missing = append(d.Missing, p)
+			}
+		}
+		for p := range advertised {
+			if !expected[p] {
+				d.Unexpected = append(d.Unexpected, p)
+			}
+		}
+		sort.Strings(d.Missing)
+		sort.Strings(d.Unexpected)
+
+		metrics.RIBMissingPrefixes.WithLabelValues(n.Address).Set(float64(len(d.Missing)))
+		metrics.RIBUnexpectedPrefixes.WithLabelValues(n.Address).Set(float64(len(d.Unexpected)))
+
+		if len(d.Missing) > 0 || len(d.Unexpected) > 0 {
+			discrepancies = append(discrepancies, d)
+		}
+	}
+
+	if !reflect.DeepEqual(discrepancies, v.last) {
+		for _, d := range discrepancies {
+			log.Printf("adj-rib-out of neighbor %s differs from its exports: missing %v, unexpected %v", d.Address, d.Missing, d.Unexpected)
+		}
+		if len(discrepancies) == 0 {
+			log.Println("adj-rib-out of every neighbor matches its exports")
+		}
+	}
+	v.last = discrepancies
+
+	status.SetRIBDiscrepancies(discrepancies)
+
+	return nil
+}
+
+// globalPrefixes returns the prefixes of the given routes which are not of a VRF
+func globalPrefixes(list []routes.Route) map[string]bool {
+	prefixes := make(map[string]bool, len(list))
+	for i := range list {
+		if list[i].VRF == "" {
+			prefixes[list[i].Prefix] = true
+		}
+	}
+
+	return prefixes
+}
+
+// verifiedFamilies returns the address families of the adj-RIB-out which are
+// verified for the given routes: IPv4, and IPv6 if any route is IPv6, since
+// the IPv6 family may not be configured for the neighbor
+func verifiedFamilies(list []routes.Route) []string {
+	for i := range list {
+		if list[i].VRF == "" && list[i].IsIPv6() {
+			return []string{"ipv4", "ipv6"}
+		}
+	}
+
+	return []string{"ipv4"}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// hostnameLabel is the well-known label which holds the hostname of a Node
+const hostnameLabel = "kubernetes.io/hostname"
+
+// Ref returns the name by which the Router is referenced: its Name, if it has one, or else its Address
+func (r *Router) Ref() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return r.Address
+}
+
+// Matches indicates whether the given reference (a name or address) refers to this Router
+func (r *Router) Matches(ref string) bool {
+	return ref == r.Address || (r.Name != "" && ref == r.Name)
+}
+
+// RemotePort returns the remote TCP port of the BGP session to the Router
+func (r *Router) RemotePort() int {
+	if r.Port != 0 {
+		return r.Port
+	}
+
+	return bgpPort
+}
+
+// PeersWith indicates whether the given Node should peer with this Router,
+// either because the Node matches one of its PeerNodes or because the Node
+// references the Router in its routers annotation.
+func (r *Router) PeersWith(n *v1.Node) bool {
+	for _, ref := range routerRefs(n.Annotations) {
+		if r.Matches(ref) {
+			return true
+		}
+	}
+
+	return matchesNode(r.PeerNodes, n)
+}
+
+// matchesNode indicates whether any of the given Node names (or glob
+// patterns) matches any of the aliases of the given Node
+func matchesNode(patterns []string, n *v1.Node) bool {
+	names := nodeAliases(n)
+
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+
+		for _, name := range names {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nodeAliases returns the lower-cased set of names by which a Node may be
+// referenced: its object name, its hostname label, and its provider ID (both
+// in full and as its final path component, which is usually the instance ID).
+func nodeAliases(n *v1.Node) []string {
+	names := []string{strings.ToLower(n.Name)}
+
+	if hostname := n.Labels[hostnameLabel]; hostname != "" {
+		names = append(names, strings.ToLower(hostname))
+	}
+
+	if id := n.Spec.ProviderID; id != "" {
+		names = append(names, strings.ToLower(id))
+
+		if i := strings.LastIndex(id, "/"); i >= 0 && i < len(id)-1 {
+			names = append(names, strings.ToLower(id[i+1:]))
+		}
+	}
+
+	return names
+}
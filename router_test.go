@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeAliases(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		node v1.Node
+		want []string
+	}{
+		{
+			name: "name only",
+			node: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "Node-A"}},
+			want: []string{"node-a"},
+		},
+		{
+			name: "hostname and provider ID",
+			node: v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{hostnameLabel: "Node-A.Example.com"}},
+				Spec:       v1.NodeSpec{ProviderID: "aws:///us-east-1a/I-0123"},
+			},
+			want: []string{"node-a", "node-a.example.com", "aws:///us-east-1a/i-0123", "i-0123"},
+		},
+		{
+			name: "provider ID without a path",
+			node: v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+				Spec:       v1.NodeSpec{ProviderID: "kind://"},
+			},
+			want: []string{"node-a", "kind://"},
+		},
+	} {
+		if got := nodeAliases(&tt.node); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPeersWith(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "rack1-node-a",
+			Labels: map[string]string{hostnameLabel: "host-a"},
+		},
+		Spec: v1.NodeSpec{ProviderID: "gce://project/zone/instance-a"},
+	}
+
+	annotated := node.DeepCopy()
+	annotated.Annotations = map[string]string{routersAnnotation: "edge-b, 10.0.0.253"}
+
+	for _, tt := range []struct {
+		name   string
+		router Router
+		node   *v1.Node
+		want   bool
+	}{
+		{name: "by name", router: Router{Address: "10.0.0.254", PeerNodes: []string{"rack1-node-a"}}, node: node, want: true},
+		{name: "case insensitively", router: Router{Address: "10.0.0.254", PeerNodes: []string{"RACK1-Node-A"}}, node: node, want: true},
+		{name: "by glob", router: Router{Address: "10.0.0.254", PeerNodes: []string{"rack1-*"}}, node: node, want: true},
+		{name: "by hostname", router: Router{Address: "10.0.0.254", PeerNodes: []string{"host-a"}}, node: node, want: true},
+		{name: "by instance ID", router: Router{Address: "10.0.0.254", PeerNodes: []string{"instance-a"}}, node: node, want: true},
+		{name: "by provider ID", router: Router{Address: "10.0.0.254", PeerNodes: []string{"gce://project/zone/instance-a"}}, node: node, want: true},
+		{name: "not matching", router: Router{Address: "10.0.0.254", PeerNodes: []string{"rack2-*"}}, node: node},
+		{name: "no peer nodes", router: Router{Address: "10.0.0.254"}, node: node},
+		{name: "annotation by name", router: Router{Name: "edge-b", Address: "10.0.0.254"}, node: annotated, want: true},
+		{name: "annotation by address", router: Router{Address: "10.0.0.253"}, node: annotated, want: true},
+		{name: "annotation of another router", router: Router{Name: "edge-c", Address: "10.0.0.252"}, node: annotated},
+	} {
+		if got := tt.router.PeersWith(tt.node); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
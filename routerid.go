@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/rotisserie/eris"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+// routerIDIndexAnnotation is the Node annotation which records the index of
+// the Node within the router-id pool.  It is also the label of the Leases by
+// which the indexes are claimed.
+const routerIDIndexAnnotation = "kube-bgp.cycoresystems.com/router-id-index"
+
+// RouterIDPool describes the pool from which the router-ids of the Nodes are
+// allocated, so that they are unique and stable even where the addresses of
+// the Nodes are not (such as where they overlap across VRFs)
+type RouterIDPool struct {
+	// CIDR is the IPv4 network from which the router-ids are allocated
+	CIDR string `yaml:"cidr"`
+}
+
+func (p *RouterIDPool) validate() error {
+	ip, _, err := net.ParseCIDR(p.CIDR)
+	if err != nil {
+		return eris.Wrapf(err, "invalid cidr %q", p.CIDR)
+	}
+
+	if ip.To4() == nil {
+		return eris.Errorf("cidr %s is not an IPv4 network", p.CIDR)
+	}
+
+	return nil
+}
+
+// network returns the network of the pool, and the offset of its first
+// router-id, which skips the network address of all but a /32
+func (p *RouterIDPool) network() (network *net.IPNet, offset int) {
+	_, network, _ = net.ParseCIDR(p.CIDR) // nolint: errcheck
+
+	if ones, _ := network.Mask.Size(); ones < 32 {
+		offset = 1
+	}
+
+	return network, offset
+}
+
+// capacity returns the number of router-ids in the pool
+func (p *RouterIDPool) capacity() int {
+	network, offset := p.network()
+
+	ones, bits := network.Mask.Size()
+	if bits-ones > 30 {
+		return 1<<30 - offset
+	}
+
+	return 1<<uint(bits-ones) - offset
+}
+
+// address returns the router-id of the given index of the pool
+func (p *RouterIDPool) address(index int) net.IP {
+	network, offset := p.network()
+
+	base := network.IP.To4()
+	n := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	n += uint32(index + offset)
+
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).To4()
+}
+
+// poolRouterID returns the router-id of the given Node from the pool, by its
+// index annotation, or nil if it has none
+func poolRouterID(n *v1.Node, pool *RouterIDPool) (net.IP, error) {
+	s, ok := n.Annotations[routerIDIndexAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	index, err := strconv.Atoi(s)
+	if err != nil || index < 0 || index >= pool.capacity() {
+		return nil, eris.Errorf("invalid %s annotation %q on node %s", routerIDIndexAnnotation, s, n.Name)
+	}
+
+	return pool.address(index), nil
+}
+
+// routerIDLeaseName returns the name of the Lease which claims the given index of the router-id pool
+func routerIDLeaseName(index int) string {
+	return "kube-bgp-router-id-" + strconv.Itoa(index)
+}
+
+// allocateRouterID allocates an index of the router-id pool to the named
+// Node, if it does not already hold one, and records it in the Node's index
+// annotation.  Each index is claimed by a Lease in the given namespace, which
+// is owned by the Node (and so released along with it), so that no two Nodes
+// may claim the same index.
+func allocateRouterID(ctx context.Context, client kubernetes.Interface, namespace, nodeName string, pool *RouterIDPool) (net.IP, error) {
+	n, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get node %s", nodeName)
+	}
+
+	leases := client.CoordinationV1().Leases(namespace)
+
+	if s, ok := n.Annotations[routerIDIndexAnnotation]; ok {
+		if index, err := strconv.Atoi(s); err == nil && index >= 0 && index < pool.capacity() {
+			held, err := claimRouterID(ctx, leases, n, index)
+			if err != nil {
+				return nil, err
+			}
+			if held {
+				return pool.address(index), nil
+			}
+		}
+
+		log.Printf("router-id index %s of node %s is invalid or held by another node; reallocating", s, nodeName)
+	}
+
+	list, err := leases.List(ctx, metav1.ListOptions{LabelSelector: routerIDIndexAnnotation})
+	if err != nil {
+		return nil, errcode.Wrap(err, errcode.APIServerUnreachable, "failed to list router-id leases")
+	}
+
+	// holders are the holders of the claimed indexes, by Lease name
+	holders := make(map[string]string, len(list.Items))
+	for _, l := range list.Items {
+		if l.Spec.HolderIdentity != nil {
+			holders[l.Name] = *l.Spec.HolderIdentity
+		}
+	}
+
+	for index := 0; index < pool.capacity(); index++ {
+		// An index already claimed by this Node, such as one whose
+		// annotation failed, is taken up again.
+		holder, claimed := holders[routerIDLeaseName(index)]
+		if claimed && holder != nodeName {
+			continue
+		}
+
+		if !claimed {
+			held, err := claimRouterID(ctx, leases, n, index)
+			if err != nil {
+				return nil, err
+			}
+			if !held {
+				continue // another Node got there first
+			}
+		}
+
+		if err := annotateRouterID(ctx, client, nodeName, index); err != nil {
+			return nil, err
+		}
+
+		log.Printf("allocated router-id %s (index %d) to node %s", pool.address(index), index, nodeName)
+
+		return pool.address(index), nil
+	}
+
+	return nil, errcode.New(errcode.ConfigInvalid, "router-id pool "+pool.CIDR+" is exhausted")
+}
+
+// claimRouterID claims the given index of the router-id pool for the given
+// Node, returning whether the Node holds it
+func claimRouterID(ctx context.Context, leases coordinationclient.LeaseInterface, n *v1.Node, index int) (bool, error) {
+	name := routerIDLeaseName(index)
+
+	l, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return l.Spec.HolderIdentity != nil && *l.Spec.HolderIdentity == n.Name, nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get router-id lease %s", name)
+	}
+
+	acquired := metav1.NowMicro()
+
+	_, err = leases.Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{routerIDIndexAnnotation: strconv.Itoa(index)},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Node",
+				Name:       n.Name,
+				UID:        n.UID,
+			}},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity: &n.Name,
+			AcquireTime:    &acquired,
+		},
+	}, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to create router-id lease %s", name)
+	}
+
+	return true, nil
+}
+
+// annotateRouterID records the given index of the router-id pool in the index annotation of the named Node
+func annotateRouterID(ctx context.Context, client kubernetes.Interface, nodeName string, index int) error {
+	for {
+		n, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to get node %s", nodeName)
+		}
+
+		if n.Annotations == nil {
+			n.Annotations = make(map[string]string)
+		}
+		n.Annotations[routerIDIndexAnnotation] = strconv.Itoa(index)
+
+		_, err = client.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to annotate node %s with its router-id index", nodeName)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRouterIDPool(t *testing.T) {
+	for _, tt := range []struct {
+		cidr     string
+		capacity int
+		first    string
+		last     string
+	}{
+		{cidr: "10.255.0.0/24", capacity: 255, first: "10.255.0.1", last: "10.255.0.255"},
+		{cidr: "10.255.0.8/30", capacity: 3, first: "10.255.0.9", last: "10.255.0.11"},
+		{cidr: "10.255.0.7/32", capacity: 1, first: "10.255.0.7", last: "10.255.0.7"},
+		{cidr: "10.0.0.0/1", capacity: 1<<30 - 1, first: "0.0.0.1"},
+	} {
+		p := &RouterIDPool{CIDR: tt.cidr}
+		if err := p.validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := p.capacity(); got != tt.capacity {
+			t.Errorf("%s: expected capacity %d, got %d", tt.cidr, tt.capacity, got)
+		}
+
+		if got := p.address(0); !got.Equal(net.ParseIP(tt.first)) {
+			t.Errorf("%s: expected first router-id %s, got %s", tt.cidr, tt.first, got)
+		}
+
+		if tt.last != "" {
+			if got := p.address(tt.capacity - 1); !got.Equal(net.ParseIP(tt.last)) {
+				t.Errorf("%s: expected last router-id %s, got %s", tt.cidr, tt.last, got)
+			}
+		}
+	}
+
+	for _, cidr := range []string{"", "10.255.0.0", "2001:db8::/64"} {
+		if err := (&RouterIDPool{CIDR: cidr}).validate(); err == nil {
+			t.Errorf("%q: expected an error", cidr)
+		}
+	}
+}
+
+func TestAllocateRouterID(t *testing.T) {
+	const namespace = "kube-bgp"
+
+	ctx := context.Background()
+
+	node := func(name string, annotations map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+
+	// The Lease of index 0 is held by a Node which no longer claims it by annotation.
+	gone := "node-gone"
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerIDLeaseName(0),
+			Namespace: namespace,
+			Labels:    map[string]string{routerIDIndexAnnotation: "0"},
+		},
+		Spec: coordinationv1.LeaseSpec{HolderIdentity: &gone},
+	}
+
+	client := fake.NewSimpleClientset(
+		lease,
+		node("node-a", nil),
+		node("node-b", nil),
+		node("node-c", map[string]string{routerIDIndexAnnotation: "0"}),
+	)
+	pool := &RouterIDPool{CIDR: "10.255.0.0/30"}
+
+	for _, tt := range []struct {
+		node string
+		want string
+	}{
+		{node: "node-a", want: "10.255.0.2"},
+		{node: "node-b", want: "10.255.0.3"},
+
+		// An allocated Node keeps its index.
+		{node: "node-a", want: "10.255.0.2"},
+	} {
+		ip, err := allocateRouterID(ctx, client, namespace, tt.node, pool)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.node, err)
+		}
+		if !ip.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("%s: expected router-id %s, got %s", tt.node, tt.want, ip)
+		}
+
+		n, err := client.CoreV1().Nodes().Get(ctx, tt.node, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := poolRouterID(n, pool); err != nil || !got.Equal(ip) {
+			t.Errorf("%s: expected the annotation to record %s, got %s, %v", tt.node, ip, got, err)
+		}
+	}
+
+	// The index annotated on node-c is held by another Node, and the pool is
+	// otherwise exhausted.
+	if ip, err := allocateRouterID(ctx, client, namespace, "node-c", pool); err == nil {
+		t.Errorf("expected the pool to be exhausted, got %s", ip)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+)
+
+// drainReason returns the reason for which every Node drains its session
+// with the given Router, or the empty string if it does not
+func drainReason(r *Router, state *clusterState) string {
+	if r.Disabled {
+		return "disabled"
+	}
+
+	for _, m := range state.RouterMaintenances {
+		if !r.Matches(m.Spec.Router) {
+			continue
+		}
+
+		if m.Spec.Reason != "" {
+			return "under maintenance (" + m.Spec.Reason + ")"
+		}
+
+		return "under maintenance"
+	}
+
+	return ""
+}
+
+// validRouterMaintenances returns the BGPRouterMaintenances of the list which
+// reference a configured Router, reporting an error for each which does not
+func validRouterMaintenances(cfg *KubeBGPConfig, list []v1alpha1.BGPRouterMaintenance) (valid []v1alpha1.BGPRouterMaintenance) {
+	for _, m := range list {
+		if cfg.router(m.Spec.Router) == nil {
+			status.Error(errcode.New(errcode.ConfigInvalid, "router maintenance "+m.Name+" references unknown router "+m.Spec.Router))
+			continue
+		}
+
+		valid = append(valid, m)
+	}
+
+	return valid
+}
+
+// routerMaintenanceName returns the name of the BGPRouterMaintenance created for the given Router
+func routerMaintenanceName(r *Router) string {
+	name := strings.ToLower(r.Ref())
+
+	return strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '.' {
+			return c
+		}
+		return '-'
+	}, name)
+}
+
+// routerMaintenanceHandler returns an http.Handler which places a Router
+// under maintenance (`/routers/disable`) or returns it to service
+// (`/routers/enable`) across every Node at once, by creating or deleting its
+// BGPRouterMaintenance.  It is served only by the admin API.
+func routerMaintenanceHandler(cfg *KubeBGPConfig, client dynamic.Interface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST is required", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ref := r.URL.Query().Get("router")
+
+		router := cfg.router(ref)
+		if router == nil {
+			http.Error(w, "unknown router "+ref, http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		resource := client.Resource(v1alpha1.BGPRouterMaintenanceResource)
+
+		switch r.URL.Path {
+		case "/routers/disable":
+			m := &v1alpha1.BGPRouterMaintenance{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: v1alpha1.Group + "/" + v1alpha1.Version,
+					Kind:       "BGPRouterMaintenance",
+				},
+				ObjectMeta: metav1.ObjectMeta{Name: routerMaintenanceName(router)},
+				Spec: v1alpha1.BGPRouterMaintenanceSpec{
+					Router: router.Ref(),
+					Reason: r.URL.Query().Get("reason"),
+				},
+			}
+
+			obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(m)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := resource.Create(ctx, &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{}); err != nil {
+				http.Error(w, "failed to create router maintenance: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			fmt.Fprintf(w, "router %s disabled\n", router.Ref()) // nolint: errcheck
+		case "/routers/enable":
+			list, err := resource.List(ctx, metav1.ListOptions{})
+			if err != nil {
+				http.Error(w, "failed to list router maintenances: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			// A BGPRouterMaintenance may have been created by hand, under any name
+			for i := range list.Items {
+				var m v1alpha1.BGPRouterMaintenance
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &m); err != nil {
+					continue
+				}
+				if !router.Matches(m.Spec.Router) {
+					continue
+				}
+
+				if err := resource.Delete(ctx, m.Name, metav1.DeleteOptions{}); err != nil {
+					http.Error(w, "failed to delete router maintenance "+m.Name+": "+err.Error(), http.StatusBadGateway)
+					return
+				}
+			}
+
+			fmt.Fprintf(w, "router %s enabled\n", router.Ref()) // nolint: errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// runRouter implements the `router` command, which asks the running kube-bgp
+// to disable (drain) or enable a Router across every Node.  It returns the
+// process exit code.
+func runRouter(args []string) int {
+	if len(args) == 0 || (args[0] != "disable" && args[0] != "enable") {
+		fmt.Fprintln(os.Stderr, "usage: kube-bgp router disable|enable -router <name or address> [-reason <reason>]") // nolint: errcheck
+		return 1
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("router "+action, flag.ExitOnError)
+	router := fs.String("router", "", "name or address of the router")
+	reason := fs.String("reason", "", "reason for the maintenance of the router")
+	fs.Parse(args[1:]) // nolint: errcheck
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read configuration:", err) // nolint: errcheck
+		return 1
+	}
+
+	if !cfg.RouterMaintenance {
+		fmt.Fprintln(os.Stderr, "routerMaintenance is not enabled") // nolint: errcheck
+		return 1
+	}
+
+	body, err := adminRequest(cfg, http.MethodPost, "/routers/"+action, url.Values{"router": {*router}, "reason": {*reason}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "router %s failed: %v\n", action, err) // nolint: errcheck
+		return 1
+	}
+
+	fmt.Print(string(body))
+
+	return 0
+}
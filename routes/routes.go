@@ -0,0 +1,280 @@
+// Package routes maintains the set of routes advertised by this Node
+package routes
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rotisserie/eris"
+)
+
+// Origin is the BGP ORIGIN attribute of a route
+type Origin string
+
+const (
+	// OriginIGP indicates that the route originated within the AS
+	OriginIGP Origin = "igp"
+
+	// OriginEGP indicates that the route was learned via EGP
+	OriginEGP Origin = "egp"
+
+	// OriginIncomplete indicates that the route was learned by some other means
+	OriginIncomplete Origin = "incomplete"
+)
+
+// Valid indicates whether the Origin is one of the known values.  The empty Origin is valid and is treated as IGP.
+func (o Origin) Valid() bool {
+	switch o {
+	case "", OriginIGP, OriginEGP, OriginIncomplete:
+		return true
+	}
+
+	return false
+}
+
+// Route is a single prefix advertised by this Node, along with its path attributes
+type Route struct {
+	// Prefix is the CIDR of the route
+	Prefix string `json:"prefix"`
+
+	// Origin is the ORIGIN attribute of the route.
+	// If empty, IGP is used.
+	Origin Origin `json:"origin,omitempty"`
+
+	// AIGP is the optional Accumulated IGP Metric of the route
+	AIGP *uint32 `json:"aigp,omitempty"`
+
+	// ASPath is the optional AS_PATH with which the route is originated, such
+	// as the local AS repeated to prepend it.  The speaker prepends its own
+	// AS to it toward eBGP neighbors, as usual.
+	ASPath []uint32 `json:"asPath,omitempty"`
+
+	// LocalPref is the optional LOCAL_PREF of the route, sent to iBGP
+	// neighbors.  If nil, the default of the speaker (100) is used.
+	LocalPref *uint32 `json:"localPref,omitempty"`
+
+	// NextHop is the optional next-hop address of the route.
+	// If empty, the speaker chooses the next-hop itself.
+	NextHop string `json:"nextHop,omitempty"`
+
+	// Communities is the optional list of standard communities of the route,
+	// each in the form `<asn>:<value>`
+	Communities []string `json:"communities,omitempty"`
+
+	// LinkBandwidth is the optional bandwidth, in bytes per second, carried in
+	// the link-bandwidth extended community of the route, by which routers
+	// performing weighted ECMP apportion traffic between its next-hops
+	LinkBandwidth *float32 `json:"linkBandwidth,omitempty"`
+
+	// VRF is the name of the VRF (that of a BGPTenant) in which the route is
+	// exported as a VPN route, with the route distinguisher and route
+	// targets of the VRF.  If empty, the route is in the global table.
+	VRF string `json:"vrf,omitempty"`
+
+	// Routers is the list of addresses of the Routers to which the route is
+	// exported, such as those named by the routers annotation of a Service.
+	// If empty, it is exported to every Router.
+	Routers []string `json:"routers,omitempty"`
+
+	// Source describes what caused the route to be originated, such as
+	// `advertisement/<name>`, `pod/<namespace>/<name>`, `egressip/<name>`,
+	// or `announce/<namespace>/<serviceaccount>`.  It is not sent to
+	// neighbors; it answers who asked for the route.
+	Source string `json:"source,omitempty"`
+
+	// Exclusive indicates that the route should be originated by only a
+	// single Node at a time, such as the host route of a Pod or an EgressIP.
+	// It is not sent to neighbors; it is used to detect conflicting claims.
+	Exclusive bool `json:"exclusive,omitempty"`
+}
+
+// IsIPv6 indicates whether the Route is for an IPv6 prefix
+func (r *Route) IsIPv6() bool {
+	ip, _, err := net.ParseCIDR(r.Prefix)
+	if err != nil {
+		return false
+	}
+
+	return ip.To4() == nil
+}
+
+// ExportedTo indicates whether the Route is exported to the Router of the given address
+func (r *Route) ExportedTo(address string) bool {
+	if len(r.Routers) == 0 {
+		return true
+	}
+
+	for _, a := range r.Routers {
+		if a == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseCommunity parses a standard community in the form `<asn>:<value>`
+func ParseCommunity(s string) (uint32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, eris.Errorf("invalid community %q", s)
+	}
+
+	asn, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, eris.Wrapf(err, "invalid community %q", s)
+	}
+
+	value, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, eris.Wrapf(err, "invalid community %q", s)
+	}
+
+	return uint32(asn)<<16 | uint32(value), nil
+}
+
+// Speaker is a BGP speaker which can originate routes
+type Speaker interface {
+
+	// AddPath originates the given Route, replacing any existing Route with the same prefix in the same VRF
+	AddPath(ctx context.Context, r Route) error
+
+	// DeletePath withdraws the given Route
+	DeletePath(ctx context.Context, r Route) error
+}
+
+// Refresher is a Speaker which can re-send an originated Route to its
+// neighbors without withdrawing it
+type Refresher interface {
+
+	// RefreshPath re-sends the given, already-originated Route
+	RefreshPath(ctx context.Context, r Route) error
+}
+
+// key identifies a Route among those originated by a Speaker: the same
+// prefix may be originated in several VRFs, such as by tenants whose
+// addresses overlap
+type key struct {
+	vrf    string
+	prefix string
+}
+
+func keyOf(r Route) key {
+	return key{vrf: r.VRF, prefix: r.Prefix}
+}
+
+func (k key) String() string {
+	if k.vrf == "" {
+		return k.prefix
+	}
+
+	return k.prefix + " in VRF " + k.vrf
+}
+
+// Advertiser maintains the set of Routes originated by a Speaker
+type Advertiser struct {
+	speaker Speaker
+
+	// applied is the set of Routes which have been successfully originated, keyed by VRF and prefix
+	applied map[key]Route
+}
+
+// NewAdvertiser returns a new Advertiser for the given Speaker
+func NewAdvertiser(speaker Speaker) *Advertiser {
+	return &Advertiser{
+		speaker: speaker,
+		applied: make(map[key]Route),
+	}
+}
+
+// Reset forgets which Routes have been originated, so that the next Apply
+// originates every desired Route.  This should be called whenever the Speaker
+// is known to have lost its state (e.g. it has restarted).
+func (a *Advertiser) Reset() {
+	a.applied = make(map[key]Route)
+}
+
+// Applied returns the Routes which have been successfully originated, sorted by prefix and VRF
+func (a *Advertiser) Applied() []Route {
+	list := make([]Route, 0, len(a.applied))
+	for _, r := range a.applied {
+		list = append(list, r)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Prefix != list[j].Prefix {
+			return list[i].Prefix < list[j].Prefix
+		}
+
+		return list[i].VRF < list[j].VRF
+	})
+
+	return list
+}
+
+// Refresh re-originates the previously-originated Route for the given
+// prefix in the given VRF (or the global table, if empty), without
+// withdrawing it
+func (a *Advertiser) Refresh(ctx context.Context, vrf, prefix string) error {
+	k := key{vrf: vrf, prefix: prefix}
+
+	r, ok := a.applied[k]
+	if !ok {
+		return eris.Errorf("%s is not advertised", k)
+	}
+
+	if refresher, ok := a.speaker.(Refresher); ok {
+		return refresher.RefreshPath(ctx, r)
+	}
+
+	return a.speaker.AddPath(ctx, r)
+}
+
+// Apply originates each of the desired Routes which is new or changed and
+// withdraws each previously-originated Route which is no longer desired.
+func (a *Advertiser) Apply(ctx context.Context, desired []Route) error {
+	want := make(map[key]Route, len(desired))
+	for _, r := range desired {
+		want[keyOf(r)] = r
+	}
+
+	var errs []error
+
+	// A route which is no longer desired, or which has moved to another VRF, is withdrawn.
+	for k, r := range a.applied {
+		if _, ok := want[k]; ok {
+			continue
+		}
+
+		if err := a.speaker.DeletePath(ctx, r); err != nil {
+			errs = append(errs, eris.Wrapf(err, "failed to withdraw %s", k))
+			continue
+		}
+
+		delete(a.applied, k)
+	}
+
+	for k, r := range want {
+		if old, ok := a.applied[k]; ok && reflect.DeepEqual(old, r) {
+			continue
+		}
+
+		if err := a.speaker.AddPath(ctx, r); err != nil {
+			errs = append(errs, eris.Wrapf(err, "failed to advertise %s", k))
+			continue
+		}
+
+		a.applied[k] = r
+	}
+
+	if len(errs) > 0 {
+		// Report the first error; the rest will be retried on the next Apply.
+		return errs[0]
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fakeSpeaker is a Speaker which records the paths added and deleted, and
+// fails those of the given prefixes
+type fakeSpeaker struct {
+	added, deleted []string
+	fail           map[string]bool
+}
+
+func (s *fakeSpeaker) AddPath(ctx context.Context, r Route) error {
+	if s.fail[r.Prefix] {
+		return errors.New("refused")
+	}
+
+	s.added = append(s.added, keyOf(r).String())
+	return nil
+}
+
+func (s *fakeSpeaker) DeletePath(ctx context.Context, r Route) error {
+	if s.fail[r.Prefix] {
+		return errors.New("refused")
+	}
+
+	s.deleted = append(s.deleted, keyOf(r).String())
+	return nil
+}
+
+func TestAdvertiserApply(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		applied, desired []Route
+		added, deleted   []string
+	}{
+		{
+			name:    "new routes",
+			desired: []Route{{Prefix: "10.0.0.1/32"}, {Prefix: "10.0.0.2/32"}},
+			added:   []string{"10.0.0.1/32", "10.0.0.2/32"},
+		},
+		{
+			name:    "unchanged",
+			applied: []Route{{Prefix: "10.0.0.1/32"}},
+			desired: []Route{{Prefix: "10.0.0.1/32"}},
+		},
+		{
+			name:    "withdrawn",
+			applied: []Route{{Prefix: "10.0.0.1/32"}, {Prefix: "10.0.0.2/32"}},
+			desired: []Route{{Prefix: "10.0.0.1/32"}},
+			deleted: []string{"10.0.0.2/32"},
+		},
+		{
+			name:    "changed attributes",
+			applied: []Route{{Prefix: "10.0.0.1/32"}},
+			desired: []Route{{Prefix: "10.0.0.1/32", Communities: []string{"64512:1"}}},
+			added:   []string{"10.0.0.1/32"},
+		},
+		{
+			name:    "moved to a VRF",
+			applied: []Route{{Prefix: "10.0.0.1/32"}},
+			desired: []Route{{Prefix: "10.0.0.1/32", VRF: "blue"}},
+			added:   []string{"10.0.0.1/32 in VRF blue"},
+			deleted: []string{"10.0.0.1/32"},
+		},
+		{
+			name:    "same prefix in two VRFs",
+			desired: []Route{{Prefix: "10.0.0.1/32", VRF: "blue"}, {Prefix: "10.0.0.1/32", VRF: "red"}},
+			added:   []string{"10.0.0.1/32 in VRF blue", "10.0.0.1/32 in VRF red"},
+		},
+	} {
+		s := new(fakeSpeaker)
+		a := NewAdvertiser(s)
+
+		if err := a.Apply(context.Background(), tt.applied); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		s.added = nil
+
+		if err := a.Apply(context.Background(), tt.desired); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		sort.Strings(s.added)
+		if !reflect.DeepEqual(s.added, tt.added) || !reflect.DeepEqual(s.deleted, tt.deleted) {
+			t.Errorf("%s: expected to add %v and delete %v, added %v and deleted %v", tt.name, tt.added, tt.deleted, s.added, s.deleted)
+		}
+
+		if got := a.Applied(); len(got) != len(tt.desired) {
+			t.Errorf("%s: expected %d routes applied, got %v", tt.name, len(tt.desired), got)
+		}
+	}
+}
+
+func TestAdvertiserRetriesFailures(t *testing.T) {
+	s := &fakeSpeaker{fail: map[string]bool{"10.0.0.2/32": true}}
+	a := NewAdvertiser(s)
+
+	desired := []Route{{Prefix: "10.0.0.1/32"}, {Prefix: "10.0.0.2/32"}}
+
+	if err := a.Apply(context.Background(), desired); err == nil {
+		t.Fatal("expected the failure to be returned")
+	}
+	if got := a.Applied(); len(got) != 1 || got[0].Prefix != "10.0.0.1/32" {
+		t.Fatalf("expected only the accepted route to be applied, got %v", got)
+	}
+
+	s.fail, s.added = nil, nil
+
+	if err := a.Apply(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.added, []string{"10.0.0.2/32"}) {
+		t.Errorf("expected only the failed route to be retried, added %v", s.added)
+	}
+}
+
+func TestAdvertiserReset(t *testing.T) {
+	s := new(fakeSpeaker)
+	a := NewAdvertiser(s)
+
+	desired := []Route{{Prefix: "10.0.0.1/32"}}
+	if err := a.Apply(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Reset()
+	s.added = nil
+
+	if err := a.Apply(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.added) != 1 {
+		t.Errorf("expected every route to be re-added after Reset, added %v", s.added)
+	}
+}
+
+func TestAdvertiserRefresh(t *testing.T) {
+	s := new(fakeSpeaker)
+	a := NewAdvertiser(s)
+
+	if err := a.Apply(context.Background(), []Route{{Prefix: "10.0.0.1/32", VRF: "blue"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.added = nil
+
+	if err := a.Refresh(context.Background(), "", "10.0.0.1/32"); err == nil {
+		t.Error("expected a prefix not advertised in the global table to be refused")
+	}
+
+	if err := a.Refresh(context.Background(), "blue", "10.0.0.1/32"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.added, []string{"10.0.0.1/32 in VRF blue"}) {
+		t.Errorf("expected the route to be re-added, added %v", s.added)
+	}
+}
+
+func TestParseCommunity(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		want    uint32
+		invalid bool
+	}{
+		{s: "64512:100", want: 64512<<16 | 100},
+		{s: "0:0"},
+		{s: "65535:65535", want: 0xffffffff},
+		{s: "65536:1", invalid: true},
+		{s: "1:65536", invalid: true},
+		{s: "64512", invalid: true},
+		{s: "a:b", invalid: true},
+	} {
+		got, err := ParseCommunity(tt.s)
+		if (err != nil) != tt.invalid {
+			t.Errorf("%s: unexpected error %v", tt.s, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.s, tt.want, got)
+		}
+	}
+}
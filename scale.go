@@ -0,0 +1,201 @@
+//go:build scale
+// +build scale
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/nodes"
+	"github.com/CyCoreSystems/kube-bgp/services"
+	"github.com/rotisserie/eris"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// runScale implements the `scale` command, a synthetic scale harness which
+// runs the Node watcher and the reconciliation of the given configuration
+// against thousands of Nodes and Services held by a fake clientset, and
+// reports the latency and allocations of each, as a baseline for changes
+// to the watching and diffing of the cluster state
+func runScale(args []string) int {
+	fs := flag.NewFlagSet("scale", flag.ExitOnError)
+	config := fs.String("config", configFile, "kube-bgp configuration file")
+	nodeCount := fs.Int("nodes", 1000, "number of synthetic Nodes")
+	serviceCount := fs.Int("services", 1000, "number of synthetic LoadBalancer Services")
+	updates := fs.Int("updates", 100, "number of Node address changes to measure")
+	iterations := fs.Int("iterations", 100, "number of reconciles to measure")
+	fs.Parse(args) // nolint: errcheck
+
+	cfg, err := loadConfig(*config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read configuration:", err)
+		return 1
+	}
+
+	if *nodeCount < 1 || *updates < 1 || *iterations < 1 {
+		fmt.Fprintln(os.Stderr, "nodes, updates, and iterations must be positive")
+		return 1
+	}
+
+	if err := measureScale(os.Stdout, cfg, *nodeCount, *serviceCount, *updates, *iterations); err != nil {
+		fmt.Fprintln(os.Stderr, "scale run failed:", err)
+		return 1
+	}
+
+	return 0
+}
+
+// measureScale runs the scale harness, writing its measurements to the given writer
+func measureScale(out io.Writer, cfg *KubeBGPConfig, nodeCount, serviceCount, updates, iterations int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	objects := make([]k8sruntime.Object, 0, nodeCount+serviceCount)
+	for i := 0; i < nodeCount; i++ {
+		objects = append(objects, syntheticNode(i, 0))
+	}
+	for i := 0; i < serviceCount; i++ {
+		objects = append(objects, syntheticService(i))
+	}
+
+	client := fake.NewSimpleClientset(objects...)
+
+	// Each new watch of Nodes is reported, so that a Node is changed only
+	// once the watcher is watching for it.  The fake clientset registers the
+	// watch before any further change is made.
+	watching := make(chan struct{}, 1)
+	client.PrependWatchReactor("nodes", func(k8stesting.Action) (bool, watch.Interface, error) {
+		select {
+		case watching <- struct{}{}:
+		default:
+		}
+		return false, nil, nil
+	})
+
+	thisNode := syntheticNode(0, 0).Name
+
+	// The initial list
+	started := time.Now()
+
+	w, err := nodes.NewWatcher(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	<-w.Changes()
+	initialSync := time.Since(started)
+
+	sw, err := services.NewWatcher(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer sw.Close()
+
+	<-sw.Changes()
+
+	// The Services are announced whether or not the configuration enables them.
+	if cfg.Services == nil {
+		cfg.Services = new(ServiceAdvertisement)
+	}
+
+	observe := func() *clusterState {
+		now := time.Now()
+		return &clusterState{
+			Nodes:           w.Nodes(),
+			Services:        sw.Services(),
+			PodsListed:      now,
+			EgressIPsListed: now,
+		}
+	}
+
+	var sessions, originated int
+
+	// reconcile derives the desired sessions and routes of this Node from
+	// the state, as the reconcile loop does
+	reconcile := func(state *clusterState) {
+		sessions = len(peers(thisNode, cfg, state)) + len(localRouters(thisNode, cfg, state))
+		announced, _ := desiredRoutes(cfg, thisNode, state, true)
+		originated = len(announced)
+	}
+
+	// Reconcile
+	state := observe()
+	reconcile(state) // warm up
+
+	reconcileTimes := make([]time.Duration, iterations)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	for i := range reconcileTimes {
+		started := time.Now()
+		reconcile(state)
+		reconcileTimes[i] = time.Since(started)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	allocs := (after.Mallocs - before.Mallocs) / uint64(iterations)
+	allocBytes := (after.TotalAlloc - before.TotalAlloc) / uint64(iterations)
+
+	// Node address changes, from the update to the end of the resulting reconcile
+	updateTimes := make([]time.Duration, updates)
+
+	for i := range updateTimes {
+		<-watching
+
+		n := syntheticNode(i%nodeCount, i/nodeCount+1)
+
+		started := time.Now()
+		if _, err := client.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+
+		select {
+		case <-w.Changes():
+		case <-time.After(time.Duration(nodes.MaximumCheckIntervalSeconds) * time.Second):
+			return eris.Errorf("change %d of node %s was not signalled", i, n.Name)
+		}
+
+		reconcile(observe())
+		updateTimes[i] = time.Since(started)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "nodes\t%d\n", nodeCount)
+	fmt.Fprintf(tw, "services\t%d\n", serviceCount)
+	fmt.Fprintf(tw, "sessions\t%d\n", sessions)
+	fmt.Fprintf(tw, "routes\t%d\n", originated)
+	fmt.Fprintf(tw, "initial sync\t%s\n", initialSync.Round(time.Microsecond))
+	fmt.Fprintf(tw, "reconcile\t%s\n", latencies(reconcileTimes))
+	fmt.Fprintf(tw, "reconcile allocations\t%d allocs/op, %d KiB/op\n", allocs, allocBytes>>10)
+	fmt.Fprintf(tw, "node change to reconcile\t%s\n", latencies(updateTimes))
+
+	return tw.Flush()
+}
+
+// latencies summarizes the given durations by their percentiles
+func latencies(list []time.Duration) string {
+	sorted := append([]time.Duration(nil), list...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	p := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))].Round(time.Microsecond)
+	}
+
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s", p(0.5), p(0.9), p(0.99), p(1))
+}
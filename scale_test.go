@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/nodes"
+	"github.com/CyCoreSystems/kube-bgp/services"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// benchmarkConfig is the configuration of the scale benchmarks: a full mesh,
+// with a Router to which every Node peers, announcing the synthetic Services
+const benchmarkConfig = `
+asn: "64512"
+featureGates:
+  ServiceAdvertisement: true
+services: {}
+advertisements:
+  - name: anycast
+    prefixes: ["192.0.2.53/32"]
+routers:
+  - name: tor
+    address: 10.255.0.1
+    asn: "64500"
+    peerNodes: ["*"]
+`
+
+// benchmarkSizes are the numbers of Nodes and Services of the scale benchmarks
+var benchmarkSizes = []struct{ nodes, services int }{
+	{100, 100},
+	{1000, 1000},
+	{5000, 3000},
+}
+
+func loadBenchmarkConfig(b *testing.B) *KubeBGPConfig {
+	b.Helper()
+
+	dir, err := ioutil.TempDir("", "kube-bgp-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	filename := filepath.Join(dir, "kube-bgp.yaml")
+	if err := ioutil.WriteFile(filename, []byte(benchmarkConfig), 0600); err != nil {
+		b.Fatal(err)
+	}
+
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return cfg
+}
+
+// syntheticClientset returns a fake clientset holding the given numbers of
+// synthetic Nodes and Services
+func syntheticClientset(nodeCount, serviceCount int) *fake.Clientset {
+	objects := make([]k8sruntime.Object, 0, nodeCount+serviceCount)
+	for i := 0; i < nodeCount; i++ {
+		objects = append(objects, syntheticNode(i, 0))
+	}
+	for i := 0; i < serviceCount; i++ {
+		objects = append(objects, syntheticService(i))
+	}
+
+	return fake.NewSimpleClientset(objects...)
+}
+
+// syntheticState returns the observed state of the given fake clientset, as
+// obtained through the Node and Services watchers
+func syntheticState(ctx context.Context, b *testing.B, client *fake.Clientset) (nodes.Watcher, *clusterState) {
+	b.Helper()
+
+	w, err := nodes.NewWatcher(ctx, client)
+	if err != nil {
+		b.Fatal(err)
+	}
+	<-w.Changes()
+
+	sw, err := services.NewWatcher(ctx, client)
+	if err != nil {
+		b.Fatal(err)
+	}
+	<-sw.Changes()
+
+	now := time.Now()
+
+	return w, &clusterState{
+		Nodes:           w.Nodes(),
+		Services:        sw.Services(),
+		PodsListed:      now,
+		EgressIPsListed: now,
+	}
+}
+
+// reconcileNode derives the desired sessions and routes of the named Node
+// from the given state, as the reconcile loop does
+func reconcileNode(cfg *KubeBGPConfig, thisNode string, state *clusterState) (sessions, originated int) {
+	sessions = len(peers(thisNode, cfg, state)) + len(localRouters(thisNode, cfg, state))
+	announced, _ := desiredRoutes(cfg, thisNode, state, true)
+	originated = len(announced)
+
+	return sessions, originated
+}
+
+// BenchmarkInitialSync measures the initial list of the Nodes by the Node watcher
+func BenchmarkInitialSync(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("nodes=%d", size.nodes), func(b *testing.B) {
+			client := syntheticClientset(size.nodes, 0)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				w, err := nodes.NewWatcher(ctx, client)
+				if err != nil {
+					b.Fatal(err)
+				}
+				<-w.Changes()
+
+				cancel()
+			}
+		})
+	}
+}
+
+// BenchmarkReconcile measures the derivation of the desired state of a Node
+func BenchmarkReconcile(b *testing.B) {
+	cfg := loadBenchmarkConfig(b)
+
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("nodes=%d/services=%d", size.nodes, size.services), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, state := syntheticState(ctx, b, syntheticClientset(size.nodes, size.services))
+			thisNode := syntheticNode(0, 0).Name
+
+			if sessions, _ := reconcileNode(cfg, thisNode, state); sessions == 0 {
+				b.Fatal("synthetic Node has no sessions")
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				reconcileNode(cfg, thisNode, state)
+			}
+		})
+	}
+}
+
+// BenchmarkNodeChange measures the time from a change of the address of a
+// Node to the end of the reconcile which it triggers
+func BenchmarkNodeChange(b *testing.B) {
+	cfg := loadBenchmarkConfig(b)
+
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("nodes=%d/services=%d", size.nodes, size.services), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := syntheticClientset(size.nodes, size.services)
+
+			// A Node is changed only once the watcher is watching for it.
+			watching := make(chan struct{}, 1)
+			client.PrependWatchReactor("nodes", func(k8stesting.Action) (bool, watch.Interface, error) {
+				select {
+				case watching <- struct{}{}:
+				default:
+				}
+				return false, nil, nil
+			})
+
+			w, state := syntheticState(ctx, b, client)
+			thisNode := syntheticNode(0, 0).Name
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				<-watching
+				n := syntheticNode(i%size.nodes, i/size.nodes+1)
+				b.StartTimer()
+
+				if _, err := client.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{}); err != nil {
+					b.Fatal(err)
+				}
+
+				select {
+				case <-w.Changes():
+				case <-time.After(time.Duration(nodes.MaximumCheckIntervalSeconds) * time.Second):
+					b.Fatalf("change of node %s was not signalled", n.Name)
+				}
+
+				state.Nodes = w.Nodes()
+				reconcileNode(cfg, thisNode, state)
+			}
+		})
+	}
+}
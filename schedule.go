@@ -0,0 +1,285 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	// The time zone database is embedded, since the container may have none.
+	_ "time/tzdata"
+
+	"github.com/rotisserie/eris"
+)
+
+// cronHorizon is the furthest ahead that the start of a schedule window is
+// sought, which covers every day of the week falling on every date
+const cronHorizon = 8 * 366 * 24 * time.Hour
+
+// Schedule restricts the announcement of an Advertisement to time windows,
+// such as a migration VIP to its cutover window.  Outside of every window,
+// the routes of the Advertisement are withdrawn.
+type Schedule struct {
+	// Timezone is the IANA time zone (such as `Europe/London`) in which the
+	// windows start.  This is optional, and if not supplied, it is UTC.
+	Timezone string `yaml:"timezone"`
+
+	// Windows is the list of the time windows during which the routes are
+	// announced
+	Windows []ScheduleWindow `yaml:"windows"`
+}
+
+// ScheduleWindow is a recurring time window
+type ScheduleWindow struct {
+	// Start is the cron expression (minute, hour, day of month, month, and
+	// day of week) of the times at which the window opens
+	Start string `yaml:"start"`
+
+	// DurationMinutes is the time for which the window stays open
+	DurationMinutes int `yaml:"durationMinutes"`
+}
+
+func (s *Schedule) validate() error {
+	loc, err := s.location()
+	if err != nil {
+		return err
+	}
+
+	if len(s.Windows) == 0 {
+		return eris.New("windows is required")
+	}
+
+	now := time.Now().In(loc)
+
+	for _, w := range s.Windows {
+		spec, err := parseCron(w.Start)
+		if err != nil {
+			return eris.Wrapf(err, "invalid start %q", w.Start)
+		}
+
+		if spec.next(now).IsZero() {
+			return eris.Errorf("start %q never occurs", w.Start)
+		}
+
+		if w.DurationMinutes <= 0 {
+			return eris.Errorf("invalid durationMinutes %d", w.DurationMinutes)
+		}
+	}
+
+	return nil
+}
+
+// location returns the time zone of the Schedule
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, eris.Wrapf(err, "invalid timezone %q", s.Timezone)
+	}
+
+	return loc, nil
+}
+
+// active indicates whether any window of the Schedule is open at the given
+// time, and returns the time at which that may next change
+func (s *Schedule) active(now time.Time) (open bool, change time.Time) {
+	loc, err := s.location()
+	if err != nil {
+		return false, time.Time{} // validated at load
+	}
+	now = now.In(loc)
+
+	earliest := func(t time.Time) {
+		if !t.IsZero() && (change.IsZero() || t.Before(change)) {
+			change = t
+		}
+	}
+
+	for _, w := range s.Windows {
+		spec, err := parseCron(w.Start)
+		if err != nil {
+			continue // validated at load
+		}
+		duration := time.Duration(w.DurationMinutes) * time.Minute
+
+		// The window is open if it last opened less than its duration ago.
+		if start := spec.next(now.Add(-duration)); !start.IsZero() && !start.After(now) {
+			open = true
+			earliest(start.Add(duration))
+		}
+
+		earliest(spec.next(now))
+	}
+
+	return open, change
+}
+
+// nextScheduleChange returns the time at which the window of the Schedule of
+// any Advertisement next opens or closes, or the zero time if none has one
+func (c *KubeBGPConfig) nextScheduleChange(now time.Time) (next time.Time) {
+	for _, a := range c.Advertisements {
+		if a.Schedule == nil {
+			continue
+		}
+
+		if _, change := a.Schedule.active(now); !change.IsZero() && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+
+	return next
+}
+
+// cronSpec is a parsed cron expression, with a bit set per field of the
+// values which it matches
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny indicate that the day of month or day of week is
+	// unrestricted; if both are restricted, a day matching either matches
+	domAny, dowAny bool
+}
+
+// cronField describes the range and the names of the values of a cron field
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// parseCron parses a cron expression of five fields: minute, hour, day of
+// month, month (1-12 or jan-dec), and day of week (0-7, where both 0 and 7
+// are Sunday, or sun-sat).  Each field is `*` or a comma-separated list of
+// values and ranges (`a-b`), each optionally with a step (`/n`).
+func parseCron(s string) (*cronSpec, error) {
+	fields := strings.Fields(s)
+	if len(fields) != len(cronFields) {
+		return nil, eris.Errorf("expected %d fields, found %d", len(cronFields), len(fields))
+	}
+
+	var bits [5]uint64
+	for i, f := range fields {
+		v, err := cronFields[i].parse(f)
+		if err != nil {
+			return nil, eris.Wrapf(err, "invalid %s %q", cronFields[i].name, f)
+		}
+		bits[i] = v
+	}
+
+	// Sunday may be given as 7, as well as 0.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &cronSpec{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parse returns the bit set of the values of the given field expression
+func (f *cronField) parse(s string) (bits uint64, err error) {
+	for _, term := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(term, "/"); i >= 0 {
+			if step, err = strconv.Atoi(term[i+1:]); err != nil || step < 1 {
+				return 0, eris.Errorf("invalid step %q", term[i+1:])
+			}
+			term = term[:i]
+		}
+
+		lo, hi := f.min, f.max
+		if term != "*" {
+			bounds := strings.SplitN(term, "-", 2)
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = f.max // `a/n` runs from a to the end of the range
+			}
+			if hi < lo {
+				return 0, eris.Errorf("invalid range %q", term)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// value parses a single value of the field, as a number or a name
+func (f *cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, eris.Errorf("invalid value %q: must be between %d and %d", s, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// matchesDay indicates whether the day of the given time matches
+func (c *cronSpec) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// next returns the first time strictly after the given one, to the minute
+// and in its time zone, which matches, or the zero time if there is none
+// within the cronHorizon
+func (c *cronSpec) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronHorizon)
+	loc := t.Location()
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
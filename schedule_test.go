@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want cronSpec
+	}{
+		{spec: "* * * * *", want: cronSpec{minute: 1<<60 - 1, hour: 1<<24 - 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1<<8 - 1, domAny: true, dowAny: true}},
+		{spec: "0,30 2 1 jan mon", want: cronSpec{minute: 1 | 1<<30, hour: 1 << 2, dom: 1 << 1, month: 1 << 1, dow: 1 << 1}},
+		{spec: "*/15 9-17/4 * FEB-Mar 7", want: cronSpec{minute: 1 | 1<<15 | 1<<30 | 1<<45, hour: 1<<9 | 1<<13 | 1<<17, dom: 1<<32 - 2, month: 1<<2 | 1<<3, dow: 1 | 1<<7, domAny: true}},
+		{spec: "5/20 0 * * sun", want: cronSpec{minute: 1<<5 | 1<<25 | 1<<45, hour: 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1, domAny: true}},
+	} {
+		got, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+
+		if *got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.spec, tt.want, *got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "* * * foo *", "*/0 * * * *", "5-1 * * * *", "a-b * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return v
+	}
+
+	for _, tt := range []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{spec: "* * * * *", after: "2021-03-01 10:00", want: "2021-03-01 10:01"},
+		{spec: "30 2 * * *", after: "2021-03-01 10:00", want: "2021-03-02 02:30"},
+		{spec: "30 2 * * *", after: "2021-03-02 02:29", want: "2021-03-02 02:30"},
+		{spec: "0 0 1 * *", after: "2021-12-15 00:00", want: "2022-01-01 00:00"},
+		{spec: "0 0 29 feb *", after: "2021-03-01 00:00", want: "2024-02-29 00:00"},
+		{spec: "0 0 * * sat", after: "2021-03-01 00:00", want: "2021-03-06 00:00"},
+
+		// Both the day of month and the day of week are restricted, so either matches.
+		{spec: "0 0 15 * mon", after: "2021-03-09 00:00", want: "2021-03-15 00:00"},
+		{spec: "0 0 20 * mon", after: "2021-03-16 00:00", want: "2021-03-20 00:00"},
+
+		// A date which never occurs.
+		{spec: "0 0 31 feb *", after: "2021-03-01 00:00"},
+	} {
+		spec, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := spec.next(at(tt.after))
+
+		var want time.Time
+		if tt.want != "" {
+			want = at(tt.want)
+		}
+
+		if !got.Equal(want) {
+			t.Errorf("%q after %s: expected %s, got %s", tt.spec, tt.after, want, got)
+		}
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	s := &Schedule{
+		Timezone: "Europe/London",
+		Windows:  []ScheduleWindow{{Start: "0 22 * * *", DurationMinutes: 120}},
+	}
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		now    time.Time
+		open   bool
+		change time.Time
+	}{
+		{now: time.Date(2021, 7, 1, 21, 0, 0, 0, loc), change: time.Date(2021, 7, 1, 22, 0, 0, 0, loc)},
+		{now: time.Date(2021, 7, 1, 23, 30, 0, 0, loc), open: true, change: time.Date(2021, 7, 2, 0, 0, 0, 0, loc)},
+		{now: time.Date(2021, 7, 2, 0, 0, 0, 0, loc), change: time.Date(2021, 7, 2, 22, 0, 0, 0, loc)},
+
+		// The window opens at 22:00 in London, which is 21:00 UTC in summer.
+		{now: time.Date(2021, 7, 1, 21, 30, 0, 0, time.UTC), open: true, change: time.Date(2021, 7, 1, 23, 0, 0, 0, time.UTC)},
+	} {
+		open, change := s.active(tt.now)
+		if open != tt.open || !change.Equal(tt.change) {
+			t.Errorf("%s: expected %v until %s, got %v until %s", tt.now, tt.open, tt.change, open, change)
+		}
+	}
+
+	for _, bad := range []Schedule{
+		{},
+		{Timezone: "Mars/Olympus", Windows: []ScheduleWindow{{Start: "* * * * *", DurationMinutes: 1}}},
+		{Windows: []ScheduleWindow{{Start: "* * * * *"}}},
+		{Windows: []ScheduleWindow{{Start: "0 0 31 feb *", DurationMinutes: 1}}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+)
+
+// schemaDialect is the JSON Schema dialect of the generated schemas
+const schemaDialect = "http://json-schema.org/draft-07/schema#"
+
+// schemaKinds are the types whose schemas may be generated, by kind: the
+// configuration file, and the spec of each custom resource
+var schemaKinds = map[string]struct {
+	typ reflect.Type
+	tag string
+}{
+	"config":       {reflect.TypeOf(KubeBGPConfig{}), "yaml"},
+	"EgressIP":     {reflect.TypeOf(v1alpha1.EgressIPSpec{}), "json"},
+	"BGPNodeGroup": {reflect.TypeOf(v1alpha1.BGPNodeGroupSpec{}), "json"},
+	"BGPTenant":    {reflect.TypeOf(v1alpha1.BGPTenantSpec{}), "json"},
+}
+
+// runConfigSchema implements the `config-schema` command, which prints the
+// JSON Schema of the configuration file (or of the spec of a custom
+// resource) of this build, generated from its Go types
+func runConfigSchema(args []string) int {
+	fs := flag.NewFlagSet("config-schema", flag.ExitOnError)
+	kind := fs.String("kind", "config", "the schema to print: `config`, or the kind of a custom resource, whose spec is described")
+	fs.Parse(args) // nolint: errcheck
+
+	k, ok := schemaKinds[*kind]
+	if !ok {
+		var kinds []string
+		for name := range schemaKinds {
+			kinds = append(kinds, name)
+		}
+		sort.Strings(kinds)
+
+		fmt.Fprintf(os.Stderr, "unknown kind %q: must be one of %s\n", *kind, strings.Join(kinds, ", "))
+		return 1
+	}
+
+	schema := typeSchema(k.typ, k.tag)
+	schema["$schema"] = schemaDialect
+	schema["title"] = *kind
+	schema["$comment"] = "generated by kube-bgp " + version
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(schema); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to encode schema:", err)
+		return 1
+	}
+
+	return 0
+}
+
+// typeSchema returns the JSON Schema of the given type, whose fields are
+// named by the given struct tag (`yaml` or `json`)
+func typeSchema(t reflect.Type, tag string) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), tag)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), tag)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), tag)}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		structProperties(t, tag, properties)
+
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// structProperties adds the schemas of the fields of the given struct type
+// to the given properties, including those of inlined structs
+func structProperties(t reflect.Type, tag string, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		parts := strings.Split(f.Tag.Get(tag), ",")
+		name := parts[0]
+
+		if name == "-" {
+			continue
+		}
+
+		if hasOption(parts[1:], "inline") || (f.Anonymous && name == "") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			structProperties(ft, tag, properties)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name) // the yaml default
+			if tag == "json" {
+				name = f.Name
+			}
+		}
+
+		properties[name] = typeSchema(f.Type, tag)
+	}
+}
+
+// hasOption indicates whether the given struct tag options include the named option
+func hasOption(options []string, name string) bool {
+	for _, o := range options {
+		if o == name {
+			return true
+		}
+	}
+
+	return false
+}
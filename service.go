@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/services"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// announceFromAnnotation is the Service annotation which restricts the Nodes
+// from which the Service's VIPs are announced to those matching the given
+// Node label selector
+const announceFromAnnotation = "kube-bgp.cycoresystems.com/announce-from"
+
+// ServiceAdvertisement configures the announcement of the ingress IPs of
+// LoadBalancer Services, from every Node selected by the announce-from
+// annotation of each Service
+type ServiceAdvertisement struct {
+	RouteAttributes `yaml:",inline"`
+
+	// WriteStatus announces the loadBalancerIP requested by each Service in
+	// place of its ingress IPs, and keeps the Service's
+	// status.loadBalancer.ingress in step with the announcement of its IPs.
+	// This is optional, and if not supplied, the ingress IPs are announced as
+	// assigned.
+	WriteStatus bool `yaml:"writeStatus"`
+}
+
+func (a *ServiceAdvertisement) validate() error {
+	return a.RouteAttributes.validate()
+}
+
+// routes returns the host routes of the ingress IPs of the Services of the
+// given state which are announced from the given Node, each restricted to
+// the Routers named by the routers annotation of its Services and, with
+// linkBandwidth, weighted by their endpoints on the Node.  The Services which
+// cannot be announced are returned as errors, for the caller to report.
+func (a *ServiceAdvertisement) routes(cfg *KubeBGPConfig, thisNode *v1.Node, state *clusterState) (list []routes.Route, errs []error) {
+	if thisNode == nil {
+		return nil, nil
+	}
+
+	serviceList := state.Services
+
+	var endpoints map[string]*v1.Endpoints
+	if cfg.LinkBandwidth != nil {
+		endpoints = endpointsByService(state.Endpoints)
+	}
+
+	// The same ingress IP may be shared by several Services (on different
+	// ports), in which case it is only exported to the Routers named by
+	// every one of them which is restricted.
+	seen := make(map[string]int)
+	restricted := make(map[string]bool)
+
+	for i := range serviceList {
+		svc := &serviceList[i]
+
+		announce, err := announcesFrom(svc, thisNode)
+		if err != nil {
+			errs = append(errs, errcode.Wrap(err, errcode.ConfigInvalid, "failed to select the nodes of service "+svc.Namespace+"/"+svc.Name))
+			continue
+		}
+		if !announce {
+			continue
+		}
+
+		routers, restricts, err := serviceRouters(cfg, svc)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, addr := range a.addresses(svc) {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				continue
+			}
+
+			prefix := hostPrefix(ip)
+
+			j, ok := seen[prefix]
+			if !ok {
+				j = len(list)
+				seen[prefix] = j
+				list = append(list, a.RouteAttributes.route(prefix, "service/"+svc.Namespace+"/"+svc.Name))
+			}
+
+			if cfg.LinkBandwidth != nil {
+				// The bandwidth of an IP shared by several Services is that of all of their endpoints.
+				bw := cfg.LinkBandwidth.bandwidth(endpoints[svc.Namespace+"/"+svc.Name], thisNode.Name)
+				if list[j].LinkBandwidth != nil {
+					bw += *list[j].LinkBandwidth
+				}
+				list[j].LinkBandwidth = &bw
+			}
+
+			switch {
+			case !restricts:
+			case restricted[prefix]:
+				list[j].Routers = intersect(list[j].Routers, routers)
+			default:
+				list[j].Routers = routers
+				restricted[prefix] = true
+			}
+		}
+	}
+
+	// A route restricted to no Router at all is not announced.
+	var exported []routes.Route
+	for _, r := range list {
+		if restricted[r.Prefix] && len(r.Routers) == 0 {
+			continue
+		}
+
+		exported = append(exported, r)
+	}
+
+	return exported, errs
+}
+
+// addresses returns the IPs at which the given Service is announced: its
+// requested loadBalancerIP, if kube-bgp writes its status, or otherwise its
+// ingress IPs, along with those which kube-bgp has withdrawn from its
+// ingress
+func (a *ServiceAdvertisement) addresses(svc *v1.Service) (list []string) {
+	if !a.WriteStatus {
+		return ingressIPs(svc)
+	}
+
+	if svc.Spec.LoadBalancerIP != "" {
+		return []string{svc.Spec.LoadBalancerIP}
+	}
+
+	list = ingressIPs(svc)
+	for _, addr := range services.Withdrawn(svc) {
+		if !containsString(list, addr) {
+			list = append(list, addr)
+		}
+	}
+
+	return list
+}
+
+// ingressIPs returns the IPs of the ingress of the given Service
+func ingressIPs(svc *v1.Service) (list []string) {
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			list = append(list, ing.IP)
+		}
+	}
+
+	return list
+}
+
+// serviceRouters returns the addresses of the Routers to which the given
+// Service's VIPs should be announced, per its routers annotation, and
+// whether the annotation restricts them at all.  References to Routers which
+// are not configured are returned as an error, along with the Routers which
+// are.
+func serviceRouters(cfg *KubeBGPConfig, svc *v1.Service) (list []string, restricted bool, err error) {
+	refs := routerRefs(svc.Annotations)
+	listed := make(map[string]bool)
+
+	var unknown []string
+
+	for _, ref := range refs {
+		var known bool
+
+		for j := range cfg.Routers {
+			if r := &cfg.Routers[j]; r.Matches(ref) {
+				known = true
+				if !listed[r.Address] {
+					listed[r.Address] = true
+					list = append(list, r.Address)
+				}
+			}
+		}
+
+		if !known {
+			unknown = append(unknown, ref)
+		}
+	}
+
+	sort.Strings(list)
+
+	if len(unknown) > 0 {
+		err = errcode.New(errcode.ConfigInvalid, "service "+svc.Namespace+"/"+svc.Name+" references unknown router "+strings.Join(unknown, ", "))
+	}
+
+	return list, len(refs) > 0, err
+}
+
+// intersect returns the sorted addresses which are in both of the given sorted lists
+func intersect(a, b []string) (list []string) {
+	for _, addr := range a {
+		if i := sort.SearchStrings(b, addr); i < len(b) && b[i] == addr {
+			list = append(list, addr)
+		}
+	}
+
+	return list
+}
+
+// announcesFrom indicates whether the given Service's VIPs should be
+// announced from the given Node, per its announce-from annotation.  Services
+// without the annotation are announced from every Node.
+func announcesFrom(svc *v1.Service, n *v1.Node) (bool, error) {
+	sel, ok := svc.Annotations[announceFromAnnotation]
+	if !ok {
+		return true, nil
+	}
+
+	selector, err := labels.Parse(sel)
+	if err != nil {
+		return false, eris.Wrapf(err, "invalid %s annotation on service %s/%s", announceFromAnnotation, svc.Namespace, svc.Name)
+	}
+
+	return selector.Matches(labels.Set(n.Labels)), nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/keyqueue"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EndpointsWatcher defines the interface for a watcher of the Endpoints of Services
+type EndpointsWatcher interface {
+
+	// Changes waits for a change to the ready endpoints of any Service to occur
+	Changes() <-chan struct{}
+
+	// Endpoints returns the current list of Endpoints which have ready
+	// addresses, in order of namespace and name
+	Endpoints() []v1.Endpoints
+
+	// Synced indicates whether the list of Endpoints has been obtained from the API at least once
+	Synced() bool
+
+	// Pending returns the number of Endpoints whose changes await processing
+	Pending() int
+
+	// Close shuts down the EndpointsWatcher
+	Close()
+}
+
+type endpointsWatcher struct {
+	cancel    context.CancelFunc
+	clientSet kubernetes.Interface
+	queue     *keyqueue.Queue
+	signal    *dirty.Flag
+
+	mu        sync.Mutex
+	endpoints map[string]*v1.Endpoints
+	synced    bool
+}
+
+// ready indicates whether the given Endpoints has any ready address
+func ready(ep *v1.Endpoints) bool {
+	for _, subset := range ep.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *endpointsWatcher) run(ctx context.Context) {
+	started := false
+
+	for ctx.Err() == nil {
+		resourceVersion, err := w.relist(ctx)
+		if err != nil {
+			status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "failed to update endpoints list"))
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if !started {
+			go w.queue.Run(ctx, workers)
+			started = true
+		}
+
+		wtch, err := w.clientSet.CoreV1().Endpoints("").Watch(ctx, metav1.ListOptions{ResourceVersion: resourceVersion})
+		if err != nil {
+			if !watches.Expired(err) {
+				status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "failed to create endpoints watcher"))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		if err := w.queue.Feed(ctx, wtch); err != nil && !watches.Expired(err) {
+			status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "endpoints watch failed"))
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// relist lists the Endpoints, returning the resourceVersion of the list, in
+// the same way as the Services are relisted
+func (w *endpointsWatcher) relist(ctx context.Context) (string, error) {
+	list, err := w.clientSet.CoreV1().Endpoints("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	w.mu.Lock()
+	synced := w.synced
+	w.mu.Unlock()
+
+	if !synced {
+		endpoints := make(map[string]*v1.Endpoints)
+		for i := range list.Items {
+			if ep := &list.Items[i]; ready(ep) {
+				endpoints[ep.Namespace+"/"+ep.Name] = ep
+			}
+		}
+
+		w.mu.Lock()
+		w.endpoints = endpoints
+		w.synced = true
+		w.mu.Unlock()
+
+		w.signal.Set()
+
+		return list.ResourceVersion, nil
+	}
+
+	listed := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		ep := &list.Items[i]
+		key := ep.Namespace + "/" + ep.Name
+
+		listed[key] = true
+		w.queue.Update(key, ep)
+	}
+
+	w.mu.Lock()
+	var gone []string
+	for key := range w.endpoints {
+		if !listed[key] {
+			gone = append(gone, key)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, key := range gone {
+		w.queue.Delete(key)
+	}
+
+	return list.ResourceVersion, nil
+}
+
+// update records the latest state of the Endpoints of the given key,
+// signalling if it changes
+func (w *endpointsWatcher) update(key string, obj runtime.Object) error {
+	ep, _ := obj.(*v1.Endpoints)
+	if ep != nil && !ready(ep) {
+		ep = nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old, ok := w.endpoints[key]
+
+	switch {
+	case ep == nil && !ok:
+		return nil
+	case ep == nil:
+		delete(w.endpoints, key)
+	case ok && old.ResourceVersion == ep.ResourceVersion:
+		return nil // endpoints are the same
+	default:
+		w.endpoints[key] = ep
+	}
+
+	w.signal.Set()
+
+	return nil
+}
+
+func (w *endpointsWatcher) Changes() <-chan struct{} {
+	return w.signal.C()
+}
+
+func (w *endpointsWatcher) Endpoints() []v1.Endpoints {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keys := make([]string, 0, len(w.endpoints))
+	for key := range w.endpoints {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]v1.Endpoints, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, *w.endpoints[key])
+	}
+
+	return list
+}
+
+func (w *endpointsWatcher) Synced() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.synced
+}
+
+func (w *endpointsWatcher) Pending() int {
+	return w.queue.Pending()
+}
+
+func (w *endpointsWatcher) Close() {
+	w.cancel()
+}
+
+// NewEndpointsWatcher returns a new watcher of the Endpoints of Services,
+// which signals whenever the ready addresses of any of them change
+func NewEndpointsWatcher(ctx context.Context, clientSet kubernetes.Interface) (EndpointsWatcher, error) {
+	localCtx, cancel := context.WithCancel(ctx)
+
+	w := &endpointsWatcher{
+		cancel:    cancel,
+		clientSet: clientSet,
+		signal:    dirty.New(),
+		endpoints: make(map[string]*v1.Endpoints),
+	}
+	w.queue = keyqueue.New("endpoints", w.update)
+
+	go w.run(localCtx)
+
+	return w, nil
+}
@@ -0,0 +1,343 @@
+// Package services watches the LoadBalancer Services of the cluster, whose
+// ingress IPs (or requested loadBalancerIPs) are announced.  Since Services
+// may number in the thousands, their changes are processed by key through a
+// keyqueue, rather than by relisting them on every change as the other
+// watchers do.
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/features"
+	"github.com/CyCoreSystems/kube-bgp/keyqueue"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WithdrawnAnnotation is the Service annotation listing (comma-separated) the
+// IPs allocated into its ingress which were removed from it because no Node
+// announced them, so that they may be restored once one does
+const WithdrawnAnnotation = "kube-bgp.cycoresystems.com/withdrawn-ips"
+
+// ErrConflict indicates that a Service could not be updated because it has
+// changed, or been deleted, since it was listed.  The change is observed by
+// the watcher, so the update may be retried by the next reconcile.
+var ErrConflict = errors.New("service changed since it was listed")
+
+// Gate is the feature gate of the announcement of the ingress IPs of LoadBalancer Services
+var Gate = features.New("ServiceAdvertisement", features.Alpha)
+
+// workers is the number of workers which process the changes to Services
+const workers = 2
+
+// Watcher defines the interface for a Service Watcher
+type Watcher interface {
+
+	// Changes waits for a change to the set of LoadBalancer Services to occur
+	Changes() <-chan struct{}
+
+	// Services returns the current list of LoadBalancer Services which have
+	// ingress IPs or request a loadBalancerIP, in order of namespace and name
+	Services() []v1.Service
+
+	// Synced indicates whether the list of Services has been obtained from the API at least once
+	Synced() bool
+
+	// Pending returns the number of Services whose changes await processing
+	Pending() int
+
+	// Close shuts down the Watcher
+	Close()
+}
+
+type watcher struct {
+	cancel    context.CancelFunc
+	clientSet kubernetes.Interface
+	queue     *keyqueue.Queue
+	signal    *dirty.Flag
+
+	mu       sync.Mutex
+	services map[string]*v1.Service
+	synced   bool
+}
+
+// Announced indicates whether the ingress IPs of the given Service are
+// announced: whether it is of type LoadBalancer and has been assigned any,
+// requests a loadBalancerIP, or has withdrawn IPs
+func Announced(svc *v1.Service) bool {
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false
+	}
+
+	if svc.Spec.LoadBalancerIP != "" || len(Withdrawn(svc)) > 0 {
+		return true
+	}
+
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *watcher) run(ctx context.Context) {
+	started := false
+
+	for ctx.Err() == nil {
+		resourceVersion, err := w.relist(ctx)
+		if err != nil {
+			status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "failed to update service list"))
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
+			continue
+		}
+
+		// The first list is applied directly, so that the Watcher is synced
+		// once it has been obtained; the changes are processed thereafter.
+		if !started {
+			go w.queue.Run(ctx, workers)
+			started = true
+		}
+
+		// The watch begins at the resourceVersion of the list, so that no
+		// change in between is missed.
+		wtch, err := w.clientSet.CoreV1().Services("").Watch(ctx, metav1.ListOptions{ResourceVersion: resourceVersion})
+		if err != nil {
+			if !watches.Expired(err) {
+				status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "failed to create service watcher"))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		if err := w.queue.Feed(ctx, wtch); err != nil && !watches.Expired(err) {
+			status.Error(errcode.Wrap(err, errcode.APIServerUnreachable, "service watch failed"))
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// relist lists the Services, returning the resourceVersion of the list.  The
+// first list replaces the Services at once; later lists queue the change of
+// every listed Service, and the deletion of every Service no longer listed,
+// behind any change already queued from the watch.
+func (w *watcher) relist(ctx context.Context) (string, error) {
+	list, err := w.clientSet.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	w.mu.Lock()
+	synced := w.synced
+	w.mu.Unlock()
+
+	if !synced {
+		services := make(map[string]*v1.Service)
+		for i := range list.Items {
+			if svc := &list.Items[i]; Announced(svc) {
+				services[svc.Namespace+"/"+svc.Name] = svc
+			}
+		}
+
+		w.mu.Lock()
+		w.services = services
+		w.synced = true
+		w.mu.Unlock()
+
+		w.signal.Set()
+
+		return list.ResourceVersion, nil
+	}
+
+	listed := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		svc := &list.Items[i]
+		key := svc.Namespace + "/" + svc.Name
+
+		listed[key] = true
+		w.queue.Update(key, svc)
+	}
+
+	w.mu.Lock()
+	var gone []string
+	for key := range w.services {
+		if !listed[key] {
+			gone = append(gone, key)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, key := range gone {
+		w.queue.Delete(key)
+	}
+
+	return list.ResourceVersion, nil
+}
+
+// update records the latest state of the Service of the given key, signalling
+// if it changes the set of the announced Services
+func (w *watcher) update(key string, obj runtime.Object) error {
+	svc, _ := obj.(*v1.Service)
+	if svc != nil && !Announced(svc) {
+		svc = nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old, ok := w.services[key]
+
+	switch {
+	case svc == nil && !ok:
+		return nil
+	case svc == nil:
+		delete(w.services, key)
+	case ok && old.ResourceVersion == svc.ResourceVersion:
+		return nil // services are the same
+	default:
+		w.services[key] = svc
+	}
+
+	w.signal.Set()
+
+	return nil
+}
+
+func (w *watcher) Changes() <-chan struct{} {
+	return w.signal.C()
+}
+
+func (w *watcher) Services() []v1.Service {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keys := make([]string, 0, len(w.services))
+	for key := range w.services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]v1.Service, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, *w.services[key])
+	}
+
+	return list
+}
+
+func (w *watcher) Synced() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.synced
+}
+
+func (w *watcher) Pending() int {
+	return w.queue.Pending()
+}
+
+func (w *watcher) Close() {
+	w.cancel()
+}
+
+// Withdrawn returns the IPs listed by the withdrawn-ips annotation of the given Service
+func Withdrawn(svc *v1.Service) (list []string) {
+	for _, s := range strings.Split(svc.Annotations[WithdrawnAnnotation], ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// UpdateStatus replaces the load balancer ingress of the given Service,
+// returning the updated Service
+func UpdateStatus(ctx context.Context, clientSet kubernetes.Interface, svc *v1.Service, ingress []v1.LoadBalancerIngress) (*v1.Service, error) {
+	updated := svc.DeepCopy()
+	updated.Status.LoadBalancer.Ingress = ingress
+
+	updated, err := clientSet.CoreV1().Services(svc.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, updateError(err, "failed to update status of service "+svc.Namespace+"/"+svc.Name)
+	}
+
+	return updated, nil
+}
+
+// SetWithdrawn replaces the withdrawn-ips annotation of the given Service,
+// removing it if there are none, and returns the updated Service
+func SetWithdrawn(ctx context.Context, clientSet kubernetes.Interface, svc *v1.Service, ips []string) (*v1.Service, error) {
+	updated := svc.DeepCopy()
+	if len(ips) == 0 {
+		delete(updated.Annotations, WithdrawnAnnotation)
+	} else {
+		if updated.Annotations == nil {
+			updated.Annotations = make(map[string]string)
+		}
+		updated.Annotations[WithdrawnAnnotation] = strings.Join(ips, ",")
+	}
+
+	updated, err := clientSet.CoreV1().Services(svc.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, updateError(err, "failed to annotate service "+svc.Namespace+"/"+svc.Name+" with its withdrawn IPs")
+	}
+
+	return updated, nil
+}
+
+// updateError categorizes an error updating a Service: a conflict or the
+// deletion of the Service is ErrConflict, a refusal for lack of permission
+// or of the update as invalid is distinguished, and any other refusal by the
+// apiserver is given its reason, apart from failures to reach it at all
+func updateError(err error, msg string) error {
+	switch {
+	case apierrors.IsConflict(err), apierrors.IsNotFound(err):
+		return ErrConflict
+	case apierrors.IsForbidden(err):
+		return errcode.Wrap(err, errcode.PermissionDenied, msg)
+	case apierrors.IsInvalid(err):
+		return errcode.Wrap(err, errcode.ConfigInvalid, msg)
+	}
+
+	if reason := apierrors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return errcode.Wrapf(err, errcode.Unknown, "%s: %s", msg, reason)
+	}
+
+	return errcode.Wrap(err, errcode.APIServerUnreachable, msg)
+}
+
+// NewWatcher returns a new Services watcher which signals whenever the set of
+// LoadBalancer Services, or the ingress IPs of any of them, changes
+func NewWatcher(ctx context.Context, clientSet kubernetes.Interface) (Watcher, error) {
+	localCtx, cancel := context.WithCancel(ctx)
+
+	w := &watcher{
+		cancel:    cancel,
+		clientSet: clientSet,
+		signal:    dirty.New(),
+		services:  make(map[string]*v1.Service),
+	}
+	w.queue = keyqueue.New("services", w.update)
+
+	go w.run(localCtx)
+
+	return w, nil
+}
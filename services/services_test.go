@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestUpdateError(t *testing.T) {
+	resource := schema.GroupResource{Resource: "services"}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		code errcode.Code
+	}{
+		{name: "forbidden", err: apierrors.NewForbidden(resource, "svc", errors.New("denied")), code: errcode.PermissionDenied},
+		{name: "invalid", err: apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "svc", field.ErrorList{field.Invalid(field.NewPath("status"), "x", "bad")}), code: errcode.ConfigInvalid},
+		{name: "internal", err: apierrors.NewInternalError(errors.New("boom")), code: errcode.Unknown},
+		{name: "unreachable", err: errors.New("connection refused"), code: errcode.APIServerUnreachable},
+	} {
+		err := updateError(tt.err, "failed to update service")
+		if err == ErrConflict {
+			t.Errorf("%s: unexpected conflict", tt.name)
+			continue
+		}
+
+		if got := errcode.Of(err); got != tt.code {
+			t.Errorf("%s: expected code %s, got %s (%v)", tt.name, tt.code, got, err)
+		}
+	}
+
+	for _, err := range []error{
+		apierrors.NewConflict(resource, "svc", errors.New("modified")),
+		apierrors.NewNotFound(resource, "svc"),
+	} {
+		if got := updateError(err, "failed to update service"); got != ErrConflict {
+			t.Errorf("%v: expected ErrConflict, got %v", err, got)
+		}
+	}
+}
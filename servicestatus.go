@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/services"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// updateServiceStatus keeps the ingress of each Service in step with the
+// announcement of the IPs which kube-bgp announces for it: its requested
+// loadBalancerIP, or else the IPs allocated into its ingress.  Each such IP
+// is added to the ingress by the Nodes which announce it, and removed, by
+// the reporter Node alone, once no Node is selected to announce it.  An
+// allocated IP which is removed is kept in the withdrawn-ips annotation of
+// the Service, so that it is restored once a Node announces it again.  The
+// other entries of the ingress, such as those written by other controllers,
+// are never changed.
+func updateServiceStatus(ctx context.Context, clientset kubernetes.Interface, a *ServiceAdvertisement, thisNode string, desired []routes.Route, state *clusterState) {
+	announced := make(map[string]bool, len(desired))
+	for _, r := range desired {
+		announced[r.Prefix] = true
+	}
+
+	reporter := reporterNode(state.Nodes)
+
+	for i := range state.Services {
+		svc := &state.Services[i]
+
+		if err := updateOneServiceStatus(ctx, clientset, a, svc, announced, reporter == thisNode && !announcedAnywhere(svc, state.Nodes)); err != nil && err != services.ErrConflict {
+			status.Error(err)
+		}
+	}
+}
+
+// updateOneServiceStatus adds each IP of the given Service which is in the
+// given set of announced prefixes to its ingress and, if withdraw is set,
+// removes them all.  A conflict, where the Service has changed or been
+// deleted since it was listed, is returned as services.ErrConflict, to be
+// retried by the next reconcile.
+func updateOneServiceStatus(ctx context.Context, clientset kubernetes.Interface, a *ServiceAdvertisement, svc *v1.Service, announced map[string]bool, withdraw bool) error {
+	ingress := append([]v1.LoadBalancerIngress(nil), svc.Status.LoadBalancer.Ingress...)
+	var ingressChanged bool
+
+	var allocated []net.IP
+
+	for _, addr := range a.addresses(svc) {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+
+		if addr != svc.Spec.LoadBalancerIP {
+			allocated = append(allocated, ip)
+		}
+
+		j := ingressIndex(ingress, ip)
+
+		switch {
+		case announced[hostPrefix(ip)] && j < 0:
+			ingress = append(ingress, v1.LoadBalancerIngress{IP: ip.String()})
+			ingressChanged = true
+		case withdraw && j >= 0:
+			ingress = append(ingress[:j:j], ingress[j+1:]...)
+			ingressChanged = true
+		}
+	}
+
+	// The allocated IPs which are not in the ingress are withdrawn.
+	var withdrawn []string
+	for _, ip := range allocated {
+		if ingressIndex(ingress, ip) < 0 {
+			withdrawn = append(withdrawn, ip.String())
+		}
+	}
+	sort.Strings(withdrawn)
+
+	previous := make(map[string]bool)
+	for _, ip := range services.Withdrawn(svc) {
+		previous[ip] = true
+	}
+
+	var annotationChanged, newlyWithdrawn bool
+	for _, ip := range withdrawn {
+		if !previous[ip] {
+			newlyWithdrawn = true
+		}
+	}
+	annotationChanged = newlyWithdrawn || len(withdrawn) != len(previous)
+
+	// An allocated IP is recorded as withdrawn before it is removed from the
+	// ingress, and restored to the ingress before it is no longer recorded,
+	// so that it is never lost.
+	if newlyWithdrawn {
+		updated, err := services.SetWithdrawn(ctx, clientset, svc, withdrawn)
+		if err != nil {
+			return err
+		}
+		svc, annotationChanged = updated, false
+	}
+
+	if ingressChanged {
+		updated, err := services.UpdateStatus(ctx, clientset, svc, ingress)
+		if err != nil {
+			return err
+		}
+		svc = updated
+	}
+
+	if annotationChanged {
+		if _, err := services.SetWithdrawn(ctx, clientset, svc, withdrawn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ingressIndex returns the index of the given IP within the given ingress, or -1 if it is not there
+func ingressIndex(ingress []v1.LoadBalancerIngress, ip net.IP) int {
+	for i, ing := range ingress {
+		if ip.Equal(net.ParseIP(ing.IP)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// announcedAnywhere indicates whether any of the given Nodes is selected to
+// announce the given Service.  A Service whose selector is invalid is
+// considered announced, so that its status is left as it is.
+func announcedAnywhere(svc *v1.Service, nodeList []v1.Node) bool {
+	for i := range nodeList {
+		if ok, err := announcesFrom(svc, &nodeList[i]); ok || err != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// setsockoptInt sets the given integer option of the socket of the given handle
+func setsockoptInt(fd uintptr, level, opt, value int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), level, opt, value)
+}
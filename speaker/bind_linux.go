@@ -0,0 +1,19 @@
+package speaker
+
+import (
+	"syscall"
+)
+
+// bindToDevice returns the dialer control function which binds the socket to the given interface
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); cerr != nil {
+			return cerr
+		}
+
+		return err
+	}
+}
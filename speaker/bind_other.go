@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package speaker
+
+import (
+	"syscall"
+
+	"github.com/rotisserie/eris"
+)
+
+// bindToDevice returns the dialer control function which fails, since
+// sockets cannot be bound to an interface on this platform
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return eris.Errorf("cannot bind to interface %s on this platform", iface)
+	}
+}
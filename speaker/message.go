@@ -0,0 +1,543 @@
+package speaker
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+)
+
+// BGP message types
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+)
+
+// BGP path attribute types
+const (
+	attrOrigin         = 1
+	attrASPath         = 2
+	attrNextHop        = 3
+	attrLocalPref      = 5
+	attrCommunities    = 8
+	attrMPReach        = 14
+	attrMPUnreach      = 15
+	attrExtCommunities = 16
+	attrAIGP           = 26
+	asPathSequence     = 2
+	flagOptional       = 0x80
+	flagTransitive     = 0x40
+	flagExtendedLen    = 0x10
+)
+
+// Capability codes and address families
+const (
+	capMultiprotocol   = 1
+	capGracefulRestart = 64
+	capFourOctetAS     = 65
+	capHostname        = 73
+	afiIPv4            = 1
+	afiIPv6            = 2
+	safiUnicast        = 1
+)
+
+// Extended community type and subtype of the link-bandwidth community
+const (
+	extCommunityNonTransitiveAS = 0x40
+	extCommunityLinkBandwidth   = 0x04
+)
+
+// asTrans is the 2-octet AS number used in place of a 4-octet AS number when talking to old speakers (RFC 6793)
+const asTrans = 23456
+
+// defaultLocalPref is the LOCAL_PREF sent to iBGP neighbors
+const defaultLocalPref = 100
+
+const headerLen = 19
+
+// maxMessageLen is the maximum length of a BGP message (RFC 4271)
+const maxMessageLen = 4096
+
+// writeMessage writes a single BGP message of the given type and body
+func writeMessage(w io.Writer, typ byte, body []byte) error {
+	msg := make([]byte, headerLen, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:], uint16(headerLen+len(body)))
+	msg[18] = typ
+
+	_, err := w.Write(append(msg, body...))
+	return err
+}
+
+// readMessage reads a single BGP message, returning its type and body
+func readMessage(r io.Reader) (typ byte, body []byte, err error) {
+	hdr := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(hdr[16:]))
+	if length < headerLen || length > maxMessageLen {
+		return 0, nil, eris.Errorf("invalid message length %d", length)
+	}
+
+	body = make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return hdr[18], body, nil
+}
+
+// maxHostnameLen is the maximum length of each of the names sent in the hostname capability
+const maxHostnameLen = 64
+
+// openMessage builds the body of an OPEN message.  If restarting, the
+// graceful restart capability says so.
+func openMessage(cfg *Config, holdTime uint16, restarting bool) []byte {
+	asn := cfg.ASN
+
+	myAS := uint16(asTrans)
+	if asn <= 0xffff {
+		myAS = uint16(asn)
+	}
+
+	caps := []byte{
+		capMultiprotocol, 4, 0, afiIPv4, 0, safiUnicast,
+		capMultiprotocol, 4, 0, afiIPv6, 0, safiUnicast,
+		capFourOctetAS, 4, 0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint32(caps[14:], asn)
+
+	if cfg.RestartTime > 0 {
+		caps = append(caps, gracefulRestartCapability(cfg.RestartTime, restarting)...)
+	}
+
+	if cfg.Hostname != "" {
+		caps = append(caps, hostnameCapability(cfg.Hostname, cfg.DomainName)...)
+	}
+
+	body := []byte{4, 0, 0, 0, 0, 0, 0, 0, 0, byte(2 + len(caps)), 2, byte(len(caps))}
+	binary.BigEndian.PutUint16(body[1:], myAS)
+	binary.BigEndian.PutUint16(body[3:], holdTime)
+	copy(body[5:9], cfg.RouterID.To4())
+
+	return append(body, caps...)
+}
+
+// maxRestartTime is the maximum restart time of the graceful restart capability, in seconds
+const maxRestartTime = 0xfff
+
+// gracefulRestartCapability builds the graceful restart capability (RFC
+// 4724) for both unicast families.  Since the Speaker keeps no RIB and
+// forwards nothing, forwarding state is always preserved across a restart.
+func gracefulRestartCapability(restartTime time.Duration, restarting bool) []byte {
+	seconds := int(restartTime / time.Second)
+	if seconds > maxRestartTime {
+		seconds = maxRestartTime
+	}
+
+	flags := seconds >> 8
+	if restarting {
+		flags |= 0x80
+	}
+
+	return []byte{
+		capGracefulRestart, 10, byte(flags), byte(seconds),
+		0, afiIPv4, safiUnicast, 0x80,
+		0, afiIPv6, safiUnicast, 0x80,
+	}
+}
+
+// hostnameCapability builds the hostname capability
+// (draft-walton-bgp-hostname-capability), which identifies the speaker in the
+// neighbor's `show bgp neighbors` output
+func hostnameCapability(hostname, domain string) []byte {
+	if len(hostname) > maxHostnameLen {
+		hostname = hostname[:maxHostnameLen]
+	}
+	if len(domain) > maxHostnameLen {
+		domain = domain[:maxHostnameLen]
+	}
+
+	c := []byte{capHostname, byte(2 + len(hostname) + len(domain)), byte(len(hostname))}
+	c = append(c, hostname...)
+	c = append(c, byte(len(domain)))
+
+	return append(c, domain...)
+}
+
+// peerOpen is the relevant content of an OPEN message received from a neighbor
+type peerOpen struct {
+	asn             uint32
+	holdTime        uint16
+	fourOctetAS     bool
+	ipv6            bool
+	gracefulRestart bool
+}
+
+// parseOpen parses the body of an OPEN message
+func parseOpen(body []byte) (*peerOpen, error) {
+	if len(body) < 10 {
+		return nil, eris.New("short OPEN message")
+	}
+
+	o := &peerOpen{
+		asn:      uint32(binary.BigEndian.Uint16(body[1:])),
+		holdTime: binary.BigEndian.Uint16(body[3:]),
+	}
+
+	params := body[10:]
+	if len(params) != int(body[9]) {
+		return nil, eris.New("invalid OPEN optional parameters length")
+	}
+
+	for len(params) >= 2 {
+		typ, l := params[0], int(params[1])
+		if len(params) < 2+l {
+			return nil, eris.New("truncated OPEN optional parameter")
+		}
+
+		if typ == 2 { // capabilities
+			caps := params[2 : 2+l]
+			for len(caps) >= 2 {
+				code, cl := caps[0], int(caps[1])
+				if len(caps) < 2+cl {
+					return nil, eris.New("truncated capability")
+				}
+				val := caps[2 : 2+cl]
+
+				switch {
+				case code == capFourOctetAS && cl == 4:
+					o.fourOctetAS = true
+					o.asn = binary.BigEndian.Uint32(val)
+				case code == capGracefulRestart && cl >= 2:
+					o.gracefulRestart = true
+				case code == capMultiprotocol && cl == 4:
+					if binary.BigEndian.Uint16(val) == afiIPv6 && val[3] == safiUnicast {
+						o.ipv6 = true
+					}
+				}
+
+				caps = caps[2+cl:]
+			}
+		}
+
+		params = params[2+l:]
+	}
+
+	return o, nil
+}
+
+// notificationMessage builds the body of a NOTIFICATION message
+func notificationMessage(code, subcode byte) []byte {
+	return []byte{code, subcode}
+}
+
+// encodePrefix encodes a prefix in NLRI form: its length in bits followed by the significant octets
+func encodePrefix(network *net.IPNet) []byte {
+	ones, bits := network.Mask.Size()
+
+	ip := network.IP.To4()
+	if bits == 128 {
+		ip = network.IP.To16()
+	}
+
+	return append([]byte{byte(ones)}, ip[:(ones+7)/8]...)
+}
+
+// attribute encodes a single path attribute
+func attribute(flags, typ byte, value []byte) []byte {
+	if len(value) > 255 {
+		a := []byte{flags | flagExtendedLen, typ, 0, 0}
+		binary.BigEndian.PutUint16(a[2:], uint16(len(value)))
+		return append(a, value...)
+	}
+
+	return append([]byte{flags, typ, byte(len(value))}, value...)
+}
+
+// originCode returns the wire encoding of an ORIGIN
+func originCode(o routes.Origin) byte {
+	switch o {
+	case routes.OriginEGP:
+		return 1
+	case routes.OriginIncomplete:
+		return 2
+	}
+
+	return 0
+}
+
+// pathAttributes encodes the attributes common to IPv4 and IPv6 announcements
+func (s *session) pathAttributes(r *routes.Route) []byte {
+	attrs := attribute(flagTransitive, attrOrigin, []byte{originCode(r.Origin)})
+
+	asns := r.ASPath
+	if !s.internal() {
+		asns = append([]uint32{s.speaker.cfg.ASN}, asns...)
+	}
+	attrs = append(attrs, attribute(flagTransitive, attrASPath, s.asPath(asns))...)
+
+	if s.internal() {
+		localPref := uint32(defaultLocalPref)
+		if r.LocalPref != nil {
+			localPref = *r.LocalPref
+		}
+
+		lp := make([]byte, 4)
+		binary.BigEndian.PutUint32(lp, localPref)
+		attrs = append(attrs, attribute(flagTransitive, attrLocalPref, lp)...)
+	}
+
+	var communities []byte
+	for _, c := range r.Communities {
+		v, err := routes.ParseCommunity(c)
+		if err != nil {
+			continue // validated by the caller
+		}
+
+		communities = append(communities, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(communities[len(communities)-4:], v)
+	}
+	if len(communities) > 0 {
+		attrs = append(attrs, attribute(flagOptional|flagTransitive, attrCommunities, communities)...)
+	}
+
+	if r.LinkBandwidth != nil {
+		attrs = append(attrs, attribute(flagOptional|flagTransitive, attrExtCommunities, s.linkBandwidth(*r.LinkBandwidth))...)
+	}
+
+	if r.AIGP != nil {
+		// A single AIGP TLV (type 1, length 11) carrying a 64-bit metric
+		aigp := []byte{1, 0, 11, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(aigp[3:], uint64(*r.AIGP))
+		attrs = append(attrs, attribute(flagOptional, attrAIGP, aigp)...)
+	}
+
+	return attrs
+}
+
+// asPath encodes the given ASNs as a single AS_SEQUENCE, or an empty AS_PATH
+// if there are none.  Without the four-octet AS capability, ASNs which do
+// not fit in two octets are sent as AS_TRANS.
+func (s *session) asPath(asns []uint32) []byte {
+	if len(asns) == 0 {
+		return nil
+	}
+
+	b := []byte{asPathSequence, byte(len(asns))}
+
+	for _, asn := range asns {
+		if s.fourOctetAS {
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], asn)
+			continue
+		}
+
+		as := uint16(asTrans)
+		if asn <= 0xffff {
+			as = uint16(asn)
+		}
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], as)
+	}
+
+	return b
+}
+
+// linkBandwidth encodes the link-bandwidth extended community
+// (draft-ietf-idr-link-bandwidth): non-transitive, two-octet AS specific,
+// carrying the bandwidth in bytes per second as an IEEE float
+func (s *session) linkBandwidth(bandwidth float32) []byte {
+	as := uint16(asTrans)
+	if s.speaker.cfg.ASN <= 0xffff {
+		as = uint16(s.speaker.cfg.ASN)
+	}
+
+	c := []byte{extCommunityNonTransitiveAS, extCommunityLinkBandwidth, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(c[2:], as)
+	binary.BigEndian.PutUint32(c[4:], math.Float32bits(bandwidth))
+
+	return c
+}
+
+// announceMessage builds the body of an UPDATE message announcing the given
+// route, or returns nil if the route cannot be sent to this neighbor
+func (s *session) announceMessage(r *routes.Route) []byte {
+	_, network, err := net.ParseCIDR(r.Prefix)
+	if err != nil {
+		return nil
+	}
+
+	attrs := s.pathAttributes(r)
+
+	if network.IP.To4() != nil {
+		nh := s.nextHop(r, false)
+		if nh == nil {
+			return nil
+		}
+		attrs = append(attrs, attribute(flagTransitive, attrNextHop, nh.To4())...)
+
+		return updateMessage(nil, attrs, encodePrefix(network))
+	}
+
+	nh := s.nextHop(r, true)
+	if nh == nil || !s.ipv6 {
+		return nil
+	}
+
+	mp := []byte{0, afiIPv6, safiUnicast, net.IPv6len}
+	mp = append(mp, nh.To16()...)
+	mp = append(mp, 0) // reserved
+	mp = append(mp, encodePrefix(network)...)
+	attrs = append(attrs, attribute(flagOptional, attrMPReach, mp)...)
+
+	return updateMessage(nil, attrs, nil)
+}
+
+// withdrawMessage builds the body of an UPDATE message withdrawing the given route
+func (s *session) withdrawMessage(r *routes.Route) []byte {
+	_, network, err := net.ParseCIDR(r.Prefix)
+	if err != nil {
+		return nil
+	}
+
+	if network.IP.To4() != nil {
+		return updateMessage(encodePrefix(network), nil, nil)
+	}
+
+	if !s.ipv6 {
+		return nil
+	}
+
+	mp := append([]byte{0, afiIPv6, safiUnicast}, encodePrefix(network)...)
+
+	return updateMessage(nil, attribute(flagOptional, attrMPUnreach, mp), nil)
+}
+
+// updateMessage builds the body of an UPDATE message
+func updateMessage(withdrawn, attrs, nlri []byte) []byte {
+	body := make([]byte, 2, 4+len(withdrawn)+len(attrs)+len(nlri))
+	binary.BigEndian.PutUint16(body, uint16(len(withdrawn)))
+	body = append(body, withdrawn...)
+
+	l := make([]byte, 2)
+	binary.BigEndian.PutUint16(l, uint16(len(attrs)))
+	body = append(body, l...)
+	body = append(body, attrs...)
+
+	return append(body, nlri...)
+}
+
+// parseUpdate parses the body of an UPDATE message, returning the unicast
+// prefixes which it announces and those which it withdraws.  The prefixes
+// of other address families are skipped.
+func parseUpdate(body []byte) (announced, withdrawn []string, err error) {
+	if len(body) < 2 {
+		return nil, nil, eris.New("short UPDATE message")
+	}
+
+	wl := int(binary.BigEndian.Uint16(body))
+	if len(body) < 4+wl {
+		return nil, nil, eris.New("invalid UPDATE withdrawn routes length")
+	}
+
+	if withdrawn, err = decodePrefixes(body[2:2+wl], false); err != nil {
+		return nil, nil, err
+	}
+
+	body = body[2+wl:]
+	al := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+al {
+		return nil, nil, eris.New("invalid UPDATE path attributes length")
+	}
+
+	attrs := body[2 : 2+al]
+	for len(attrs) >= 3 {
+		flags, typ := attrs[0], attrs[1]
+
+		hl, l := 3, int(attrs[2])
+		if flags&flagExtendedLen != 0 {
+			if len(attrs) < 4 {
+				return nil, nil, eris.New("truncated path attribute")
+			}
+			hl, l = 4, int(binary.BigEndian.Uint16(attrs[2:]))
+		}
+		if len(attrs) < hl+l {
+			return nil, nil, eris.New("truncated path attribute")
+		}
+		value := attrs[hl : hl+l]
+
+		switch typ {
+		case attrMPReach:
+			// AFI, SAFI, the next hop and a reserved octet precede the NLRI
+			if len(value) < 5 || len(value) < 5+int(value[3]) {
+				return nil, nil, eris.New("truncated MP_REACH_NLRI")
+			}
+
+			if binary.BigEndian.Uint16(value) == afiIPv6 && value[2] == safiUnicast {
+				list, err := decodePrefixes(value[5+int(value[3]):], true)
+				if err != nil {
+					return nil, nil, err
+				}
+				announced = append(announced, list...)
+			}
+		case attrMPUnreach:
+			if len(value) < 3 {
+				return nil, nil, eris.New("truncated MP_UNREACH_NLRI")
+			}
+
+			if binary.BigEndian.Uint16(value) == afiIPv6 && value[2] == safiUnicast {
+				list, err := decodePrefixes(value[3:], true)
+				if err != nil {
+					return nil, nil, err
+				}
+				withdrawn = append(withdrawn, list...)
+			}
+		}
+
+		attrs = attrs[hl+l:]
+	}
+
+	nlri, err := decodePrefixes(body[2+al:], false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(announced, nlri...), withdrawn, nil
+}
+
+// decodePrefixes decodes a list of prefixes in NLRI form
+func decodePrefixes(b []byte, ipv6 bool) (list []string, err error) {
+	size := net.IPv4len
+	if ipv6 {
+		size = net.IPv6len
+	}
+
+	for len(b) > 0 {
+		ones := int(b[0])
+		if ones > size*8 || len(b) < 1+(ones+7)/8 {
+			return nil, eris.New("invalid prefix in NLRI")
+		}
+
+		ip := make(net.IP, size)
+		copy(ip, b[1:1+(ones+7)/8])
+
+		mask := net.CIDRMask(ones, size*8)
+		network := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		list = append(list, network.String())
+
+		b = b[1+(ones+7)/8:]
+	}
+
+	return list, nil
+}
@@ -0,0 +1,236 @@
+package speaker
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+func TestEncodePrefix(t *testing.T) {
+	for _, tt := range []struct {
+		prefix string
+		want   []byte
+	}{
+		{"10.1.2.3/32", []byte{32, 10, 1, 2, 3}},
+		{"10.1.0.0/16", []byte{16, 10, 1}},
+		{"10.1.128.0/17", []byte{17, 10, 1, 128}},
+		{"0.0.0.0/0", []byte{0}},
+		{"2001:db8::/32", []byte{32, 0x20, 0x01, 0x0d, 0xb8}},
+		{"2001:db8::1/128", []byte{128, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+	} {
+		_, network, err := net.ParseCIDR(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := encodePrefix(network); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.prefix, tt.want, got)
+		}
+	}
+}
+
+func TestDecodePrefixes(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		b       []byte
+		ipv6    bool
+		want    []string
+		invalid bool
+	}{
+		{name: "empty"},
+		{name: "ipv4", b: []byte{32, 10, 1, 2, 3, 16, 10, 2}, want: []string{"10.1.2.3/32", "10.2.0.0/16"}},
+		{name: "host bits of the last octet", b: []byte{12, 10, 0x1f}, want: []string{"10.16.0.0/12"}},
+		{name: "ipv6", b: []byte{32, 0x20, 0x01, 0x0d, 0xb8}, ipv6: true, want: []string{"2001:db8::/32"}},
+		{name: "truncated", b: []byte{24, 10, 1}, invalid: true},
+		{name: "too long for ipv4", b: []byte{33, 10, 1, 2, 3, 4}, invalid: true},
+	} {
+		got, err := decodePrefixes(tt.b, tt.ipv6)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseOpen(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  Config
+		hold uint16
+	}{
+		{"two-octet AS", Config{ASN: 64512, RouterID: net.ParseIP("10.0.0.1")}, 90},
+		{"four-octet AS", Config{ASN: 4200000000, RouterID: net.ParseIP("10.0.0.1")}, 90},
+		{"graceful restart", Config{ASN: 64512, RouterID: net.ParseIP("10.0.0.1"), RestartTime: 2 * time.Minute}, 30},
+		{"hostname", Config{ASN: 64512, RouterID: net.ParseIP("10.0.0.1"), Hostname: "node-a", DomainName: "cluster"}, 0},
+	} {
+		o, err := parseOpen(openMessage(&tt.cfg, tt.hold, false))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if o.asn != tt.cfg.ASN || o.holdTime != tt.hold || !o.fourOctetAS || !o.ipv6 {
+			t.Errorf("%s: unexpected OPEN %+v", tt.name, o)
+		}
+		if o.gracefulRestart != (tt.cfg.RestartTime > 0) {
+			t.Errorf("%s: expected graceful restart %v", tt.name, tt.cfg.RestartTime > 0)
+		}
+	}
+}
+
+func TestParseOpenInvalid(t *testing.T) {
+	valid := openMessage(&Config{ASN: 64512, RouterID: net.ParseIP("10.0.0.1")}, 90, false)
+
+	for _, tt := range []struct {
+		name string
+		body []byte
+	}{
+		{"short", valid[:9]},
+		{"parameters length", append(append([]byte(nil), valid...), 0)},
+		{"truncated parameter", append(append([]byte(nil), valid[:9]...), 2, 2, 4)},
+	} {
+		if _, err := parseOpen(tt.body); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestGracefulRestartCapability(t *testing.T) {
+	for _, tt := range []struct {
+		restartTime time.Duration
+		restarting  bool
+		flags, low  byte
+	}{
+		{120 * time.Second, false, 0, 120},
+		{300 * time.Second, false, 1, 44},
+		{300 * time.Second, true, 0x81, 44},
+		{2 * time.Hour, false, 0x0f, 0xff},
+	} {
+		c := gracefulRestartCapability(tt.restartTime, tt.restarting)
+		if c[0] != capGracefulRestart || int(c[1]) != len(c)-2 || c[2] != tt.flags || c[3] != tt.low {
+			t.Errorf("%v (restarting %v): unexpected capability %v", tt.restartTime, tt.restarting, c)
+		}
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	short := attribute(flagOptional, attrCommunities, make([]byte, 4))
+	if !bytes.Equal(short[:3], []byte{flagOptional, attrCommunities, 4}) || len(short) != 7 {
+		t.Errorf("unexpected attribute %v", short)
+	}
+
+	long := attribute(flagOptional, attrCommunities, make([]byte, 300))
+	if !bytes.Equal(long[:4], []byte{flagOptional | flagExtendedLen, attrCommunities, 1, 44}) || len(long) != 304 {
+		t.Errorf("unexpected extended-length attribute %v", long[:4])
+	}
+}
+
+// testSession returns a session with a neighbor of the given ASN, as
+// negotiated with both the four-octet AS and the IPv6 capabilities
+func testSession(asn uint32) *session {
+	return &session{
+		speaker:     &Speaker{cfg: Config{ASN: 64512, RouterID: net.ParseIP("10.0.0.1")}},
+		neighbor:    Neighbor{Address: "10.0.0.254", ASN: asn},
+		fourOctetAS: true,
+		ipv6:        true,
+	}
+}
+
+func TestUpdateRoundTrip(t *testing.T) {
+	s := testSession(64513)
+
+	for _, tt := range []struct {
+		route routes.Route
+	}{
+		{routes.Route{Prefix: "10.1.2.3/32", NextHop: "10.0.0.1"}},
+		{routes.Route{Prefix: "10.2.0.0/16", NextHop: "10.0.0.1", Communities: []string{"64512:100"}}},
+		{routes.Route{Prefix: "2001:db8::1/128", NextHop: "2001:db8::ffff"}},
+	} {
+		announced, withdrawn, err := parseUpdate(s.announceMessage(&tt.route))
+		if err != nil {
+			t.Errorf("%s: failed to parse announcement: %v", tt.route.Prefix, err)
+			continue
+		}
+		if !reflect.DeepEqual(announced, []string{tt.route.Prefix}) || len(withdrawn) > 0 {
+			t.Errorf("%s: announcement parsed as %v, withdrawing %v", tt.route.Prefix, announced, withdrawn)
+		}
+
+		announced, withdrawn, err = parseUpdate(s.withdrawMessage(&tt.route))
+		if err != nil {
+			t.Errorf("%s: failed to parse withdrawal: %v", tt.route.Prefix, err)
+			continue
+		}
+		if !reflect.DeepEqual(withdrawn, []string{tt.route.Prefix}) || len(announced) > 0 {
+			t.Errorf("%s: withdrawal parsed as %v, announcing %v", tt.route.Prefix, withdrawn, announced)
+		}
+	}
+}
+
+func TestAnnounceMessage(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		s     *session
+		route routes.Route
+		sent  bool
+	}{
+		{"ipv4", testSession(64513), routes.Route{Prefix: "10.1.2.3/32", NextHop: "10.0.0.1"}, true},
+		{"invalid prefix", testSession(64513), routes.Route{Prefix: "10.1.2.3"}, false},
+		{"ipv6 without the capability", &session{speaker: testSession(64513).speaker, neighbor: Neighbor{ASN: 64513}}, routes.Route{Prefix: "2001:db8::1/128", NextHop: "2001:db8::ffff"}, false},
+	} {
+		if got := tt.s.announceMessage(&tt.route); (got != nil) != tt.sent {
+			t.Errorf("%s: expected sent %v, got %v", tt.name, tt.sent, got)
+		}
+	}
+}
+
+func TestASPath(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		fourOctetAS bool
+		asns        []uint32
+		want        []byte
+	}{
+		{"empty", true, nil, nil},
+		{"four-octet", true, []uint32{64512, 4200000000}, []byte{asPathSequence, 2, 0, 0, 0xfc, 0, 0xfa, 0x56, 0xea, 0}},
+		{"two-octet", false, []uint32{64512}, []byte{asPathSequence, 1, 0xfc, 0}},
+		{"AS_TRANS", false, []uint32{4200000000}, []byte{asPathSequence, 1, 0x5b, 0xa0}},
+	} {
+		s := testSession(64513)
+		s.fourOctetAS = tt.fourOctetAS
+
+		if got := s.asPath(tt.asns); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseUpdateInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		body []byte
+	}{
+		{"short", []byte{0}},
+		{"withdrawn routes length", []byte{0, 5, 0, 0}},
+		{"path attributes length", []byte{0, 0, 0, 9}},
+		{"truncated attribute", []byte{0, 0, 0, 3, flagOptional, attrMPReach, 9}},
+		{"truncated MP_REACH_NLRI", []byte{0, 0, 0, 5, flagOptional, attrMPReach, 2, 0, afiIPv6}},
+	} {
+		if _, _, err := parseUpdate(tt.body); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,732 @@
+// Package speaker implements a minimal, announce-only BGP speaker for
+// deployments which only need to advertise routes, so that gobgpd is not
+// needed at all.  It keeps no RIB: routes received from neighbors are never
+// accepted, though their prefixes may be retained for inspection.
+package speaker
+
+import (
+	"context"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/features"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+)
+
+// Gate is the feature gate of the speakers which run within kube-bgp itself.
+// It is beta, since the built-in speaker shipped before the gates, but may be
+// disabled to keep every session in an external gobgpd.
+var Gate = features.New("EmbeddedSpeaker", features.Beta)
+
+// DefaultHoldTime is the hold time proposed to neighbors
+var DefaultHoldTime = 90 * time.Second
+
+// ConnectRetryInterval is the amount of time to wait before reconnecting to a neighbor whose session failed
+var ConnectRetryInterval = 10 * time.Second
+
+// refreshQueueLen is the number of refreshes which may be pending for a single neighbor
+const refreshQueueLen = 16
+
+// DefaultPort is the default TCP port of BGP neighbors
+const DefaultPort = 179
+
+// Config describes the local BGP speaker
+type Config struct {
+	// ASN is the local Autonomous System Number
+	ASN uint32
+
+	// RouterID is the local BGP identifier, which must be an IPv4 address
+	RouterID net.IP
+
+	// Hostname is the name by which the Speaker identifies itself to its
+	// neighbors.  This is optional, and if not supplied, no name is sent.
+	Hostname string
+
+	// DomainName is the domain sent alongside the Hostname
+	DomainName string
+
+	// RestartTime is the time within which the Speaker undertakes to
+	// re-establish its sessions should it restart, advertised in the
+	// graceful restart capability (RFC 4724), during which neighbors
+	// retain its routes.  If zero, graceful restart is not advertised.
+	RestartTime time.Duration
+
+	// Restarting indicates that the Speaker is taking over from a previous
+	// speaker of this Node (a restart, as far as its neighbors can tell), so
+	// that neighbors retain the routes of the previous speaker until the
+	// Speaker has sent its own.  It is only meaningful with a RestartTime.
+	Restarting bool
+
+	// StateChange is called with the address of a neighbor whenever its
+	// session is established or goes down, along with the reason for it going
+	// down.  This is optional.
+	StateChange func(address string, established bool, reason error)
+}
+
+// Neighbor describes a BGP neighbor of the Speaker
+type Neighbor struct {
+	// Address is the address of the neighbor
+	Address string
+
+	// ASN is the Autonomous System Number of the neighbor
+	ASN uint32
+
+	// Port is the TCP port of the neighbor.
+	// If zero, DefaultPort is used.
+	Port int
+
+	// MinAdvertisementInterval is the minimum interval between the batches
+	// of UPDATEs sent to the neighbor (its MRAI), so that bursts of route
+	// changes are sent together rather than as a storm of UPDATEs.
+	// If zero, changes are sent at once.
+	MinAdvertisementInterval time.Duration
+
+	// LocalAddress is the local address of the session.
+	// If empty, it is chosen by the routing table.
+	LocalAddress string
+
+	// Interface is the local interface to which the session is bound, such
+	// as the link to the neighbor of a dual-homed host.
+	// If empty, the session is not bound to any.
+	Interface string
+
+	// SoftReconfigurationInbound retains the prefixes received from the
+	// neighbor (its Adj-RIB-In), which are otherwise discarded, so that they
+	// may be inspected.  None of them is ever accepted.
+	SoftReconfigurationInbound bool
+}
+
+// Speaker is an announce-only BGP speaker
+type Speaker struct {
+	cfg Config
+	ctx context.Context
+
+	mu       sync.Mutex
+	routes   map[string]routes.Route
+	sessions map[Neighbor]*session
+
+	// handoff indicates that the sessions are being closed for another
+	// speaker to take over, without withdrawing the routes
+	handoff bool
+}
+
+// New returns a new Speaker which runs until the given context is cancelled
+func New(ctx context.Context, cfg Config) (*Speaker, error) {
+	if cfg.RouterID.To4() == nil {
+		return nil, eris.Errorf("router ID %s is not an IPv4 address", cfg.RouterID)
+	}
+
+	return &Speaker{
+		cfg:      cfg,
+		ctx:      ctx,
+		routes:   make(map[string]routes.Route),
+		sessions: make(map[Neighbor]*session),
+	}, nil
+}
+
+// SetNeighbors sets the list of neighbors of the Speaker, establishing
+// sessions to new neighbors and closing those to neighbors which are no
+// longer listed
+func (s *Speaker) SetNeighbors(list []Neighbor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want := make(map[Neighbor]bool, len(list))
+	for _, n := range list {
+		want[n] = true
+
+		if _, ok := s.sessions[n]; !ok {
+			s.sessions[n] = s.startSession(n)
+		}
+	}
+
+	for n, sess := range s.sessions {
+		if !want[n] {
+			sess.cancel()
+			delete(s.sessions, n)
+		}
+	}
+}
+
+// Handoff closes every session without a NOTIFICATION, as though the
+// Speaker had restarted, so that neighbors which negotiated graceful restart
+// retain its routes until another speaker of this Node takes over.  The
+// Speaker may not be used thereafter.
+func (s *Speaker) Handoff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handoff = true
+
+	for n, sess := range s.sessions {
+		sess.cancel()
+		delete(s.sessions, n)
+	}
+}
+
+// handingOff indicates whether the sessions are being closed by Handoff
+func (s *Speaker) handingOff() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handoff
+}
+
+// AddPath implements routes.Speaker
+func (s *Speaker) AddPath(ctx context.Context, r routes.Route) error {
+	s.mu.Lock()
+	s.routes[r.Prefix] = r
+	s.mu.Unlock()
+
+	s.changed()
+
+	return nil
+}
+
+// DeletePath implements routes.Speaker
+func (s *Speaker) DeletePath(ctx context.Context, r routes.Route) error {
+	s.mu.Lock()
+	delete(s.routes, r.Prefix)
+	s.mu.Unlock()
+
+	s.changed()
+
+	return nil
+}
+
+// Refresh re-sends every route to the neighbor with the given address,
+// without resetting the session
+func (s *Speaker) Refresh(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, sess := range s.sessions {
+		if n.Address == address {
+			return sess.requestRefresh("")
+		}
+	}
+
+	return eris.Errorf("no neighbor %s", address)
+}
+
+// RefreshPath implements routes.Refresher
+func (s *Speaker) RefreshPath(ctx context.Context, r routes.Route) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		if err := sess.requestRefresh(r.Prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changed notifies every session that the set of routes has changed
+func (s *Speaker) changed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		sess.dirty.Set()
+	}
+}
+
+// Routes returns the routes which the Speaker announces
+func (s *Speaker) Routes() []routes.Route {
+	m := s.snapshot()
+
+	list := make([]routes.Route, 0, len(m))
+	for _, r := range m {
+		list = append(list, r)
+	}
+
+	return list
+}
+
+// Neighbors returns the list of the neighbors of the Speaker
+func (s *Speaker) Neighbors() []Neighbor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Neighbor, 0, len(s.sessions))
+	for n := range s.sessions {
+		list = append(list, n)
+	}
+
+	return list
+}
+
+// Received returns the prefixes retained from the neighbor with the given
+// address, and whether they are retained at all: only those of a neighbor
+// with SoftReconfigurationInbound are
+func (s *Speaker) Received(address string) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, sess := range s.sessions {
+		if n.Address == address && n.SoftReconfigurationInbound {
+			return sess.received(), true
+		}
+	}
+
+	return nil, false
+}
+
+// snapshot returns a copy of the current set of routes
+func (s *Speaker) snapshot() map[string]routes.Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[string]routes.Route, len(s.routes))
+	for k, v := range s.routes {
+		m[k] = v
+	}
+
+	return m
+}
+
+func (s *Speaker) startSession(n Neighbor) *session {
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	sess := &session{
+		speaker:  s,
+		neighbor: n,
+		cancel:   cancel,
+		dirty:    dirty.New(),
+		refresh:  make(chan string, refreshQueueLen),
+	}
+
+	go sess.run(ctx)
+
+	return sess
+}
+
+// session is the BGP session with a single neighbor
+type session struct {
+	speaker  *Speaker
+	neighbor Neighbor
+	cancel   context.CancelFunc
+
+	// dirty is signaled whenever the Speaker's routes change
+	dirty *dirty.Flag
+
+	// refresh receives the prefixes which should be re-sent to the neighbor
+	// even though they are unchanged; the empty prefix means all of them
+	refresh chan string
+
+	// connected indicates whether any connection has been established, after
+	// which the session is no longer restarting
+	connected bool
+
+	// state negotiated with the neighbor for the current connection
+	conn            net.Conn
+	fourOctetAS     bool
+	ipv6            bool
+	gracefulRestart bool
+
+	// sent is the set of routes announced over the current connection
+	sent map[string]routes.Route
+
+	// adjRIBIn is the set of prefixes received over the current connection,
+	// if the neighbor has SoftReconfigurationInbound
+	ribMu    sync.Mutex
+	adjRIBIn map[string]bool
+}
+
+// received returns the prefixes received over the current connection
+func (s *session) received() []string {
+	s.ribMu.Lock()
+	defer s.ribMu.Unlock()
+
+	list := make([]string, 0, len(s.adjRIBIn))
+	for p := range s.adjRIBIn {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// receive records the prefixes of an UPDATE received from the neighbor.  An
+// UPDATE which cannot be parsed is discarded, since none is acted upon.
+func (s *session) receive(body []byte) {
+	announced, withdrawn, err := parseUpdate(body)
+	if err != nil {
+		log.Printf("builtin speaker: discarding UPDATE from %s: %v", s.neighbor.Address, err)
+		return
+	}
+
+	s.ribMu.Lock()
+	defer s.ribMu.Unlock()
+
+	// The connection may have closed since the UPDATE was read
+	if s.adjRIBIn == nil {
+		return
+	}
+
+	for _, p := range withdrawn {
+		delete(s.adjRIBIn, p)
+	}
+	for _, p := range announced {
+		s.adjRIBIn[p] = true
+	}
+}
+
+// requestRefresh asks the session to re-send the given prefix (or, if empty, every prefix)
+func (s *session) requestRefresh(prefix string) error {
+	select {
+	case s.refresh <- prefix:
+		return nil
+	default:
+		return eris.Errorf("too many refreshes pending for neighbor %s", s.neighbor.Address)
+	}
+}
+
+// internal indicates whether the neighbor is an iBGP neighbor
+func (s *session) internal() bool {
+	return s.neighbor.ASN == s.speaker.cfg.ASN
+}
+
+// nextHop returns the next-hop to be sent for the given route in the given
+// family: the route's own next-hop if it is of that family, or the local
+// address of the session if it is.
+func (s *session) nextHop(r *routes.Route, ipv6 bool) net.IP {
+	if ip := net.ParseIP(r.NextHop); ip != nil && (ip.To4() == nil) == ipv6 {
+		return ip
+	}
+
+	if addr, ok := s.conn.LocalAddr().(*net.TCPAddr); ok && (addr.IP.To4() == nil) == ipv6 {
+		return addr.IP
+	}
+
+	return nil
+}
+
+func (s *session) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := s.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("builtin speaker: session with %s down: %v", s.neighbor.Address, err)
+
+		if s.speaker.cfg.StateChange != nil {
+			s.speaker.cfg.StateChange(s.neighbor.Address, false, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(ConnectRetryInterval):
+		}
+	}
+}
+
+// connect establishes a single connection with the neighbor and runs it until it fails
+func (s *session) connect(ctx context.Context) error {
+	port := s.neighbor.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	d := net.Dialer{Timeout: ConnectRetryInterval}
+	if s.neighbor.LocalAddress != "" {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(s.neighbor.LocalAddress)}
+	}
+	if s.neighbor.Interface != "" {
+		d.Control = bindToDevice(s.neighbor.Interface)
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(s.neighbor.Address, strconv.Itoa(port)))
+	if err != nil {
+		return eris.Wrap(err, "failed to connect")
+	}
+	defer conn.Close() // nolint: errcheck
+
+	s.conn = conn
+	s.sent = make(map[string]routes.Route)
+
+	s.ribMu.Lock()
+	s.adjRIBIn = make(map[string]bool)
+	s.ribMu.Unlock()
+
+	defer func() {
+		s.ribMu.Lock()
+		s.adjRIBIn = nil
+		s.ribMu.Unlock()
+	}()
+
+	holdTime, err := s.handshake()
+	if err != nil {
+		return err
+	}
+
+	s.connected = true
+
+	log.Printf("builtin speaker: session with %s established", s.neighbor.Address)
+
+	if s.speaker.cfg.StateChange != nil {
+		s.speaker.cfg.StateChange(s.neighbor.Address, true, nil)
+	}
+
+	// Read everything the neighbor sends, watching for keepalives and errors,
+	// and discarding the UPDATEs unless their prefixes are retained
+	readErr := make(chan error, 1)
+	received := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			typ, body, err := readMessage(conn)
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			if typ == msgNotification {
+				readErr <- notificationError(body)
+				return
+			}
+
+			if typ == msgUpdate && s.neighbor.SoftReconfigurationInbound {
+				s.receive(body)
+			}
+
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	var keepalive <-chan time.Time
+	var hold *time.Timer
+
+	if holdTime > 0 {
+		ticker := time.NewTicker(holdTime / 3)
+		defer ticker.Stop()
+		keepalive = ticker.C
+
+		hold = time.NewTimer(holdTime)
+		defer hold.Stop()
+	} else {
+		// A zero hold time disables both keepalives and the hold timer
+		hold = time.NewTimer(0)
+		hold.Stop()
+	}
+
+	if err := s.sync(); err != nil {
+		return err
+	}
+
+	if err := s.endOfRIB(); err != nil {
+		return err
+	}
+
+	// Changes within the MinAdvertisementInterval of the last batch are batched until it has passed
+	lastSync := time.Now()
+	var batch <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			if !s.speaker.handingOff() {
+				writeMessage(conn, msgNotification, notificationMessage(6, 2)) // nolint: errcheck
+			}
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case <-received:
+			if holdTime > 0 {
+				hold.Reset(holdTime)
+			}
+		case <-hold.C:
+			writeMessage(conn, msgNotification, notificationMessage(4, 0)) // nolint: errcheck
+			return eris.New("hold timer expired")
+		case <-keepalive:
+			if err := writeMessage(conn, msgKeepalive, nil); err != nil {
+				return eris.Wrap(err, "failed to send KEEPALIVE")
+			}
+		case <-s.dirty.C():
+			if wait := s.neighbor.MinAdvertisementInterval - time.Since(lastSync); wait > 0 {
+				if batch == nil {
+					batch = time.After(wait)
+				}
+				continue
+			}
+
+			if err := s.sync(); err != nil {
+				return err
+			}
+			lastSync = time.Now()
+		case <-batch:
+			batch = nil
+
+			if err := s.sync(); err != nil {
+				return err
+			}
+			lastSync = time.Now()
+		case prefix := <-s.refresh:
+			if prefix == "" {
+				s.sent = make(map[string]routes.Route)
+			} else {
+				delete(s.sent, prefix)
+			}
+
+			if err := s.sync(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handshake exchanges OPEN and KEEPALIVE messages with the neighbor,
+// returning the negotiated hold time
+func (s *session) handshake() (time.Duration, error) {
+	cfg := s.speaker.cfg
+
+	if err := s.conn.SetDeadline(time.Now().Add(DefaultHoldTime)); err != nil {
+		return 0, eris.Wrap(err, "failed to set handshake deadline")
+	}
+	defer s.conn.SetDeadline(time.Time{}) // nolint: errcheck
+
+	restarting := cfg.Restarting && !s.connected
+
+	if err := writeMessage(s.conn, msgOpen, openMessage(&cfg, uint16(DefaultHoldTime/time.Second), restarting)); err != nil {
+		return 0, eris.Wrap(err, "failed to send OPEN")
+	}
+
+	typ, body, err := readMessage(s.conn)
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to read OPEN")
+	}
+	if typ == msgNotification {
+		return 0, notificationError(body)
+	}
+	if typ != msgOpen {
+		return 0, eris.Errorf("expected OPEN, received message type %d", typ)
+	}
+
+	open, err := parseOpen(body)
+	if err != nil {
+		writeMessage(s.conn, msgNotification, notificationMessage(2, 0)) // nolint: errcheck
+		return 0, eris.Wrap(err, "invalid OPEN")
+	}
+
+	if open.asn != s.neighbor.ASN {
+		writeMessage(s.conn, msgNotification, notificationMessage(2, 2)) // nolint: errcheck
+		return 0, eris.Errorf("neighbor AS %d does not match expected AS %d", open.asn, s.neighbor.ASN)
+	}
+
+	s.fourOctetAS = open.fourOctetAS
+	s.ipv6 = open.ipv6
+	s.gracefulRestart = open.gracefulRestart && cfg.RestartTime > 0
+
+	if err := writeMessage(s.conn, msgKeepalive, nil); err != nil {
+		return 0, eris.Wrap(err, "failed to send KEEPALIVE")
+	}
+
+	// Wait for the neighbor to confirm the session
+	typ, body, err = readMessage(s.conn)
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to read KEEPALIVE")
+	}
+	if typ == msgNotification {
+		return 0, notificationError(body)
+	}
+	if typ != msgKeepalive {
+		return 0, eris.Errorf("expected KEEPALIVE, received message type %d", typ)
+	}
+
+	holdTime := DefaultHoldTime
+	if peerHold := time.Duration(open.holdTime) * time.Second; peerHold < holdTime {
+		holdTime = peerHold
+	}
+
+	return holdTime, nil
+}
+
+// sync sends the UPDATEs needed to bring the neighbor in line with the Speaker's current routes
+func (s *session) sync() error {
+	desired := s.speaker.snapshot()
+
+	for prefix, r := range desired {
+		if !r.ExportedTo(s.neighbor.Address) {
+			delete(desired, prefix)
+		}
+	}
+
+	for prefix, r := range s.sent {
+		if _, ok := desired[prefix]; ok {
+			continue
+		}
+
+		if msg := s.withdrawMessage(&r); msg != nil {
+			if err := writeMessage(s.conn, msgUpdate, msg); err != nil {
+				return eris.Wrapf(err, "failed to withdraw %s", prefix)
+			}
+		}
+
+		delete(s.sent, prefix)
+	}
+
+	for prefix, r := range desired {
+		if old, ok := s.sent[prefix]; ok && reflect.DeepEqual(old, r) {
+			continue
+		}
+
+		msg := s.announceMessage(&r)
+		if msg == nil {
+			continue // not expressible on this session (e.g. IPv6 without an IPv6 next-hop)
+		}
+
+		if err := writeMessage(s.conn, msgUpdate, msg); err != nil {
+			return eris.Wrapf(err, "failed to announce %s", prefix)
+		}
+
+		s.sent[prefix] = r
+	}
+
+	return nil
+}
+
+// endOfRIB sends the End-of-RIB marker of each family, by which a neighbor
+// which negotiated graceful restart knows that every route has been sent
+// and flushes those of the previous connection which were not sent again
+func (s *session) endOfRIB() error {
+	if !s.gracefulRestart {
+		return nil
+	}
+
+	if err := writeMessage(s.conn, msgUpdate, updateMessage(nil, nil, nil)); err != nil {
+		return eris.Wrap(err, "failed to send IPv4 End-of-RIB")
+	}
+
+	if s.ipv6 {
+		eor := attribute(flagOptional, attrMPUnreach, []byte{0, afiIPv6, safiUnicast})
+		if err := writeMessage(s.conn, msgUpdate, updateMessage(nil, eor, nil)); err != nil {
+			return eris.Wrap(err, "failed to send IPv6 End-of-RIB")
+		}
+	}
+
+	return nil
+}
+
+func notificationError(body []byte) error {
+	if len(body) < 2 {
+		return eris.New("received NOTIFICATION")
+	}
+
+	return eris.Errorf("received NOTIFICATION (code %d, subcode %d)", body[0], body[1])
+}
+
+// ensure Speaker implements routes.Speaker
+var _ routes.Speaker = (*Speaker)(nil)
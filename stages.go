@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/speaker"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// speakerOutputs are the speakers to which the desired state is applied,
+// each through its own Advertiser.  They change as the outputs are
+// reconfigured at runtime.
+type speakerOutputs struct {
+	advertisers []*routes.Advertiser
+
+	// names is the name of the output of each of the advertisers
+	names []string
+
+	gobgpAdvertiser *routes.Advertiser
+	gobgpd          *gobgp.Client
+	builtin         *speaker.Speaker
+}
+
+// remove stops applying the desired state to the named output
+func (o *speakerOutputs) remove(name string) {
+	for i := range o.names {
+		if o.names[i] == name {
+			o.advertisers = append(o.advertisers[:i:i], o.advertisers[i+1:]...)
+			o.names = append(o.names[:i:i], o.names[i+1:]...)
+			return
+		}
+	}
+}
+
+// ownership is the ownership of this Node by this instance, which a standby
+// takes over once its state matches that of the owner
+type ownership interface {
+	// Owner indicates whether this instance owns the Node
+	Owner() bool
+
+	// Verify compares the given hash of the desired state with that of the
+	// owner, taking over the Node if they match
+	Verify(ctx context.Context, stateHash string) bool
+}
+
+// observeStage observes the state of the cluster and, when the sessions are
+// reconfigured, checks it for hazards and saves it to the state cache
+type observeStage struct {
+	cfg       *KubeBGPConfig
+	observe   func() *clusterState
+	synced    func() bool
+	hazards   *hazardMonitor
+	ownership ownership
+}
+
+func (s *observeStage) Name() string {
+	return stageObserve
+}
+
+func (s *observeStage) Run(ctx context.Context, r *reconcileRun) error {
+	if r.state == nil {
+		r.state = s.observe()
+	}
+	debugUpdate(func(d *StateDump) { d.State = r.state })
+
+	if !r.sessions {
+		return nil
+	}
+
+	debugPhase("check hazards")
+	s.hazards.Check(r.state.Services)
+
+	debugPhase("save state cache")
+	if s.cfg.StateCache != nil && s.synced() && s.ownership.Owner() {
+		if err := s.cfg.StateCache.save(r.state); err != nil {
+			status.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// desireStage derives the desired sessions and routes from the observed
+// state, holding back the routes which the gates do not yet allow
+type desireStage struct {
+	cfg      *KubeBGPConfig
+	nodeName string
+
+	conditions *conditionMonitor
+	bootstrap  *bootstrapGate
+	holdDown   *holdDownGate
+	takeover   *takeoverGate
+	freeze     *freezeGate
+
+	// peers and routers are the sessions last configured, which are kept
+	// by the reconciles which only re-advertise the routes
+	peers   []Peer
+	routers []Router
+
+	// holdDownRelease fires once the next of the routes held down is due
+	// for release, scheduleChange once the next window of the scheduled
+	// advertisements opens or closes, and takeoverCheck once the deferred
+	// takeovers should be re-checked
+	holdDownRelease <-chan time.Time
+	scheduleChange  <-chan time.Time
+	takeoverCheck   <-chan time.Time
+}
+
+func (s *desireStage) Name() string {
+	return stageDesire
+}
+
+func (s *desireStage) Run(ctx context.Context, r *reconcileRun) error {
+	cfg := s.cfg
+
+	if r.sessions {
+		s.peers, s.routers = peers(s.nodeName, cfg, r.state), localRouters(s.nodeName, cfg, r.state)
+
+		debugUpdate(func(d *StateDump) {
+			d.DesiredPeers = s.peers
+			d.DesiredRouters = s.routers
+		})
+
+		if cfg.hasOutput(outputStatus) {
+			status.SetDesiredSessions(peerSessions(s.peers), routerSessions(s.routers))
+		}
+	}
+	r.peers, r.routers = s.peers, s.routers
+
+	s.conditions.Check(findNode(s.nodeName, r.state.Nodes))
+
+	intended, errs := desiredRoutes(cfg, s.nodeName, r.state, s.bootstrap.Open(ctx, r.state.Nodes))
+	for _, err := range errs {
+		status.Error(err)
+	}
+	r.intended, r.dryRun = splitDryRun(cfg, intended)
+
+	desired, release := s.holdDown.Apply(cfg, r.intended, time.Now())
+	desired, deferred := s.takeover.Apply(ctx, desired, time.Now())
+	r.desired = s.freeze.Apply(desired, s.nodeName, r.state)
+
+	s.holdDownRelease = nil
+	if release > 0 {
+		s.holdDownRelease = time.After(release)
+	}
+
+	s.scheduleChange = nil
+	if next := cfg.nextScheduleChange(time.Now()); !next.IsZero() {
+		s.scheduleChange = time.After(time.Until(next))
+	}
+
+	s.takeoverCheck = nil
+	if deferred {
+		s.takeoverCheck = time.After(takeoverCheckInterval)
+	}
+
+	r.model = desiredModel(cfg, findNode(s.nodeName, r.state.Nodes), r.peers, r.routers, r.desired)
+
+	debugUpdate(func(d *StateDump) {
+		d.DesiredRoutes = r.desired
+		d.DesiredModel = r.model
+	})
+
+	if cfg.hasOutput(outputStatus) {
+		status.SetDesiredRoutes(r.desired)
+	}
+
+	return nil
+}
+
+// diffStage compares the desired state with that last applied and, by a
+// standby, with that of the owner, halting the reconcile until they match
+type diffStage struct {
+	cfg       *KubeBGPConfig
+	nodeName  string
+	diffs     *reconcileDiffer
+	applies   *applyTracker
+	ownership ownership
+	outputs   *speakerOutputs
+	vrfs      *vrfProvisioner
+
+	// serve starts the servers which only the owner of the Node runs, once
+	// a standby has taken it over
+	serve func(ctx context.Context)
+
+	// handoverCheck fires once a standby should compare its state with
+	// that of the owner again
+	handoverCheck <-chan time.Time
+}
+
+func (s *diffStage) Name() string {
+	return stageDiff
+}
+
+func (s *diffStage) Run(ctx context.Context, r *reconcileRun) error {
+	s.diffs.Log(r.trigger, r.peers, r.routers, r.desired)
+
+	s.handoverCheck = nil
+	if !s.ownership.Owner() {
+		debugPhase("verify handover")
+
+		if !s.ownership.Verify(ctx, appliedStateHash(r.peers, r.routers, r.desired)) {
+			s.handoverCheck = time.After(handoverCheckInterval)
+			return errHalt
+		}
+
+		// Everything is re-applied as this instance's own, though it is identical.
+		for _, a := range s.outputs.advertisers {
+			a.Reset()
+		}
+		s.vrfs.Reset()
+
+		s.serve(ctx)
+
+		r.sessions = true
+	}
+
+	reportDryRun(s.cfg, dryRunRouters(s.nodeName, s.cfg, r.state), r.intended, r.dryRun)
+
+	r.intendedHash = appliedStateHash(r.peers, r.routers, r.intended)
+	s.applies.Observe(r.intendedHash, time.Now())
+
+	return nil
+}
+
+// applyStage applies the desired sessions and routes to the speakers, and
+// writes the status of the objects which they announce.  A failed apply is
+// retried with backoff.
+type applyStage struct {
+	cfg           *KubeBGPConfig
+	nodeName      string
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	recorder      events.Recorder
+	synced        func() bool
+	dns           *dnsPublisher
+	vrfs          *vrfProvisioner
+	outputs       *speakerOutputs
+
+	// sessions applies the desired BGP sessions of the given reconcile to
+	// the speakers, returning whether they should be retried
+	sessions func(ctx context.Context, r *reconcileRun) (failed bool)
+
+	// retry fires once a failed apply should be retried, after backoff
+	retry   <-chan time.Time
+	backoff time.Duration
+}
+
+func (s *applyStage) Name() string {
+	return stageApply
+}
+
+func (s *applyStage) Run(ctx context.Context, r *reconcileRun) error {
+	cfg := s.cfg
+
+	var failed bool
+
+	if r.sessions {
+		debugPhase("reconfigure")
+		failed = s.sessions(ctx, r)
+	}
+
+	if cfg.EgressIPs != nil && s.synced() {
+		debugPhase("update egress IP status")
+		updateEgressStatus(ctx, s.dynamicClient, s.recorder, s.nodeName, r.state)
+	}
+
+	if cfg.Services != nil && cfg.Services.WriteStatus && s.synced() {
+		debugPhase("update service status")
+		updateServiceStatus(ctx, s.clientset, cfg.Services, s.nodeName, r.desired, r.state)
+	}
+
+	if s.dns != nil && s.synced() {
+		debugPhase("publish DNS records")
+		if err := s.dns.Publish(ctx, dnsRecords(cfg, r.desired, r.state), r.state); err != nil {
+			status.Error(err)
+		}
+	}
+
+	if cfg.Tenants && s.outputs.gobgpAdvertiser != nil {
+		debugPhase("provision VRFs")
+
+		changed, err := s.vrfs.Ensure(ctx, r.state.Tenants)
+		if err != nil {
+			status.Error(err)
+			failed = true
+		}
+		if changed {
+			s.outputs.gobgpAdvertiser.Reset()
+		}
+	}
+
+	for i, a := range s.outputs.advertisers {
+		name := s.outputs.names[i]
+		debugPhase("apply routes to " + name)
+
+		if err := a.Apply(ctx, r.desired); err != nil {
+			status.Error(err)
+			failed = true
+		}
+
+		applied := a.Applied()
+		debugUpdate(func(d *StateDump) { d.Applied[name] = applied })
+	}
+
+	debugUpdate(func(d *StateDump) { d.RetryPending = failed })
+
+	if failed {
+		s.retry = time.After(s.backoff)
+		if s.backoff *= 2; s.backoff > maxReapplyBackoff {
+			s.backoff = maxReapplyBackoff
+		}
+
+		return eris.New("failed to apply the desired state")
+	}
+
+	s.retry = nil
+	s.backoff = minReapplyBackoff
+
+	return nil
+}
+
+// verifyStage publishes what was applied, verifying it against what the
+// speakers export where they are asked to
+type verifyStage struct {
+	cfg        *KubeBGPConfig
+	synced     func() bool
+	applies    *applyTracker
+	ribs       *ribVerifier
+	nodeStatus *nodeStatusWriter
+	outputs    *speakerOutputs
+}
+
+func (s *verifyStage) Name() string {
+	return stageVerify
+}
+
+func (s *verifyStage) Run(ctx context.Context, r *reconcileRun) error {
+	cfg := s.cfg
+
+	publishAppliedHashes(cfg, r.peers, r.routers, r.desired)
+	s.applies.Applied(appliedStateHash(r.peers, r.routers, r.desired), r.intendedHash, time.Now())
+
+	originated, exported := countPrefixes(r.desired), exportCounts(cfg, r.peers, r.routers, r.desired)
+	publishExportCounts(originated, exported)
+
+	if cfg.VerifyAdjRIBOut && s.outputs.gobgpd != nil {
+		debugPhase("verify adj-rib-out")
+		if err := s.ribs.Verify(ctx, exportedRoutes(cfg, r.peers, r.routers, r.desired)); err != nil {
+			status.Error(err)
+		}
+	}
+
+	// The speakers not in use are passed as nil interfaces, rather than as nil pointers.
+	var gobgpd neighborLister
+	if s.outputs.gobgpd != nil {
+		gobgpd = s.outputs.gobgpd
+	}
+
+	var builtin receivedLister
+	if s.outputs.builtin != nil {
+		builtin = s.outputs.builtin
+	}
+
+	debugPhase("publish received routes")
+	if err := publishReceivedRoutes(ctx, gobgpd, builtin, r.routers); err != nil {
+		status.Error(err)
+	}
+
+	if s.nodeStatus != nil && s.synced() {
+		debugPhase("write node status")
+		if err := s.nodeStatus.Write(ctx, originated, exported, r.state); err != nil {
+			status.Error(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// maxPrependCount is the largest number of times the AS path of a backup may be prepended
+const maxPrependCount = 10
+
+// standbyRole is the role of a Node in the announcement of an Advertisement with a Standby
+type standbyRole int
+
+const (
+	// standbyNone indicates that the Node does not announce the routes
+	standbyNone standbyRole = iota
+
+	// standbyPrimary indicates that the Node announces the routes as usual
+	standbyPrimary
+
+	// standbyBackup indicates that the Node announces the routes less preferably
+	standbyBackup
+)
+
+// Standby designates the primary and backup Nodes of an Advertisement (such
+// as of a VIP).  The backups announce the routes as well, but less
+// preferably, so that the routers already hold the backup paths and fail
+// over to them as soon as the primaries withdraw theirs, without waiting for
+// any election.
+type Standby struct {
+	// Primary is the list of Nodes, by name (matched like the peerNodes of a
+	// Router, and which may be glob patterns), which announce the routes as
+	// usual
+	Primary []string `yaml:"primary"`
+
+	// Backup is the list of Nodes which announce the routes as backups.
+	// This is optional, and if not supplied, every Node which is not a
+	// primary is a backup.
+	Backup []string `yaml:"backup"`
+
+	// PrependCount is the number of times the local AS is prepended to the
+	// AS path of the routes announced by the backups, which makes them less
+	// preferable to eBGP routers
+	PrependCount int `yaml:"prependCount"`
+
+	// LocalPref is the LOCAL_PREF of the routes announced by the backups,
+	// which makes them less preferable to iBGP peers (such as route
+	// reflectors) if it is below the default of 100
+	LocalPref *uint32 `yaml:"localPref"`
+}
+
+func (s *Standby) validate() error {
+	if len(s.Primary) == 0 {
+		return eris.New("primary is required")
+	}
+
+	if s.PrependCount < 0 || s.PrependCount > maxPrependCount {
+		return eris.Errorf("invalid prependCount %d: must be between 0 and %d", s.PrependCount, maxPrependCount)
+	}
+
+	if s.PrependCount == 0 && s.LocalPref == nil {
+		return eris.New("prependCount or localPref is required, or else the backups are as preferable as the primaries")
+	}
+
+	return nil
+}
+
+// role returns the role of the given Node.  A Node which is listed as both
+// primary and backup is a primary.
+func (s *Standby) role(n *v1.Node) standbyRole {
+	switch {
+	case n == nil:
+		return standbyNone
+	case matchesNode(s.Primary, n):
+		return standbyPrimary
+	case len(s.Backup) == 0 || matchesNode(s.Backup, n):
+		return standbyBackup
+	}
+
+	return standbyNone
+}
+
+// backupRoutes returns the given routes as announced by a backup, whose local AS is the given ASN
+func (s *Standby) backupRoutes(list []routes.Route, asn string) []routes.Route {
+	local, _ := parseASN(asn) // nolint: errcheck // validated at load
+
+	for i := range list {
+		for n := 0; n < s.PrependCount; n++ {
+			list[i].ASPath = append(list[i].ASPath, local)
+		}
+
+		list[i].LocalPref = s.LocalPref
+	}
+
+	return list
+}
@@ -0,0 +1,360 @@
+// Package status tracks the state of kube-bgp for reporting via the status endpoint
+package status
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+)
+
+// ErrorStatus describes the errors encountered in a single category
+type ErrorStatus struct {
+	// Count is the number of errors encountered in this category
+	Count uint64 `json:"count"`
+
+	// LastMessage is the message of the most recent error in this category
+	LastMessage string `json:"lastMessage"`
+
+	// LastTime is the time of the most recent error in this category
+	LastTime time.Time `json:"lastTime"`
+}
+
+// Session describes a desired BGP session
+type Session struct {
+	// Address is the address of the neighbor
+	Address string `json:"address"`
+
+	// Name is the name of the neighbor, if it has one
+	Name string `json:"name,omitempty"`
+
+	// ASN is the Autonomous System Number of the neighbor, if it differs from the system ASN
+	ASN string `json:"asn,omitempty"`
+}
+
+// DesiredState is the BGP state which kube-bgp is applying
+type DesiredState struct {
+	// Peers is the list of desired iBGP sessions to other Nodes
+	Peers []Session `json:"peers"`
+
+	// Routers is the list of desired sessions to Routers
+	Routers []Session `json:"routers"`
+
+	// Routes is the list of routes which this Node should originate
+	Routes []routes.Route `json:"routes"`
+}
+
+// DryRunRouter describes the session which would be configured with a Router in dry run
+type DryRunRouter struct {
+	Session `json:",inline"`
+
+	// Exports is the list of prefixes which would be exported to the Router
+	Exports []string `json:"exports"`
+}
+
+// DryRun is what kube-bgp would apply, but for the advertisements and
+// Routers in dry run
+type DryRun struct {
+	// Routes is the list of the routes of the advertisements in dry run,
+	// which are not advertised
+	Routes []routes.Route `json:"routes,omitempty"`
+
+	// Routers is the list of the Routers in dry run, with which no session
+	// is configured
+	Routers []DryRunRouter `json:"routers,omitempty"`
+}
+
+// RIBDiscrepancy describes how the adj-RIB-out of gobgpd for a neighbor
+// differs from the prefixes which should be exported to it
+type RIBDiscrepancy struct {
+	Session `json:",inline"`
+
+	// Missing is the list of the prefixes which should be, but are not, advertised to the neighbor
+	Missing []string `json:"missing,omitempty"`
+
+	// Unexpected is the list of the prefixes originated by this Node which
+	// are advertised to the neighbor, though they should not be
+	Unexpected []string `json:"unexpected,omitempty"`
+}
+
+// ReceivedRoutes is the number of the prefixes of an address family
+// received from a Router, and of those accepted
+type ReceivedRoutes struct {
+	Session `json:",inline"`
+
+	// Speaker is the speaker which has the session with the Router
+	Speaker string `json:"speaker"`
+
+	// Family is the address family of the prefixes
+	Family string `json:"family"`
+
+	// Received is the number of the prefixes received from the Router (its Adj-RIB-In)
+	Received int `json:"received"`
+
+	// Accepted is the number of the received prefixes which were accepted
+	Accepted int `json:"accepted"`
+}
+
+// Report is the status of kube-bgp as served by the status endpoint
+type Report struct {
+	// Cluster is the name of the cluster of this kube-bgp
+	Cluster string `json:"cluster,omitempty"`
+
+	// Node is the name of the Node of this kube-bgp
+	Node string `json:"node,omitempty"`
+
+	// Features is whether the feature of each feature gate is enabled, by
+	// the name of the gate
+	Features map[string]bool `json:"features,omitempty"`
+
+	// Errors describes the errors encountered, by category
+	Errors map[errcode.Code]ErrorStatus `json:"errors"`
+
+	// Warnings describes the currently-detected configurations which are
+	// known to break advertised traffic
+	Warnings []string `json:"warnings,omitempty"`
+
+	// ResourceWarnings describes the guardrails of the resource usage of
+	// kube-bgp itself which are currently exceeded
+	ResourceWarnings []string `json:"resourceWarnings,omitempty"`
+
+	// ConfigHash is the short hash of the configuration under which the
+	// desired state was last applied successfully
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// AppliedHash is the short hash of the desired state last applied successfully
+	AppliedHash string `json:"appliedHash,omitempty"`
+
+	// LastApplied is the time at which the desired state was last applied successfully
+	LastApplied *time.Time `json:"lastApplied,omitempty"`
+
+	// UnappliedSince is the time of the oldest change of the desired state
+	// which has not yet been applied, if any
+	UnappliedSince *time.Time `json:"unappliedSince,omitempty"`
+
+	// Frozen is the reason for which the advertisements are frozen, if they are
+	Frozen string `json:"frozen,omitempty"`
+
+	// Desired is the BGP state which kube-bgp is applying, if the status
+	// output is enabled
+	Desired *DesiredState `json:"desired,omitempty"`
+
+	// DryRun is what kube-bgp would apply, but for the advertisements and
+	// Routers in dry run, if there are any
+	DryRun *DryRun `json:"dryRun,omitempty"`
+
+	// RIBDiscrepancies describes each neighbor whose adj-RIB-out differs
+	// from the prefixes which should be exported to it, if it is verified
+	RIBDiscrepancies []RIBDiscrepancy `json:"ribDiscrepancies,omitempty"`
+
+	// Received describes the prefixes received from each Router whose
+	// received routes are retained, by address family
+	Received []ReceivedRoutes `json:"received,omitempty"`
+}
+
+var (
+	mu      sync.Mutex
+	current = Report{
+		Errors: make(map[errcode.Code]ErrorStatus),
+	}
+)
+
+// SetIdentity records the cluster and Node of this kube-bgp in the status Report
+func SetIdentity(cluster, node string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.Cluster = cluster
+	current.Node = node
+}
+
+// SetFeatures records whether the feature of each feature gate is enabled in the status Report
+func SetFeatures(m map[string]bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.Features = m
+}
+
+// SetWarnings records the currently-detected hazards in the status Report
+func SetWarnings(list []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.Warnings = list
+}
+
+// SetResourceWarnings records the currently-exceeded resource guardrails in the status Report
+func SetResourceWarnings(list []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.ResourceWarnings = list
+}
+
+// SetAppliedHashes records the short hashes of the configuration and desired
+// state last applied successfully in the status Report
+func SetAppliedHashes(config, applied string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.ConfigHash = config
+	current.AppliedHash = applied
+}
+
+// SetApplyTimes records the time of the last successful apply of the
+// desired state, if it has been, and that of the oldest change not yet applied, or the zero
+// time if every change has been applied, in the status Report
+func SetApplyTimes(lastApplied, unappliedSince time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.LastApplied = nil
+	if !lastApplied.IsZero() {
+		current.LastApplied = &lastApplied
+	}
+
+	current.UnappliedSince = nil
+	if !unappliedSince.IsZero() {
+		current.UnappliedSince = &unappliedSince
+	}
+
+	metrics.SetApplyTimes(lastApplied, unappliedSince)
+}
+
+// SetDryRun records what would be applied, but for the advertisements and
+// Routers in dry run, or nil if there are none, in the status Report
+func SetDryRun(d *DryRun) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.DryRun = d
+}
+
+// SetRIBDiscrepancies records the neighbors whose adj-RIB-out differs from
+// the prefixes which should be exported to them in the status Report
+func SetRIBDiscrepancies(list []RIBDiscrepancy) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.RIBDiscrepancies = list
+}
+
+// SetFrozen records the reason for which the advertisements are frozen, or
+// the empty string if they are not, in the status Report
+func SetFrozen(reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.Frozen = reason
+}
+
+// SetDesiredSessions records the desired BGP sessions in the status Report
+func SetDesiredSessions(peers, routers []Session) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current.Desired == nil {
+		current.Desired = new(DesiredState)
+	}
+
+	current.Desired.Peers = peers
+	current.Desired.Routers = routers
+}
+
+// SetDesiredRoutes records the routes which this Node should originate in the status Report
+func SetDesiredRoutes(list []routes.Route) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current.Desired == nil {
+		current.Desired = new(DesiredState)
+	}
+
+	current.Desired.Routes = list
+}
+
+// SetReceivedRoutes records the prefixes received from, and accepted from,
+// each Router in the status Report
+func SetReceivedRoutes(list []ReceivedRoutes) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.Received = list
+}
+
+// Error records an error, counting it in the metrics by category and
+// surfacing it in the status Report
+func Error(err error) {
+	if err == nil {
+		return
+	}
+
+	code := errcode.Of(err)
+
+	log.Printf("[%s] %v", code, err)
+
+	metrics.Errors.WithLabelValues(string(code)).Inc()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := current.Errors[code]
+	s.Count++
+	s.LastMessage = err.Error()
+	s.LastTime = time.Now()
+	current.Errors[code] = s
+}
+
+// Current returns a copy of the current status Report
+func Current() Report {
+	mu.Lock()
+	defer mu.Unlock()
+
+	r := Report{
+		Cluster:          current.Cluster,
+		Node:             current.Node,
+		Errors:           make(map[errcode.Code]ErrorStatus, len(current.Errors)),
+		Warnings:         current.Warnings,
+		ResourceWarnings: current.ResourceWarnings,
+		ConfigHash:       current.ConfigHash,
+		AppliedHash:      current.AppliedHash,
+		LastApplied:      current.LastApplied,
+		UnappliedSince:   current.UnappliedSince,
+		Frozen:           current.Frozen,
+		DryRun:           current.DryRun,
+		RIBDiscrepancies: current.RIBDiscrepancies,
+		Received:         current.Received,
+	}
+	if current.Desired != nil {
+		d := *current.Desired
+		r.Desired = &d
+	}
+	for k, v := range current.Errors {
+		r.Errors[k] = v
+	}
+	if current.Features != nil {
+		r.Features = make(map[string]bool, len(current.Features))
+		for k, v := range current.Features {
+			r.Features[k] = v
+		}
+	}
+
+	return r
+}
+
+// Handler returns an http.Handler which serves the current status Report as JSON
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(Current()); err != nil {
+			log.Println("failed to write status:", err)
+		}
+	})
+}
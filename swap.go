@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+)
+
+// configReloadInterval is the interval at which the config file is checked for changes
+const configReloadInterval = 10 * time.Second
+
+// maxGracefulRestartSeconds is the largest restart time which the graceful
+// restart capability can carry
+const maxGracefulRestartSeconds = 4095
+
+func (c *KubeBGPConfig) validateGracefulRestart() error {
+	if c.GracefulRestartSeconds < 0 || c.GracefulRestartSeconds > maxGracefulRestartSeconds {
+		return eris.Errorf("invalid gracefulRestartSeconds %d: must be between 0 and %d", c.GracefulRestartSeconds, maxGracefulRestartSeconds)
+	}
+
+	return nil
+}
+
+// restartTime returns the restart time advertised by the built-in speaker
+func (c *KubeBGPConfig) restartTime() time.Duration {
+	return time.Duration(c.GracefulRestartSeconds) * time.Second
+}
+
+// watchConfig checks the given config file for changes at intervals,
+// sending each valid new configuration.  Invalid configurations are
+// reported and otherwise ignored.
+func watchConfig(ctx context.Context, filename string) <-chan *KubeBGPConfig {
+	ch := make(chan *KubeBGPConfig, 1)
+
+	last, _ := ioutil.ReadFile(filename) // nolint: errcheck
+
+	go func() {
+		ticker := time.NewTicker(configReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "failed to reload config file %s", filename))
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+
+			cfg, err := loadConfig(filename)
+			if err != nil {
+				status.Error(errcode.Wrap(err, errcode.ConfigInvalid, "failed to reload configuration"))
+				continue
+			}
+
+			select {
+			case ch <- cfg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// swappableConfig returns whether the configurations differ only in the
+// settings which may be changed at runtime: the speaker, the outputs, and the
+// graceful restart time
+func swappableConfig(a, b *KubeBGPConfig) bool {
+	x, y := *a, *b
+
+	x.Speaker, y.Speaker = "", ""
+	x.Outputs, y.Outputs = nil, nil
+	x.GracefulRestartSeconds, y.GracefulRestartSeconds = 0, 0
+
+	return reflect.DeepEqual(x, y)
+}
+
+// logOutputSwap logs the change of a backend, noting whether it can be made hitlessly
+func logOutputSwap(cfg *KubeBGPConfig, name string, added bool) {
+	verb := "removing"
+	if added {
+		verb = "adding"
+	}
+
+	if cfg.GracefulRestartSeconds == 0 {
+		log.Printf("%s output %s without graceful restart; routes may be withdrawn by routers during the change", verb, name)
+		return
+	}
+
+	log.Printf("%s output %s using graceful restart", verb, name)
+}
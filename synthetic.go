@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The synthetic cluster of the scale harness and of the scale benchmarks
+
+// scaleRacks is the number of racks across which the synthetic Nodes are spread
+const scaleRacks = 32
+
+// syntheticNode returns the i-th synthetic Node, in its given generation of
+// addresses
+func syntheticNode(i, generation int) *v1.Node {
+	prefix := 10
+	if generation%2 == 1 {
+		prefix = 100
+	}
+
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("node-%05d", i),
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone": fmt.Sprintf("rack-%d", i%scaleRacks),
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: fmt.Sprintf("%d.%d.%d.%d", prefix, i>>16&255, i>>8&255, i&255)},
+				{Type: v1.NodeInternalIP, Address: fmt.Sprintf("fd00::%x:%x", generation, i)},
+			},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+}
+
+// syntheticService returns the i-th synthetic LoadBalancer Service.  Every
+// fourth is announced only from the Nodes of a single rack.
+func syntheticService(i int) *v1.Service {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("svc-%05d", i),
+			Namespace: fmt.Sprintf("ns-%d", i%16),
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeLoadBalancer,
+		},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{
+					{IP: fmt.Sprintf("198.%d.%d.%d", 18+i>>16&1, i>>8&255, i&255)},
+				},
+			},
+		},
+	}
+
+	if i%4 == 0 {
+		svc.Annotations = map[string]string{
+			announceFromAnnotation: fmt.Sprintf("topology.kubernetes.io/zone=rack-%d", i%scaleRacks),
+		}
+	}
+
+	return svc
+}
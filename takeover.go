@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+)
+
+// takeoverCheckInterval is the interval at which deferred takeovers are re-checked
+const takeoverCheckInterval = 2 * time.Second
+
+// takeoverGate defers the announcement of the exclusive routes (such as
+// those of EgressIPs) which this Node takes over, for as long as gobgpd
+// still receives them from another Node, so that a prefix which should have
+// a single announcer is not announced by two Nodes at once while its
+// previous host withdraws it
+type takeoverGate struct {
+	recorder events.Recorder
+
+	// client is the gobgpd whose RIB is checked, or nil if takeovers are not deferred
+	client *gobgp.Client
+
+	// limit is the longest time for which a takeover is deferred
+	limit time.Duration
+
+	// announced is the set of exclusive prefixes last announced
+	announced map[string]bool
+
+	// deferred is the time since which the takeover of each prefix has been deferred
+	deferred map[string]time.Time
+}
+
+func newTakeoverGate(recorder events.Recorder, client *gobgp.Client, limit time.Duration) *takeoverGate {
+	return &takeoverGate{
+		recorder:  recorder,
+		client:    client,
+		limit:     limit,
+		announced: make(map[string]bool),
+		deferred:  make(map[string]time.Time),
+	}
+}
+
+// Apply returns the given desired routes without the exclusive routes whose
+// takeover is deferred, along with whether any is, in which case they should
+// be re-checked after takeoverCheckInterval
+func (g *takeoverGate) Apply(ctx context.Context, desired []routes.Route, now time.Time) (list []routes.Route, pending bool) {
+	if g.client == nil {
+		return desired, false
+	}
+
+	announced := make(map[string]bool)
+
+	for _, r := range desired {
+		if !r.Exclusive {
+			list = append(list, r)
+			continue
+		}
+
+		if !g.announced[r.Prefix] {
+			since, ok := g.deferred[r.Prefix]
+			if !ok {
+				since = now
+			}
+
+			if now.Sub(since) < g.limit && g.receivedElsewhere(ctx, r.Prefix, !ok) {
+				g.deferred[r.Prefix] = since
+				pending = true
+				continue
+			}
+
+			if ok {
+				delete(g.deferred, r.Prefix)
+				g.recorder.Normal("TakeoverCompleted", "took over %s after deferring it for %s", r.Prefix, now.Sub(since).Round(time.Second))
+			}
+		}
+
+		announced[r.Prefix] = true
+		list = append(list, r)
+	}
+
+	// Takeovers which are no longer desired are abandoned.
+	for prefix := range g.deferred {
+		if !hasRoute(desired, prefix) {
+			delete(g.deferred, prefix)
+		}
+	}
+
+	g.announced = announced
+
+	return list, pending
+}
+
+// receivedElsewhere indicates whether gobgpd receives the given prefix from
+// another speaker.  If its RIB cannot be read, the takeover is not deferred.
+func (g *takeoverGate) receivedElsewhere(ctx context.Context, prefix string, first bool) bool {
+	from, err := g.client.ReceivedFrom(ctx, prefix)
+	if err != nil {
+		status.Error(err)
+		return false
+	}
+
+	if len(from) == 0 {
+		return false
+	}
+
+	if first {
+		g.recorder.Normal("TakeoverDeferred", "deferring takeover of %s while it is still received from %s", prefix, strings.Join(from, ", "))
+	}
+
+	return true
+}
+
+// hasRoute indicates whether the given list includes a route for the given prefix
+func hasRoute(list []routes.Route, prefix string) bool {
+	for _, r := range list {
+		if r.Prefix == prefix {
+			return true
+		}
+	}
+
+	return false
+}
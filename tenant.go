@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+)
+
+func validateTenant(t *v1alpha1.BGPTenant) error {
+	if len(t.Spec.Prefixes) == 0 {
+		return eris.New("prefixes are required")
+	}
+
+	for _, p := range t.Spec.Prefixes {
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			return eris.Wrapf(err, "invalid prefix %q", p)
+		}
+	}
+
+	if err := validateVPNValue(t.Spec.RouteDistinguisher); err != nil {
+		return eris.Wrap(err, "invalid routeDistinguisher")
+	}
+
+	if len(t.Spec.RouteTargets) == 0 {
+		return eris.New("routeTargets are required")
+	}
+
+	for _, rt := range t.Spec.RouteTargets {
+		if err := validateVPNValue(rt); err != nil {
+			return eris.Wrap(err, "invalid routeTarget")
+		}
+	}
+
+	return nil
+}
+
+// validateVPNValue checks a route distinguisher or route target, which is
+// either `<asn>:<value>` or `<ipv4>:<value>`
+func validateVPNValue(s string) error {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return eris.Errorf("%q is not of the form <asn>:<value> or <ipv4>:<value>", s)
+	}
+
+	admin, value := s[:i], s[i+1:]
+
+	if ip := net.ParseIP(admin); ip != nil {
+		if ip.To4() == nil {
+			return eris.Errorf("%q has an IPv6 administrator", s)
+		}
+
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return eris.Wrapf(err, "invalid value in %q", s)
+		}
+
+		return nil
+	}
+
+	if _, err := parseASN(admin); err != nil {
+		return eris.Wrapf(err, "invalid administrator in %q", s)
+	}
+
+	if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+		return eris.Wrapf(err, "invalid value in %q", s)
+	}
+
+	return nil
+}
+
+// validTenants returns the BGPTenants of the list which are valid, reporting
+// and skipping the rest, so that a single bad tenant does not affect the
+// others
+func validTenants(list []v1alpha1.BGPTenant) (valid []v1alpha1.BGPTenant) {
+	for i := range list {
+		if err := validateTenant(&list[i]); err != nil {
+			status.Error(errcode.Wrapf(err, errcode.ConfigInvalid, "invalid tenant %s", list[i].Name))
+			continue
+		}
+
+		valid = append(valid, list[i])
+	}
+
+	return valid
+}
+
+// tenantRoutes places each of the given routes which lies within the VIP
+// pools of a BGPTenant in the VRF of the tenant (the first, by name, if
+// several claim it), leaving the rest in the global table
+func tenantRoutes(list []routes.Route, tenants []v1alpha1.BGPTenant) []routes.Route {
+	sorted := append([]v1alpha1.BGPTenant(nil), tenants...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for i := range list {
+		_, network, err := net.ParseCIDR(list[i].Prefix)
+		if err != nil {
+			continue
+		}
+
+		for _, t := range sorted {
+			if withinAny(network, t.Spec.Prefixes) {
+				list[i].VRF = t.Name
+				break
+			}
+		}
+	}
+
+	return list
+}
+
+// withinAny indicates whether the given network lies within any of the given CIDRs
+func withinAny(network *net.IPNet, cidrs []string) bool {
+	ones, _ := network.Mask.Size()
+
+	for _, c := range cidrs {
+		_, pool, err := net.ParseCIDR(c)
+		if err != nil {
+			continue // validated by validTenants
+		}
+
+		if poolOnes, _ := pool.Mask.Size(); poolOnes <= ones && pool.Contains(network.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// vrfProvisioner maintains a VRF in gobgpd for each BGPTenant
+type vrfProvisioner struct {
+	client *gobgp.Client
+
+	// applied is the spec of each VRF created, by name
+	applied map[string]v1alpha1.BGPTenantSpec
+}
+
+func newVRFProvisioner(client *gobgp.Client) *vrfProvisioner {
+	return &vrfProvisioner{
+		client:  client,
+		applied: make(map[string]v1alpha1.BGPTenantSpec),
+	}
+}
+
+// Reset forgets the VRFs created, such as after gobgpd has restarted
+func (p *vrfProvisioner) Reset() {
+	p.applied = make(map[string]v1alpha1.BGPTenantSpec)
+}
+
+// Ensure creates the VRFs of the given BGPTenants, recreating those whose
+// route distinguisher or route targets have changed and deleting those of
+// tenants which no longer exist.  It returns whether any existing VRF was
+// replaced or deleted, in which case its routes are gone and must be
+// re-applied.
+func (p *vrfProvisioner) Ensure(ctx context.Context, tenants []v1alpha1.BGPTenant) (changed bool, err error) {
+	want := make(map[string]v1alpha1.BGPTenantSpec, len(tenants))
+	for _, t := range tenants {
+		want[t.Name] = t.Spec
+	}
+
+	for name, spec := range p.applied {
+		if w, ok := want[name]; ok && sameVRF(w, spec) {
+			continue
+		}
+
+		if err := p.client.DeleteVRF(ctx, name); err != nil {
+			return changed, errcode.Wrapf(err, errcode.Of(err), "failed to delete VRF %s", name)
+		}
+
+		delete(p.applied, name)
+		changed = true
+	}
+
+	for name, spec := range want {
+		if _, ok := p.applied[name]; ok {
+			continue
+		}
+
+		replaced, err := p.client.AddVRF(ctx, name, spec.RouteDistinguisher, spec.RouteTargets)
+		if err != nil {
+			return changed, errcode.Wrapf(err, errcode.Of(err), "failed to create VRF %s", name)
+		}
+		changed = changed || replaced
+
+		p.applied[name] = spec
+	}
+
+	return changed, nil
+}
+
+// sameVRF indicates whether the VRFs of the given tenant specs are the same
+func sameVRF(a, b v1alpha1.BGPTenantSpec) bool {
+	return a.RouteDistinguisher == b.RouteDistinguisher && reflect.DeepEqual(a.RouteTargets, b.RouteTargets)
+}
@@ -0,0 +1,56 @@
+package tenants
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/watches"
+	"k8s.io/client-go/dynamic"
+)
+
+// MaximumCheckIntervalSeconds is the maximum amount to time to wait before forcing an update check
+var MaximumCheckIntervalSeconds = 60
+
+// Watcher defines the interface for a BGPTenant Watcher
+type Watcher interface {
+
+	// Changes waits for a change to the set of BGPTenants to occur
+	Changes() <-chan struct{}
+
+	// Tenants returns the current list of BGPTenants
+	Tenants() []v1alpha1.BGPTenant
+
+	// Synced indicates whether the list of BGPTenants has been obtained from the API at least once
+	Synced() bool
+
+	// Listed returns the time at which the list of BGPTenants was last obtained from the API
+	Listed() time.Time
+
+	// Close shuts down the Watcher
+	Close()
+}
+
+type watcher struct {
+	*watches.Watcher
+}
+
+func (w *watcher) Tenants() []v1alpha1.BGPTenant {
+	objects := w.Objects()
+
+	list := make([]v1alpha1.BGPTenant, len(objects))
+	for i, obj := range objects {
+		list[i] = *obj.(*v1alpha1.BGPTenant)
+	}
+
+	return list
+}
+
+// NewWatcher returns a new BGPTenant watcher which signals whenever the set of BGPTenants changes
+func NewWatcher(ctx context.Context, client dynamic.Interface) (Watcher, error) {
+	source := watches.Dynamic(client, v1alpha1.BGPTenantResource, "tenant", time.Duration(MaximumCheckIntervalSeconds)*time.Second, func() interface{} {
+		return new(v1alpha1.BGPTenant)
+	})
+
+	return &watcher{watches.NewWatcher(ctx, source)}, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Topology describes an additional iBGP mesh among the Nodes which it
+// selects, alongside the primary mesh, such as a management-plane mesh on a
+// different interface or address family from the dataplane
+type Topology struct {
+	// Name is the name of the topology
+	Name string `yaml:"name"`
+
+	// NodeSelector is the label selector of the Nodes which join the
+	// topology.  This is optional, and if not supplied, every Node joins it.
+	NodeSelector string `yaml:"nodeSelector"`
+
+	// ASN is the ASN of the topology's sessions.  This is optional, and if
+	// not supplied, the system ASN is used.
+	ASN string `yaml:"asn"`
+
+	// PeerAddress describes how the address by which each Node is peered in
+	// the topology is chosen.  This is optional, and if not supplied, the
+	// first InternalIP of each Node is used.
+	PeerAddress *PeerAddressSelection `yaml:"peerAddress"`
+
+	// Advertisements is the list of Advertisements (by name) whose routes
+	// are announced over the topology.  This is optional, and if not
+	// supplied, every route is announced.
+	Advertisements []string `yaml:"advertisements"`
+}
+
+func (t *Topology) validate() error {
+	if t.Name == "" {
+		return eris.New("name is required")
+	}
+
+	if _, err := labels.Parse(t.NodeSelector); err != nil {
+		return eris.Wrapf(err, "invalid nodeSelector %q", t.NodeSelector)
+	}
+
+	if t.ASN != "" {
+		if _, err := parseASN(t.ASN); err != nil {
+			return err
+		}
+	}
+
+	if t.PeerAddress != nil {
+		if err := t.PeerAddress.validate(); err != nil {
+			return eris.Wrap(err, "invalid peerAddress")
+		}
+	}
+
+	return nil
+}
+
+func (c *KubeBGPConfig) validateTopologies() error {
+	names := make(map[string]bool)
+
+	for i := range c.Topologies {
+		t := &c.Topologies[i]
+
+		if err := t.validate(); err != nil {
+			return eris.Wrapf(err, "invalid topology %s", t.Name)
+		}
+
+		if names[t.Name] {
+			return eris.Errorf("duplicate topology %s", t.Name)
+		}
+		names[t.Name] = true
+
+		for _, name := range t.Advertisements {
+			if c.advertisement(name) == nil {
+				return eris.Errorf("topology %s references unknown advertisement %s", t.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// advertisement returns the Advertisement of the given name, or nil if there is none
+func (c *KubeBGPConfig) advertisement(name string) *Advertisement {
+	for i := range c.Advertisements {
+		if c.Advertisements[i].Name == name {
+			return &c.Advertisements[i]
+		}
+	}
+
+	return nil
+}
+
+// topology returns the Topology of the given name, or nil if there is none
+func (c *KubeBGPConfig) topology(name string) *Topology {
+	for i := range c.Topologies {
+		if c.Topologies[i].Name == name {
+			return &c.Topologies[i]
+		}
+	}
+
+	return nil
+}
+
+// selects indicates whether the given Node joins the topology
+func (t *Topology) selects(n *v1.Node) bool {
+	if n == nil {
+		return false
+	}
+
+	selector, err := labels.Parse(t.NodeSelector)
+	if err != nil {
+		return false // validated at load
+	}
+
+	return selector.Matches(labels.Set(n.Labels))
+}
+
+// exports indicates whether the given route is announced over the topology
+func (t *Topology) exports(r *routes.Route) bool {
+	if len(t.Advertisements) == 0 {
+		return true
+	}
+
+	for _, name := range t.Advertisements {
+		if r.Source == "advertisement/"+name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// topologyPeers returns the iBGP peers of the named Node in each Topology
+// which it joins: every other Node of the topology.  A peer whose address is
+// already that of another session (given) is reported and skipped, since a
+// speaker may have only one session with any address.
+func topologyPeers(thisNode string, cfg *KubeBGPConfig, state *clusterState, used map[string]bool) (list []Peer) {
+	local := findNode(thisNode, state.Nodes)
+
+	for i := range cfg.Topologies {
+		t := &cfg.Topologies[i]
+
+		if !t.selects(local) {
+			continue
+		}
+
+		var asn string
+		if t.ASN != cfg.ASN {
+			asn = t.ASN
+		}
+
+		for j := range state.Nodes {
+			n := &state.Nodes[j]
+
+			if n.Name == thisNode || !t.selects(n) {
+				continue
+			}
+
+			ips := internalIPs(n)
+			if len(ips) == 0 {
+				status.Error(errcode.New(errcode.ConfigInvalid, "node "+n.Name+" has no InternalIP for topology "+t.Name))
+				continue
+			}
+
+			ip := selectAddress(ips, t.PeerAddress)
+
+			if used[ip.String()] {
+				status.Error(errcode.New(errcode.ConfigInvalid, "address "+ip.String()+" of node "+n.Name+" in topology "+t.Name+" is already peered; choose a distinct peerAddress for the topology"))
+				continue
+			}
+			used[ip.String()] = true
+
+			var hold int
+			if nodeReady(n) {
+				hold = cfg.PeerDownHoldSeconds
+			}
+
+			list = append(list, Peer{
+				Address:      ip.String(),
+				Name:         n.Name,
+				Description:  nodeDescription(n, cfg) + " [" + t.Name + "]",
+				ASN:          asn,
+				LocalASN:     asn,
+				Topology:     t.Name,
+				HoldSeconds:  hold,
+				PasswordFile: cfg.MeshPasswordFile,
+			})
+		}
+	}
+
+	return list
+}
+
+// selectAddress returns the address of the given list chosen by the given
+// selection: the first within the first matching CIDR, or else the first of
+// the preferred family, or else the first
+func selectAddress(ips []net.IP, sel *PeerAddressSelection) net.IP {
+	if sel == nil {
+		return ips[0]
+	}
+
+	for _, c := range sel.CIDRs {
+		_, network, err := net.ParseCIDR(c)
+		if err != nil {
+			continue // validated at load
+		}
+
+		for _, ip := range ips {
+			if network.Contains(ip) {
+				return ip
+			}
+		}
+	}
+
+	if sel.Family != "" {
+		for _, ip := range ips {
+			if (ip.To4() != nil) == (sel.Family == "ipv4") {
+				return ip
+			}
+		}
+	}
+
+	return ips[0]
+}
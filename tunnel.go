@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/events"
+	"github.com/CyCoreSystems/kube-bgp/metrics"
+	"github.com/rotisserie/eris"
+)
+
+// Tunnel types
+const (
+	// tunnelWireGuard is a WireGuard interface, whose liveness is the age of its latest handshake
+	tunnelWireGuard = "wireguard"
+
+	// tunnelIPsec is a route-based IPsec (VTI or XFRM) interface, whose
+	// liveness is the interface being up
+	tunnelIPsec = "ipsec"
+)
+
+// defaultMaxHandshakeAgeSeconds is the default maximum age of the latest
+// WireGuard handshake of a live tunnel.  WireGuard re-handshakes every two
+// minutes while traffic flows, and BGP keepalives ensure that it does.
+const defaultMaxHandshakeAgeSeconds = 180
+
+// tunnelCheckInterval is the interval at which the liveness of the tunnels is checked
+const tunnelCheckInterval = 10 * time.Second
+
+// wgCommand is the WireGuard CLI
+var wgCommand = "wg"
+
+// Tunnel describes the local tunnel interface through which a Router is
+// reached.  While the tunnel is dead, the session to the Router, and so the
+// advertisements to it, are held down.
+type Tunnel struct {
+	// Type is the type of the tunnel: `wireguard` or `ipsec`
+	Type string `yaml:"type"`
+
+	// Interface is the name of the local tunnel interface (e.g. `wg0`)
+	Interface string `yaml:"interface"`
+
+	// PublicKey is the WireGuard public key of the Router's end of the
+	// tunnel.  This is optional, and if not supplied, a handshake with any
+	// peer of the interface counts.
+	PublicKey string `yaml:"publicKey"`
+
+	// MaxHandshakeAgeSeconds is the age of the latest WireGuard handshake
+	// beyond which the tunnel is considered dead.  If not supplied, it
+	// defaults to 180.
+	MaxHandshakeAgeSeconds int `yaml:"maxHandshakeAgeSeconds"`
+}
+
+func (t *Tunnel) validate() error {
+	switch t.Type {
+	case tunnelWireGuard, tunnelIPsec:
+	default:
+		return eris.Errorf("invalid type %q", t.Type)
+	}
+
+	if t.Interface == "" {
+		return eris.New("interface is required")
+	}
+
+	if t.MaxHandshakeAgeSeconds < 0 {
+		return eris.Errorf("invalid maxHandshakeAgeSeconds %d", t.MaxHandshakeAgeSeconds)
+	}
+
+	if t.PublicKey != "" && t.Type != tunnelWireGuard {
+		return eris.New("publicKey applies only to wireguard tunnels")
+	}
+
+	return nil
+}
+
+// maxHandshakeAge returns the maximum age of the latest handshake of a live tunnel
+func (t *Tunnel) maxHandshakeAge() time.Duration {
+	if t.MaxHandshakeAgeSeconds > 0 {
+		return time.Duration(t.MaxHandshakeAgeSeconds) * time.Second
+	}
+
+	return defaultMaxHandshakeAgeSeconds * time.Second
+}
+
+// alive checks the liveness of the tunnel, returning an error describing why it is dead
+func (t *Tunnel) alive(ctx context.Context) error {
+	iface, err := net.InterfaceByName(t.Interface)
+	if err != nil {
+		return eris.Wrapf(err, "tunnel interface %s not found", t.Interface)
+	}
+
+	if iface.Flags&net.FlagUp == 0 {
+		return eris.Errorf("tunnel interface %s is down", t.Interface)
+	}
+
+	if t.Type != tunnelWireGuard {
+		return nil
+	}
+
+	latest, err := wgLatestHandshake(ctx, t.Interface, t.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if latest.IsZero() {
+		return eris.Errorf("tunnel %s has never completed a handshake", t.Interface)
+	}
+
+	if age := time.Since(latest); age > t.maxHandshakeAge() {
+		return eris.Errorf("latest handshake of tunnel %s was %s ago", t.Interface, age.Round(time.Second))
+	}
+
+	return nil
+}
+
+// wgLatestHandshake returns the time of the latest handshake of the given
+// WireGuard interface with the peer of the given public key (or with any
+// peer, if none is given), or the zero time if there has been none
+func wgLatestHandshake(ctx context.Context, iface, publicKey string) (time.Time, error) {
+	out, err := exec.CommandContext(ctx, wgCommand, "show", iface, "latest-handshakes").Output()
+	if err != nil {
+		return time.Time{}, eris.Wrapf(err, "failed to read handshakes of tunnel %s", iface)
+	}
+
+	var latest time.Time
+
+	// Each line is the public key of a peer and the UNIX time of its latest handshake (0 if none).
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 || (publicKey != "" && fields[0] != publicKey) {
+			continue
+		}
+
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || secs == 0 {
+			continue
+		}
+
+		if t := time.Unix(secs, 0); t.After(latest) {
+			latest = t
+		}
+	}
+
+	return latest, nil
+}
+
+// hasTunnels indicates whether any Router is reached through a tunnel
+func (c *KubeBGPConfig) hasTunnels() bool {
+	for _, r := range c.Routers {
+		if r.Tunnel != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tunnelMonitor checks the liveness of the tunnels through which Routers
+// are reached, reporting changes in it
+type tunnelMonitor struct {
+	recorder events.Recorder
+
+	mu sync.Mutex
+
+	// down is the set of Router addresses whose tunnels are dead
+	down map[string]bool
+}
+
+func newTunnelMonitor(recorder events.Recorder) *tunnelMonitor {
+	return &tunnelMonitor{
+		recorder: recorder,
+		down:     make(map[string]bool),
+	}
+}
+
+// Check checks the tunnel of each of the given Routers, recording an Event
+// whenever one dies or comes back, and returns whether any has done so
+func (m *tunnelMonitor) Check(ctx context.Context, routers []Router) (changed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range routers {
+		if r.Tunnel == nil {
+			continue
+		}
+
+		err := r.Tunnel.alive(ctx)
+		if err != nil {
+			metrics.RouterTunnelUp.WithLabelValues(r.Ref()).Set(0)
+
+			if !m.down[r.Address] {
+				m.recorder.Warning("TunnelDown", "tunnel to router %s is dead; holding its session down: %v", r.Ref(), err)
+				m.down[r.Address] = true
+				changed = true
+			}
+
+			continue
+		}
+
+		metrics.RouterTunnelUp.WithLabelValues(r.Ref()).Set(1)
+
+		if m.down[r.Address] {
+			m.recorder.Normal("TunnelUp", "tunnel to router %s is alive", r.Ref())
+			delete(m.down, r.Address)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Down returns the set of Router addresses whose tunnels are dead
+func (m *tunnelMonitor) Down() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	down := make(map[string]bool, len(m.down))
+	for addr := range m.down {
+		down[addr] = true
+	}
+
+	return down
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the changes to the umask, which is shared by the whole process
+var umaskMu sync.Mutex
+
+// withUmask runs the given function with the given umask in place of that of the process
+func withUmask(mask int, f func()) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+
+	f()
+}
@@ -0,0 +1,6 @@
+package main
+
+// withUmask runs the given function; Windows has no umask
+func withUmask(mask int, f func()) {
+	f()
+}
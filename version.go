@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/features"
+	"github.com/CyCoreSystems/kube-bgp/gobgp"
+)
+
+// version and commit identify the build, and are set at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD)"
+var (
+	version = "0.0.0-dev"
+	commit  = ""
+)
+
+// configSchemaVersions are the versions of the configuration file schema
+// which this build reads.  The file carries no version of its own; the
+// schema is versioned only by incompatible changes to it, of which there
+// have been none.
+var configSchemaVersions = []string{"v1"}
+
+// gobgpVersionTimeout is the time allowed to obtain the version of the gobgp API
+const gobgpVersionTimeout = 5 * time.Second
+
+// BuildInfo describes this build of kube-bgp, for tooling which gates
+// rollouts on its capabilities
+type BuildInfo struct {
+	// Version is the semantic version of the build
+	Version string `json:"version"`
+
+	// Commit is the git commit of the build, if known
+	Commit string `json:"commit,omitempty"`
+
+	// GoVersion is the version of Go with which the build was made
+	GoVersion string `json:"goVersion"`
+
+	// GoBGP is the generation (`v2` or `v3`) of the gobgp API served by
+	// gobgpd at the configured API address, if it can be reached
+	GoBGP string `json:"gobgp,omitempty"`
+
+	// ConfigSchemaVersions are the versions of the configuration file schema which the build reads
+	ConfigSchemaVersions []string `json:"configSchemaVersions"`
+
+	// APIVersions are the versions of the custom resources which the build reads
+	APIVersions []string `json:"apiVersions"`
+
+	// ConfigKeys are the top-level keys of the configuration file which the
+	// build supports, by which the support of each feature may be checked
+	ConfigKeys []string `json:"configKeys"`
+
+	// FeatureGates are the stages of the feature gates which the build
+	// declares, by name
+	FeatureGates map[string]features.Stage `json:"featureGates"`
+}
+
+// buildInfo returns the description of this build, with the generation of
+// the gobgp API served at the given address
+func buildInfo(ctx context.Context, gobgpAddress string) *BuildInfo {
+	info := &BuildInfo{
+		Version:              version,
+		Commit:               commit,
+		GoVersion:            runtime.Version(),
+		ConfigSchemaVersions: configSchemaVersions,
+		APIVersions:          []string{v1alpha1.Group + "/" + v1alpha1.Version},
+		ConfigKeys:           configKeys(),
+		FeatureGates:         make(map[string]features.Stage),
+	}
+	for _, g := range features.Gates() {
+		info.FeatureGates[g.Name] = g.Stage
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gobgpVersionTimeout)
+	defer cancel()
+
+	client := gobgp.New(gobgpAddress)
+	defer client.Close() // nolint: errcheck
+
+	if v, err := client.Version(ctx); err == nil {
+		info.GoBGP = v
+	}
+
+	return info
+}
+
+// configKeys returns the sorted top-level keys of the configuration file
+func configKeys() (keys []string) {
+	t := reflect.TypeOf(KubeBGPConfig{})
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		keys = append(keys, name)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// runVersion implements the `version` command, which prints the version of
+// this build, or with -json, the full description of it
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the full build description as JSON")
+	gobgpAddress := fs.String("gobgp-api", defaultGoBGPAPIAddress, "address of the gobgpd API whose generation is described")
+	fs.Parse(args) // nolint: errcheck
+
+	info := buildInfo(context.Background(), *gobgpAddress)
+
+	if !*asJSON {
+		if info.Commit != "" {
+			fmt.Printf("kube-bgp %s (%s)\n", info.Version, info.Commit)
+		} else {
+			fmt.Printf("kube-bgp %s\n", info.Version)
+		}
+		return 0
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(info); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to encode build description:", err)
+		return 1
+	}
+
+	return 0
+}
+
+// versionHandler serves the description of this build, with the generation
+// of the gobgp API served at the given address.  The description is cached
+// once the generation has been obtained; until then, such as while gobgpd is
+// starting, it is obtained afresh for each request.
+func versionHandler(gobgpAddress string) http.Handler {
+	var (
+		mu     sync.Mutex
+		cached *BuildInfo
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		info := cached
+		if info == nil {
+			info = buildInfo(r.Context(), gobgpAddress)
+			if info.GoBGP != "" {
+				cached = info
+			}
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			log.Println("failed to encode build description:", err)
+		}
+	})
+}
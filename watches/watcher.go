@@ -0,0 +1,238 @@
+package watches
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/kube-bgp/dirty"
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// Item is one listed resource, along with the identity and version by
+// which its changes are detected
+type Item struct {
+	UID             types.UID
+	ResourceVersion string
+
+	// Object is the resource, decoded
+	Object interface{}
+}
+
+// Source lists and watches one kind of resource for a Watcher
+type Source struct {
+	// Resource names the kind of resource in errors and logs, such as `node group`
+	Resource string
+
+	// List lists the resources, returning them along with the resourceVersion of the list
+	List func(ctx context.Context) ([]Item, string, error)
+
+	// Watch watches the resources from the given resourceVersion
+	Watch func(ctx context.Context, resourceVersion string) (watch.Interface, error)
+
+	// Interval is the longest time for which to wait for a change before relisting anyway
+	Interval time.Duration
+}
+
+// Dynamic returns the Source of the custom resources of the given
+// GroupVersionResource, each decoded into a new object of its type, as
+// returned by newObject
+func Dynamic(client dynamic.Interface, gvr schema.GroupVersionResource, resource string, interval time.Duration, newObject func() interface{}) Source {
+	return Source{
+		Resource: resource,
+		List: func(ctx context.Context) ([]Item, string, error) {
+			list, err := client.Resource(gvr).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, "", err
+			}
+
+			items := make([]Item, len(list.Items))
+			for i := range list.Items {
+				u := &list.Items[i]
+
+				obj := newObject()
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+					return nil, "", errcode.Wrapf(err, errcode.ConfigInvalid, "failed to decode %s %s", resource, u.GetName())
+				}
+
+				items[i] = Item{UID: u.GetUID(), ResourceVersion: u.GetResourceVersion(), Object: obj}
+			}
+
+			return items, list.GetResourceVersion(), nil
+		},
+		Watch: func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+			return client.Resource(gvr).Watch(ctx, metav1.ListOptions{ResourceVersion: resourceVersion})
+		},
+		Interval: interval,
+	}
+}
+
+// Watcher keeps the list of the resources of a Source, relisting them
+// whenever their watch reports a change, ends, or times out, and signalling
+// whenever the list changes
+type Watcher struct {
+	source Source
+	cancel context.CancelFunc
+	signal *dirty.Flag
+
+	mu     sync.Mutex
+	items  []Item
+	synced bool
+	listed time.Time
+
+	// resourceVersion is the resourceVersion of the last list
+	resourceVersion string
+}
+
+// NewWatcher returns a Watcher of the resources of the given Source, which
+// runs until the given context is cancelled or it is closed
+func NewWatcher(ctx context.Context, source Source) *Watcher {
+	localCtx, cancel := context.WithCancel(ctx)
+
+	w := &Watcher{
+		source: source,
+		cancel: cancel,
+		signal: dirty.New(),
+	}
+
+	go w.run(localCtx)
+
+	return w
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		changed, err := w.updateList(ctx)
+		if err != nil {
+			status.Error(errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to update %s list", w.source.Resource))
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if changed {
+			w.signal.Set()
+		}
+
+		// The watch begins at the resourceVersion of the list, so that no
+		// change in between is missed.
+		if err := w.watchOnce(ctx); err != nil {
+			status.Error(err)
+
+			// Prevent runaway short loop.
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (w *Watcher) watchOnce(ctx context.Context) error {
+	w.mu.Lock()
+	resourceVersion := w.resourceVersion
+	w.mu.Unlock()
+
+	wtch, err := w.source.Watch(ctx, resourceVersion)
+	if err != nil {
+		if Expired(err) {
+			return nil // relist
+		}
+
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "failed to create %s watcher", w.source.Resource)
+	}
+
+	if err := Await(ctx, wtch, w.source.Resource, w.source.Interval); err != nil {
+		return errcode.Wrapf(err, errcode.APIServerUnreachable, "%s watch failed", w.source.Resource)
+	}
+
+	return nil
+}
+
+// updateList lists the resources, returning whether the list has changed:
+// whether any resource has been added, removed, or changed
+func (w *Watcher) updateList(ctx context.Context) (changed bool, err error) {
+	items, resourceVersion, err := w.source.List(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.listed = time.Now()
+	w.resourceVersion = resourceVersion
+
+	if w.synced && sameItems(w.items, items) {
+		return false, nil
+	}
+
+	w.synced = true
+	w.items = items
+
+	return true, nil
+}
+
+// sameItems indicates whether the given lists are of the same versions of the same resources
+func sameItems(old, items []Item) bool {
+	if len(old) != len(items) {
+		return false
+	}
+
+	versions := make(map[types.UID]string, len(old))
+	for _, item := range old {
+		versions[item.UID] = item.ResourceVersion
+	}
+
+	for _, item := range items {
+		if v, ok := versions[item.UID]; !ok || v != item.ResourceVersion {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Changes waits for a change to the list of resources to occur
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.signal.C()
+}
+
+// Objects returns the current list of the decoded resources
+func (w *Watcher) Objects() []interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := make([]interface{}, len(w.items))
+	for i := range w.items {
+		list[i] = w.items[i].Object
+	}
+
+	return list
+}
+
+// Synced indicates whether the list of resources has been obtained from the API at least once
+func (w *Watcher) Synced() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.synced
+}
+
+// Listed returns the time at which the list of resources was last obtained from the API
+func (w *Watcher) Listed() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.listed
+}
+
+// Close shuts down the Watcher
+func (w *Watcher) Close() {
+	w.cancel()
+}
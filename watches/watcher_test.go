@@ -0,0 +1,113 @@
+package watches
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// fakeSource is a Source whose list is replaced by the test, and whose
+// watch reports a change whenever it is (or at once, if it was replaced
+// while it was not watched)
+type fakeSource struct {
+	mu      sync.Mutex
+	items   []Item
+	watches []*watch.FakeWatcher
+	changed bool
+}
+
+func (s *fakeSource) set(items ...Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = items
+	s.changed = len(s.watches) == 0
+	for _, w := range s.watches {
+		w.Action(watch.Modified, nil)
+	}
+	s.watches = nil
+}
+
+func (s *fakeSource) source() Source {
+	return Source{
+		Resource: "thing",
+		List: func(ctx context.Context) ([]Item, string, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			return append([]Item(nil), s.items...), "1", nil
+		},
+		Watch: func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			w := watch.NewFakeWithChanSize(1, false)
+			if s.changed {
+				s.changed = false
+				w.Action(watch.Modified, nil)
+			} else {
+				s.watches = append(s.watches, w)
+			}
+
+			return w, nil
+		},
+		Interval: time.Minute,
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	s := new(fakeSource)
+	s.items = []Item{{UID: "a", ResourceVersion: "1", Object: "a1"}}
+
+	w := NewWatcher(context.Background(), s.source())
+	defer w.Close()
+
+	expect := func(what string, changed bool, want ...interface{}) {
+		t.Helper()
+
+		select {
+		case <-w.Changes():
+			if !changed {
+				t.Errorf("%s: unexpected change signalled", what)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if changed {
+				t.Fatalf("%s: expected a change to be signalled", what)
+			}
+		}
+
+		got := w.Objects()
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %v, got %v", what, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected %v, got %v", what, want, got)
+			}
+		}
+	}
+
+	expect("first list", true, "a1")
+
+	if !w.Synced() || w.Listed().IsZero() {
+		t.Error("expected the watcher to be synced")
+	}
+
+	s.set(Item{UID: "a", ResourceVersion: "1", Object: "a1"})
+	expect("unchanged", false, "a1")
+
+	s.set(Item{UID: "a", ResourceVersion: "2", Object: "a2"})
+	expect("modified", true, "a2")
+
+	s.set(Item{UID: "a", ResourceVersion: "2", Object: "a2"}, Item{UID: "b", ResourceVersion: "1", Object: "b1"})
+	expect("added", true, "a2", "b1")
+
+	s.set(Item{UID: "b", ResourceVersion: "1", Object: "b1"})
+	expect("deleted", true, "b1")
+
+	s.set(Item{UID: "c", ResourceVersion: "1", Object: "c1"})
+	expect("replaced", true, "c1")
+}
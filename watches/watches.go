@@ -0,0 +1,52 @@
+// Package watches waits on the watches of kubernetes resources on behalf of
+// the resource watchers, telling them when to relist, and provides the
+// Watcher which relists the resources of most of them (such as Pods and the
+// custom resources)
+package watches
+
+import (
+	"context"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Expired indicates whether the given error (of a List or Watch) reports
+// that the requested resourceVersion has been compacted away (410 Gone), in
+// which case the resources must be relisted at once
+func Expired(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+}
+
+// Await waits until the resources of the given watch should be relisted:
+// when any of them changes, when the watch ends or its resourceVersion has
+// expired, or when the timeout passes.  It returns an error only if the
+// watch fails for some other reason, in which case the caller should back
+// off before relisting.
+func Await(ctx context.Context, w watch.Interface, resource string, timeout time.Duration) error {
+	defer w.Stop()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case ev, ok := <-w.ResultChan():
+		if !ok || ev.Type != watch.Error {
+			return nil
+		}
+
+		err := apierrors.FromObject(ev.Object)
+		if Expired(err) {
+			log.Printf("%s watch expired; relisting", resource)
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
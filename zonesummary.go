@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net"
+	"sort"
+
+	"github.com/CyCoreSystems/kube-bgp/errcode"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	"github.com/CyCoreSystems/kube-bgp/status"
+	"github.com/rotisserie/eris"
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultZoneLabel is the default Node label whose value identifies the zone of each Node
+const defaultZoneLabel = "topology.kubernetes.io/zone"
+
+// ZoneSummary describes the summarization of the pod CIDRs of the Nodes of
+// each zone into aggregates (e.g. one /20 per zone), which are advertised
+// only by the route reflectors of the zone, in place of a route per Node
+type ZoneSummary struct {
+	// Name is the name of this summary
+	Name string `yaml:"name"`
+
+	// ZoneLabel is the Node label whose value identifies the zone of each
+	// Node.  If not supplied, it defaults to `topology.kubernetes.io/zone`.
+	// Nodes without the label are not summarized.
+	ZoneLabel string `yaml:"zoneLabel"`
+
+	// PrefixLength is the length of the IPv4 aggregates.  This is optional,
+	// and if not supplied, IPv4 pod CIDRs are not summarized.
+	PrefixLength int `yaml:"prefixLength"`
+
+	// PrefixLengthV6 is the length of the IPv6 aggregates.  This is
+	// optional, and if not supplied, IPv6 pod CIDRs are not summarized.
+	PrefixLengthV6 int `yaml:"prefixLengthV6"`
+
+	RouteAttributes `yaml:",inline"`
+}
+
+func (z *ZoneSummary) validate() error {
+	if z.PrefixLength == 0 && z.PrefixLengthV6 == 0 {
+		return eris.New("prefixLength or prefixLengthV6 is required")
+	}
+
+	if z.PrefixLength < 0 || z.PrefixLength > 32 {
+		return eris.Errorf("invalid prefixLength %d", z.PrefixLength)
+	}
+
+	if z.PrefixLengthV6 < 0 || z.PrefixLengthV6 > 128 {
+		return eris.Errorf("invalid prefixLengthV6 %d", z.PrefixLengthV6)
+	}
+
+	return z.RouteAttributes.validate()
+}
+
+// zoneLabel returns the Node label which identifies the zone of each Node
+func (z *ZoneSummary) zoneLabel() string {
+	if z.ZoneLabel != "" {
+		return z.ZoneLabel
+	}
+
+	return defaultZoneLabel
+}
+
+// aggregate returns the aggregate of the given pod CIDR, or the pod CIDR
+// itself if it is already shorter than the aggregates of its family, or ""
+// if its family is not summarized
+func (z *ZoneSummary) aggregate(network *net.IPNet) string {
+	length, bits := z.PrefixLengthV6, 128
+	if network.IP.To4() != nil {
+		length, bits = z.PrefixLength, 32
+	}
+
+	if length == 0 {
+		return ""
+	}
+
+	if ones, _ := network.Mask.Size(); ones < length {
+		return network.String()
+	}
+
+	aggregate := &net.IPNet{IP: network.IP, Mask: net.CIDRMask(length, bits)}
+	aggregate.IP = aggregate.IP.Mask(aggregate.Mask)
+
+	return aggregate.String()
+}
+
+// routes returns the aggregates which the given Node should advertise: those
+// of its own zone, if it is one of the zone's announcers.  Aggregates which
+// would also cover the pod CIDRs of Nodes in other zones are reported and
+// not advertised, since they would draw those Nodes' traffic to this zone.
+func (z *ZoneSummary) routes(n *v1.Node, state *clusterState) (list []routes.Route) {
+	if n == nil {
+		return nil
+	}
+
+	label := z.zoneLabel()
+
+	zone := n.Labels[label]
+	if zone == "" || !zoneAnnouncer(n, label, state) {
+		return nil
+	}
+
+	aggregates := make(map[string]bool)
+	var others []*net.IPNet
+
+	for i := range state.Nodes {
+		other := &state.Nodes[i]
+
+		for _, network := range podCIDRs(other) {
+			if other.Labels[label] != zone {
+				others = append(others, network)
+				continue
+			}
+
+			if a := z.aggregate(network); a != "" {
+				aggregates[a] = true
+			}
+		}
+	}
+
+	var prefixes []string
+	for a := range aggregates {
+		prefixes = append(prefixes, a)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		_, aggregate, _ := net.ParseCIDR(prefix) // nolint: errcheck
+
+		if network := overlapping(aggregate, others); network != nil {
+			status.Error(errcode.New(errcode.ConfigInvalid, "zone summary "+z.Name+" aggregate "+prefix+" of zone "+zone+" overlaps pod CIDR "+network.String()+" of another zone; not advertising it"))
+			continue
+		}
+
+		list = append(list, z.RouteAttributes.route(prefix, "zonesummary/"+z.Name))
+	}
+
+	return list
+}
+
+// zoneAnnouncer indicates whether the given Node announces the aggregates of
+// its zone: if the zone has any Ready route reflectors, only they do, or
+// else every Node of the zone does
+func zoneAnnouncer(n *v1.Node, label string, state *clusterState) bool {
+	var reflectors bool
+
+	for i := range state.Nodes {
+		other := &state.Nodes[i]
+
+		if other.Labels[label] == n.Labels[label] && nodeReady(other) && isReflector(other, state) {
+			reflectors = true
+			break
+		}
+	}
+
+	if !reflectors {
+		return true
+	}
+
+	return nodeReady(n) && isReflector(n, state)
+}
+
+// podCIDRs returns the pod CIDRs allocated to the given Node
+func podCIDRs(n *v1.Node) (list []*net.IPNet) {
+	cidrs := n.Spec.PodCIDRs
+	if len(cidrs) == 0 && n.Spec.PodCIDR != "" {
+		cidrs = []string{n.Spec.PodCIDR}
+	}
+
+	for _, s := range cidrs {
+		if _, network, err := net.ParseCIDR(s); err == nil {
+			list = append(list, network)
+		}
+	}
+
+	return list
+}
+
+// overlapping returns the first network of the list which overlaps the given network, if any
+func overlapping(network *net.IPNet, list []*net.IPNet) *net.IPNet {
+	for _, other := range list {
+		if network.Contains(other.IP) || other.Contains(network.IP) {
+			return other
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/CyCoreSystems/kube-bgp/api/v1alpha1"
+	"github.com/CyCoreSystems/kube-bgp/routes"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestZoneSummary(t *testing.T) {
+	node := func(name, zone string, reflector bool, cidrs ...string) v1.Node {
+		labels := map[string]string{defaultZoneLabel: zone}
+		if reflector {
+			labels["rr"] = "true"
+		}
+
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       v1.NodeSpec{PodCIDRs: cidrs},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}},
+		}
+	}
+
+	reflectors := []v1alpha1.BGPNodeGroup{{
+		ObjectMeta: metav1.ObjectMeta{Name: "reflectors"},
+		Spec: v1alpha1.BGPNodeGroupSpec{
+			NodeSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"rr": "true"}},
+			RouteReflector: true,
+		},
+	}}
+
+	z := &ZoneSummary{Name: "racks", PrefixLength: 20, PrefixLengthV6: 56}
+	if err := z.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	route := func(prefix string) routes.Route {
+		return routes.Route{Prefix: prefix, Source: "zonesummary/racks"}
+	}
+
+	state := &clusterState{
+		Nodes: []v1.Node{
+			node("a1", "zone-a", true, "10.0.0.0/24", "2001:db8::/64"),
+			node("a2", "zone-a", false, "10.0.1.0/24", "2001:db8:0:1::/64"),
+			node("b1", "zone-b", false, "10.0.16.0/24"),
+			node("c1", "", false, "10.0.32.0/24"),
+		},
+		NodeGroups: reflectors,
+	}
+
+	for _, tt := range []struct {
+		node int
+		want []routes.Route
+	}{
+		// Only the route reflector of zone-a announces its aggregates.
+		{node: 0, want: []routes.Route{route("10.0.0.0/20"), route("2001:db8::/56")}},
+		{node: 1},
+
+		// zone-b has no route reflectors, so every Node of it announces.
+		{node: 2, want: []routes.Route{route("10.0.16.0/20")}},
+
+		// A Node without a zone is not summarized.
+		{node: 3},
+	} {
+		n := &state.Nodes[tt.node]
+		if got := z.routes(n, state); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", n.Name, tt.want, got)
+		}
+	}
+
+	// An aggregate which covers the pod CIDR of another zone is not advertised.
+	state.Nodes = append(state.Nodes, node("b2", "zone-b", false, "10.0.2.0/24"))
+
+	want := []routes.Route{route("2001:db8::/56")}
+	if got := z.routes(&state.Nodes[0], state); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v once the aggregates overlap, got %+v", want, got)
+	}
+}
+
+func TestZoneSummaryAggregate(t *testing.T) {
+	z := &ZoneSummary{PrefixLength: 20}
+
+	for prefix, want := range map[string]string{
+		"10.0.5.0/24":   "10.0.0.0/20",
+		"10.0.0.0/16":   "10.0.0.0/16",
+		"2001:db8::/64": "",
+	} {
+		network := parseCIDR(t, prefix)
+		if got := z.aggregate(network); got != want {
+			t.Errorf("%s: expected %q, got %q", prefix, want, got)
+		}
+	}
+
+	for _, bad := range []ZoneSummary{{}, {PrefixLength: 33}, {PrefixLengthV6: 129}, {PrefixLength: -1}} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+}
+
+func parseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return network
+}